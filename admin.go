@@ -0,0 +1,247 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EntryInfo describes an entry of the cache machine, as reported by the
+// admin handler.
+type EntryInfo struct {
+	Key        string        `json:"key"`
+	Size       int           `json:"size"`
+	InRam      bool          `json:"in_ram"`
+	OnDisk     bool          `json:"on_disk"`
+	DiskSynced bool          `json:"disk_synced"`
+	S3Synced   bool          `json:"s3_synced"`
+	SetAt      time.Time     `json:"set_at"`
+	TTL        time.Duration `json:"ttl"`
+	LegalHold  bool          `json:"legal_hold"`
+}
+
+// adminKeysLimit is the number of keys listed by the admin handler when the
+// request sets no limit.
+const adminKeysLimit = 1000
+
+// adminKeysPage is a page of keys, as listed by the admin handler.
+type adminKeysPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// AdminHandler returns an http.Handler exposing endpoints to inspect and
+// manage the cache machine. It is meant to be mounted on an internal port,
+// under a prefix stripped with http.StripPrefix:
+//
+//	GET    /keys        lists a page of keys, see Keys
+//	GET    /keys/{key}  returns the metadata of an entry
+//	DELETE /keys/{key}  deletes an entry from every tier
+//	GET    /where/{key} returns the copies of an entry held by every tier
+//	GET    /holds       lists the keys under legal hold
+//	PUT    /holds/{key} places an entry under legal hold
+//	DELETE /holds/{key} releases the legal hold of an entry
+//	POST   /flush       syncs the RAM cache to disk
+//	GET    /stats       returns the stats
+//	GET    /errors      returns the recent background errors
+//	GET    /disk        returns the disk pressure
+//
+// Every endpoint answers with JSON. /keys takes the prefix, limit and cursor
+// query parameters of Keys, limit defaulting to 1000, and answers with the
+// keys and the cursor of the next page, if any.
+func (c *CacheMachine) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		limit := adminKeysLimit
+		if value := query.Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeAdminError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+		}
+		keys, next := c.Keys(query.Get("prefix"), limit, query.Get("cursor"))
+		if keys == nil {
+			keys = []string{}
+		}
+		writeAdminJSON(w, http.StatusOK, adminKeysPage{Keys: keys, Next: next})
+	})
+	mux.HandleFunc("/keys/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/keys/")
+		switch r.Method {
+		case http.MethodGet:
+			info, ok := c.entryInfo(key)
+			if !ok {
+				writeAdminError(w, http.StatusNotFound, "key not found")
+				return
+			}
+			writeAdminJSON(w, http.StatusOK, info)
+		case http.MethodDelete:
+			deleted, err := c.DeleteCtx(r.Context(), key)
+			if err == ErrLegalHold {
+				writeAdminError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if err != nil {
+				writeAdminError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !deleted {
+				writeAdminError(w, http.StatusNotFound, "key not found")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/where/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		residency, err := c.WhereCtx(r.Context(), strings.TrimPrefix(r.URL.Path, "/where/"))
+		if err != nil {
+			writeAdminError(w, http.StatusGatewayTimeout, err.Error())
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, residency)
+	})
+	mux.HandleFunc("/holds", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, c.LegalHolds())
+	})
+	mux.HandleFunc("/holds/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/holds/")
+		var hold bool
+		switch r.Method {
+		case http.MethodPut:
+			hold = true
+		case http.MethodDelete:
+			hold = false
+		default:
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !hold && !c.LegalHold(key) {
+			writeAdminError(w, http.StatusNotFound, "key not under legal hold")
+			return
+		}
+		if err := c.SetLegalHold(r.Context(), key, hold); err != nil {
+			writeAdminError(w, http.StatusConflict, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := c.Flush(); err != nil {
+			writeAdminError(w, http.StatusConflict, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, c.Stats())
+	})
+	mux.HandleFunc("/errors", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, c.RecentErrors())
+	})
+	mux.HandleFunc("/disk", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if c.DiskCache == nil {
+			writeAdminError(w, http.StatusNotFound, "disk cache is not enabled")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, c.DiskPressure())
+	})
+	return mux
+}
+
+// keys returns the sorted list of keys known to the cache machine.
+func (c *CacheMachine) keys() []string {
+	keys := make([]string, 0)
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		for key := range shard.entries {
+			keys = append(keys, key)
+		}
+		shard.Unlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entryInfo returns the metadata of the entry for the given key, without
+// affecting the access statistics of the tiers. The size of the entries
+// only held by S3 is not known, as S3 is not queried.
+func (c *CacheMachine) entryInfo(key string) (EntryInfo, bool) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	cacheSync, ok := shard.entries[key]
+	if !ok {
+		return EntryInfo{}, false
+	}
+	info := EntryInfo{
+		Key:        key,
+		DiskSynced: cacheSync.DiskSynced,
+		S3Synced:   cacheSync.S3Sync,
+		SetAt:      cacheSync.SetAt,
+		LegalHold:  c.legalHolds.held(key),
+	}
+	if !cacheSync.ExpiresAt.IsZero() {
+		info.TTL = time.Until(cacheSync.ExpiresAt)
+	}
+	if value, err := c.RamCache.Peek([]byte(key)); err == nil {
+		info.InRam = true
+		info.Size = len(value)
+	}
+	if c.DiskCache != nil {
+		if size, ok := c.DiskCache.EntrySize(key); ok {
+			info.OnDisk = true
+			if !info.InRam {
+				info.Size = int(size)
+			}
+		}
+	}
+	return info, info.InRam || info.OnDisk || info.S3Synced
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
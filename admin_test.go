@@ -0,0 +1,168 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_AdminHandler(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.SetWithTTL("key3", []byte("abcde"), time.Hour)
+
+	handler := CacheMachine.AdminHandler()
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+
+	recorder := serve(http.MethodGet, "/keys")
+	var page adminKeysPage
+	json.Unmarshal(recorder.Body.Bytes(), &page)
+	if len(page.Keys) != 3 || page.Keys[0] != "key1" || page.Keys[1] != "key2" || page.Next != "" {
+		t.Errorf("Expected keys key1, key2 and key3, got %s", recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/keys?limit=1")
+	page = adminKeysPage{}
+	json.Unmarshal(recorder.Body.Bytes(), &page)
+	if len(page.Keys) != 1 || page.Keys[0] != "key1" || page.Next != "key1" {
+		t.Errorf("Expected a first page with key1, got %s", recorder.Body.String())
+	}
+	recorder = serve(http.MethodGet, "/keys?limit=1&cursor="+page.Next)
+	page = adminKeysPage{}
+	json.Unmarshal(recorder.Body.Bytes(), &page)
+	if len(page.Keys) != 1 || page.Keys[0] != "key2" {
+		t.Errorf("Expected a second page with key2, got %s", recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/keys?limit=zero")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid limit to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = serve(http.MethodPost, "/flush")
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected flush to succeed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/keys/key1")
+	var info EntryInfo
+	json.Unmarshal(recorder.Body.Bytes(), &info)
+	if !info.InRam || !info.OnDisk || !info.DiskSynced || info.Size != 5 {
+		t.Errorf("Unexpected metadata for key1: %s", recorder.Body.String())
+	}
+
+	// The TTL is reported for the entries evicted from RAM as well.
+	CacheMachine.RamCache.Del([]byte("key3"))
+	recorder = serve(http.MethodGet, "/keys/key3")
+	info = EntryInfo{}
+	json.Unmarshal(recorder.Body.Bytes(), &info)
+	if info.InRam || info.TTL < 59*time.Minute || info.TTL > time.Hour {
+		t.Errorf("Expected key3 to expire in about 1h, got %s", recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodDelete, "/keys/key1")
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected delete to succeed, got %d", recorder.Code)
+	}
+	recorder = serve(http.MethodDelete, "/keys/key1")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected deleting a missing key to be not found, got %d", recorder.Code)
+	}
+
+	CacheMachine.SetLegalHold(context.Background(), "key2", true)
+	recorder = serve(http.MethodDelete, "/keys/key2")
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected deleting a held key to conflict, got %d", recorder.Code)
+	}
+	CacheMachine.SetLegalHold(context.Background(), "key2", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/keys/key2", nil).WithContext(ctx))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a failed delete to be an internal error, got %d", recorder.Code)
+	}
+	if _, ok := CacheMachine.Get("key1"); ok {
+		t.Errorf("Expected key1 to be deleted from every tier")
+	}
+
+	recorder = serve(http.MethodGet, "/keys/key1")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected key1 not to be found, got %d", recorder.Code)
+	}
+
+	recorder = serve(http.MethodGet, "/stats")
+	var stats Stats
+	json.Unmarshal(recorder.Body.Bytes(), &stats)
+	if stats.SetCount != 3 || stats.DiskWriteCount != 3 {
+		t.Errorf("Unexpected stats: %s", recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/flush")
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /flush not to be allowed, got %d", recorder.Code)
+	}
+}
+
+func TestCacheMachine_AdminHandlerS3(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	err = CacheMachine.EnableS3Cache(newMemoryStore())
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	// key1 is only held by S3.
+	CacheMachine.RamCache.Del([]byte("key1"))
+	CacheMachine.DiskCache.Delete("key1")
+
+	recorder := httptest.NewRecorder()
+	CacheMachine.AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/keys/key1", nil))
+	var info EntryInfo
+	json.Unmarshal(recorder.Body.Bytes(), &info)
+	if recorder.Code != http.StatusOK || info.InRam || info.OnDisk || !info.S3Synced {
+		t.Errorf("Unexpected metadata for key1: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
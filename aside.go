@@ -0,0 +1,231 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by the fetch function of CacheAside, possibly
+// wrapped, when the value does not exist in the backing store. CacheAside
+// returns it as is, from the fetch or from a negative entry. It is also
+// returned by the Get method of a Tier that does not hold the key.
+var ErrNotFound = errors.New("not found")
+
+// FetchError is returned by CacheAside when the fetch function fails, other
+// than with ErrNotFound, and no stale value can be served instead.
+type FetchError struct {
+	Key string
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("error fetching key %s: %s", e.Key, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Codec encodes the values cached by CacheAside.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is the Codec encoding values with encoding/json. It is the
+// default codec of CacheAside.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// AsideOption configures a CacheAside call.
+type AsideOption func(*asideOptions)
+
+type asideOptions struct {
+	codec       Codec
+	negativeTTL time.Duration
+	staleFor    time.Duration
+}
+
+// WithCodec makes CacheAside encode values with codec instead of JSONCodec.
+func WithCodec(codec Codec) AsideOption {
+	return func(o *asideOptions) {
+		o.codec = codec
+	}
+}
+
+// WithNegativeTTL makes CacheAside cache that the value does not exist for
+// ttl, when the fetch function returns ErrNotFound, see SetNegative.
+func WithNegativeTTL(ttl time.Duration) AsideOption {
+	return func(o *asideOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithStaleFor keeps values cached for staleFor after their TTL, to be
+// served when fetching them again fails.
+func WithStaleFor(staleFor time.Duration) AsideOption {
+	return func(o *asideOptions) {
+		o.staleFor = staleFor
+	}
+}
+
+// asideHeaderSize is the size of the header prepended by CacheAside to the
+// encoded values, holding the time until which they are fresh, in Unix
+// nanoseconds, or 0 when they never expire.
+const asideHeaderSize = 8
+
+// CacheAside returns the value of key from c, or fetches it on a miss and
+// caches it for ttl, a ttl of 0 meaning that it never expires. Concurrent
+// calls for the same key and the same T share a single fetch, run with the
+// context of the first caller. Values are encoded with the codec of
+// WithCodec, and are meant to be only read and written through CacheAside
+// for a given key.
+//
+// The errors returned are:
+//   - ErrNotFound, when the fetch function returns it, or when the key is
+//     cached as not found after WithNegativeTTL;
+//   - a *FetchError wrapping the error of the fetch function otherwise,
+//     unless a stale value kept by WithStaleFor is served instead;
+//   - the error of ctx, when it is done before the value is available.
+//
+// Failures to decode a cached value or to cache a fetched value are logged,
+// and otherwise handled as misses.
+func CacheAside[T any](ctx context.Context, c *CacheMachine, key string, ttl time.Duration, fetch func(ctx context.Context) (T, error), opts ...AsideOption) (T, error) {
+	o := asideOptions{codec: JSONCodec}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var zero T
+	data, ok, err := c.GetCtx(ctx, key)
+	if err == ErrNegativeHit {
+		return zero, ErrNotFound
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	var stale *T
+	if ok && len(data) >= asideHeaderSize {
+		var value T
+		freshUntil := int64(binary.BigEndian.Uint64(data))
+		if err := o.codec.Unmarshal(data[asideHeaderSize:], &value); err != nil {
+			c.Logger.Warn("error decoding cached value", "key", key, "error", err)
+		} else if freshUntil == 0 || time.Now().UnixNano() < freshUntil {
+			return value, nil
+		} else {
+			stale = &value
+		}
+	}
+
+	// The loads are keyed by T too, so that a caller never gets the value
+	// fetched for a caller of another type.
+	result, err := c.loads.do(ctx, loadKey{key: key, typ: reflect.TypeOf((*T)(nil))}, func() (interface{}, error) {
+		value, err := fetch(ctx)
+		if errors.Is(err, ErrNotFound) {
+			if o.negativeTTL > 0 {
+				if err := c.SetNegative(key, o.negativeTTL); err != nil {
+					c.Logger.Warn("error caching negative entry", "key", key, "error", err)
+				}
+			}
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, &FetchError{Key: key, Err: err}
+		}
+
+		encoded, err := o.codec.Marshal(value)
+		if err != nil {
+			c.logError("error encoding value", "key", key, "error", err)
+			return value, nil
+		}
+		data := make([]byte, asideHeaderSize+len(encoded))
+		storedTTL := time.Duration(0)
+		if ttl > 0 {
+			binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+			storedTTL = ttl + o.staleFor
+		}
+		copy(data[asideHeaderSize:], encoded)
+		if err := c.SetWithTTL(key, data, storedTTL); err != nil {
+			c.Logger.Warn("error caching fetched value", "key", key, "error", err)
+		}
+		return value, nil
+	})
+
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) && stale != nil {
+		c.Logger.Warn("serving stale value", "key", key, "error", fetchErr.Err)
+		return *stale, nil
+	}
+	if err != nil {
+		return zero, err
+	}
+	// result is a nil interface when T is an interface type and the fetch
+	// returned nil, which the checked assertion turns into the zero T.
+	value, _ := result.(T)
+	return value, nil
+}
+
+// loadGroup deduplicates concurrent loads of the same key.
+type loadGroup struct {
+	mu    sync.Mutex
+	loads map[loadKey]*load
+}
+
+// loadKey identifies a load by the key loaded and the type of its value.
+type loadKey struct {
+	key string
+	typ reflect.Type
+}
+
+type load struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs fn, unless a load of key is already running, in which case it
+// waits for its result instead. It stops waiting when ctx is done.
+func (g *loadGroup) do(ctx context.Context, key loadKey, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if l, ok := g.loads[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-l.done:
+			return l.value, l.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if g.loads == nil {
+		g.loads = make(map[loadKey]*load)
+	}
+	// The error is reported to the waiters if fn panics.
+	l := &load{done: make(chan struct{}), err: fmt.Errorf("error loading key %s: load panicked", key.key)}
+	g.loads[key] = l
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.loads, key)
+		g.mu.Unlock()
+		close(l.done)
+	}()
+	l.value, l.err = fn()
+	return l.value, l.err
+}
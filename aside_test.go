@@ -0,0 +1,198 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestCacheAside(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	ctx := context.Background()
+	var fetches int32
+	fetch := func(ctx context.Context) (user, error) {
+		atomic.AddInt32(&fetches, 1)
+		return user{Name: "alice", Age: 42}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		u, err := CacheAside(ctx, CacheMachine, "user:1", time.Hour, fetch)
+		if err != nil || u.Name != "alice" || u.Age != 42 {
+			t.Errorf("Expected alice, got %+v, %v", u, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch, got %d", fetches)
+	}
+}
+
+func TestCacheAside_SingleFlight(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return 7, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := CacheAside(context.Background(), CacheMachine, "key1", time.Hour, fetch)
+			if err != nil || v != 7 {
+				t.Errorf("Expected 7, got %d, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch, got %d", fetches)
+	}
+}
+
+func TestCacheAside_SingleFlightTypes(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		v, err := CacheAside(context.Background(), CacheMachine, "key1", time.Hour, func(ctx context.Context) (int, error) {
+			<-release
+			return 7, nil
+		})
+		if err != nil || v != 7 {
+			t.Errorf("Expected 7, got %d, %v", v, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		v, err := CacheAside(context.Background(), CacheMachine, "key1", time.Hour, func(ctx context.Context) (string, error) {
+			<-release
+			return "seven", nil
+		})
+		if err != nil || v != "seven" {
+			t.Errorf("Expected seven, got %s, %v", v, err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestCacheAside_NilInterface(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := CacheAside(context.Background(), CacheMachine, "key1", time.Hour, func(ctx context.Context) (fmt.Stringer, error) {
+			return nil, nil
+		})
+		if err != nil || v != nil {
+			t.Errorf("Expected a nil value, got %v, %v", v, err)
+		}
+	}
+}
+
+func TestCacheAside_Errors(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	ctx := context.Background()
+	var fetches int32
+	notFound := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&fetches, 1)
+		return "", fmt.Errorf("no such user: %w", ErrNotFound)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := CacheAside(ctx, CacheMachine, "user:2", time.Hour, notFound, WithNegativeTTL(time.Hour))
+		if err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the negative entry to spare the second fetch, got %d fetches", fetches)
+	}
+
+	failure := errors.New("database is down")
+	failing := func(ctx context.Context) (string, error) {
+		return "", failure
+	}
+	_, err = CacheAside(ctx, CacheMachine, "user:3", time.Hour, failing)
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.Key != "user:3" || !errors.Is(err, failure) {
+		t.Errorf("Expected a FetchError wrapping the failure, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := CacheAside(canceled, CacheMachine, "user:3", time.Hour, failing); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCacheAside_StaleOnError(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	ctx := context.Background()
+	fetch := func(ctx context.Context) (string, error) {
+		return "fresh", nil
+	}
+	failing := func(ctx context.Context) (string, error) {
+		return "", errors.New("database is down")
+	}
+
+	v, err := CacheAside(ctx, CacheMachine, "key1", 10*time.Millisecond, fetch, WithStaleFor(time.Hour))
+	if err != nil || v != "fresh" {
+		t.Errorf("Expected fresh, got %q, %v", v, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	v, err = CacheAside(ctx, CacheMachine, "key1", 10*time.Millisecond, failing, WithStaleFor(time.Hour))
+	if err != nil || v != "fresh" {
+		t.Errorf("Expected the stale value, got %q, %v", v, err)
+	}
+
+	var fetched bool
+	refetch := func(ctx context.Context) (string, error) {
+		fetched = true
+		return "fresher", nil
+	}
+	v, err = CacheAside(ctx, CacheMachine, "key1", time.Hour, refetch, WithStaleFor(time.Hour))
+	if err != nil || v != "fresher" || !fetched {
+		t.Errorf("Expected the stale value to be refetched, got %q, %v", v, err)
+	}
+}
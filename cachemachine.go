@@ -1,53 +1,258 @@
 package cachemachine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/cdemers/cachemachine/diskcache"
 	"github.com/coocood/freecache"
-	"gopkg.in/stash.v1"
-	"io/ioutil"
-	"log"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Logger is the minimum interface that a logger must implement. It is used
-// to log messages. The default logger is a no-op. The idea here is to decouple
-// the logger from the library, so that the library can be used in contexts.
-type Logger interface {
-	Log(v ...interface{})
-	Logf(format string, v ...interface{})
-}
+// CacheSyncTable is the sync state of a key. DiskSynced and S3Sync report
+// that the value was written to these tiers, which may have evicted it since.
+// The sync state of a key is forgotten once the key is in no tier anymore.
+type CacheSyncTable struct {
+	DiskSynced bool
+	S3Sync     bool
+	SetAt      time.Time
+	ExpiresAt  time.Time
 
-type DefaultLogger struct{}
+	// Negative is set for keys cached as not found, see SetNegative.
+	Negative bool
 
-func (l DefaultLogger) Log(v ...interface{}) {
-	log.Print(v...)
-}
+	// dirtySince is when the entry was queued to be synced, see
+	// SyncCoalesceWindow.
+	dirtySince time.Time
 
-func (l DefaultLogger) Logf(format string, v ...interface{}) {
-	log.Printf(format, v...)
+	// cold is set when the value was written straight to disk rather than
+	// stored in RAM, and thrash tracks its previous values evicted from RAM
+	// without being read, see EnableThrashProtection.
+	cold   bool
+	thrash thrashState
+
+	// generation is the generation of the cache machine the entry was set
+	// at, see Snapshot.
+	generation uint64
 }
 
-type CacheSyncTable struct {
-	DiskSynced bool
-	S3Sync     bool
+// expired reports whether the entry has a TTL that is elapsed.
+func (s CacheSyncTable) expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
 }
 
+// tier names a storage tier of the cache machine.
+type tier string
+
+const (
+	tierRAM  tier = "ram"
+	tierDisk tier = "disk"
+	tierS3   tier = "s3"
+)
+
 type CacheMachine struct {
 	MaxItemSizeInBytes   int
-	CacheSyncTable       map[string]CacheSyncTable
 	RamCache             *freecache.Cache
 	RamCacheSizeInBytes  int
-	DiskCache            *stash.Cache
+	DiskCache            *diskcache.Cache
 	DiskCacheSizeInBytes int64
 	DiskCachePath        string
 	DiskCacheSyncTicker  *time.Ticker
 	DiskCacheSyncQuit    chan int
+	S3Cache              ObjectStore
 	Logger               Logger
+
+	// HedgeDelay, when set, hedges the reads of entries held both on disk
+	// and in S3: if the disk has not answered after HedgeDelay, S3 is read
+	// too and the first answer wins. It improves tail latency when the local
+	// disk is degraded, at the cost of extra S3 requests. S3 is read first
+	// instead while it is preferred, see EnableTierReordering.
+	HedgeDelay time.Duration
+
+	// S3MaxAge, when set along with S3Refresh, is the age from which an
+	// entry read from S3 is considered stale. Stale entries are served, but
+	// refreshed in the background through S3Refresh, so that the S3 tier
+	// does not become an archive of permanently stale data.
+	S3MaxAge  time.Duration
+	S3Refresh S3RefreshFunc
+
+	// MaxMetricsNamespaces is the number of namespaces that get their own
+	// stats, the others being aggregated under OtherNamespace.
+	MaxMetricsNamespaces int
+
+	// BigEvictionSizeInBytes is the size from which the eviction of an
+	// entry from disk is reported as an EventBigEviction.
+	BigEvictionSizeInBytes int64
+
+	// OnBeforeEvict, when set, is called before an entry is evicted from
+	// disk, and vetoes the eviction by returning false. It is meant for rare
+	// entries whose loss is costly and which the application knows are about
+	// to be read. At most MaxEvictionVetoes vetoes are honored per write to
+	// disk. It is called with the disk cache locked, so it must not call back
+	// into the cache machine. Both must be set before enabling the disk cache.
+	OnBeforeEvict     func(key string, meta diskcache.Meta) bool
+	MaxEvictionVetoes int
+
+	// MaxDirtyKeys is the number of keys waiting to be synced from which the
+	// background sync is started early, rather than on its next tick. It
+	// also bounds the sync queue, see Stats.SyncQueueOverflows.
+	MaxDirtyKeys int
+
+	// RecentErrorsSize is the number of background errors kept for
+	// RecentErrors. No error is kept when it is zero.
+	RecentErrorsSize int
+
+	// MinTTL and MaxTTL, when set, clamp the TTL of every entry set, after
+	// it is rewritten by TTLPolicy. Entries that would never expire get
+	// MaxTTL. Every rewritten TTL is reported as an EventPolicyTrip.
+	MinTTL    time.Duration
+	MaxTTL    time.Duration
+	TTLPolicy TTLPolicy
+
+	// AdmissionMinFrequency, when set along with frequency tracking, is the
+	// number of recent requests a key needs for its values to be stored in
+	// RAM. Values of keys requested less often are written straight to disk,
+	// or not cached at all without a disk cache, so that keys read only once
+	// do not evict the hot ones. They are promoted to RAM once requested
+	// often enough.
+	AdmissionMinFrequency int
+
+	// SyncMaxBytesPerSecond and SyncMaxItemsPerTick, when set, limit the
+	// rate at which the background sync writes values, and the number of
+	// entries it writes each time it runs, so that it does not saturate the
+	// disk at the expense of the serving path. The entries beyond the limits
+	// wait for the next sync. Flush is not limited.
+	SyncMaxBytesPerSecond int64
+	SyncMaxItemsPerTick   int
+
+	// S3DownloadMaxBytesPerSecond, when set, caps the rate at which values
+	// are downloaded from S3, and gives the downloads of the serving path
+	// priority over the background ones, such as those of
+	// PrefetchFromIndex, so that warming a cache up never starves the
+	// reads it serves.
+	S3DownloadMaxBytesPerSecond int64
+
+	// SyncBatchSize is the number of entries the sync writes before letting
+	// the Sets it holds up through, and SyncWorkers the number of stripes of
+	// the sync table it syncs concurrently.
+	SyncBatchSize int
+	SyncWorkers   int
+
+	// SyncCoalesceWindow, when set, makes the background sync leave the
+	// entries queued for less than SyncCoalesceWindow for a later sync, so
+	// that a key updated many times per second is written once per window,
+	// with its latest value, rather than every time a sync runs. Flush
+	// ignores it.
+	SyncCoalesceWindow time.Duration
+
+	// StrictLimits makes Set check the key and the value against the limits
+	// of the RAM cache, see MaxKeySize and MaxEntrySize, before touching
+	// any tier, and return a LimitError when they exceed them.
+	StrictLimits bool
+
+	// DefaultTimeouts are the deadlines applied to the operations whose
+	// context has none.
+	DefaultTimeouts Timeouts
+
+	// DiskLeaseTTL, when set, makes the disk cache hold a lease on its
+	// directory, renewed every third of DiskLeaseTTL, so that two cache
+	// machines sharing a volume across hosts never use the same directory.
+	// A directory whose lease was not renewed for DiskLeaseTTL is only
+	// taken over when DiskLeaseTakeOver is set. A cache machine whose lease
+	// is taken over stops writing to the directory, see
+	// diskcache.Cache.AcquireLease.
+	DiskLeaseTTL      time.Duration
+	DiskLeaseTakeOver bool
+
+	// EphemeralStorageLimit is the ephemeral storage limit of the pod, to
+	// which the disk tier is bounded when enabled, less
+	// EphemeralStorageHeadroomPercent percent kept for the rest of the
+	// pod. When not set, it is read from the EphemeralStorageLimitEnv
+	// environment variable, if any. See DiskPressure.
+	EphemeralStorageLimit           int64
+	EphemeralStorageHeadroomPercent int
+
+	stats     statsCounters
+	syncTable *syncTable
+	syncNow   chan struct{}
+	sweepHand uint32
+
+	syncLimiterMu sync.Mutex
+	syncLimiter   *byteRateLimiter
+
+	downloadLimiterMu    sync.Mutex
+	downloadLimiter      *byteRateLimiter
+	interactiveDownloads int32
+
+	evictedMu   sync.Mutex
+	evictedKeys []string
+
+	refreshMu  sync.Mutex
+	refreshing map[string]struct{}
+
+	subjects   subjectIndex
+	legalHolds legalHolds
+	loads      loadGroup
+	namespaces sync.Map
+
+	recentErrors recentErrors
+	tierOrder    tierOrder
+	sliding      slidingPolicies
+	compression  compressionPolicies
+	adaptiveTTLs adaptiveTTLPolicies
+
+	sinksMu sync.Mutex
+	sinks   []*sinkRunner
+
+	usage usageTable
+
+	moveMu sync.Mutex
+
+	// drainingDisk is the disk engine drained by SwapDiskEngine or
+	// DrainDiskEngine, until it is drained. It is guarded by the stripes
+	// of the sync table.
+	drainingDisk DiskEngine
+	diskOptions  diskOptions
+
+	persistStats  int32
+	statsRestored int32
+	// saveStatsMu serializes the saves of the persisted stats, which share
+	// a temporary file.
+	saveStatsMu sync.Mutex
+
+	instanceID []byte
+	// invalidationMu guards the invalidation queue, which Set and Delete
+	// send to under a read lock so that it is never closed under them.
+	invalidationMu    sync.RWMutex
+	invalidationBus   InvalidationBus
+	invalidationQueue chan string
+	invalidationDone  chan struct{}
+
+	events       atomic.Value
+	tracer       atomic.Value
+	frequency    atomic.Value
+	thrash       atomic.Value
+	idle         atomic.Value
+	reaccess     atomic.Value
+	evictionHook atomic.Value
+	random       atomic.Value
+
+	// generation counts the entries set, see Snapshot.
+	generation    uint64
+	snapshotsMu   sync.Mutex
+	snapshots     []*Snapshot
+	openSnapshots int32
 }
 
 const (
 	DiskCacheSyncInterval = time.Second * 30
+
+	// DefaultMaxEvictionVetoes is the default value of
+	// CacheMachine.MaxEvictionVetoes.
+	DefaultMaxEvictionVetoes = 8
 )
 
 func NewCacheMachine(maxRamCacheSizeInBytes int, maxItemSizeInBytes int) (cm *CacheMachine, err error) {
@@ -66,19 +271,30 @@ func NewCacheMachine(maxRamCacheSizeInBytes int, maxItemSizeInBytes int) (cm *Ca
 		debug.SetGCPercent(20)
 	}
 
-	defaultLogger := DefaultLogger{}
-
 	cm = &CacheMachine{
-		CacheSyncTable:      make(map[string]CacheSyncTable),
 		MaxItemSizeInBytes:  maxRamCacheSizeInBytes,
 		RamCache:            ramCache,
 		RamCacheSizeInBytes: maxRamCacheSizeInBytes,
-		Logger:              defaultLogger,
+		Logger:              DefaultLogger{},
+		syncTable:           newSyncTable(),
+
+		MaxMetricsNamespaces:   DefaultMaxMetricsNamespaces,
+		BigEvictionSizeInBytes: DefaultBigEvictionSizeInBytes,
+		MaxEvictionVetoes:      DefaultMaxEvictionVetoes,
+		MaxDirtyKeys:           DefaultMaxDirtyKeys,
+		RecentErrorsSize:       DefaultRecentErrorsSize,
+		SyncBatchSize:          DefaultSyncBatchSize,
+		SyncWorkers:            1,
+
+		EphemeralStorageHeadroomPercent: DefaultEphemeralStorageHeadroomPercent,
 	}
 	return cm, nil
 }
 
-func (c *CacheMachine) EnableDiskCache(maxDiskCacheSizeInBytes int64, cachePath string) (err error) {
+// EnableDiskCache adds a disk cache of maxDiskCacheSizeInBytes in
+// cachePath, synced from the RAM cache in the background. The disk engine is
+// tuned by opts.
+func (c *CacheMachine) EnableDiskCache(maxDiskCacheSizeInBytes int64, cachePath string, opts ...DiskOption) (err error) {
 
 	if maxDiskCacheSizeInBytes <= 0 {
 		err = fmt.Errorf("maxDiskCacheSizeInBytes must be greater than 0")
@@ -90,123 +306,440 @@ func (c *CacheMachine) EnableDiskCache(maxDiskCacheSizeInBytes int64, cachePath
 		return err
 	}
 
-	c.DiskCache, err = stash.New(cachePath, maxDiskCacheSizeInBytes, 1024)
+	maxDiskCacheSizeInBytes, err = c.capDiskCacheSize(maxDiskCacheSizeInBytes, cachePath)
+	if err != nil {
+		return err
+	}
+
+	c.diskOptions = newDiskOptions(opts)
+	c.DiskCache, err = c.newDiskCache(cachePath, maxDiskCacheSizeInBytes)
 	if err != nil {
-		return fmt.Errorf("error creating disk cache: %s", err)
+		return err
 	}
 	c.DiskCacheSizeInBytes = maxDiskCacheSizeInBytes
 	c.DiskCachePath = cachePath
 
 	c.DiskCacheSyncTicker = time.NewTicker(DiskCacheSyncInterval)
 	c.DiskCacheSyncQuit = make(chan int)
+	c.syncNow = make(chan struct{}, 1)
+
+	c.syncTable.lockAll()
+	c.enqueueAll(false)
+	c.syncTable.unlockAll()
 
-	go func() {
+	go func(ticker *time.Ticker, syncNow chan struct{}, quit chan int) {
 		for {
 			select {
-			case <-c.DiskCacheSyncTicker.C:
+			case <-ticker.C:
 				c.SyncRamCacheToDiskCache()
-			case <-c.DiskCacheSyncQuit:
-				c.DiskCacheSyncTicker.Stop()
+			case <-syncNow:
+				c.SyncRamCacheToDiskCache()
+			case <-quit:
+				ticker.Stop()
 				return
 			}
 		}
-	}()
+	}(c.DiskCacheSyncTicker, c.syncNow, c.DiskCacheSyncQuit)
 
 	return nil
 }
 
+// newDiskCache creates a disk cache in cachePath, tuned by the options given
+// to EnableDiskCache, and hooked to the stats and eviction policies of the
+// cache machine.
+func (c *CacheMachine) newDiskCache(cachePath string, maxDiskCacheSizeInBytes int64) (*diskcache.Cache, error) {
+	diskCache, err := diskcache.NewWithOptions(cachePath, maxDiskCacheSizeInBytes, c.diskOptions.maxItems, c.diskOptions.layout)
+	if err != nil {
+		return nil, fmt.Errorf("error creating disk cache: %w", err)
+	}
+	diskCache.OnEvict = func(meta diskcache.Meta) {
+		age := time.Since(meta.CreatedAt)
+		c.stats.diskEvictionAges.record(age)
+		c.recordDiskEviction(meta.Key)
+		if meta.Size >= c.BigEvictionSizeInBytes {
+			c.emitEvent(EventBigEviction, "big entry evicted from disk", map[string]interface{}{
+				"key":  meta.Key,
+				"size": meta.Size,
+				"age":  age.String(),
+			})
+		}
+	}
+	onBeforeEvict := c.OnBeforeEvict
+	diskCache.OnBeforeEvict = func(meta diskcache.Meta) bool {
+		if c.legalHolds.held(meta.Key) {
+			return false
+		}
+		return onBeforeEvict == nil || onBeforeEvict(meta.Key, meta)
+	}
+	diskCache.MaxVetoes = c.MaxEvictionVetoes
+
+	if c.DiskLeaseTTL > 0 {
+		err = diskCache.AcquireLease(diskcache.LeaseOptions{
+			TTL:           c.DiskLeaseTTL,
+			TakeOverStale: c.DiskLeaseTakeOver,
+			OnLost: func(owner diskcache.LeaseInfo) {
+				c.logError("disk cache lease taken over", "path", cachePath, "owner", owner.String())
+				c.emitEvent(EventLeaseLost, "disk cache lease taken over", map[string]interface{}{
+					"path":  cachePath,
+					"owner": owner.String(),
+				})
+			},
+		})
+		if err != nil {
+			diskCache.Close()
+			return nil, fmt.Errorf("error leasing disk cache: %w", err)
+		}
+	}
+	return diskCache, nil
+}
+
 func (c *CacheMachine) DisableDiskCache() {
+	if c.DiskCache == nil {
+		return
+	}
 	c.DiskCacheSyncQuit <- 1
 	c.DiskCacheSyncTicker.Stop()
+	c.closeDiskCache()
+}
+
+// closeDiskCache saves the stats and closes the disk tier, once the
+// background sync is stopped.
+func (c *CacheMachine) closeDiskCache() {
+	if err := c.saveStats(); err != nil {
+		c.logError("error saving stats", "error", err)
+	}
+	atomic.StoreInt32(&c.persistStats, 0)
+	c.syncTable.lockAll()
+	if c.drainingDisk != nil {
+		c.closeDrainedDisk(c.drainingDisk)
+		c.drainingDisk = nil
+	}
+	c.syncTable.unlockAll()
+	if err := c.DiskCache.Close(); err != nil {
+		c.logError("error closing disk cache", "error", err)
+	}
 	c.DiskCache = nil
 }
 
+// SyncRamCacheToDiskCache syncs the entries waiting to be written to disk,
+// and to S3 when enabled, within the limits set by SyncMaxItemsPerTick and
+// SyncMaxBytesPerSecond, and leaves the entries queued for less than
+// SyncCoalesceWindow for a later sync. It is called by the background sync.
 func (c *CacheMachine) SyncRamCacheToDiskCache() {
+	c.syncRamCacheToDiskCache(context.Background(), c.newSyncLimits(), c.SyncCoalesceWindow)
+}
+
+// syncRamCacheToDiskCache syncs the queued entries within limits, except
+// those queued for less than coalesce. The entries left when ctx is done
+// stay queued.
+func (c *CacheMachine) syncRamCacheToDiskCache(ctx context.Context, limits *syncLimits, coalesce time.Duration) {
 	if c.DiskCache == nil {
-		c.Logger.Log("[cachemachine] Disk Cache is not enabled")
+		c.Logger.Warn("disk cache is not enabled")
 		return
 	}
-	var syncCount int
-	for key := range c.CacheSyncTable {
-		// TODO: There should only be one thread handling this key at a
-		//       time, but just in case, we'll lock the key.
-		cacheSync := c.CacheSyncTable[key]
-		if !cacheSync.DiskSynced {
-			value, err := c.RamCache.Get([]byte(key))
-			if err != nil {
-				delete(c.CacheSyncTable, key)
-				continue
-			}
-			err = c.DiskCache.Put(key, value)
-			if err != nil {
-				c.Logger.Log("[cachemachine] Error syncing to disk: ", err)
-				continue
+	start := time.Now()
+	defer func() { c.stats.recordSyncCycle(time.Since(start)) }()
+	_, span := c.startSpan(ctx, "cachemachine.Sync", "")
+
+	workers := c.SyncWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	var result syncResult
+	shards := make(chan *syncTableShard)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for shard := range shards {
+				c.syncShard(ctx, shard, limits, coalesce, &result)
 			}
-			cacheSync.DiskSynced = true
-			c.CacheSyncTable[key] = cacheSync
-			syncCount++
+		}()
+	}
+	for i := range c.syncTable {
+		shards <- &c.syncTable[i]
+	}
+	close(shards)
+	wg.Wait()
+	atomic.StoreInt64(&c.stats.syncLag, result.maxLag)
+
+	c.forgetEvicted()
+	c.sweep()
+	if span != nil {
+		span.SetAttribute(AttributeSynced, result.synced)
+		span.SetAttribute(AttributeLost, result.lost)
+		span.End()
+	}
+	if result.synced > 0 {
+		c.Logger.Debug("synced items to disk", "count", result.synced)
+	}
+	if result.lost > 0 {
+		c.emitEvent(EventUnsyncedEvictions, "entries evicted from RAM before being synced to disk", map[string]interface{}{
+			"count": result.lost,
+		})
+		c.reportUnsyncedEvictions(result.lostKeys)
+	}
+	if err := c.saveStats(); err != nil {
+		c.logError("error saving stats", "error", err)
+	}
+}
+
+// syncShard syncs the entries queued in shard. Only one goroutine handles a
+// key at a time: the stripe of the key stays locked while its value is being
+// written, but is released between batches of SyncBatchSize entries and
+// during the pauses of the rate limit, so that Sets are not held up by a long
+// sync. The entries beyond the budget of the sync, those queued for less
+// than coalesce, and those left when ctx is done, are left queued.
+func (c *CacheMachine) syncShard(ctx context.Context, shard *syncTableShard, limits *syncLimits, coalesce time.Duration, result *syncResult) {
+	s3Cache := c.S3Cache
+	now := time.Now()
+	batchSize := c.SyncBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultSyncBatchSize
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	queue := c.dirtyKeys(shard)
+	shard.dirty = nil
+	requeued := 0
+	requeue := func(keys ...string) {
+		shard.dirty = append(shard.dirty, keys...)
+		requeued += len(keys)
+	}
+	written := 0
+	for i, key := range queue {
+		cacheSync, ok := shard.entries[key]
+		if !ok || cacheSync.Negative {
+			continue
+		}
+		syncS3 := s3Cache != nil && !cacheSync.S3Sync
+		if cacheSync.DiskSynced && !syncS3 {
+			continue
+		}
+		if coalesce > 0 && now.Sub(cacheSync.dirtySince) < coalesce {
+			requeue(key)
+			continue
+		}
+		if ctx.Err() != nil || !limits.takeItem() {
+			requeue(queue[i:]...)
+			break
+		}
+		value, retry := c.syncEntry(ctx, shard, key, cacheSync, s3Cache, result)
+		if retry {
+			requeue(key)
+		}
+
+		// The entries of the queue are read again once the stripe is
+		// locked back, as they may have been set or deleted meanwhile.
+		written++
+		if pause := limits.wrote(len(value)); pause > 0 || written%batchSize == 0 {
+			shard.Unlock()
+			time.Sleep(pause)
+			shard.Lock()
+		}
+	}
+	atomic.AddInt64(&c.stats.syncQueueDepth, int64(requeued-len(queue)))
+}
+
+// syncEntry writes the value of the entry for key to disk, and to S3 when
+// s3Cache is set, according to its sync state cacheSync, and returns the
+// value. It reports whether the entry must be synced again, after a write
+// failed. It must be called with the stripe of the key locked.
+func (c *CacheMachine) syncEntry(ctx context.Context, shard *syncTableShard, key string, cacheSync CacheSyncTable, s3Cache ObjectStore, result *syncResult) (value []byte, retry bool) {
+	value, err := c.RamCache.Get([]byte(key))
+	if err != nil && cacheSync.DiskSynced {
+		// Entries synced to disk before the S3 tier was enabled are
+		// copied from there.
+		value, err = c.DiskCache.Get(key)
+		if err != nil {
+			return nil, false
 		}
 	}
-	if syncCount > 0 {
-		c.Logger.Logf("[cachemachine] Synced %d items to disk", syncCount)
+	if err != nil {
+		c.stats.ramEvictionAges.record(time.Since(cacheSync.SetAt))
+		result.recordLost(key)
+		delete(shard.entries, key)
+		return nil, false
+	}
+	if !cacheSync.DiskSynced {
+		err = c.putDisk(c.DiskCache, key, value)
+		if err != nil {
+			c.logError("error syncing to disk", "key", key, "error", err)
+			c.emitEvent(EventSyncFailure, "error syncing to disk", map[string]interface{}{
+				"key":   key,
+				"error": err.Error(),
+			})
+			return value, true
+		}
+		c.stats.recordDiskWrite(len(value))
+		result.recordLag(time.Since(cacheSync.SetAt))
+		cacheSync.DiskSynced = true
+		atomic.AddInt64(&result.synced, 1)
 	}
+	if s3Cache != nil && !cacheSync.S3Sync {
+		err = s3Cache.Put(ctx, key, c.sealObject(key, value))
+		if err != nil {
+			c.logError("error syncing to S3", "key", key, "error", err)
+			c.emitEvent(EventSyncFailure, "error syncing to S3", map[string]interface{}{
+				"key":   key,
+				"error": err.Error(),
+			})
+			retry = true
+		} else if err = c.syncLegalHold(ctx, s3Cache, key); err != nil {
+			c.logError("error placing legal hold on S3", "key", key, "error", err)
+			retry = true
+		} else {
+			c.stats.recordS3Write(len(value))
+			cacheSync.S3Sync = true
+		}
+	}
+	shard.entries[key] = cacheSync
+	return value, retry
 }
 
-func (c *CacheMachine) EnableS3Cache(maxItemSizeInBytes int, s3Bucket string) (err error) {
-	return fmt.Errorf("not implemented")
+// Flush synchronously syncs every entry of the RAM cache that is not yet on
+// disk, instead of waiting for the next background sync.
+func (c *CacheMachine) Flush() error {
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	c.syncRamCacheToDiskCache(context.Background(), nil, 0)
+	return nil
 }
 
-func (c *CacheMachine) SetLogger(logger *Logger) {
-	c.Logger = *logger
+// SetLogger replaces the logger of the cache machine.
+func (c *CacheMachine) SetLogger(logger Logger) {
+	c.Logger = logger
 }
 
 // Get returns the value for the given key. If the key exists, Get returns
 // the value and true. If the key does not exist, Get returns nil and false.
 func (c *CacheMachine) Get(key string) (value []byte, ok bool) {
-	var err error
-
-	value, err = c.RamCache.Get([]byte(key))
-	if err == nil {
-		return value, true
-	}
-
-	if c.CacheSyncTable[key].DiskSynced {
-		valueFromDisk, err := c.DiskCache.Get(key)
-		if err == nil {
-			value, err := ioutil.ReadAll(valueFromDisk)
-			if err == nil {
-				return value, true
-			}
-		}
-	}
-
-	return nil, false
+	value, ok, _ = c.GetCtx(context.Background(), key)
+	return value, ok
 }
 
 // Set sets the value for the given key. If the key is larger than 65535 or
 // value is larger than 1/1024 of the cache size, the entry will not be
 // written to the cache.
 func (c *CacheMachine) Set(key string, val []byte) error {
-	c.CacheSyncTable[key] = CacheSyncTable{
-		DiskSynced: false,
-		S3Sync:     false,
+	return c.SetCtx(context.Background(), key, val)
+}
+
+// set must be called with the stripe of the key locked. A ttl of 0 means
+// that the entry never expires, or gets the sliding TTL of its namespace.
+func (c *CacheMachine) set(shard *syncTableShard, key string, val []byte, ttl time.Duration) error {
+	if c.legalHolds.held(key) {
+		return ErrLegalHold
+	}
+	if c.StrictLimits {
+		if err := c.checkLimits(key, val); err != nil {
+			return err
+		}
+	}
+	if ttl == 0 {
+		ttl = c.slidingTTL(key)
+	}
+	ttl = c.applyTTLPolicy(key, ttl)
+	now := time.Now()
+	var expiresAt time.Time
+	var expireSeconds int
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+		expireSeconds = int((ttl + time.Second - 1) / time.Second)
+	}
+	c.sweepSome(shard)
+	c.preserveForSnapshots(shard, key)
+	c.recordReaccessSet(key, now)
+	previous, ok := shard.entries[key]
+	thrash := c.observeThrash(key, previous, ok, now)
+	if now.Before(thrash.divertedUntil) && c.DiskCache != nil {
+		c.stats.recordThrashDiversion()
+		return c.setCold(shard, key, val, now, expiresAt, thrash)
 	}
-	err := c.RamCache.Set([]byte(key), val, 0)
+	if !c.admit(key) {
+		c.stats.recordAdmissionRejection()
+		return c.setCold(shard, key, val, now, expiresAt, thrash)
+	}
+	// The value is stored before the sync table is updated, so that a
+	// failure leaves the previous entry as it was.
+	err := c.RamCache.Set([]byte(key), val, expireSeconds)
 	if err != nil {
 		return fmt.Errorf("error setting key %s: %s", key, err)
 	}
+	dirtySince := now
+	if c.DiskCache != nil {
+		if !ok || previous.DiskSynced || previous.Negative {
+			c.enqueueDirty(shard, key)
+		} else {
+			// The previous value was never synced, and never will be.
+			dirtySince = previous.dirtySince
+			c.stats.recordCoalescedSet()
+		}
+	}
+	shard.entries[key] = CacheSyncTable{
+		DiskSynced: false,
+		S3Sync:     false,
+		SetAt:      now,
+		ExpiresAt:  expiresAt,
+		dirtySince: dirtySince,
+		thrash:     thrash,
+		generation: c.nextGeneration(),
+	}
+	c.stats.recordSet(len(val))
+	c.namespaceCounters(key).recordSet(len(val))
+	c.invalidate(key)
 	return nil
 }
 
-// Delete deletes the value for the given key. If the key exists, Delete
-// returns true. If the key does not exist, Delete returns false.
+// Delete deletes the value for the given key from every tier. If the key
+// exists, Delete returns true. If the key does not exist, or is under legal
+// hold, Delete returns false.
 func (c *CacheMachine) Delete(key string) bool {
-	return c.RamCache.Del([]byte(key))
+	deleted, _ := c.DeleteCtx(context.Background(), key)
+	return deleted
 }
 
-// ClearRamCache clears the cache.
+// ClearRamCache clears the RAM cache. Entries that were not yet synced to
+// disk are lost, so they are also dropped from the sync table. Entries under
+// legal hold are kept.
 func (c *CacheMachine) ClearRamCache() {
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	c.clearRamCacheLocked()
+	for i := range c.syncTable {
+		for key, cacheSync := range c.syncTable[i].entries {
+			if !cacheSync.DiskSynced && !c.legalHolds.held(key) {
+				delete(c.syncTable[i].entries, key)
+			}
+		}
+	}
+}
+
+// clearRamCacheLocked clears the RAM cache but for the entries under legal
+// hold. It must be called with every stripe locked.
+func (c *CacheMachine) clearRamCacheLocked() {
+	type heldValue struct {
+		key           string
+		value         []byte
+		expireSeconds int
+	}
+	var held []heldValue
+	for _, key := range c.legalHolds.list() {
+		if value, err := c.RamCache.Peek([]byte(key)); err == nil {
+			ttl, _ := c.RamCache.TTL([]byte(key))
+			held = append(held, heldValue{key: key, value: value, expireSeconds: int(ttl)})
+		}
+	}
 	c.RamCache.Clear()
+	for _, h := range held {
+		if err := c.RamCache.Set([]byte(h.key), h.value, h.expireSeconds); err != nil {
+			c.logError("error keeping held entry in RAM", "key", h.key, "error", err)
+		}
+	}
 }
 
 // RamCacheSize returns the size of the cache in bytes.
@@ -214,9 +747,99 @@ func (c *CacheMachine) RamCacheSize() int {
 	return c.RamCacheSizeInBytes
 }
 
-// ClearDiskCache clears the cache.
-func (c *CacheMachine) ClearDiskCache() {
-	for _, v := range c.DiskCache.Keys() {
-		c.DiskCache.Put(v, []byte(""))
+// ClearDiskCache removes every entry from the disk cache, reclaiming the
+// space they used. The entries still in RAM are queued to be synced to disk
+// again, and those only left on S3 are read from there, the others are
+// forgotten. Entries under legal hold are kept.
+func (c *CacheMachine) ClearDiskCache() error {
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	now := time.Now()
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		for key, cacheSync := range shard.entries {
+			if !cacheSync.DiskSynced || c.legalHolds.held(key) {
+				continue
+			}
+			if _, err := c.RamCache.Peek([]byte(key)); err != nil {
+				// The entries synced to S3 can still be read from there.
+				if !cacheSync.S3Sync || c.S3Cache == nil {
+					delete(shard.entries, key)
+				}
+				continue
+			}
+			cacheSync.DiskSynced = false
+			cacheSync.dirtySince = now
+			shard.entries[key] = cacheSync
+			c.enqueueDirty(shard, key)
+		}
 	}
+	if err := c.clearDiskCacheLocked(); err != nil {
+		return fmt.Errorf("error clearing disk cache: %s", err)
+	}
+	return nil
+}
+
+// clearDiskCacheLocked clears the disk cache but for the entries under legal
+// hold. It must be called with every stripe locked.
+func (c *CacheMachine) clearDiskCacheLocked() error {
+	return c.DiskCache.ClearExcept(func(meta diskcache.Meta) bool {
+		return c.legalHolds.held(meta.Key)
+	})
+}
+
+// ClearAll wipes the RAM, disk and S3 tiers and the sync table. The S3
+// objects deleted are those of the entries known to be synced there, the
+// object store having no listing; objects written by other instances are
+// left in place. Entries under legal hold are kept in every tier. The
+// background sync cannot run while the tiers are being cleared, so it never
+// observes a partially cleared cache.
+func (c *CacheMachine) ClearAll() error {
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	var s3Keys []string
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		entries := make(map[string]CacheSyncTable)
+		for key, cacheSync := range shard.entries {
+			switch {
+			case c.legalHolds.held(key):
+				entries[key] = cacheSync
+			case cacheSync.S3Sync:
+				s3Keys = append(s3Keys, key)
+			}
+		}
+		shard.entries = entries
+		shard.dirty = nil
+		shard.overflowed = false
+	}
+	c.clearRamCacheLocked()
+	atomic.StoreInt64(&c.stats.syncQueueDepth, 0)
+	if c.DiskCache != nil {
+		c.enqueueAll(c.S3Cache != nil)
+		if err := c.clearDiskCacheLocked(); err != nil {
+			return fmt.Errorf("error clearing disk cache: %s", err)
+		}
+	}
+	if c.S3Cache != nil {
+		var failed int
+		var lastErr error
+		for _, key := range s3Keys {
+			err := c.S3Cache.Delete(context.Background(), key)
+			if err != nil && !errors.Is(err, ErrObjectNotFound) {
+				failed++
+				lastErr = err
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("error deleting %d objects from S3: %s", failed, lastErr)
+		}
+	}
+	return nil
 }
@@ -1,10 +1,14 @@
 package cachemachine
 
 import (
+	"errors"
 	"fmt"
+	"github.com/cdemers/cachemachine/diskcache"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewCacheMachine(t *testing.T) {
@@ -122,6 +126,149 @@ func TestCacheMachine_EnableDiskCache(t *testing.T) {
 	CacheMachine.DisableDiskCache()
 }
 
+func TestCacheMachine_ClearDiskCache(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	err = CacheMachine.ClearDiskCache()
+	if err == nil {
+		t.Errorf("Expected error clearing a disabled disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	// key2 is only on disk.
+	CacheMachine.RamCache.Del([]byte("key2"))
+
+	err = CacheMachine.ClearDiskCache()
+	if err != nil {
+		t.Errorf("Expected no error clearing disk cache, got %s", err)
+	}
+	if CacheMachine.DiskCache.Len() != 0 {
+		t.Errorf("Expected disk cache to be empty, got %d entries", CacheMachine.DiskCache.Len())
+	}
+	files, _ := ioutil.ReadDir(tmpFolder)
+	if len(files) != 1 || files[0].Name() != diskcache.LockFileName {
+		t.Errorf("Expected only the lock file in disk cache folder, got %d files", len(files))
+	}
+	if _, ok := CacheMachine.SyncState("key2"); ok {
+		t.Errorf("Expected key2 to be removed from the sync table")
+	}
+
+	// key1 is still in RAM, so it is kept and synced to disk again.
+	state, ok := CacheMachine.SyncState("key1")
+	if !ok || state.DiskSynced {
+		t.Errorf("Expected key1 to be kept and not synced to disk, got %+v, %t", state, ok)
+	}
+	if keys, _ := CacheMachine.Keys("", 0, ""); len(keys) != 1 || keys[0] != "key1" {
+		t.Errorf("Expected keys [key1], got %v", keys)
+	}
+	CacheMachine.SyncRamCacheToDiskCache()
+	if _, ok := CacheMachine.DiskCache.Stat("key1"); !ok {
+		t.Errorf("Expected key1 to be synced to disk again")
+	}
+}
+
+func TestCacheMachine_ClearAll(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	err = CacheMachine.EnableS3Cache(store)
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	CacheMachine.Set("key2", []byte("67890"))
+	if store.len() != 1 {
+		t.Errorf("Expected 1 object in S3, got %d", store.len())
+	}
+
+	err = CacheMachine.ClearAll()
+	if err != nil {
+		t.Errorf("Expected no error clearing all tiers, got %s", err)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if _, ok := CacheMachine.Get(key); ok {
+			t.Errorf("Expected cache miss getting %s after ClearAll", key)
+		}
+	}
+	if store.len() != 0 {
+		t.Errorf("Expected S3 to be empty, got %d objects", store.len())
+	}
+	if CacheMachine.syncTable.len() != 0 {
+		t.Errorf("Expected sync table to be empty, got %d entries", CacheMachine.syncTable.len())
+	}
+}
+
+func TestCacheMachine_OnBeforeEvict(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.OnBeforeEvict = func(key string, meta diskcache.Meta) bool {
+		return key != "keep"
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(10, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for _, key := range []string{"keep", "key1", "key2"} {
+		CacheMachine.Set(key, []byte("12345"))
+		CacheMachine.Flush()
+	}
+
+	if _, err := CacheMachine.DiskCache.Get("keep"); err != nil {
+		t.Errorf("Expected keep to stay on disk, got %v", err)
+	}
+	if _, err := CacheMachine.DiskCache.Get("key1"); err == nil {
+		t.Errorf("Expected key1 to be evicted from disk")
+	}
+	if stats := CacheMachine.Stats(); stats.DiskEvictionVetoes != 1 {
+		t.Errorf("Expected 1 veto, got %d", stats.DiskEvictionVetoes)
+	}
+}
+
 func createTempFolder() (string, error) {
 	tmpFolder, err := ioutil.TempDir("", "test")
 	if err != nil {
@@ -136,3 +283,161 @@ func removeTempFolder(tmpFolder string) {
 		fmt.Printf("error removing temp folder: %s", err)
 	}
 }
+
+func TestCacheMachine_EnableDiskCacheLocked(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	other, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	err = other.EnableDiskCache(1024, tmpFolder)
+	if !errors.Is(err, diskcache.ErrLocked) {
+		t.Errorf("Expected ErrLocked enabling a disk cache in use, got %v", err)
+	}
+
+	CacheMachine.DisableDiskCache()
+	err = other.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error once the disk cache is disabled, got %s", err)
+	}
+	other.DisableDiskCache()
+}
+
+func TestCacheMachine_EnableDiskCacheLeased(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.DiskLeaseTTL = time.Minute
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	// The directory is leased by another host.
+	leasePath := filepath.Join(tmpFolder, diskcache.LeaseFileName)
+	lease := fmt.Sprintf(`{"id":"other","pid":1,"host":"other","heartbeat_at":%q}`, time.Now().Format(time.RFC3339Nano))
+	if err := os.WriteFile(leasePath, []byte(lease), 0644); err != nil {
+		t.Fatalf("Error writing lease: %s", err)
+	}
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if !errors.Is(err, diskcache.ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld enabling a leased disk cache, got %v", err)
+	}
+
+	// The other host stopped renewing its lease.
+	lease = fmt.Sprintf(`{"id":"other","pid":1,"host":"other","heartbeat_at":%q}`, time.Now().Add(-time.Hour).Format(time.RFC3339Nano))
+	if err := os.WriteFile(leasePath, []byte(lease), 0644); err != nil {
+		t.Fatalf("Error writing lease: %s", err)
+	}
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if !errors.Is(err, diskcache.ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld enabling a disk cache with a stale lease, got %v", err)
+	}
+	CacheMachine.DiskLeaseTakeOver = true
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected the stale lease to be taken over, got %s", err)
+	}
+
+	CacheMachine.DisableDiskCache()
+	if _, err := os.Stat(leasePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the lease to be released, got %v", err)
+	}
+}
+
+func TestCacheMachine_SetFailure(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	// Too large for the RAM cache.
+	large := make([]byte, 1000)
+
+	if err := CacheMachine.Set("key1", large); err == nil {
+		t.Errorf("Expected an error setting a value too large")
+	}
+	if _, ok := CacheMachine.syncTable.get("key1"); ok {
+		t.Errorf("Expected key1 not to be tracked")
+	}
+	if stats := CacheMachine.Stats(); stats.SyncQueueDepth != 0 || stats.SetCount != 0 {
+		t.Errorf("Expected nothing queued nor counted, got %d queued and %d sets", stats.SyncQueueDepth, stats.SetCount)
+	}
+
+	// The previous entry is left as it was, whether synced or not.
+	CacheMachine.Set("key2", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.Set("key3", []byte("67890"))
+	synced, _ := CacheMachine.syncTable.get("key2")
+	for _, key := range []string{"key2", "key3"} {
+		previous, _ := CacheMachine.syncTable.get(key)
+		if err := CacheMachine.Set(key, large); err == nil {
+			t.Errorf("Expected an error setting a value too large")
+		}
+		if cacheSync, _ := CacheMachine.syncTable.get(key); cacheSync != previous {
+			t.Errorf("Expected the entry of %s to be left as it was, got %+v", key, cacheSync)
+		}
+	}
+	if cacheSync, _ := CacheMachine.syncTable.get("key2"); !cacheSync.DiskSynced || !cacheSync.SetAt.Equal(synced.SetAt) {
+		t.Errorf("Expected key2 to stay synced, got %+v", cacheSync)
+	}
+	if value, ok := CacheMachine.Get("key3"); !ok || string(value) != "67890" {
+		t.Errorf("Expected the previous value of key3, got %q, %v", value, ok)
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 1 {
+		t.Errorf("Expected only key3 to be queued, got %d", depth)
+	}
+
+	// A failed Set forgets the subjects of a key it did not store.
+	CacheMachine.SetWithOptions("key4", large, WithSubject("user1"))
+	if keys := CacheMachine.subjects.subjectKeys("user1"); len(keys) != 0 {
+		t.Errorf("Expected key4 not to be indexed, got %v", keys)
+	}
+
+	// Values not admitted to RAM are left as they were when they cannot be
+	// written to disk either.
+	CacheMachine.EnableFrequencyTracking()
+	CacheMachine.AdmissionMinFrequency = 1
+	if err := CacheMachine.Set("key5", []byte("abcde")); err != nil {
+		t.Fatalf("Expected no error setting key5 on disk, got %s", err)
+	}
+	previous, _ := CacheMachine.syncTable.get("key5")
+	if err := CacheMachine.Set("key5", make([]byte, 2000)); err == nil {
+		t.Errorf("Expected an error setting a value too large for the disk")
+	}
+	if cacheSync, _ := CacheMachine.syncTable.get("key5"); cacheSync != previous {
+		t.Errorf("Expected the entry of key5 to be left as it was, got %+v", cacheSync)
+	}
+	if value, ok := CacheMachine.Peek("key5"); !ok || string(value) != "abcde" {
+		t.Errorf("Expected the previous value of key5, got %q, %v", value, ok)
+	}
+}
@@ -0,0 +1,98 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+// ErrCorruptObject is returned when an object read from S3 does not match
+// its checksum. The object is deleted, and the read handled as a miss.
+var ErrCorruptObject = errors.New("corrupt object")
+
+// ErrUnsupportedObject is returned when an object read from S3 was written
+// with storage features this version does not support, such as a newer
+// cache machine sharing the bucket would. The object is left in place, and
+// the read handled as a miss.
+var ErrUnsupportedObject = errors.New("unsupported object")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// sealObject returns the object holding val in S3, after its entry header.
+func sealObject(val []byte) []byte {
+	return entry.Seal(val, 0, entry.CodecNone)
+}
+
+// openObject returns the value held by an object read from S3, or
+// ErrCorruptObject when it has no entry header or does not match its
+// checksum.
+func openObject(object []byte) ([]byte, error) {
+	header, val, err := entry.Open(object)
+	switch err {
+	case nil:
+	case entry.ErrNoHeader, entry.ErrCorrupt:
+		return nil, ErrCorruptObject
+	default:
+		return nil, ErrUnsupportedObject
+	}
+	if header.Flags&^(entry.FlagChecksum|entry.FlagCompressed) != 0 {
+		return nil, ErrUnsupportedObject
+	}
+	val, err = entry.Payload(header, val)
+	switch {
+	case errors.Is(err, entry.ErrUnsupportedCodec):
+		return nil, ErrUnsupportedObject
+	case err != nil:
+		return nil, ErrCorruptObject
+	}
+	return val, nil
+}
+
+// getObject reads key from store for the serving path, verifying its
+// checksum. Corrupt objects are deleted.
+func (c *CacheMachine) getObject(ctx context.Context, store ObjectStore, key string) ([]byte, error) {
+	return c.downloadObject(ctx, store, key, false)
+}
+
+// getBackgroundObject is like getObject, for the reads off the serving
+// path, which yield to the others, see S3DownloadMaxBytesPerSecond.
+func (c *CacheMachine) getBackgroundObject(ctx context.Context, store ObjectStore, key string) ([]byte, error) {
+	return c.downloadObject(ctx, store, key, true)
+}
+
+func (c *CacheMachine) downloadObject(ctx context.Context, store ObjectStore, key string, background bool) ([]byte, error) {
+	limiter := c.s3DownloadLimiter()
+	if limiter != nil {
+		if !background {
+			atomic.AddInt32(&c.interactiveDownloads, 1)
+			defer atomic.AddInt32(&c.interactiveDownloads, -1)
+		}
+		if err := c.waitDownload(ctx, limiter, background); err != nil {
+			return nil, err
+		}
+	}
+	object, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if limiter != nil {
+		limiter.reserve(len(object))
+	}
+	val, err := openObject(object)
+	if err == ErrUnsupportedObject {
+		c.Logger.Warn("skipping object stored in an unsupported format", "key", key)
+		return nil, err
+	}
+	if err != nil {
+		c.stats.recordS3Corruption()
+		c.Logger.Warn("deleting corrupt object from S3", "key", key)
+		if err := store.Delete(ctx, key); err != nil {
+			c.logError("error deleting corrupt object from S3", "key", key, "error", err)
+		}
+		return nil, err
+	}
+	return val, nil
+}
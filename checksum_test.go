@@ -0,0 +1,94 @@
+package cachemachine
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+func TestCacheMachine_Checksums(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+
+	// key1 is truncated on disk, and read from S3 instead.
+	diskCache := CacheMachine.DiskCache
+	path := filepath.Join(tmpFolder, fmt.Sprintf("%x", sha256.Sum256([]byte("key1"))))
+	if err := os.Truncate(path, 3); err != nil {
+		t.Fatalf("Error truncating %s: %s", path, err)
+	}
+	value, ok := CacheMachine.Get("key1")
+	if !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 from S3, got %q, %v", value, ok)
+	}
+	if stats := CacheMachine.Stats(); stats.DiskCorruptions != 1 || stats.S3Hits != 1 {
+		t.Errorf("Expected 1 disk corruption and 1 S3 hit, got %d and %d", stats.DiskCorruptions, stats.S3Hits)
+	}
+
+	// key2 is corrupt in S3, and gone from disk.
+	diskCache.Delete("key2")
+	object, _ := store.Get(context.Background(), "key2")
+	object[len(object)-1] ^= 0xff
+	store.Put(context.Background(), "key2", object)
+	if _, ok := CacheMachine.Get("key2"); ok {
+		t.Errorf("Expected a miss reading a corrupt object")
+	}
+	if _, err := store.Get(context.Background(), "key2"); err != ErrObjectNotFound {
+		t.Errorf("Expected the corrupt object to be deleted, got %v", err)
+	}
+	if stats := CacheMachine.Stats(); stats.S3Corruptions != 1 {
+		t.Errorf("Expected 1 S3 corruption, got %d", stats.S3Corruptions)
+	}
+}
+
+func TestOpenObject(t *testing.T) {
+	value, err := openObject(sealObject([]byte("12345")))
+	if err != nil || string(value) != "12345" {
+		t.Errorf("Expected 12345, got %q, %v", value, err)
+	}
+
+	// Objects without entry header or without checksum are corrupt.
+	if _, err := openObject([]byte("12345")); err != ErrCorruptObject {
+		t.Errorf("Expected ErrCorruptObject reading an object without header, got %v", err)
+	}
+	unchecked := sealObject([]byte("12345"))
+	unchecked[4] &^= byte(entry.FlagChecksum)
+	if _, err := openObject(unchecked); err != ErrCorruptObject {
+		t.Errorf("Expected ErrCorruptObject reading an object without checksum, got %v", err)
+	}
+
+	object := sealObject([]byte("12345"))
+	if _, err := openObject(object[:len(object)-1]); err != ErrCorruptObject {
+		t.Errorf("Expected ErrCorruptObject reading a truncated object, got %v", err)
+	}
+
+	if _, err := openObject(entry.Seal([]byte("12345"), entry.FlagEncrypted, entry.CodecNone)); err != ErrUnsupportedObject {
+		t.Errorf("Expected ErrUnsupportedObject reading an encrypted object, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package cachemachine
+
+import "time"
+
+// Temperature is the classification of an entry returned by Classify.
+type Temperature int
+
+const (
+	// Unknown entries are not in the cache, or are expired.
+	Unknown Temperature = iota
+	// Cold entries are only in S3, and cost a network round trip to read.
+	Cold
+	// Warm entries are on disk but not in RAM, and cost a file read.
+	Warm
+	// Hot entries are in RAM, and are read without IO.
+	Hot
+)
+
+func (t Temperature) String() string {
+	switch t {
+	case Cold:
+		return "cold"
+	case Warm:
+		return "warm"
+	case Hot:
+		return "hot"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify reports how expensive reading the entry for the given key would
+// be, so that applications can adapt, e.g. by choosing a cheaper rendering
+// path when an expensive asset is cold. Since the RAM cache keeps the
+// entries that are accessed the most and evicts the others, residence in RAM
+// is what tells hot entries from the others. Classify does not affect the
+// access statistics of the tiers, and the classification may be outdated as
+// soon as it is returned.
+func (c *CacheMachine) Classify(key string) Temperature {
+	cacheSync, ok := c.syncTable.get(key)
+	if !ok || cacheSync.expired(time.Now()) {
+		return Unknown
+	}
+	if _, err := c.RamCache.TTL([]byte(key)); err == nil {
+		return Hot
+	}
+	if diskCache := c.DiskCache; cacheSync.DiskSynced && diskCache != nil {
+		if _, ok := diskCache.EntrySize(key); ok {
+			return Warm
+		}
+	}
+	if cacheSync.S3Sync && c.S3Cache != nil {
+		return Cold
+	}
+	return Unknown
+}
@@ -0,0 +1,42 @@
+package cachemachine
+
+import "testing"
+
+func TestCacheMachine_Classify(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(newMemoryStore())
+
+	CacheMachine.Set("hot", []byte("12345"))
+	CacheMachine.Set("warm", []byte("12345"))
+	CacheMachine.Set("cold", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("warm"))
+	CacheMachine.RamCache.Del([]byte("cold"))
+	CacheMachine.DiskCache.Delete("cold")
+
+	for key, expected := range map[string]Temperature{
+		"hot":     Hot,
+		"warm":    Warm,
+		"cold":    Cold,
+		"missing": Unknown,
+	} {
+		if temperature := CacheMachine.Classify(key); temperature != expected {
+			t.Errorf("Expected %s to be %s, got %s", key, expected, temperature)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package cachemachine
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cdemers/cachemachine/diskcache"
+	"github.com/cdemers/cachemachine/entry"
+)
+
+// compression is how the values of a namespace are compressed on disk and
+// in S3.
+type compression struct {
+	codec   entry.Codec
+	minSize int
+}
+
+// compressionPolicies maps namespaces to their compression. The map is
+// replaced rather than updated, so that writes do not lock.
+type compressionPolicies struct {
+	mu       sync.Mutex
+	policies atomic.Value
+}
+
+func (p *compressionPolicies) get(namespace string) (compression, bool) {
+	policies, _ := p.policies.Load().(map[string]compression)
+	policy, ok := policies[namespace]
+	return policy, ok
+}
+
+func (p *compressionPolicies) set(namespace string, policy *compression) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous, _ := p.policies.Load().(map[string]compression)
+	policies := make(map[string]compression, len(previous)+1)
+	for name, policy := range previous {
+		policies[name] = policy
+	}
+	if policy == nil {
+		delete(policies, namespace)
+	} else {
+		policies[namespace] = *policy
+	}
+	p.policies.Store(policies)
+}
+
+// SetNamespaceCompression makes the values of namespace, see KeyNamespace,
+// of at least minSize bytes be compressed with codec when written to disk
+// and to S3, such as gzip for JSON documents. Values stay uncompressed in
+// RAM, and are decompressed on reads. Values that compressing does not make
+// smaller are stored uncompressed, but compressing them still costs CPU, so
+// the namespaces of already compressed data, such as images, are better
+// left with entry.CodecNone.
+//
+// Codecs other than gzip must be registered with entry.RegisterCompressor
+// first, by every program sharing the disk cache or the bucket: the disk
+// entries compressed with a codec the reading program does not support are
+// removed as corrupt, and such objects in S3 are skipped.
+func (c *CacheMachine) SetNamespaceCompression(namespace string, codec entry.Codec, minSize int) error {
+	if !entry.Supported(codec) {
+		return fmt.Errorf("codec %d has no compressor registered", codec)
+	}
+	if minSize < 0 {
+		return fmt.Errorf("minSize must not be negative")
+	}
+	c.compression.set(namespace, &compression{codec: codec, minSize: minSize})
+	return nil
+}
+
+// ClearNamespaceCompression stops compressing the values of namespace
+// written from then on. The values already compressed stay readable.
+func (c *CacheMachine) ClearNamespaceCompression(namespace string) {
+	c.compression.set(namespace, nil)
+}
+
+// compressionCodec returns the codec val, the value of key, is compressed
+// with. The values compressed at their origin, see SetEncoded, are not
+// compressed again.
+func (c *CacheMachine) compressionCodec(key string, val []byte) entry.Codec {
+	policy, ok := c.compression.get(KeyNamespace(key))
+	if !ok || len(val) < policy.minSize || contentEncoded(val) {
+		return entry.CodecNone
+	}
+	return policy.codec
+}
+
+// putDisk writes val to diskCache against key, compressed as set for its
+// namespace.
+func (c *CacheMachine) putDisk(diskCache *diskcache.Cache, key string, val []byte) error {
+	return diskCache.PutCompressed(key, val, c.compressionCodec(key, val))
+}
+
+// sealObject returns the object holding val in S3 against key, after its
+// entry header, compressed as set for its namespace. Values that cannot be
+// compressed are stored as is.
+func (c *CacheMachine) sealObject(key string, val []byte) []byte {
+	codec := c.compressionCodec(key, val)
+	if codec == entry.CodecNone {
+		return sealObject(val)
+	}
+	compressed, err := entry.Compress(codec, val)
+	if err != nil || len(compressed) >= len(val) {
+		return sealObject(val)
+	}
+	return entry.Seal(compressed, entry.FlagCompressed, codec)
+}
@@ -0,0 +1,85 @@
+package cachemachine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+func TestCacheMachine_NamespaceCompression(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	if err := CacheMachine.SetNamespaceCompression("api", entry.CodecZstd, 0); err == nil {
+		t.Errorf("Expected an error setting an unsupported codec")
+	}
+	if err := CacheMachine.SetNamespaceCompression("api", entry.CodecGzip, 100); err != nil {
+		t.Fatalf("Expected no error setting the compression of api, got %s", err)
+	}
+
+	document := []byte(strings.Repeat(`{"id":1,"name":"item"}`, 20))
+	CacheMachine.Set("api:large", document)
+	CacheMachine.Set("api:small", []byte(`{"id":1}`))
+	CacheMachine.Set("images:large", document)
+	CacheMachine.Flush()
+
+	for _, c := range []struct {
+		key        string
+		compressed bool
+	}{
+		{key: "api:large", compressed: true},
+		{key: "api:small", compressed: false},
+		{key: "images:large", compressed: false},
+	} {
+		size, _ := CacheMachine.DiskCache.EntrySize(c.key)
+		object, _ := store.Get(context.Background(), c.key)
+		h, _, err := entry.Open(object)
+		if err != nil {
+			t.Fatalf("Expected no error opening the object of %s, got %s", c.key, err)
+		}
+		if compressed := h.Flags&entry.FlagCompressed != 0; compressed != c.compressed {
+			t.Errorf("Expected the object of %s to be compressed %v, got %v", c.key, c.compressed, compressed)
+		}
+		if compressed := size < int64(len(document)) && c.key != "api:small"; compressed != c.compressed {
+			t.Errorf("Expected %s to be compressed on disk %v, got %d bytes", c.key, c.compressed, size)
+		}
+	}
+
+	// Compressed values are read back from disk and from S3.
+	CacheMachine.ClearRamCache()
+	if value, ok := CacheMachine.Get("api:large"); !ok || string(value) != string(document) {
+		t.Errorf("Expected api:large from disk, got %d bytes, %v", len(value), ok)
+	}
+	CacheMachine.ClearRamCache()
+	CacheMachine.DiskCache.Delete("api:large")
+	if value, ok := CacheMachine.Get("api:large"); !ok || string(value) != string(document) {
+		t.Errorf("Expected api:large from S3, got %d bytes, %v", len(value), ok)
+	}
+
+	// Values set after the compression is cleared are stored as is.
+	CacheMachine.ClearNamespaceCompression("api")
+	CacheMachine.Set("api:other", document)
+	CacheMachine.Flush()
+	if size, _ := CacheMachine.DiskCache.EntrySize("api:other"); size != int64(len(document)) {
+		t.Errorf("Expected api:other to be stored as is, got %d bytes", size)
+	}
+}
@@ -0,0 +1,88 @@
+package cachemachine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetIfAbsent sets the value for the given key only if the key is not
+// already cached in any tier. It returns true if the value was set.
+func (c *CacheMachine) SetIfAbsent(key string, val []byte) (bool, error) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	_, ok, err := c.lookup(shard, key)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return false, nil
+	}
+	if err := c.set(shard, key, val, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap sets the value for the given key to new only if its current
+// value is equal to old. A missing key never matches. It returns true if the
+// value was swapped. The swapped value keeps the expiry of the entry.
+func (c *CacheMachine) CompareAndSwap(key string, old, new []byte) (bool, error) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	current, ok, err := c.lookup(shard, key)
+	if err != nil {
+		return false, err
+	}
+	if !ok || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	if err := c.set(shard, key, new, c.remainingTTL(shard, key)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// remainingTTL returns the time left before the entry for key expires, or 0
+// when it does not expire, so that replacing its value keeps its expiry. It
+// must be called with the stripe of the key locked.
+func (c *CacheMachine) remainingTTL(shard *syncTableShard, key string) time.Duration {
+	expiresAt := shard.entries[key].ExpiresAt
+	if expiresAt.IsZero() {
+		return 0
+	}
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		return ttl
+	}
+	return time.Nanosecond
+}
+
+// lookup returns the value for the given key from the RAM cache, falling back
+// to the disk and S3 tiers, within the Get default timeout. It returns an
+// error only when a tier could not tell whether it holds the key. It must
+// be called with the stripe of the key locked.
+func (c *CacheMachine) lookup(shard *syncTableShard, key string) ([]byte, bool, error) {
+	cacheSync := shard.entries[key]
+	if cacheSync.expired(time.Now()) {
+		return nil, false, nil
+	}
+	value, err := c.RamCache.Get([]byte(key))
+	if err == nil {
+		return value, true, nil
+	}
+	ctx, cancel := withDefaultTimeout(context.Background(), c.DefaultTimeouts.Get)
+	defer cancel()
+	value, err = c.readLocked(ctx, shard, key, cacheSync)
+	if err == ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading key %s: %s", key, err)
+	}
+	return value, true, nil
+}
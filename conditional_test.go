@@ -0,0 +1,146 @@
+package cachemachine
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SetIfAbsent(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set, err := CacheMachine.SetIfAbsent("lock", []byte("owner"))
+			if err != nil {
+				t.Errorf("Expected no error setting lock, got %s", err)
+			}
+			if set {
+				atomic.AddInt32(&wins, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Errorf("Expected exactly one SetIfAbsent to succeed, got %d", wins)
+	}
+}
+
+func TestCacheMachine_CompareAndSwap(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	swapped, err := CacheMachine.CompareAndSwap("key1", nil, []byte("12345"))
+	if err != nil || swapped {
+		t.Errorf("Expected no swap on a missing key, got %v, %v", swapped, err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+
+	swapped, err = CacheMachine.CompareAndSwap("key1", []byte("00000"), []byte("67890"))
+	if err != nil || swapped {
+		t.Errorf("Expected no swap with a wrong old value, got %v, %v", swapped, err)
+	}
+
+	swapped, err = CacheMachine.CompareAndSwap("key1", []byte("12345"), []byte("67890"))
+	if err != nil || !swapped {
+		t.Errorf("Expected swap with the right old value, got %v, %v", swapped, err)
+	}
+
+	value, _ := CacheMachine.Get("key1")
+	if string(value) != "67890" {
+		t.Errorf("Expected value to be 67890, got %s", value)
+	}
+}
+
+func TestCacheMachine_ConditionalS3(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	err = CacheMachine.EnableS3Cache(newMemoryStore())
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.Set("lock", []byte("owner"))
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("counter", []byte("5"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	// The keys are only held by S3.
+	for _, key := range []string{"lock", "key1", "counter"} {
+		CacheMachine.RamCache.Del([]byte(key))
+		CacheMachine.DiskCache.Delete(key)
+	}
+
+	set, err := CacheMachine.SetIfAbsent("lock", []byte("other"))
+	if err != nil || set {
+		t.Errorf("Expected no set on a key held by S3, got %v, %v", set, err)
+	}
+
+	swapped, err := CacheMachine.CompareAndSwap("key1", []byte("12345"), []byte("67890"))
+	if err != nil || !swapped {
+		t.Errorf("Expected swap of a key held by S3, got %v, %v", swapped, err)
+	}
+
+	value, err := CacheMachine.Increment("counter", 1)
+	if err != nil || value != 6 {
+		t.Errorf("Expected counter held by S3 to be incremented to 6, got %d, %v", value, err)
+	}
+}
+
+func TestCacheMachine_CompareAndSwapKeepsTTL(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	CacheMachine.SetWithTTL("key1", []byte("old"), time.Minute)
+	expiresAt := CacheMachine.Where("key1").ExpiresAt
+	swapped, err := CacheMachine.CompareAndSwap("key1", []byte("old"), []byte("new"))
+	if err != nil || !swapped {
+		t.Errorf("Expected key1 to be swapped, got %t, %v", swapped, err)
+	}
+	if got := CacheMachine.Where("key1").ExpiresAt; got.Before(expiresAt.Add(-10*time.Millisecond)) || got.After(expiresAt.Add(10*time.Millisecond)) {
+		t.Errorf("Expected key1 to keep expiring at %s, got %s", expiresAt, got)
+	}
+	if ttl, err := CacheMachine.RamCache.TTL([]byte("key1")); err != nil || ttl == 0 {
+		t.Errorf("Expected key1 to expire from RAM, got a TTL of %d, %v", ttl, err)
+	}
+
+	// Once expired, key1 no longer matches.
+	shard := CacheMachine.syncTable.shard("key1")
+	shard.Lock()
+	cacheSync := shard.entries["key1"]
+	cacheSync.ExpiresAt = time.Now().Add(-time.Second)
+	shard.entries["key1"] = cacheSync
+	shard.Unlock()
+	swapped, err = CacheMachine.CompareAndSwap("key1", []byte("new"), []byte("newer"))
+	if err != nil || swapped {
+		t.Errorf("Expected an expired key1 not to be swapped, got %t, %v", swapped, err)
+	}
+}
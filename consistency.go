@@ -0,0 +1,124 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// ConsistencyMismatch describes a key whose copy in a tier differs from
+// its copy in RAM.
+type ConsistencyMismatch struct {
+	Key          string
+	Tier         string
+	RamChecksum  uint32
+	DiskChecksum uint32
+	S3Checksum   uint32
+	Reason       string
+}
+
+// ConsistencyReport is the result of VerifyConsistency.
+type ConsistencyReport struct {
+	Sampled    int
+	Mismatches []ConsistencyMismatch
+}
+
+// VerifyConsistency samples the keys that are synced to disk or to S3 and
+// still present in RAM, and compares the checksum of the RAM copy with the
+// ones of the disk and S3 copies. sampleRate is the fraction of keys to
+// check, in the ]0, 1] range. Keys that are modified while being verified
+// are skipped, since their synced copies are expected to be stale until the
+// next sync. The S3 copies are read as background downloads, see
+// S3DownloadMaxBytesPerSecond, and verified against their own checksum as on
+// any read.
+func (c *CacheMachine) VerifyConsistency(ctx context.Context, sampleRate float64) (ConsistencyReport, error) {
+	var report ConsistencyReport
+
+	if sampleRate <= 0 || sampleRate > 1 {
+		return report, fmt.Errorf("sampleRate must be greater than 0 and at most 1")
+	}
+	if c.DiskCache == nil {
+		return report, fmt.Errorf("disk cache is not enabled")
+	}
+
+	var keys, synced []string
+	seeded := c.seededRandom() != nil
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		synced = synced[:0]
+		shard.Lock()
+		for key, cacheSync := range shard.entries {
+			if cacheSync.DiskSynced || cacheSync.S3Sync {
+				synced = append(synced, key)
+			}
+		}
+		shard.Unlock()
+		// The keys are sampled in a set order when a seed is set, see
+		// SetRandomSeed.
+		if seeded {
+			sort.Strings(synced)
+		}
+		for _, key := range synced {
+			if c.randomFloat64() < sampleRate {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		before, _ := c.syncTable.get(key)
+		ramValue, err := c.RamCache.Get([]byte(key))
+		if err != nil {
+			continue
+		}
+		var diskValue, s3Value []byte
+		var diskErr, s3Err error
+		if before.DiskSynced {
+			diskValue, diskErr = c.DiskCache.Get(key)
+		}
+		s3Cache := c.S3Cache
+		if before.S3Sync && s3Cache != nil {
+			s3Value, s3Err = c.getBackgroundObject(ctx, s3Cache, key)
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+		}
+
+		if after, _ := c.syncTable.get(key); !after.SetAt.Equal(before.SetAt) {
+			continue
+		}
+
+		report.Sampled++
+		ramChecksum := crc32.ChecksumIEEE(ramValue)
+		if before.DiskSynced {
+			mismatch := ConsistencyMismatch{Key: key, Tier: string(tierDisk), RamChecksum: ramChecksum}
+			if diskErr != nil {
+				mismatch.Reason = fmt.Sprintf("error reading from disk: %s", diskErr)
+				report.Mismatches = append(report.Mismatches, mismatch)
+			} else if mismatch.DiskChecksum = crc32.ChecksumIEEE(diskValue); mismatch.DiskChecksum != ramChecksum {
+				mismatch.Reason = "checksum mismatch"
+				report.Mismatches = append(report.Mismatches, mismatch)
+			}
+		}
+		if before.S3Sync && s3Cache != nil {
+			mismatch := ConsistencyMismatch{Key: key, Tier: string(tierS3), RamChecksum: ramChecksum}
+			if s3Err != nil {
+				mismatch.Reason = fmt.Sprintf("error reading from S3: %s", s3Err)
+				report.Mismatches = append(report.Mismatches, mismatch)
+			} else if mismatch.S3Checksum = crc32.ChecksumIEEE(s3Value); mismatch.S3Checksum != ramChecksum {
+				mismatch.Reason = "checksum mismatch"
+				report.Mismatches = append(report.Mismatches, mismatch)
+			}
+		}
+	}
+
+	if len(report.Mismatches) > 0 {
+		c.Logger.Warn("found inconsistent items", "mismatches", len(report.Mismatches), "sampled", report.Sampled)
+	}
+	return report, nil
+}
@@ -0,0 +1,112 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCacheMachine_VerifyConsistency(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	_, err = CacheMachine.VerifyConsistency(context.Background(), 1)
+	if err == nil {
+		t.Errorf("Expected error verifying consistency without a disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Set("key3", []byte("abcde"))
+	CacheMachine.SyncRamCacheToDiskCache()
+
+	// Corrupt the disk copy of key2 and lose the one of key3.
+	CacheMachine.DiskCache.Put("key2", []byte("00000"))
+	CacheMachine.DiskCache.Delete("key3")
+
+	_, err = CacheMachine.VerifyConsistency(context.Background(), 0)
+	if err == nil {
+		t.Errorf("Expected error verifying consistency with a 0 sample rate")
+	}
+
+	report, err := CacheMachine.VerifyConsistency(context.Background(), 1)
+	if err != nil {
+		t.Errorf("Expected no error verifying consistency, got %s", err)
+	}
+	if report.Sampled != 3 {
+		t.Errorf("Expected 3 sampled keys, got %d", report.Sampled)
+	}
+	if len(report.Mismatches) != 2 {
+		t.Errorf("Expected 2 mismatches, got %+v", report.Mismatches)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = CacheMachine.VerifyConsistency(ctx, 1)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCacheMachine_VerifyConsistencyS3(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	err = CacheMachine.EnableS3Cache(store)
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Set("key3", []byte("abcde"))
+	CacheMachine.SyncRamCacheToDiskCache()
+
+	// Replace the S3 copy of key2 and lose the one of key3.
+	store.Put(context.Background(), "key2", CacheMachine.sealObject("key2", []byte("00000")))
+	store.Delete(context.Background(), "key3")
+
+	report, err := CacheMachine.VerifyConsistency(context.Background(), 1)
+	if err != nil {
+		t.Errorf("Expected no error verifying consistency, got %s", err)
+	}
+	if report.Sampled != 3 {
+		t.Errorf("Expected 3 sampled keys, got %d", report.Sampled)
+	}
+	if len(report.Mismatches) != 2 {
+		t.Fatalf("Expected 2 mismatches, got %+v", report.Mismatches)
+	}
+	for _, mismatch := range report.Mismatches {
+		if mismatch.Tier != "s3" {
+			t.Errorf("Expected a mismatch of the S3 tier, got %+v", mismatch)
+		}
+	}
+}
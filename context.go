@@ -0,0 +1,200 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+)
+
+// GetCtx is like Get, but gives up waiting on the cold tiers when ctx is
+// done, returning the error of ctx. The RAM cache is always consulted, since
+// it answers without blocking.
+func (c *CacheMachine) GetCtx(ctx context.Context, key string) (value []byte, ok bool, err error) {
+	return c.getCtx(ctx, "cachemachine.Get", key, false)
+}
+
+// GetLocalCtx is like GetCtx, but never reads the S3 tier, for callers that
+// read S3 themselves on a miss, such as the peers of a fleet sharing an S3
+// bucket.
+func (c *CacheMachine) GetLocalCtx(ctx context.Context, key string) (value []byte, ok bool, err error) {
+	return c.getCtx(ctx, "cachemachine.GetLocal", key, true)
+}
+
+func (c *CacheMachine) getCtx(ctx context.Context, operation, key string, local bool) (value []byte, ok bool, err error) {
+	ctx, span := c.startSpan(ctx, operation, key)
+	value, t, err := c.get(ctx, key, local)
+	if span != nil {
+		span.SetAttribute(AttributeTier, string(t))
+		span.SetAttribute(AttributeHit, t != "")
+		span.SetAttribute(AttributeBytes, int64(len(value)))
+		if err == ErrNegativeHit {
+			span.SetAttribute(AttributeNegative, true)
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
+		}
+	}
+	return value, t != "", err
+}
+
+// get returns the value of key and the tier it was found in, or an empty
+// tier on a miss. The S3 tier is not read when local is set.
+func (c *CacheMachine) get(ctx context.Context, key string, local bool) ([]byte, tier, error) {
+	if sketch := c.frequencySketch(); sketch != nil {
+		sketch.record(key)
+	}
+	c.recordReaccessRead(key)
+	value, err := c.RamCache.Get([]byte(key))
+	if err == nil {
+		c.stats.recordRamHit(len(value))
+		c.namespaceCounters(key).recordRamHit()
+		c.recordUsageHit(key)
+		c.recordRamRead(key)
+		c.recordIdleRead(key)
+		c.slideExpiration(key)
+		return value, tierRAM, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	// The default deadline only bounds the reads of the cold tiers, so that
+	// RAM hits do not pay for a timer.
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Get)
+	defer cancel()
+
+	cacheSync, _ := c.syncTable.get(key)
+	if local {
+		cacheSync.S3Sync = false
+	}
+	if cacheSync.expired(time.Now()) {
+		c.expireLazily(key, cacheSync)
+	} else if cacheSync.Negative {
+		c.stats.recordNegativeHit()
+		return nil, "", ErrNegativeHit
+	} else {
+		var t tier
+		value, t, err = c.readColdTiers(ctx, key, cacheSync)
+		if err != nil {
+			return nil, "", err
+		}
+		switch t {
+		case tierDisk:
+			c.stats.recordDiskHit(len(value))
+			c.namespaceCounters(key).recordDiskHit()
+			c.recordUsageHit(key)
+			c.promote(key, value, cacheSync)
+			c.recordIdleRead(key)
+			c.slideExpiration(key)
+			return value, t, nil
+		case tierS3:
+			c.stats.recordS3Hit(len(value))
+			c.namespaceCounters(key).recordS3Hit()
+			c.recordUsageHit(key)
+			c.refreshStale(key, cacheSync)
+			c.promote(key, value, cacheSync)
+			c.recordIdleRead(key)
+			c.slideExpiration(key)
+			return value, t, nil
+		}
+	}
+
+	c.stats.recordMiss()
+	c.namespaceCounters(key).recordMiss()
+	return nil, "", nil
+}
+
+// SetCtx is like Set, but does nothing and returns the error of ctx if ctx is
+// already done.
+func (c *CacheMachine) SetCtx(ctx context.Context, key string, val []byte) (err error) {
+	_, span := c.startSpan(ctx, "cachemachine.Set", key)
+	if span != nil {
+		span.SetAttribute(AttributeTier, string(tierRAM))
+		span.SetAttribute(AttributeBytes, int64(len(val)))
+		defer func() { endSpan(span, err) }()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	return c.set(shard, key, val, 0)
+}
+
+// DeleteCtx is like Delete, but does nothing and returns the error of ctx if
+// ctx is already done. ctx bounds the deletion from the S3 tier.
+func (c *CacheMachine) DeleteCtx(ctx context.Context, key string) (deleted bool, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Delete)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "cachemachine.Delete", key)
+	if span != nil {
+		defer func() {
+			span.SetAttribute(AttributeDeleted, deleted)
+			endSpan(span, err)
+		}()
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if c.legalHolds.held(key) {
+		return false, ErrLegalHold
+	}
+	return c.deleteLocked(ctx, shard, key), nil
+}
+
+// deleteLocked deletes key from every tier. It must be called with the
+// stripe of the key locked.
+func (c *CacheMachine) deleteLocked(ctx context.Context, shard *syncTableShard, key string) bool {
+	c.preserveForSnapshots(shard, key)
+	deleted := c.RamCache.Del([]byte(key))
+	if c.DiskCache != nil {
+		deletedFromDisk, err := c.DiskCache.Delete(key)
+		if err != nil {
+			c.logError("error deleting from disk", "key", key, "error", err)
+		}
+		deleted = deleted || deletedFromDisk
+	}
+	if cacheSync, ok := shard.entries[key]; ok && cacheSync.S3Sync && c.S3Cache != nil {
+		if err := c.S3Cache.Delete(ctx, key); err != nil {
+			c.logError("error deleting from S3", "key", key, "error", err)
+		}
+		deleted = true
+	}
+	delete(shard.entries, key)
+	c.subjects.forget(key)
+	c.invalidate(key)
+	return deleted
+}
+
+// withContext runs read and returns its result, or the error of ctx if ctx is
+// done first. read must itself watch ctx to stop its IO; withContext only
+// spares the caller the wait for read to notice, which can be long when a
+// disk is degraded.
+func withContext(ctx context.Context, read func() ([]byte, error)) ([]byte, error) {
+	if ctx.Done() == nil {
+		return read()
+	}
+
+	type result struct {
+		value []byte
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		value, err := read()
+		results <- result{value, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_GetCtx(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	ctx := context.Background()
+	err = CacheMachine.SetCtx(ctx, "key1", []byte("12345"))
+	if err != nil {
+		t.Errorf("Expected no error setting key1, got %s", err)
+	}
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("key1"))
+
+	value, ok, err := CacheMachine.GetCtx(ctx, "key1")
+	if err != nil || !ok || string(value) != "12345" {
+		t.Errorf("Expected value to be 12345 from disk, got %s, %v, %v", value, ok, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, ok, err = CacheMachine.GetCtx(cancelled, "key1")
+	if err != context.Canceled || ok {
+		t.Errorf("Expected context.Canceled getting key1, got %v, %v", ok, err)
+	}
+	err = CacheMachine.SetCtx(cancelled, "key2", []byte("67890"))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled setting key2, got %v", err)
+	}
+	_, err = CacheMachine.DeleteCtx(cancelled, "key1")
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled deleting key1, got %v", err)
+	}
+
+	deleted, err := CacheMachine.DeleteCtx(ctx, "key1")
+	if err != nil || !deleted {
+		t.Errorf("Expected key1 to be deleted, got %v, %v", deleted, err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+	_, err := withContext(ctx, func() ([]byte, error) {
+		<-release
+		return []byte("late"), nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
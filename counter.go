@@ -0,0 +1,57 @@
+package cachemachine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ErrNotAnInteger is returned by Increment and Decrement when the value
+// stored for the key is not a base 10 integer.
+var ErrNotAnInteger = errors.New("value is not an integer")
+
+// Increment atomically adds delta to the integer stored for the given key and
+// returns the new value. A missing key is treated as 0. An existing counter
+// keeps its expiry. Counters are stored as base 10 strings, so they can also
+// be read with Get and are synced to disk like any other value.
+func (c *CacheMachine) Increment(key string, delta int64) (int64, error) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	var current int64
+	var ttl time.Duration
+	value, ok, err := c.lookup(shard, key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		ttl = c.remainingTTL(shard, key)
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error incrementing key %s: %w", key, ErrNotAnInteger)
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, fmt.Errorf("error incrementing key %s: integer overflow", key)
+	}
+	current += delta
+
+	err = c.set(shard, key, []byte(strconv.FormatInt(current, 10)), ttl)
+	if err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// Decrement atomically subtracts delta from the integer stored for the given
+// key and returns the new value. See Increment.
+func (c *CacheMachine) Decrement(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, fmt.Errorf("error decrementing key %s: integer overflow", key)
+	}
+	return c.Increment(key, -delta)
+}
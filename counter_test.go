@@ -0,0 +1,97 @@
+package cachemachine
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_Increment(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	value, err := CacheMachine.Increment("counter", 5)
+	if err != nil || value != 5 {
+		t.Errorf("Expected counter to be 5, got %d, %v", value, err)
+	}
+
+	value, err = CacheMachine.Decrement("counter", 7)
+	if err != nil || value != -2 {
+		t.Errorf("Expected counter to be -2, got %d, %v", value, err)
+	}
+
+	stored, ok := CacheMachine.Get("counter")
+	if !ok || string(stored) != "-2" {
+		t.Errorf("Expected stored counter to be -2, got %s", stored)
+	}
+
+	CacheMachine.Set("text", []byte("abcde"))
+	_, err = CacheMachine.Increment("text", 1)
+	if !errors.Is(err, ErrNotAnInteger) {
+		t.Errorf("Expected ErrNotAnInteger incrementing text, got %v", err)
+	}
+
+	CacheMachine.Increment("max", math.MaxInt64)
+	_, err = CacheMachine.Increment("max", 1)
+	if err == nil {
+		t.Errorf("Expected overflow error incrementing max")
+	}
+}
+
+func TestCacheMachine_IncrementConcurrent(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				CacheMachine.Increment("counter", 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, err := CacheMachine.Increment("counter", 0)
+	if err != nil || value != 1000 {
+		t.Errorf("Expected counter to be 1000, got %d, %v", value, err)
+	}
+}
+
+func TestCacheMachine_IncrementKeepsTTL(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	CacheMachine.SetWithTTL("counter", []byte("1"), time.Second)
+	expiresAt := CacheMachine.Where("counter").ExpiresAt
+	value, err := CacheMachine.Increment("counter", 1)
+	if err != nil || value != 2 {
+		t.Errorf("Expected counter to be 2, got %d, %v", value, err)
+	}
+	if got := CacheMachine.Where("counter").ExpiresAt; got.Before(expiresAt.Add(-10*time.Millisecond)) || got.After(expiresAt.Add(10*time.Millisecond)) {
+		t.Errorf("Expected counter to keep expiring at %s, got %s", expiresAt, got)
+	}
+
+	// RAM expiries have a granularity of a second.
+	time.Sleep(2100 * time.Millisecond)
+	if _, ok := CacheMachine.Get("counter"); ok {
+		t.Errorf("Expected counter to expire after its increment")
+	}
+	value, err = CacheMachine.Increment("counter", 1)
+	if err != nil || value != 1 {
+		t.Errorf("Expected an expired counter to restart at 1, got %d, %v", value, err)
+	}
+	if !CacheMachine.Where("counter").ExpiresAt.IsZero() {
+		t.Errorf("Expected a restarted counter not to expire")
+	}
+}
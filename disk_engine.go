@@ -0,0 +1,33 @@
+package cachemachine
+
+import "github.com/cdemers/cachemachine/diskcache"
+
+// DiskEngine is the storage engine of a disk tier, as seen by the cache
+// machine when it drains the tier into another one, see SwapDiskEngine and
+// DrainDiskEngine. diskcache.Cache implements it, and so can the other
+// engines the disk tier is migrated away from, such as one backed by bbolt
+// or by a segment log.
+type DiskEngine interface {
+	// Dir returns the location of the engine, as reported in the logs and
+	// events.
+	Dir() string
+
+	// Entries returns the metadata of every entry, in the order they are
+	// to be migrated, and Stat the metadata of the entry for key.
+	Entries() []diskcache.Meta
+	Stat(key string) (diskcache.Meta, bool)
+
+	// Peek returns the value stored against key, without affecting its
+	// recency, or diskcache.ErrNotFound.
+	Peek(key string) ([]byte, error)
+
+	// Delete removes the entry for key, reporting whether it existed, and
+	// Clear removes every entry.
+	Delete(key string) (bool, error)
+	Clear() error
+
+	// Close releases the engine once drained.
+	Close() error
+}
+
+var _ DiskEngine = (*diskcache.Cache)(nil)
@@ -0,0 +1,66 @@
+package cachemachine
+
+import (
+	"os"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// DefaultDiskMaxItems is the default number of entries the disk cache
+// holds at most, see WithDiskMaxItems.
+const DefaultDiskMaxItems = 1024
+
+// DiskOption tunes the disk engine enabled by EnableDiskCache.
+type DiskOption func(*diskOptions)
+
+type diskOptions struct {
+	maxItems int64
+	layout   diskcache.Options
+}
+
+func newDiskOptions(opts []DiskOption) diskOptions {
+	o := diskOptions{maxItems: DefaultDiskMaxItems}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithDiskMaxItems sets the number of entries the disk cache holds at most,
+// DefaultDiskMaxItems by default. The least recently used entries are
+// evicted beyond it, even if the disk cache has room left, so it should be
+// raised along with the size of large disk caches.
+func WithDiskMaxItems(n int64) DiskOption {
+	return func(o *diskOptions) {
+		o.maxItems = n
+	}
+}
+
+// WithDiskShardDepth spreads the files of the entries over depth levels of
+// subdirectories, so that the directories stay small when the disk cache
+// holds many entries, see diskcache.Options.ShardDepth.
+func WithDiskShardDepth(depth int) DiskOption {
+	return func(o *diskOptions) {
+		o.layout.ShardDepth = depth
+	}
+}
+
+// WithDiskFileMode sets the permissions of the files of the entries and of
+// the directories of the disk cache, 0644 and 0755 by default.
+func WithDiskFileMode(fileMode, dirMode os.FileMode) DiskOption {
+	return func(o *diskOptions) {
+		o.layout.FileMode = fileMode
+		o.layout.DirMode = dirMode
+	}
+}
+
+// WithDiskInlineSize keeps the values of at most size bytes, once
+// compressed, in the index of the disk cache rather than in files of their
+// own, which spares a file and its IO per tiny value, see
+// diskcache.Options.InlineSize. Inline values use memory rather than disk,
+// so size should stay small.
+func WithDiskInlineSize(size int) DiskOption {
+	return func(o *diskOptions) {
+		o.layout.InlineSize = size
+	}
+}
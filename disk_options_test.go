@@ -0,0 +1,79 @@
+package cachemachine
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheMachine_DiskOptions(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10*1024*1024, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder, WithDiskMaxItems(2000), WithDiskShardDepth(1))
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for i := 0; i < 1500; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte("12345"))
+	}
+	CacheMachine.Flush()
+	if n := CacheMachine.DiskCache.Len(); n != 1500 {
+		t.Errorf("Expected 1500 entries on disk, got %d", n)
+	}
+	if stats := CacheMachine.Stats(); stats.DiskEvictions != 0 {
+		t.Errorf("Expected no eviction from disk, got %d", stats.DiskEvictions)
+	}
+
+	residency := CacheMachine.Where("key1")
+	if len(residency.Copies) != 2 || residency.Copies[1].Path == "" {
+		t.Fatalf("Expected key1 on disk, got %+v", residency)
+	}
+	if dir := filepath.Dir(filepath.Dir(residency.Copies[1].Path)); dir != filepath.Clean(tmpFolder) {
+		t.Errorf("Expected key1 to be stored in a shard directory, got %s", residency.Copies[1].Path)
+	}
+
+	// The default is 1024 entries.
+	CacheMachine.DisableDiskCache()
+	if err := CacheMachine.EnableDiskCache(1024*1024, tmpFolder); err != nil {
+		t.Fatalf("Expected no error enabling disk cache, got %s", err)
+	}
+	CacheMachine.ClearDiskCache()
+	for i := 0; i < 1500; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte("12345"))
+	}
+	CacheMachine.Flush()
+	if n := CacheMachine.DiskCache.Len(); n != DefaultDiskMaxItems {
+		t.Errorf("Expected %d entries on disk, got %d", DefaultDiskMaxItems, n)
+	}
+
+	// Tiny values are kept inline rather than in files.
+	CacheMachine.DisableDiskCache()
+	if err := CacheMachine.EnableDiskCache(1024*1024, tmpFolder, WithDiskInlineSize(8)); err != nil {
+		t.Fatalf("Expected no error enabling disk cache, got %s", err)
+	}
+	CacheMachine.ClearDiskCache()
+	CacheMachine.Set("tiny", []byte("12345"))
+	CacheMachine.Set("large", []byte("1234567890"))
+	CacheMachine.Flush()
+	if meta, _ := CacheMachine.DiskCache.Stat("tiny"); !meta.Inline {
+		t.Errorf("Expected tiny to be kept inline, got %+v", meta)
+	}
+	if meta, _ := CacheMachine.DiskCache.Stat("large"); meta.Inline {
+		t.Errorf("Expected large to be stored in a file, got %+v", meta)
+	}
+	CacheMachine.ClearRamCache()
+	if value, ok := CacheMachine.Get("tiny"); !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 from disk, got %q, %v", value, ok)
+	}
+}
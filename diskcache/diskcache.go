@@ -1 +1,694 @@
+// Package diskcache implements a size-bounded, LRU-evicted blob store backed
+// by one file per entry in a directory. It fills the same role as stash, but
+// it also supports removing individual entries and wiping the whole store,
+// which is required to actually reclaim disk space.
 package diskcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrBadDir   = errors.New("invalid directory")
+	ErrBadSize  = errors.New("storage size must be greater than zero")
+	ErrBadCap   = errors.New("item count must be greater than zero")
+	ErrTooLarge = errors.New("item size must be less or equal storage size")
+	ErrBadDepth = errors.New("shard depth must be between 0 and 4")
+
+	// ErrCorrupt is returned when the file of an entry does not match its
+	// checksum, such as a file truncated by a power loss. The entry is
+	// removed.
+	ErrCorrupt = errors.New("corrupt entry")
+)
+
+// Meta describes an entry stored on disk.
+type Meta struct {
+	Key       string
+	Size      int64
+	Path      string
+	CreatedAt time.Time
+
+	// Inline is true for the entries small enough to be kept in the index
+	// rather than in a file, see Options.InlineSize. Their Path is empty.
+	Inline bool
+
+	// data is the header and payload of an inline entry.
+	data []byte
+}
+
+// Stats holds the IO counters of a Cache. The disk engine never compacts,
+// so the only IO spent outside of Put and Get is the removal of entries by
+// eviction, Delete or Clear. Inline entries cost no IO, and are not counted
+// in the bytes written, read and removed.
+type Stats struct {
+	BytesWritten int64
+	BytesRead    int64
+	BytesRemoved int64
+	Evictions    int64
+	Vetoes       int64
+	Corruptions  int64
+}
+
+// Cache is a directory of files, one per key, bounded both in total size
+// and in number of items. When a bound is exceeded, the least recently used
+// entries are removed.
+type Cache struct {
+	dir      string
+	maxSize  int64
+	maxItems int64
+	opts     Options
+
+	sizeUsed int64
+	stats    Stats
+
+	list  *list.List
+	items map[string]*list.Element
+
+	// OnEvict, when set, is called with the metadata of every entry removed
+	// to make room for a new one. It is called with the cache locked, so it
+	// must not call back into the cache.
+	OnEvict func(meta Meta)
+
+	// OnBeforeEvict, when set, is called with the metadata of every entry
+	// about to be evicted, and vetoes its eviction by returning false. A
+	// vetoed entry is treated as just used. At most MaxVetoes vetoes are
+	// honored per Put, after which entries are evicted regardless, so that
+	// the cache always gets back within its bounds. It is called with the
+	// cache locked, so it must not call back into the cache.
+	OnBeforeEvict func(meta Meta) bool
+	MaxVetoes     int
+
+	unlock    func() error
+	lease     *lease
+	leaseLost bool
+	mu        sync.Mutex
+}
+
+// Options tunes the layout of the directory of a Cache.
+type Options struct {
+	// ShardDepth is the number of levels of subdirectories the files of
+	// the entries are spread over, each level holding up to 256
+	// subdirectories named after the next byte of the hash of the key. It
+	// keeps directories small when the cache holds many entries. It is 0
+	// by default, with every file directly in the directory of the cache.
+	ShardDepth int
+
+	// FileMode and DirMode are the permissions of the files of the entries
+	// and of the directories, 0644 and 0755 by default.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// InlineSize is the size in bytes, once compressed, up to which values
+	// are kept in the index in memory rather than written to a file of
+	// their own, sparing the file and the IO of every tiny value. Inline
+	// values still count in the bounds of the cache, and are lost with the
+	// index like the files are. It is 0 by default, with every value in a
+	// file.
+	InlineSize int
+}
+
+// New creates a Cache backed by dir. The cache allows at most maxItems
+// files with a total size of maxSize bytes. The cache owns dir until it is
+// closed: New fails with ErrLocked when dir is already used by another
+// cache, in this process or another one.
+func New(dir string, maxSize, maxItems int64) (*Cache, error) {
+	return NewWithOptions(dir, maxSize, maxItems, Options{})
+}
+
+// NewWithOptions is like New, with the layout of dir tuned by opts.
+func NewWithOptions(dir string, maxSize, maxItems int64, opts Options) (*Cache, error) {
+	if dir == "" {
+		return nil, ErrBadDir
+	}
+	if maxSize <= 0 {
+		return nil, ErrBadSize
+	}
+	if maxItems <= 0 {
+		return nil, ErrBadCap
+	}
+	if opts.ShardDepth < 0 || opts.ShardDepth > maxShardDepth {
+		return nil, ErrBadDepth
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = 0644
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = 0755
+	}
+
+	if err := os.MkdirAll(dir, opts.DirMode); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %s", dir, err)
+	}
+
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		dir:      filepath.Clean(dir),
+		maxSize:  maxSize,
+		maxItems: maxItems,
+		opts:     opts,
+		list:     list.New(),
+		items:    make(map[string]*list.Element),
+		unlock:   unlock,
+	}, nil
+}
+
+// Dir returns the directory backing the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Close releases the ownership of the directory of the cache, so that
+// another cache can use it. The files of the entries are left in place.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.releaseLease(); err != nil {
+		return err
+	}
+	if c.unlock == nil {
+		return nil
+	}
+	err := c.unlock()
+	c.unlock = nil
+	if err != nil {
+		return fmt.Errorf("error unlocking directory %s: %s", c.dir, err)
+	}
+	return nil
+}
+
+// Put stores val against key, replacing any previous value, and evicts the
+// least recently used entries until the cache is back within its bounds.
+func (c *Cache) Put(key string, val []byte) error {
+	return c.PutCompressed(key, val, entry.CodecNone)
+}
+
+// PutCompressed is like Put, but stores val compressed with codec, which
+// must have a compressor registered, see entry.RegisterCompressor. Get
+// returns val decompressed. The size of the entry is its compressed size,
+// and val is stored as is when compressing does not make it smaller.
+func (c *Cache) PutCompressed(key string, val []byte, codec entry.Codec) error {
+	var flags entry.Flags
+	if codec != entry.CodecNone {
+		compressed, err := entry.Compress(codec, val)
+		if err != nil {
+			return fmt.Errorf("error compressing %s: %s", key, err)
+		}
+		if len(compressed) < len(val) {
+			val = compressed
+			flags = entry.FlagCompressed
+		} else {
+			codec = entry.CodecNone
+		}
+	}
+	if int64(len(val)) > c.maxSize {
+		return ErrTooLarge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leaseLost {
+		return ErrLeaseLost
+	}
+	meta := &Meta{
+		Key:       key,
+		Size:      int64(len(val)),
+		CreatedAt: time.Now(),
+	}
+	if len(val) <= c.opts.InlineSize {
+		meta.Inline = true
+		meta.data = entry.Seal(val, flags, codec)
+	} else {
+		meta.Path = c.path(key)
+		if err := c.writeFile(meta.Path, val, flags, codec); err != nil {
+			return fmt.Errorf("error writing %s: %s", meta.Path, err)
+		}
+		c.stats.BytesWritten += meta.Size
+	}
+
+	if element, ok := c.items[key]; ok {
+		previous := element.Value.(*Meta)
+		if meta.Inline && !previous.Inline {
+			if err := os.Remove(previous.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing %s: %s", previous.Path, err)
+			}
+		}
+		c.sizeUsed -= previous.Size
+		c.list.Remove(element)
+	}
+	c.items[key] = c.list.PushFront(meta)
+	c.sizeUsed += meta.Size
+
+	var vetoes int
+	for c.sizeUsed > c.maxSize || int64(c.list.Len()) > c.maxItems {
+		element := c.list.Back()
+		if c.OnBeforeEvict != nil && vetoes < c.MaxVetoes && !c.OnBeforeEvict(*element.Value.(*Meta)) {
+			vetoes++
+			c.stats.Vetoes++
+			c.list.MoveToFront(element)
+			continue
+		}
+		if err := c.removeElement(element); err != nil {
+			return err
+		}
+		c.stats.Evictions++
+		if c.OnEvict != nil {
+			c.OnEvict(*element.Value.(*Meta))
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored against key, or ErrNotFound.
+func (c *Cache) Get(key string) ([]byte, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// readChunkSize is the size of the reads of GetContext, between which the
+// context is checked.
+const readChunkSize = 64 * 1024
+
+// GetContext is like Get, but stops reading and returns the error of ctx as
+// soon as ctx is done. The file is read in chunks, so a read in progress
+// stops at the end of the current chunk.
+func (c *Cache) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return c.get(ctx, key, true)
+}
+
+// Peek is like Get, but does not affect the recency of the entry, so that
+// inspecting an entry does not keep it from being evicted.
+func (c *Cache) Peek(key string) ([]byte, error) {
+	return c.get(context.Background(), key, false)
+}
+
+func (c *Cache) get(ctx context.Context, key string, promote bool) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	element, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if promote {
+		c.list.MoveToFront(element)
+	}
+	meta := *element.Value.(*Meta)
+	c.mu.Unlock()
+
+	// Inline entries are copied, as callers may modify the values returned.
+	data := append([]byte(nil), meta.data...)
+	if !meta.Inline {
+		var err error
+		data, err = readFile(ctx, meta.Path, entry.HeaderSize+meta.Size)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !meta.Inline {
+		c.stats.BytesRead += meta.Size
+	}
+	value, ok := openFile(data, meta.Size)
+	if !ok {
+		c.stats.Corruptions++
+		// The entry may have been replaced while its file was read. The
+		// files of a lost directory are left to its new owner.
+		if c.items[key] == element && !c.leaseLost {
+			if err := c.removeElement(element); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrCorrupt
+	}
+	return value, nil
+}
+
+// GetMulti returns the values stored against keys, leaving out the keys not
+// found and the entries that do not match their checksum, which are
+// removed. The entries are looked up at once, then their files are read in
+// the order of their paths rather than in the order of keys, which keeps
+// the reads of a large batch close to each other on disk. It fails when ctx
+// is done, or when a corrupt entry cannot be removed.
+func (c *Cache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	type read struct {
+		element *list.Element
+		meta    Meta
+	}
+	reads := make([]read, 0, len(keys))
+	c.mu.Lock()
+	for _, key := range keys {
+		element, ok := c.items[key]
+		if !ok {
+			continue
+		}
+		c.list.MoveToFront(element)
+		reads = append(reads, read{element, *element.Value.(*Meta)})
+	}
+	c.mu.Unlock()
+	sort.Slice(reads, func(i, j int) bool { return reads[i].meta.Path < reads[j].meta.Path })
+
+	values := make(map[string][]byte, len(reads))
+	var corrupt []read
+	var bytesRead int64
+	for _, r := range reads {
+		if values[r.meta.Key] != nil {
+			continue
+		}
+		data := append([]byte(nil), r.meta.data...)
+		if !r.meta.Inline {
+			var err error
+			data, err = readFile(ctx, r.meta.Path, entry.HeaderSize+r.meta.Size)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return nil, ctxErr
+				}
+				continue
+			}
+			bytesRead += r.meta.Size
+		}
+		value, ok := openFile(data, r.meta.Size)
+		if !ok {
+			corrupt = append(corrupt, r)
+			continue
+		}
+		values[r.meta.Key] = value
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.BytesRead += bytesRead
+	for _, r := range corrupt {
+		c.stats.Corruptions++
+		// The entry may have been replaced while its file was read. The
+		// files of a lost directory are left to its new owner.
+		if c.items[r.meta.Key] == r.element && !c.leaseLost {
+			if err := c.removeElement(r.element); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// Delete removes the entry stored against key. It returns true if the
+// entry existed.
+func (c *Cache) Delete(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leaseLost {
+		return false, ErrLeaseLost
+	}
+	element, ok := c.items[key]
+	if !ok {
+		return false, nil
+	}
+	return true, c.removeElement(element)
+}
+
+// Clear removes every entry from the cache and from the directory,
+// including the files of entries left in the directory by a previous cache
+// that are not in the index.
+func (c *Cache) Clear() error {
+	return c.ClearExcept(nil)
+}
+
+// ClearExcept is like Clear, but keeps the entries for which keep returns
+// true. It is called with the cache locked, so it must not call back into
+// the cache.
+func (c *Cache) ClearExcept(keep func(meta Meta) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leaseLost {
+		return ErrLeaseLost
+	}
+	kept := make(map[string]bool)
+	for element := c.list.Back(); element != nil; {
+		meta := element.Value.(*Meta)
+		prev := element.Prev()
+		if keep != nil && keep(*meta) {
+			if !meta.Inline {
+				kept[meta.Path] = true
+			}
+		} else if err := c.removeElement(element); err != nil {
+			return err
+		}
+		element = prev
+	}
+	_, err := c.removeStrayFiles(c.dir, 0, kept)
+	return err
+}
+
+// removeStrayFiles removes the files of entries and the shard directories
+// found in dir, at the given shard depth, but for the files in kept. Other
+// files, such as the lock and the lease, are left in place. It reports
+// whether dir was left without any file. The caller must hold c.mu.
+func (c *Cache) removeStrayFiles(dir string, depth int, kept map[string]bool) (bool, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %s", dir, err)
+	}
+	empty := true
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		switch {
+		case file.IsDir() && depth < maxShardDepth && isHex(file.Name(), 2):
+			removed, err := c.removeStrayFiles(path, depth+1, kept)
+			if err != nil {
+				return false, err
+			}
+			if !removed {
+				empty = false
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return false, fmt.Errorf("error removing %s: %s", path, err)
+			}
+		case file.Type().IsRegular() && isHex(file.Name(), 2*sha256.Size) && !kept[path]:
+			if info, err := file.Info(); err == nil {
+				c.stats.BytesRemoved += info.Size()
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return false, fmt.Errorf("error removing %s: %s", path, err)
+			}
+		default:
+			empty = false
+		}
+	}
+	return empty, nil
+}
+
+// isHex reports whether name is made of n lowercase hexadecimal digits, as
+// the names of the files of the entries and of the shard directories are.
+func isHex(name string, n int) bool {
+	if len(name) != n {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if (name[i] < '0' || name[i] > '9') && (name[i] < 'a' || name[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the sorted list of keys in the cache.
+func (c *Cache) Keys() []string {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Entries returns the metadata of the entries in the cache, from the least
+// to the most recently used.
+func (c *Cache) Entries() []Meta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]Meta, 0, c.list.Len())
+	for element := c.list.Back(); element != nil; element = element.Prev() {
+		entries = append(entries, *element.Value.(*Meta))
+	}
+	return entries
+}
+
+// Stat returns the metadata of the entry stored against key, without
+// affecting its recency.
+func (c *Cache) Stat(key string) (Meta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return Meta{}, false
+	}
+	return *element.Value.(*Meta), true
+}
+
+// EntrySize returns the size of the entry stored against key, without
+// affecting its recency.
+func (c *Cache) EntrySize(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	return element.Value.(*Meta).Size, true
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
+// Size returns the total size in bytes of the entries in the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sizeUsed
+}
+
+// Stats returns a copy of the IO counters of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// removeElement deletes the file of an entry and forgets about it. The
+// caller must hold c.mu.
+func (c *Cache) removeElement(element *list.Element) error {
+	meta := element.Value.(*Meta)
+	if !meta.Inline {
+		if err := os.Remove(meta.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %s", meta.Path, err)
+		}
+		c.stats.BytesRemoved += meta.Size
+	}
+	c.sizeUsed -= meta.Size
+	c.list.Remove(element)
+	delete(c.items, meta.Key)
+	return nil
+}
+
+// writeFile writes val to the file at path, after its entry header with
+// flags and codec, creating the shard directories of path when needed.
+func (c *Cache) writeFile(path string, val []byte, flags entry.Flags, codec entry.Codec) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.opts.FileMode)
+	if os.IsNotExist(err) && c.opts.ShardDepth > 0 {
+		if err := os.MkdirAll(filepath.Dir(path), c.opts.DirMode); err != nil {
+			return err
+		}
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.opts.FileMode)
+	}
+	if err != nil {
+		return err
+	}
+	header := entry.NewHeader(val, flags, codec).AppendTo(nil)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(val); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// openFile returns the value held by the data of a file, decompressed, or
+// false when the file is not size bytes of payload after a valid entry
+// header, or its payload cannot be decompressed.
+func openFile(data []byte, size int64) ([]byte, bool) {
+	header, payload, err := entry.Open(data)
+	if err != nil || int64(len(payload)) != size {
+		return nil, false
+	}
+	value, err := entry.Payload(header, payload)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// readFile reads the file at path, expected to be size bytes long, checking
+// ctx between chunks.
+func readFile(ctx context.Context, path string, size int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	value := make([]byte, 0, size)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(value) == cap(value) {
+			value = append(value, 0)[:len(value)]
+		}
+		chunk := value[len(value):cap(value)]
+		if len(chunk) > readChunkSize {
+			chunk = chunk[:readChunkSize]
+		}
+		n, err := file.Read(chunk)
+		value = value[:len(value)+n]
+		if err == io.EOF {
+			return value, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxShardDepth is the largest Options.ShardDepth.
+const maxShardDepth = 4
+
+func (c *Cache) path(key string) string {
+	name := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+	elems := make([]string, 0, c.opts.ShardDepth+2)
+	elems = append(elems, c.dir)
+	for i := 0; i < c.opts.ShardDepth; i++ {
+		elems = append(elems, name[2*i:2*i+2])
+	}
+	return filepath.Join(append(elems, name)...)
+}
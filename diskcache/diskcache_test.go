@@ -1 +1,632 @@
 package diskcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+func newTestCache(t *testing.T, maxSize, maxItems int64) *Cache {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("Error creating temp folder: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cache, err := New(dir, maxSize, maxItems)
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	return cache
+}
+
+// countFiles returns the number of files of the entries in dir.
+func countFiles(t *testing.T, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", dir, err)
+	}
+	count := 0
+	for _, file := range files {
+		if file.Name() != LockFileName {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNew(t *testing.T) {
+	for i, c := range []struct {
+		dir      string
+		maxSize  int64
+		maxItems int64
+		err      error
+	}{
+		{dir: "", maxSize: 10, maxItems: 10, err: ErrBadDir},
+		{dir: os.TempDir(), maxSize: 0, maxItems: 10, err: ErrBadSize},
+		{dir: os.TempDir(), maxSize: 10, maxItems: 0, err: ErrBadCap},
+	} {
+		_, err := New(c.dir, c.maxSize, c.maxItems)
+		if err != c.err {
+			t.Errorf("#%d: Expected error %q, got %q", i+1, c.err, err)
+		}
+	}
+}
+
+func TestCache_PutGet(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	err := cache.Put("key1", []byte("12345"))
+	if err != nil {
+		t.Errorf("Expected no error putting key1, got %s", err)
+	}
+
+	value, err := cache.Get("key1")
+	if err != nil {
+		t.Errorf("Expected no error getting key1, got %s", err)
+	}
+	if string(value) != "12345" {
+		t.Errorf("Expected value to be 12345, got %s", value)
+	}
+
+	_, err = cache.Get("key2")
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound getting key2, got %v", err)
+	}
+
+	err = cache.Put("key3", make([]byte, 101))
+	if err != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge putting key3, got %v", err)
+	}
+}
+
+func TestCache_Eviction(t *testing.T) {
+	cache := newTestCache(t, 10, 2)
+	var evicted []string
+	cache.OnEvict = func(meta Meta) {
+		evicted = append(evicted, meta.Key)
+	}
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+	cache.Get("key1")
+	cache.Put("key3", []byte("abcde"))
+
+	if _, err := cache.Get("key2"); err != ErrNotFound {
+		t.Errorf("Expected key2 to be evicted, got %v", err)
+	}
+	if len(evicted) != 1 || evicted[0] != "key2" {
+		t.Errorf("Expected OnEvict to be called for key2, got %v", evicted)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+	if cache.Size() != 10 {
+		t.Errorf("Expected size to be 10, got %d", cache.Size())
+	}
+	if countFiles(t, cache.dir) != 2 {
+		t.Errorf("Expected 2 files on disk, got %d", countFiles(t, cache.dir))
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.BytesRemoved != 5 || stats.BytesWritten != 15 || stats.BytesRead != 5 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	cache.Put("key1", []byte("12345"))
+
+	deleted, err := cache.Delete("key1")
+	if err != nil || !deleted {
+		t.Errorf("Expected key1 to be deleted, got %v, %v", deleted, err)
+	}
+	deleted, err = cache.Delete("key1")
+	if err != nil || deleted {
+		t.Errorf("Expected key1 to be already deleted, got %v, %v", deleted, err)
+	}
+	if cache.Size() != 0 {
+		t.Errorf("Expected size to be 0, got %d", cache.Size())
+	}
+	if countFiles(t, cache.dir) != 0 {
+		t.Errorf("Expected no files on disk, got %d", countFiles(t, cache.dir))
+	}
+}
+
+func TestCache_Clear(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+
+	err := cache.Clear()
+	if err != nil {
+		t.Errorf("Expected no error clearing cache, got %s", err)
+	}
+	if len(cache.Keys()) != 0 {
+		t.Errorf("Expected no keys, got %v", cache.Keys())
+	}
+	if countFiles(t, cache.dir) != 0 {
+		t.Errorf("Expected no files on disk, got %d", countFiles(t, cache.dir))
+	}
+}
+
+func TestCache_ClearStrayFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("Error creating temp folder: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The files of a previous cache are not in the index of the next one.
+	previous, err := NewWithOptions(dir, 100, 10, Options{ShardDepth: 1})
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	previous.Put("key1", []byte("12345"))
+	previous.Put("key2", []byte("67890"))
+	previous.Close()
+
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("kept"), 0644); err != nil {
+		t.Fatalf("Error writing %s: %s", other, err)
+	}
+
+	cache, err := NewWithOptions(dir, 100, 10, Options{ShardDepth: 1})
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	defer cache.Close()
+	cache.Put("key3", []byte("abcde"))
+
+	if err := cache.Clear(); err != nil {
+		t.Errorf("Expected no error clearing cache, got %s", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", dir, err)
+	}
+	for _, file := range files {
+		if name := file.Name(); name != LockFileName && name != "other" {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected files other than entries to be kept, got %s", err)
+	}
+	if stats := cache.Stats(); stats.BytesRemoved < 15 {
+		t.Errorf("Expected the stray files to be counted as removed, got %d bytes", stats.BytesRemoved)
+	}
+}
+
+func TestCache_GetContext(t *testing.T) {
+	cache := newTestCache(t, 1024*1024, 10)
+
+	value := make([]byte, readChunkSize*3+1)
+	cache.Put("key1", value)
+
+	read, err := cache.GetContext(context.Background(), "key1")
+	if err != nil || len(read) != len(value) {
+		t.Errorf("Expected %d bytes, got %d, %v", len(value), len(read), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cache.GetContext(ctx, "key1")
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if stats := cache.Stats(); stats.BytesRead != int64(len(value)) {
+		t.Errorf("Expected %d bytes read, got %d", len(value), stats.BytesRead)
+	}
+}
+
+func TestCache_OnBeforeEvict(t *testing.T) {
+	cache := newTestCache(t, 100, 2)
+	cache.MaxVetoes = 1
+	cache.OnBeforeEvict = func(meta Meta) bool {
+		return meta.Key != "key1"
+	}
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+	cache.Put("key3", []byte("abcde"))
+
+	if _, err := cache.Get("key1"); err != nil {
+		t.Errorf("Expected key1 to be kept, got %v", err)
+	}
+	if _, err := cache.Get("key2"); err != ErrNotFound {
+		t.Errorf("Expected key2 to be evicted, got %v", err)
+	}
+
+	// key1 is the least recently used entry again, and the veto budget is
+	// not enough to keep both key1 and key3.
+	cache.Get("key3")
+	cache.OnBeforeEvict = func(meta Meta) bool { return false }
+	cache.Put("key4", []byte("fghij"))
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+	if stats := cache.Stats(); stats.Vetoes != 2 || stats.Evictions != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCache_Peek(t *testing.T) {
+	cache := newTestCache(t, 10, 2)
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+
+	value, err := cache.Peek("key1")
+	if err != nil || string(value) != "12345" {
+		t.Errorf("Expected value to be 12345, got %s, %v", value, err)
+	}
+
+	// Peeking key1 did not make it more recent than key2.
+	cache.Put("key3", []byte("abcde"))
+	if _, err := cache.Peek("key1"); err != ErrNotFound {
+		t.Errorf("Expected key1 to be evicted, got %v", err)
+	}
+}
+
+func TestCache_Entries(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("678"))
+	cache.Put("key3", []byte("abcde"))
+	cache.Get("key1")
+
+	entries := cache.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	for i, key := range []string{"key2", "key3", "key1"} {
+		if entries[i].Key != key {
+			t.Errorf("Expected entry #%d to be %s, got %s", i+1, key, entries[i].Key)
+		}
+	}
+	if entries[0].Size != 3 {
+		t.Errorf("Expected key2 to be 3 bytes, got %d", entries[0].Size)
+	}
+}
+
+func TestNew_Locked(t *testing.T) {
+	cache := newTestCache(t, 10, 10)
+
+	_, err := New(cache.dir, 10, 10)
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked opening the directory twice, got %v", err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Errorf("Expected no error closing the cache, got %s", err)
+	}
+	other, err := New(cache.dir, 10, 10)
+	if err != nil {
+		t.Fatalf("Expected no error once the directory is released, got %s", err)
+	}
+	other.Close()
+}
+
+func TestNew_LockedByAnotherProcess(t *testing.T) {
+	if dir := os.Getenv("DISKCACHE_LOCK_DIR"); dir != "" {
+		// Runs in the process started below.
+		_, err := New(dir, 10, 10)
+		if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getppid())) {
+			fmt.Printf("unexpected error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("file locks are only tested on linux and darwin")
+	}
+
+	cache := newTestCache(t, 10, 10)
+	defer cache.Close()
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestNew_LockedByAnotherProcess$")
+	cmd.Env = append(os.Environ(), "DISKCACHE_LOCK_DIR="+cache.dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Expected the other process to fail with ErrLocked, got %s: %s", err, output)
+	}
+}
+
+func TestCache_Corrupt(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+	path := cache.path("key1")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", path, err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatalf("Error truncating %s: %s", path, err)
+	}
+
+	if _, err := cache.Get("key1"); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt reading a truncated entry, got %v", err)
+	}
+	if _, err := cache.Get("key1"); err != ErrNotFound {
+		t.Errorf("Expected the corrupt entry to be removed, got %v", err)
+	}
+	if value, err := cache.Get("key2"); err != nil || string(value) != "67890" {
+		t.Errorf("Expected 67890, got %q, %v", value, err)
+	}
+	if cache.Stats().Corruptions != 1 {
+		t.Errorf("Expected 1 corruption, got %d", cache.Stats().Corruptions)
+	}
+	if countFiles(t, cache.dir) != 1 {
+		t.Errorf("Expected 1 file on disk, got %d", countFiles(t, cache.dir))
+	}
+}
+
+func TestCache_AcquireLease(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+	path := filepath.Join(cache.dir, LeaseFileName)
+
+	// Another host holds a fresh lease.
+	other := LeaseInfo{ID: "other", PID: 1, Host: "other", AcquiredAt: time.Now(), HeartbeatAt: time.Now()}
+	if err := writeLease(path, other); err != nil {
+		t.Fatalf("Error writing lease: %s", err)
+	}
+	err := cache.AcquireLease(LeaseOptions{TTL: time.Minute, TakeOverStale: true})
+	if !errors.Is(err, ErrLeaseHeld) || !strings.Contains(err.Error(), "on other") {
+		t.Errorf("Expected ErrLeaseHeld with a fresh lease, got %v", err)
+	}
+
+	// Its lease is stale.
+	other.HeartbeatAt = time.Now().Add(-time.Hour)
+	if err := writeLease(path, other); err != nil {
+		t.Fatalf("Error writing lease: %s", err)
+	}
+	if err := cache.AcquireLease(LeaseOptions{TTL: time.Minute}); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld with a stale lease, got %v", err)
+	}
+	if err := cache.AcquireLease(LeaseOptions{TTL: time.Minute, TakeOverStale: true}); err != nil {
+		t.Fatalf("Expected the stale lease to be taken over, got %s", err)
+	}
+	info, err := readLease(path)
+	if err != nil || info.ID == "other" || info.PID != os.Getpid() {
+		t.Errorf("Expected the lease to be ours, got %+v, %v", info, err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Errorf("Expected no error closing the cache, got %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the lease to be released, got %v", err)
+	}
+}
+
+func TestCache_LeaseLost(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+	defer cache.Close()
+	path := filepath.Join(cache.dir, LeaseFileName)
+
+	lost := make(chan LeaseInfo, 1)
+	err := cache.AcquireLease(LeaseOptions{TTL: 30 * time.Millisecond, OnLost: func(owner LeaseInfo) { lost <- owner }})
+	if err != nil {
+		t.Fatalf("Expected no error acquiring the lease, got %s", err)
+	}
+	if err := cache.Put("key1", []byte("12345")); err != nil {
+		t.Errorf("Expected no error writing with the lease, got %s", err)
+	}
+
+	// The lease is renewed.
+	first, _ := readLease(path)
+	time.Sleep(50 * time.Millisecond)
+	renewed, _ := readLease(path)
+	if !renewed.HeartbeatAt.After(first.HeartbeatAt) {
+		t.Errorf("Expected the lease to be renewed")
+	}
+
+	// Another host takes the lease over, and renews it.
+	other := LeaseInfo{ID: "other", PID: 1, Host: "other", AcquiredAt: time.Now()}
+	timeout := time.After(time.Second)
+	for renewing := true; renewing; {
+		other.HeartbeatAt = time.Now()
+		if err := writeLease(path, other); err != nil {
+			t.Fatalf("Error writing lease: %s", err)
+		}
+		select {
+		case owner := <-lost:
+			if owner.ID != "other" {
+				t.Errorf("Expected the lease to be lost to other, got %s", owner.ID)
+			}
+			renewing = false
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("Expected the lease to be lost")
+		}
+	}
+
+	if err := cache.Put("key2", []byte("67890")); err != ErrLeaseLost {
+		t.Errorf("Expected ErrLeaseLost writing without the lease, got %v", err)
+	}
+	if _, err := cache.Delete("key1"); err != ErrLeaseLost {
+		t.Errorf("Expected ErrLeaseLost deleting without the lease, got %v", err)
+	}
+	if value, err := cache.Get("key1"); err != nil || string(value) != "12345" {
+		t.Errorf("Expected key1 to still be readable, got %q, %v", value, err)
+	}
+
+	// The lease of the new owner is left in place.
+	cache.Close()
+	if info, err := readLease(path); err != nil || info.ID != "other" {
+		t.Errorf("Expected the lease of other to be kept, got %+v, %v", info, err)
+	}
+}
+
+func TestCache_GetMulti(t *testing.T) {
+	cache := newTestCache(t, 100, 10)
+
+	cache.Put("key1", []byte("12345"))
+	cache.Put("key2", []byte("67890"))
+	cache.Put("key3", []byte(""))
+	cache.Put("key4", []byte("abcde"))
+	if err := os.Truncate(cache.path("key4"), 2); err != nil {
+		t.Fatalf("Error truncating key4: %s", err)
+	}
+
+	values, err := cache.GetMulti(context.Background(), []string{"key4", "key3", "missing", "key1", "key2", "key1"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(values) != 3 || string(values["key1"]) != "12345" || string(values["key2"]) != "67890" || values["key3"] == nil {
+		t.Errorf("Expected key1, key2 and key3, got %q", values)
+	}
+	if stats := cache.Stats(); stats.Corruptions != 1 || stats.BytesRead != 15 {
+		t.Errorf("Expected 1 corruption and 15 bytes read, got %+v", stats)
+	}
+	if _, err := cache.Peek("key4"); err != ErrNotFound {
+		t.Errorf("Expected the corrupt key4 to be removed, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.GetMulti(ctx, []string{"key1"}); err != context.Canceled {
+		t.Errorf("Expected the error of ctx, got %v", err)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("Error creating temp folder: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewWithOptions(dir, 100, 10, Options{ShardDepth: 5}); err != ErrBadDepth {
+		t.Errorf("Expected ErrBadDepth, got %v", err)
+	}
+
+	cache, err := NewWithOptions(dir, 100, 10, Options{ShardDepth: 2, FileMode: 0600})
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	defer cache.Close()
+
+	if err := cache.Put("key1", []byte("12345")); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	path := cache.path("key1")
+	name := filepath.Base(path)
+	if expected := filepath.Join(cache.dir, name[0:2], name[2:4], name); path != expected {
+		t.Errorf("Expected key1 to be stored in %s, got %s", expected, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the file of key1 to exist, got %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file of key1 to be 0600, got %v", info.Mode().Perm())
+	}
+	if value, err := cache.Get("key1"); err != nil || string(value) != "12345" {
+		t.Errorf("Expected 12345, got %q, %v", value, err)
+	}
+	if _, err := cache.Delete("key1"); err != nil {
+		t.Errorf("Expected no error deleting key1, got %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file of key1 to be removed, got %v", err)
+	}
+}
+
+func TestCache_PutCompressed(t *testing.T) {
+	cache := newTestCache(t, 1000, 10)
+
+	value := []byte(strings.Repeat("12345", 100))
+	if err := cache.PutCompressed("key1", value, entry.CodecGzip); err != nil {
+		t.Fatalf("Expected no error putting key1, got %s", err)
+	}
+	if size, _ := cache.EntrySize("key1"); size >= int64(len(value)) {
+		t.Errorf("Expected key1 to be stored compressed, got %d bytes", size)
+	}
+	if got, err := cache.Get("key1"); err != nil || string(got) != string(value) {
+		t.Errorf("Expected key1 to be decompressed, got %d bytes, %v", len(got), err)
+	}
+
+	// Values compressing does not make smaller are stored as is.
+	if err := cache.PutCompressed("key2", []byte("12345"), entry.CodecGzip); err != nil {
+		t.Fatalf("Expected no error putting key2, got %s", err)
+	}
+	if size, _ := cache.EntrySize("key2"); size != 5 {
+		t.Errorf("Expected key2 to be stored as is, got %d bytes", size)
+	}
+	if got, err := cache.Get("key2"); err != nil || string(got) != "12345" {
+		t.Errorf("Expected 12345, got %q, %v", got, err)
+	}
+
+	if err := cache.PutCompressed("key3", value, entry.CodecZstd); err == nil {
+		t.Errorf("Expected an error putting key3 with an unsupported codec")
+	}
+}
+
+func TestCache_Inline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("Error creating temp folder: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewWithOptions(dir, 100, 10, Options{InlineSize: 8})
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	defer cache.Close()
+
+	if err := cache.Put("key1", []byte("12345")); err != nil {
+		t.Fatalf("Expected no error putting key1, got %s", err)
+	}
+	if n := countFiles(t, dir); n != 0 {
+		t.Errorf("Expected key1 to be kept inline, got %d files", n)
+	}
+	meta, _ := cache.Stat("key1")
+	if !meta.Inline || meta.Path != "" || meta.Size != 5 || cache.Size() != 5 {
+		t.Errorf("Expected key1 to be a 5 bytes inline entry, got %+v", meta)
+	}
+	value, err := cache.Get("key1")
+	if err != nil || string(value) != "12345" {
+		t.Fatalf("Expected 12345, got %q, %v", value, err)
+	}
+	value[0] = 'x'
+	if values, _ := cache.GetMulti(context.Background(), []string{"key1"}); string(values["key1"]) != "12345" {
+		t.Errorf("Expected modifying a value not to modify key1, got %q", values["key1"])
+	}
+
+	// Larger values are written to files, which are removed when they are
+	// replaced by inline values.
+	cache.Put("key1", []byte("1234567890"))
+	if n := countFiles(t, dir); n != 1 {
+		t.Errorf("Expected key1 in a file, got %d files", n)
+	}
+	cache.Put("key1", []byte("123"))
+	if n := countFiles(t, dir); n != 0 {
+		t.Errorf("Expected the file of key1 to be removed, got %d files", n)
+	}
+	if value, err := cache.Get("key1"); err != nil || string(value) != "123" {
+		t.Errorf("Expected 123, got %q, %v", value, err)
+	}
+	if stats := cache.Stats(); stats.BytesWritten != 10 || stats.BytesRemoved != 0 {
+		t.Errorf("Expected only the file of key1 to be counted, got %+v", stats)
+	}
+
+	if ok, err := cache.Delete("key1"); !ok || err != nil || cache.Len() != 0 || cache.Size() != 0 {
+		t.Errorf("Expected key1 to be deleted, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,217 @@
+package diskcache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LeaseFileName is the name of the file, in the directory of a Cache,
+// holding the lease of its owner.
+const LeaseFileName = ".lease"
+
+var (
+	// ErrLeaseHeld is returned by AcquireLease when the directory is leased
+	// by another owner whose lease is still fresh, or is stale and
+	// LeaseOptions.TakeOverStale is not set.
+	ErrLeaseHeld = errors.New("directory is leased by another owner")
+
+	// ErrLeaseLost is returned by the operations writing to the directory
+	// once another owner has taken the lease over. The cache stops writing
+	// to the directory, which now belongs to the other owner.
+	ErrLeaseLost = errors.New("lease of the directory was lost")
+)
+
+// LeaseOptions configures the lease taken on the directory of a Cache.
+type LeaseOptions struct {
+	// TTL is the time after which a lease that was not renewed is stale.
+	// The lease is renewed every third of TTL. The clocks of the hosts
+	// sharing the directory must agree well within TTL.
+	TTL time.Duration
+
+	// TakeOverStale allows AcquireLease to take the lease of an owner that
+	// stopped renewing it, such as a crashed process. Otherwise the stale
+	// lease file must be removed by hand.
+	TakeOverStale bool
+
+	// OnLost, when set, is called with the description of the new owner
+	// when another owner takes the lease over.
+	OnLost func(owner LeaseInfo)
+}
+
+// LeaseInfo is the content of the lease file.
+type LeaseInfo struct {
+	ID          string    `json:"id"`
+	PID         int       `json:"pid"`
+	Host        string    `json:"host"`
+	AcquiredAt  time.Time `json:"acquired_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// String describes the owner of the lease.
+func (info LeaseInfo) String() string {
+	return fmt.Sprintf("pid %d on %s since %s, last seen %s",
+		info.PID, info.Host, info.AcquiredAt.Format(time.RFC3339), info.HeartbeatAt.Format(time.RFC3339))
+}
+
+// lease is the lease held by a Cache on its directory.
+type lease struct {
+	info LeaseInfo
+	path string
+	quit chan struct{}
+	done chan struct{}
+}
+
+// AcquireLease takes a lease on the directory of the cache, and renews it
+// in the background until the cache is closed. The lock taken by New only
+// excludes the caches running on the same host; the lease also protects a
+// directory on a volume shared between hosts, such as when an orchestrator
+// schedules the same workload twice. It fails with ErrLeaseHeld when
+// another owner holds the lease.
+//
+// When another owner takes the lease over, because this one could not
+// renew it in time, the cache stops writing to the directory: Put, Delete
+// and Clear fail with ErrLeaseLost.
+func (c *Cache) AcquireLease(opts LeaseOptions) error {
+	if opts.TTL <= 0 {
+		return fmt.Errorf("lease TTL must be greater than zero")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lease != nil {
+		return fmt.Errorf("lease of directory %s already acquired", c.dir)
+	}
+
+	path := filepath.Join(c.dir, LeaseFileName)
+	current, err := readLease(path)
+	if err != nil && !os.IsNotExist(err) && err != errBadLease {
+		return fmt.Errorf("error reading lease %s: %s", path, err)
+	}
+	// A lease file that cannot be parsed is as good as stale.
+	if !os.IsNotExist(err) {
+		if time.Since(current.HeartbeatAt) < opts.TTL {
+			return fmt.Errorf("%w: %s is leased by %s", ErrLeaseHeld, c.dir, current)
+		}
+		if !opts.TakeOverStale {
+			return fmt.Errorf("%w: %s has a stale lease from %s", ErrLeaseHeld, c.dir, current)
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("error generating lease id: %s", err)
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	l := &lease{
+		info: LeaseInfo{
+			ID:          hex.EncodeToString(id),
+			PID:         os.Getpid(),
+			Host:        hostname,
+			AcquiredAt:  now,
+			HeartbeatAt: now,
+		},
+		path: path,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := writeLease(path, l.info); err != nil {
+		return fmt.Errorf("error writing lease %s: %s", path, err)
+	}
+	c.lease = l
+	go c.renewLease(l, opts)
+	return nil
+}
+
+// renewLease renews l every third of its TTL until the cache is closed or
+// the lease is taken over.
+func (c *Cache) renewLease(l *lease, opts LeaseOptions) {
+	defer close(l.done)
+	ticker := time.NewTicker(opts.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.quit:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		current, err := readLease(l.path)
+		if err == nil && current.ID != l.info.ID {
+			c.leaseLost = true
+			c.mu.Unlock()
+			if opts.OnLost != nil {
+				opts.OnLost(current)
+			}
+			return
+		}
+		// A lease file that cannot be read is written back: only another
+		// owner replacing it means the lease was lost.
+		l.info.HeartbeatAt = time.Now()
+		writeLease(l.path, l.info)
+		c.mu.Unlock()
+	}
+}
+
+// releaseLease stops renewing the lease, and removes the lease file unless
+// another owner took it over. The caller must hold c.mu.
+func (c *Cache) releaseLease() error {
+	l := c.lease
+	if l == nil {
+		return nil
+	}
+	c.lease = nil
+	close(l.quit)
+	c.mu.Unlock()
+	<-l.done
+	c.mu.Lock()
+
+	if c.leaseLost {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing lease %s: %s", l.path, err)
+	}
+	return nil
+}
+
+// errBadLease is returned by readLease when the lease file is not valid.
+var errBadLease = errors.New("invalid lease file")
+
+func readLease(path string) (LeaseInfo, error) {
+	var info LeaseInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil || info.ID == "" {
+		return LeaseInfo{}, errBadLease
+	}
+	return info, nil
+}
+
+// writeLease replaces the lease file at path at once, so that it is never
+// read half written.
+func writeLease(path string, info LeaseInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := path + "." + info.ID
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package diskcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockFileName is the name of the file, in the directory of a Cache, locked
+// by the Cache to own the directory. It holds a description of its owner.
+const LockFileName = ".lock"
+
+// ErrLocked is returned by New when the directory is already used by another
+// Cache, in this process or another one. Two caches sharing a directory
+// would overwrite each other's files and lose track of their sizes.
+var ErrLocked = errors.New("directory is used by another cache")
+
+// lockedDirs are the directories owned by the caches of this process, for
+// the platforms where file locks do not exclude the other caches of the
+// same process.
+var (
+	lockedDirsMu sync.Mutex
+	lockedDirs   = make(map[string]bool)
+)
+
+// lockDir takes the ownership of dir, and returns the function releasing it.
+func lockDir(dir string) (func() error, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving directory %s: %s", dir, err)
+	}
+	lockedDirsMu.Lock()
+	defer lockedDirsMu.Unlock()
+	if lockedDirs[abs] {
+		return nil, fmt.Errorf("%w: %s is used by another cache of this process", ErrLocked, dir)
+	}
+
+	path := filepath.Join(abs, LockFileName)
+	file, err := lockFile(path)
+	if err != nil {
+		if errors.Is(err, errWouldBlock) {
+			owner, _ := os.ReadFile(path)
+			return nil, fmt.Errorf("%w: %s is used by %s", ErrLocked, dir, strings.TrimSpace(string(owner)))
+		}
+		return nil, fmt.Errorf("error locking directory %s: %s", dir, err)
+	}
+
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("pid %d on %s since %s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(owner), 0)
+	}
+	lockedDirs[abs] = true
+
+	return func() error {
+		lockedDirsMu.Lock()
+		defer lockedDirsMu.Unlock()
+		delete(lockedDirs, abs)
+		// The lock file is left in place: removing it would let another
+		// cache lock a new file while a third one still holds the old one.
+		return file.Close()
+	}, nil
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package diskcache
+
+import (
+	"errors"
+	"os"
+)
+
+// errWouldBlock is never returned: without file locks, only the caches of
+// the same process exclude each other.
+var errWouldBlock = errors.New("would block")
+
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
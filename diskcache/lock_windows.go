@@ -0,0 +1,26 @@
+//go:build windows
+
+package diskcache
+
+import (
+	"os"
+	"syscall"
+)
+
+// errWouldBlock is ERROR_SHARING_VIOLATION, which syscall does not define.
+var errWouldBlock = syscall.Errno(32)
+
+// lockFile opens the file at path without sharing it, so that no other
+// process can open it until it is closed, or until the process exits.
+func lockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
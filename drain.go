@@ -0,0 +1,99 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HottestEntries returns the metadata of the n hottest live entries of the
+// cache machine, hottest first, ranked like PrefetchFromIndex ranks the
+// entries of an index. All of them are returned when n is negative. The
+// ranking relies on frequency tracking, see EnableFrequencyTracking, and
+// falls back to residency in RAM and recency without it.
+func (c *CacheMachine) HottestEntries(n int) []IndexEntry {
+	entries := c.indexEntries()
+	rankIndexEntries(entries)
+	if n >= 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// DrainToS3 prepares the decommissioning of the node: it writes to the S3
+// tier the n hottest entries that are not there yet, then uploads the index
+// of the cache machine under key, DefaultIndexKey when empty, as the
+// manifest of its hot set. The nodes taking over call PrefetchFromIndex
+// with key to read the hot set back, so that a scale-down does not cost
+// the fleet the entries it reads the most. It returns the number of entries
+// written to S3, and fails when some could not be written, when the index
+// could not be uploaded, or with the error of ctx when ctx is done first.
+//
+// The entries are read from the local RAM and disk tiers only. DrainToS3
+// does not stop the cache machine, which Close does.
+func (c *CacheMachine) DrainToS3(ctx context.Context, key string, n int) (int, error) {
+	s3Cache := c.S3Cache
+	if s3Cache == nil {
+		return 0, fmt.Errorf("S3 cache is not enabled")
+	}
+	if key == "" {
+		key = DefaultIndexKey
+	}
+
+	var drained, failed int
+	for _, entry := range c.HottestEntries(n) {
+		if err := ctx.Err(); err != nil {
+			return drained, err
+		}
+		if entry.S3Synced {
+			continue
+		}
+		ok, err := c.drainEntry(ctx, s3Cache, entry.Key)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return drained, ctxErr
+			}
+			c.logError("error draining to S3", "key", entry.Key, "error", err)
+			failed++
+			continue
+		}
+		if ok {
+			drained++
+		}
+	}
+
+	if err := c.uploadIndex(ctx, s3Cache, key); err != nil {
+		return drained, err
+	}
+	c.emitEvent(EventHotSetDrained, "hot set drained to S3", map[string]interface{}{
+		"count": drained,
+		"index": key,
+	})
+	if failed > 0 {
+		return drained, fmt.Errorf("%d entries failed to drain to S3", failed)
+	}
+	return drained, nil
+}
+
+// drainEntry writes the entry for key to s3Cache, unless it is gone or
+// already there, and reports whether it did.
+func (c *CacheMachine) drainEntry(ctx context.Context, s3Cache ObjectStore, key string) (bool, error) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	cacheSync, ok := shard.entries[key]
+	if !ok || cacheSync.Negative || cacheSync.S3Sync || cacheSync.expired(time.Now()) {
+		return false, nil
+	}
+	value, ok := c.peek(shard, key)
+	if !ok {
+		return false, nil
+	}
+	if err := s3Cache.Put(ctx, key, c.sealObject(key, value)); err != nil {
+		return false, err
+	}
+	cacheSync.S3Sync = true
+	shard.entries[key] = cacheSync
+	return true, nil
+}
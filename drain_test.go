@@ -0,0 +1,109 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_HottestEntries(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.EnableFrequencyTracking()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("12345"))
+	CacheMachine.Set("key3", []byte("12345"))
+	CacheMachine.SetNegative("key4", time.Hour)
+	for i := 0; i < 3; i++ {
+		CacheMachine.Get("key2")
+	}
+	CacheMachine.Get("key3")
+
+	entries := CacheMachine.HottestEntries(-1)
+	if len(entries) != 3 || entries[0].Key != "key2" || entries[1].Key != "key3" || entries[2].Key != "key1" {
+		t.Errorf("Expected key2, key3 and key1, got %+v", entries)
+	}
+	if entries := CacheMachine.HottestEntries(1); len(entries) != 1 || entries[0].Key != "key2" {
+		t.Errorf("Expected key2 only, got %+v", entries)
+	}
+}
+
+func TestCacheMachine_DrainToS3(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if _, err := CacheMachine.DrainToS3(context.Background(), "", 10); err == nil {
+		t.Errorf("Expected an error draining without an S3 cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+	CacheMachine.EnableFrequencyTracking()
+
+	CacheMachine.SetWithTTL("key1", []byte("12345"), time.Hour)
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Set("key3", []byte("abcde"))
+	for i := 0; i < 3; i++ {
+		CacheMachine.Get("key1")
+	}
+	CacheMachine.Get("key2")
+
+	n, err := CacheMachine.DrainToS3(context.Background(), "", 2)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 entries drained, got %d, %v", n, err)
+	}
+	for key, drained := range map[string]bool{"key1": true, "key2": true, "key3": false} {
+		if _, err := store.Get(context.Background(), key); (err == nil) != drained {
+			t.Errorf("Expected %s to be drained %v, got %v", key, drained, err)
+		}
+	}
+
+	// Entries already drained are not written again.
+	if n, err := CacheMachine.DrainToS3(context.Background(), "", 2); err != nil || n != 0 {
+		t.Errorf("Expected no entry drained again, got %d, %v", n, err)
+	}
+
+	// The node taking over reads the hot set back.
+	successor, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	successorFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(successorFolder)
+
+	err = successor.EnableDiskCache(1024*1024, successorFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer successor.DisableDiskCache()
+	successor.EnableS3Cache(store)
+	n, err = successor.PrefetchFromIndex(context.Background(), "", 10)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 entries prefetched, got %d, %v", n, err)
+	}
+	if value, ok := successor.Get("key1"); !ok || string(value) != "12345" {
+		t.Errorf("Expected key1 to be prefetched, got %q, %v", value, ok)
+	}
+	if residency := successor.Where("key1"); residency.ExpiresAt.IsZero() {
+		t.Errorf("Expected key1 to keep its expiry, got %+v", residency)
+	}
+}
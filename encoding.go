@@ -0,0 +1,62 @@
+package cachemachine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+// SetEncoded stores val, a value compressed by its origin with
+// contentEncoding, an HTTP content coding such as "gzip", so that
+// GetEncoded can serve it compressed as is, or decompressed, without it
+// being decompressed and compressed again in between. The value is not
+// compressed again by SetNamespaceCompression. The entry expires after ttl
+// like with SetWithTTL, and values without a coding or with the "identity"
+// coding are stored like SetWithTTL stores them. It fails for the codings
+// that have no codec, see entry.ContentEncodingCodec.
+//
+// Get returns the values set by SetEncoded in an envelope, so they must be
+// read with GetEncoded.
+func (c *CacheMachine) SetEncoded(key string, val []byte, contentEncoding string, ttl time.Duration) error {
+	codec, ok := entry.ContentEncodingCodec(contentEncoding)
+	if !ok {
+		return fmt.Errorf("unsupported content encoding %s", contentEncoding)
+	}
+	if codec != entry.CodecNone {
+		val = entry.Seal(val, entry.FlagContentEncoded, codec)
+	}
+	return c.SetWithTTL(key, val, ttl)
+}
+
+// GetEncoded returns the value for key, along with the content coding it
+// was set with by SetEncoded. When decode is true, the value is returned
+// decompressed, with no coding, unless its codec has no compressor
+// registered, in which case it is returned compressed, with its coding.
+// The values set by Set are returned as is, with no coding.
+func (c *CacheMachine) GetEncoded(key string, decode bool) ([]byte, string, bool) {
+	val, ok := c.Get(key)
+	if !ok {
+		return nil, "", false
+	}
+	h, payload, err := entry.Open(val)
+	if err != nil || h.Flags&entry.FlagContentEncoded == 0 {
+		return val, "", true
+	}
+	if !decode || !entry.Supported(h.Codec) {
+		return payload, h.Codec.ContentEncoding(), true
+	}
+	decoded, err := entry.Decompress(h.Codec, payload)
+	if err != nil {
+		c.logError("error decoding value", "key", key, "error", err)
+		return nil, "", false
+	}
+	return decoded, "", true
+}
+
+// contentEncoded reports whether val was set by SetEncoded, without
+// verifying it.
+func contentEncoded(val []byte) bool {
+	h, _, err := entry.ParseHeader(val)
+	return err == nil && h.Flags&entry.FlagContentEncoded != 0
+}
@@ -0,0 +1,68 @@
+package cachemachine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+func TestCacheMachine_SetEncoded(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.SetNamespaceCompression("api", entry.CodecGzip, 0)
+
+	document := strings.Repeat(`{"id":1}`, 100)
+	body, _ := entry.Compress(entry.CodecGzip, []byte(document))
+	if err := CacheMachine.SetEncoded("api:doc", body, "gzip", time.Hour); err != nil {
+		t.Fatalf("Expected no error setting api:doc, got %s", err)
+	}
+	if err := CacheMachine.SetEncoded("api:br", body, "br", 0); err == nil {
+		t.Errorf("Expected an error setting an unsupported encoding")
+	}
+
+	value, encoding, ok := CacheMachine.GetEncoded("api:doc", false)
+	if !ok || encoding != "gzip" || string(value) != string(body) {
+		t.Errorf("Expected the gzipped document, got %d bytes, %q, %v", len(value), encoding, ok)
+	}
+	value, encoding, ok = CacheMachine.GetEncoded("api:doc", true)
+	if !ok || encoding != "" || string(value) != document {
+		t.Errorf("Expected the decoded document, got %d bytes, %q, %v", len(value), encoding, ok)
+	}
+
+	// The encoded value is not compressed again on disk, and survives the
+	// RAM tier.
+	CacheMachine.Flush()
+	if size, _ := CacheMachine.DiskCache.EntrySize("api:doc"); size != int64(len(body)+entry.HeaderSize) {
+		t.Errorf("Expected api:doc to be stored as is on disk, got %d bytes", size)
+	}
+	CacheMachine.ClearRamCache()
+	if value, encoding, ok := CacheMachine.GetEncoded("api:doc", false); !ok || encoding != "gzip" || string(value) != string(body) {
+		t.Errorf("Expected the gzipped document from disk, got %d bytes, %q, %v", len(value), encoding, ok)
+	}
+
+	// Values set with Set or without a coding are returned as is.
+	CacheMachine.Set("plain", []byte("12345"))
+	CacheMachine.SetEncoded("identity", []byte("67890"), "identity", 0)
+	if value, encoding, ok := CacheMachine.GetEncoded("plain", false); !ok || encoding != "" || string(value) != "12345" {
+		t.Errorf("Expected 12345, got %q, %q, %v", value, encoding, ok)
+	}
+	if value, ok := CacheMachine.Get("identity"); !ok || string(value) != "67890" {
+		t.Errorf("Expected 67890, got %q, %v", value, ok)
+	}
+}
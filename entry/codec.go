@@ -0,0 +1,152 @@
+package entry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedCodec is returned when a payload is compressed with a codec
+// that has no compressor registered.
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// Compressor compresses and decompresses payloads. It must be safe for
+// concurrent use.
+type Compressor interface {
+	Compress(payload []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[Codec]Compressor{
+		CodecGzip: gzipCompressor{},
+	}
+)
+
+// RegisterCompressor makes the payloads compressed with codec readable and
+// writable, replacing the compressor registered for codec, if any. Only
+// gzip is registered by default, and compressors for other codecs, such as
+// zstd, are registered by the programs that depend on a library
+// implementing them. Every program sharing entries must register the same
+// compressors.
+func RegisterCompressor(codec Codec, compressor Compressor) {
+	if codec == CodecNone {
+		panic("entry: cannot register a compressor for CodecNone")
+	}
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[codec] = compressor
+}
+
+// Supported reports whether a compressor is registered for codec.
+// CodecNone is always supported.
+func Supported(codec Codec) bool {
+	if codec == CodecNone {
+		return true
+	}
+	_, ok := compressor(codec)
+	return ok
+}
+
+func compressor(codec Codec) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	compressor, ok := compressors[codec]
+	return compressor, ok
+}
+
+// Compress returns payload compressed with codec.
+func Compress(codec Codec, payload []byte) ([]byte, error) {
+	if codec == CodecNone {
+		return payload, nil
+	}
+	compressor, ok := compressor(codec)
+	if !ok {
+		return nil, ErrUnsupportedCodec
+	}
+	return compressor.Compress(payload)
+}
+
+// Decompress returns the payload data was compressed from with codec.
+func Decompress(codec Codec, data []byte) ([]byte, error) {
+	if codec == CodecNone {
+		return data, nil
+	}
+	compressor, ok := compressor(codec)
+	if !ok {
+		return nil, ErrUnsupportedCodec
+	}
+	payload, err := compressor.Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	return payload, nil
+}
+
+// Payload returns the value held by the payload of an entry, decompressed
+// according to its header.
+func Payload(h Header, payload []byte) ([]byte, error) {
+	if h.Flags&FlagCompressed == 0 {
+		return payload, nil
+	}
+	return Decompress(h.Codec, payload)
+}
+
+// contentEncodings are the HTTP content codings of the codecs.
+var contentEncodings = map[Codec]string{
+	CodecGzip: "gzip",
+	CodecZstd: "zstd",
+}
+
+// ContentEncoding returns the HTTP content coding of codec, such as "gzip",
+// or "" for CodecNone and the codecs that have none.
+func (c Codec) ContentEncoding() string {
+	return contentEncodings[c]
+}
+
+// ContentEncodingCodec returns the codec of an HTTP content coding, or
+// CodecNone for no coding and "identity", and false when the coding has no
+// codec.
+func ContentEncodingCodec(contentEncoding string) (Codec, bool) {
+	contentEncoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+	switch contentEncoding {
+	case "", "identity":
+		return CodecNone, true
+	case "x-gzip":
+		return CodecGzip, true
+	}
+	for codec, name := range contentEncodings {
+		if name == contentEncoding {
+			return codec, true
+		}
+	}
+	return CodecNone, false
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
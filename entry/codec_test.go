@@ -0,0 +1,95 @@
+package entry
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// reverseCompressor "compresses" payloads by reversing them.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(payload []byte) ([]byte, error) {
+	data := make([]byte, len(payload))
+	for i, b := range payload {
+		data[len(payload)-1-i] = b
+	}
+	return data, nil
+}
+
+func (r reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return r.Compress(data)
+}
+
+func TestCompress(t *testing.T) {
+	payload := bytes.Repeat([]byte("12345"), 100)
+	data, err := Compress(CodecGzip, payload)
+	if err != nil || len(data) >= len(payload) {
+		t.Fatalf("Expected gzip to compress the payload, got %d bytes, %v", len(data), err)
+	}
+	decompressed, err := Decompress(CodecGzip, data)
+	if err != nil || !bytes.Equal(decompressed, payload) {
+		t.Errorf("Expected the payload to round trip, got %d bytes, %v", len(decompressed), err)
+	}
+	if _, err := Decompress(CodecGzip, []byte("12345")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt decompressing garbage, got %v", err)
+	}
+
+	if data, err := Compress(CodecNone, payload); err != nil || !bytes.Equal(data, payload) {
+		t.Errorf("Expected CodecNone to leave the payload as is, got %v", err)
+	}
+	if Supported(CodecZstd) {
+		t.Errorf("Expected zstd not to be supported by default")
+	}
+	if _, err := Compress(CodecZstd, payload); err != ErrUnsupportedCodec {
+		t.Errorf("Expected ErrUnsupportedCodec, got %v", err)
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	const codec Codec = 200
+	RegisterCompressor(codec, reverseCompressor{})
+	if !Supported(codec) {
+		t.Fatalf("Expected codec %d to be supported once registered", codec)
+	}
+
+	data, _ := Compress(codec, []byte("12345"))
+	h, payload, err := Open(Seal(data, FlagCompressed, codec))
+	if err != nil {
+		t.Fatalf("Expected no error opening an entry, got %s", err)
+	}
+	value, err := Payload(h, payload)
+	if err != nil || string(value) != "12345" {
+		t.Errorf("Expected 12345, got %q, %v", value, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering a compressor for CodecNone to panic")
+		}
+	}()
+	RegisterCompressor(CodecNone, reverseCompressor{})
+}
+
+func TestContentEncodingCodec(t *testing.T) {
+	for _, c := range []struct {
+		contentEncoding string
+		codec           Codec
+		ok              bool
+	}{
+		{contentEncoding: "", codec: CodecNone, ok: true},
+		{contentEncoding: "identity", codec: CodecNone, ok: true},
+		{contentEncoding: "gzip", codec: CodecGzip, ok: true},
+		{contentEncoding: " X-Gzip", codec: CodecGzip, ok: true},
+		{contentEncoding: "zstd", codec: CodecZstd, ok: true},
+		{contentEncoding: "br", codec: CodecNone, ok: false},
+	} {
+		codec, ok := ContentEncodingCodec(c.contentEncoding)
+		if codec != c.codec || ok != c.ok {
+			t.Errorf("Expected codec %d, %v for %q, got %d, %v", c.codec, c.ok, c.contentEncoding, codec, ok)
+		}
+	}
+	if name := CodecGzip.ContentEncoding(); name != "gzip" {
+		t.Errorf("Expected gzip, got %q", name)
+	}
+}
@@ -0,0 +1,167 @@
+// Package entry implements the header written in front of every value the
+// cache machine persists, in the files of the disk tier and in the objects of
+// the S3 tier. The header describes how the value is stored, so that new
+// storage features can be added without breaking the entries written before
+// them, and so that tools outside of the cache machine can parse them.
+//
+// A header is laid out as follows, with integers in big-endian order:
+//
+//	magic    3 bytes  "cm\x00"
+//	version  1 byte   Version
+//	flags    1 byte   Flags
+//	codec    1 byte   Codec
+//	length   2 bytes  length of the header, including the fields above
+//	checksum 4 bytes  CRC-32C of the payload
+//
+// The payload follows the header. Future versions only append fields to the
+// header, so a reader skips the fields it does not know by skipping length
+// bytes. Every entry carries FlagChecksum: an entry without it, or with a
+// version older than Version, is corrupt, so that flipping a bit of the
+// header cannot turn the verification off.
+package entry
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	// ErrNoHeader is returned when data does not start with a header.
+	ErrNoHeader = errors.New("no entry header")
+
+	// ErrCorrupt is returned when an entry is truncated, has no checksum or
+	// does not match it.
+	ErrCorrupt = errors.New("corrupt entry")
+
+	// ErrUnsupportedVersion is returned when an entry was written with a
+	// newer version of the header.
+	ErrUnsupportedVersion = errors.New("unsupported entry version")
+)
+
+// Version is the version of the headers written by this package.
+const Version = 2
+
+// HeaderSize is the length of the headers written by this package.
+const HeaderSize = 12
+
+var magic = []byte("cm\x00")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Flags describe how the payload of an entry is stored.
+type Flags uint8
+
+const (
+	// FlagChecksum is set when the header holds the checksum of the
+	// payload, which every entry must.
+	FlagChecksum Flags = 1 << iota
+
+	// FlagCompressed is set when the payload is compressed with the codec
+	// of the header.
+	FlagCompressed
+
+	// FlagEncrypted is set when the payload is encrypted.
+	FlagEncrypted
+
+	// FlagChunked is set when the payload only holds the first chunk of
+	// the value, or the list of its chunks.
+	FlagChunked
+
+	// FlagContentEncoded is set when the payload is a value compressed
+	// with the codec of the header by the origin of the data, such as a
+	// gzipped HTTP body, which is stored and served compressed.
+	FlagContentEncoded
+)
+
+// Codec identifies the compression codec of a payload, see
+// RegisterCompressor.
+type Codec uint8
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+
+	// CodecZstd is reserved for zstd, which has no compressor registered
+	// by default.
+	CodecZstd
+)
+
+// Header describes an entry.
+type Header struct {
+	Version  uint8
+	Flags    Flags
+	Codec    Codec
+	Checksum uint32
+}
+
+// NewHeader returns the header of payload, with the given flags and codec
+// and the checksum of payload.
+func NewHeader(payload []byte, flags Flags, codec Codec) Header {
+	return Header{
+		Version:  Version,
+		Flags:    flags | FlagChecksum,
+		Codec:    codec,
+		Checksum: crc32.Checksum(payload, crc32c),
+	}
+}
+
+// Seal returns payload after its header.
+func Seal(payload []byte, flags Flags, codec Codec) []byte {
+	data := make([]byte, 0, HeaderSize+len(payload))
+	data = NewHeader(payload, flags, codec).AppendTo(data)
+	return append(data, payload...)
+}
+
+// AppendTo appends the encoded header to dst.
+func (h Header) AppendTo(dst []byte) []byte {
+	var header [HeaderSize]byte
+	copy(header[:], magic)
+	header[3] = h.Version
+	header[4] = byte(h.Flags)
+	header[5] = byte(h.Codec)
+	binary.BigEndian.PutUint16(header[6:], HeaderSize)
+	binary.BigEndian.PutUint32(header[8:], h.Checksum)
+	return append(dst, header[:]...)
+}
+
+// ParseHeader decodes the header at the start of data, and returns it along
+// with its length. It does not verify the payload.
+func ParseHeader(data []byte) (Header, int, error) {
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != string(magic) {
+		return Header{}, 0, ErrNoHeader
+	}
+	h := Header{Version: data[len(magic)]}
+	switch {
+	case h.Version < Version:
+		return Header{}, 0, ErrCorrupt
+	case h.Version > Version:
+		return Header{}, 0, ErrUnsupportedVersion
+	}
+	if len(data) < HeaderSize {
+		return Header{}, 0, ErrCorrupt
+	}
+	length := int(binary.BigEndian.Uint16(data[6:]))
+	if length < HeaderSize || length > len(data) {
+		return Header{}, 0, ErrCorrupt
+	}
+	h.Flags = Flags(data[4])
+	h.Codec = Codec(data[5])
+	h.Checksum = binary.BigEndian.Uint32(data[8:])
+	return h, length, nil
+}
+
+// Open decodes the header at the start of data and returns it along with the
+// payload, after checking the payload against its checksum. Entries without
+// a checksum are corrupt.
+func Open(data []byte) (Header, []byte, error) {
+	h, length, err := ParseHeader(data)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	payload := data[length:]
+	if h.Flags&FlagChecksum == 0 || crc32.Checksum(payload, crc32c) != h.Checksum {
+		return Header{}, nil, ErrCorrupt
+	}
+	return h, payload, nil
+}
@@ -0,0 +1,71 @@
+package entry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	data := Seal([]byte("12345"), FlagCompressed, CodecGzip)
+	if len(data) != HeaderSize+5 || !bytes.HasPrefix(data, []byte("cm\x00\x02")) {
+		t.Fatalf("Expected a version %d header, got %q", Version, data)
+	}
+
+	h, payload, err := Open(data)
+	if err != nil {
+		t.Fatalf("Expected no error opening an entry, got %s", err)
+	}
+	if string(payload) != "12345" {
+		t.Errorf("Expected 12345, got %q", payload)
+	}
+	if h.Version != Version || h.Flags != FlagChecksum|FlagCompressed || h.Codec != CodecGzip {
+		t.Errorf("Expected the header to round trip, got %+v", h)
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, _, err := Open(data); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt opening a corrupt entry, got %v", err)
+	}
+	if _, _, err := Open(data[:HeaderSize-1]); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt opening a truncated header, got %v", err)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	if _, _, err := ParseHeader([]byte("12345")); err != ErrNoHeader {
+		t.Errorf("Expected ErrNoHeader, got %v", err)
+	}
+
+	// Older versions and entries without checksum are corrupt.
+	for _, version := range []byte{0, 1} {
+		older := Seal([]byte("12345"), 0, CodecNone)
+		older[3] = version
+		if _, _, err := Open(older); err != ErrCorrupt {
+			t.Errorf("Expected ErrCorrupt opening a version %d entry, got %v", version, err)
+		}
+	}
+	unchecked := Seal([]byte("12345"), 0, CodecNone)
+	unchecked[4] &^= byte(FlagChecksum)
+	if _, _, err := Open(unchecked); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt opening an entry without checksum, got %v", err)
+	}
+
+	// Fields appended by future versions are skipped.
+	data := Seal([]byte("12345"), 0, CodecNone)
+	longer := append(append(data[:HeaderSize:HeaderSize], "ext"...), data[HeaderSize:]...)
+	binary.BigEndian.PutUint16(longer[6:], HeaderSize+3)
+	_, length, err := ParseHeader(longer)
+	if err != nil || length != HeaderSize+3 {
+		t.Errorf("Expected a %d bytes header, got %d, %v", HeaderSize+3, length, err)
+	}
+	if _, payload, err := Open(longer); err != nil || string(payload) != "12345" {
+		t.Errorf("Expected 12345, got %q, %v", payload, err)
+	}
+
+	newer := Seal([]byte("12345"), 0, CodecNone)
+	newer[3] = Version + 1
+	if _, _, err := ParseHeader(newer); err != ErrUnsupportedVersion {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
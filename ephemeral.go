@@ -0,0 +1,201 @@
+package cachemachine
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+// EphemeralStorageLimitEnv is the environment variable from which the
+// ephemeral storage limit of the pod is read, when
+// CacheMachine.EphemeralStorageLimit is not set. It is meant to be set
+// from the downward API:
+//
+//	env:
+//	- name: CACHEMACHINE_EPHEMERAL_STORAGE_LIMIT
+//	  valueFrom:
+//	    resourceFieldRef:
+//	      resource: limits.ephemeral-storage
+const EphemeralStorageLimitEnv = "CACHEMACHINE_EPHEMERAL_STORAGE_LIMIT"
+
+// DefaultEphemeralStorageHeadroomPercent is the default value of
+// CacheMachine.EphemeralStorageHeadroomPercent.
+const DefaultEphemeralStorageHeadroomPercent = 10
+
+// diskBlockSize is the block size files are assumed to be allocated by, on
+// the platforms where the space allocated to a file is not known.
+const diskBlockSize = 4096
+
+// DiskPressure describes how close the disk tier is to the storage it may
+// use, as reported by CacheMachine.DiskPressure.
+type DiskPressure struct {
+	// Limit is the ephemeral storage limit, or 0 when there is none.
+	Limit int64 `json:"limit"`
+
+	// Capacity is the size the disk tier is bounded to, counting the
+	// values of its entries only, and Used the space taken by the files of
+	// its directory, headers, block rounding and files left by a previous
+	// process included.
+	Capacity int64 `json:"capacity"`
+	Used     int64 `json:"used"`
+
+	// Free is the free space of the filesystem of the disk tier, or -1
+	// when it cannot be known on this platform.
+	Free int64 `json:"free"`
+
+	// UnderPressure is set when the disk tier uses more than the ephemeral
+	// storage limit less the headroom, or when the free space of its
+	// filesystem fell below the headroom.
+	UnderPressure bool `json:"under_pressure"`
+}
+
+// ephemeralStorageLimit returns the ephemeral storage limit, from
+// EphemeralStorageLimit or else from the EphemeralStorageLimitEnv
+// environment variable, or 0 when there is none.
+func (c *CacheMachine) ephemeralStorageLimit() (int64, error) {
+	if c.EphemeralStorageLimit > 0 {
+		return c.EphemeralStorageLimit, nil
+	}
+	value := os.Getenv(EphemeralStorageLimitEnv)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := parseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", EphemeralStorageLimitEnv, err)
+	}
+	return limit, nil
+}
+
+// headroom returns the number of bytes kept free out of size.
+func (c *CacheMachine) headroom(size int64) int64 {
+	percent := c.EphemeralStorageHeadroomPercent
+	if percent < 0 {
+		percent = 0
+	}
+	return size / 100 * int64(percent)
+}
+
+// capDiskCacheSize bounds the size of the disk tier in path to the
+// ephemeral storage limit, less the headroom and the space already taken in
+// path, such as by the files left by a previous process, so that the kubelet
+// does not evict the pod for using more than its limit.
+func (c *CacheMachine) capDiskCacheSize(size int64, path string) (int64, error) {
+	limit, err := c.ephemeralStorageLimit()
+	if err != nil || limit <= 0 {
+		return size, err
+	}
+	used, err := diskUsage(path)
+	if err != nil {
+		return 0, fmt.Errorf("error measuring the disk usage of %s: %s", path, err)
+	}
+	budget := limit - c.headroom(limit) - used
+	if budget <= 0 {
+		return 0, fmt.Errorf("ephemeral storage limit of %d bytes leaves no room for the disk cache", limit)
+	}
+	if size > budget {
+		c.Logger.Warn("disk cache size capped to the ephemeral storage limit", "size", size, "capped", budget, "limit", limit)
+		return budget, nil
+	}
+	return size, nil
+}
+
+// DiskPressure reports how close the disk tier is to the storage it may
+// use. It walks the directory of the disk tier to measure its usage, so its
+// cost grows with the number of entries. It returns the zero DiskPressure
+// when the disk tier is not enabled.
+func (c *CacheMachine) DiskPressure() DiskPressure {
+	diskCache := c.DiskCache
+	if diskCache == nil {
+		return DiskPressure{}
+	}
+	limit, _ := c.ephemeralStorageLimit()
+	pressure := DiskPressure{
+		Limit:    limit,
+		Capacity: c.DiskCacheSizeInBytes,
+		Free:     -1,
+	}
+	used, err := diskUsage(c.DiskCachePath)
+	if err != nil {
+		used = diskCache.Size() + int64(diskCache.Len())*entry.HeaderSize
+	}
+	pressure.Used = used
+	if free, err := freeSpace(c.DiskCachePath); err == nil {
+		pressure.Free = free
+	}
+
+	total := pressure.Limit
+	if total == 0 {
+		total = pressure.Capacity
+	}
+	pressure.UnderPressure = (limit > 0 && pressure.Used > limit-c.headroom(limit)) ||
+		(pressure.Free >= 0 && pressure.Free < c.headroom(total))
+	return pressure
+}
+
+// diskUsage returns the space taken by the files in dir and its
+// subdirectories. A missing dir takes no space.
+func diskUsage(dir string) (int64, error) {
+	var used int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		used += allocatedSize(info)
+		return nil
+	})
+	return used, err
+}
+
+// roundToBlock rounds size up to a multiple of diskBlockSize.
+func roundToBlock(size int64) int64 {
+	return (size + diskBlockSize - 1) / diskBlockSize * diskBlockSize
+}
+
+// quantitySuffixes are the multipliers of the suffixes of the Kubernetes
+// resource quantities.
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseQuantity parses a number of bytes written as a Kubernetes resource
+// quantity, such as 2Gi or 500M.
+func parseQuantity(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := 1.0
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			s = strings.TrimSuffix(s, q.suffix)
+			multiplier = q.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return int64(value * multiplier), nil
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !linux
+
+package cachemachine
+
+import (
+	"errors"
+	"os"
+)
+
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
+
+// allocatedSize returns the size of the file described by info, rounded up
+// to a block, the space allocated to files not being known on this
+// platform.
+func allocatedSize(info os.FileInfo) int64 {
+	return roundToBlock(info.Size())
+}
@@ -0,0 +1,27 @@
+//go:build darwin || linux
+
+package cachemachine
+
+import (
+	"os"
+	"syscall"
+)
+
+// freeSpace returns the space available to unprivileged users on the
+// filesystem of path.
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// allocatedSize returns the space allocated to the file described by info,
+// in blocks of 512 bytes.
+func allocatedSize(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Blocks) * 512
+	}
+	return roundToBlock(info.Size())
+}
@@ -0,0 +1,135 @@
+package cachemachine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdemers/cachemachine/entry"
+)
+
+func TestParseQuantity(t *testing.T) {
+	for _, c := range []struct {
+		quantity string
+		bytes    int64
+	}{
+		{"1073741824", 1 << 30},
+		{"2Gi", 2 << 30},
+		{"512Mi", 512 << 20},
+		{"1.5Ki", 1536},
+		{"500M", 500 * 1000 * 1000},
+		{"1k", 1000},
+	} {
+		bytes, err := parseQuantity(c.quantity)
+		if err != nil || bytes != c.bytes {
+			t.Errorf("Expected %s to be %d bytes, got %d, %v", c.quantity, c.bytes, bytes, err)
+		}
+	}
+	for _, quantity := range []string{"", "Gi", "-1", "12X"} {
+		if _, err := parseQuantity(quantity); err == nil {
+			t.Errorf("Expected an error parsing %q", quantity)
+		}
+	}
+}
+
+func TestCacheMachine_EphemeralStorageLimit(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	t.Setenv(EphemeralStorageLimitEnv, "1Mi")
+	err = CacheMachine.EnableDiskCache(2<<20, tmpFolder)
+	if err != nil {
+		t.Fatalf("Expected no error enabling disk cache, got %s", err)
+	}
+	if CacheMachine.DiskCacheSizeInBytes != 1048576-104850 {
+		t.Errorf("Expected the disk cache to be capped to 943726 bytes, got %d", CacheMachine.DiskCacheSizeInBytes)
+	}
+	pressure := CacheMachine.DiskPressure()
+	if pressure.Limit != 1<<20 || pressure.Capacity != 943726 || pressure.UnderPressure {
+		t.Errorf("Expected a limit of 1Mi and no pressure, got %+v", pressure)
+	}
+	CacheMachine.DisableDiskCache()
+
+	// The files left in the directory by a previous process count against
+	// the limit.
+	leftover := filepath.Join(tmpFolder, "leftover")
+	if err := ioutil.WriteFile(leftover, make([]byte, 100<<10), 0644); err != nil {
+		t.Fatalf("Error writing %s: %s", leftover, err)
+	}
+	err = CacheMachine.EnableDiskCache(2<<20, tmpFolder)
+	if err != nil {
+		t.Fatalf("Expected no error enabling disk cache, got %s", err)
+	}
+	if CacheMachine.DiskCacheSizeInBytes > 943726-100<<10 {
+		t.Errorf("Expected the disk cache to be capped below %d bytes, got %d", 943726-100<<10, CacheMachine.DiskCacheSizeInBytes)
+	}
+	if pressure := CacheMachine.DiskPressure(); pressure.Used < 100<<10 {
+		t.Errorf("Expected the leftover file to be counted as used, got %+v", pressure)
+	}
+	CacheMachine.DisableDiskCache()
+	os.Remove(leftover)
+
+	// The hard cap takes precedence over the environment.
+	CacheMachine.EphemeralStorageLimit = 100
+	CacheMachine.EphemeralStorageHeadroomPercent = 100
+	if err := CacheMachine.EnableDiskCache(1024, tmpFolder); err == nil {
+		t.Errorf("Expected an error enabling disk cache without room left")
+	}
+
+	t.Setenv(EphemeralStorageLimitEnv, "lots")
+	CacheMachine.EphemeralStorageLimit = 0
+	if err := CacheMachine.EnableDiskCache(1024, tmpFolder); err == nil {
+		t.Errorf("Expected an error enabling disk cache with an invalid limit")
+	}
+}
+
+func TestCacheMachine_DiskPressure(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if pressure := CacheMachine.DiskPressure(); pressure != (DiskPressure{}) {
+		t.Errorf("Expected no disk pressure without a disk cache, got %+v", pressure)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	if err := CacheMachine.Flush(); err != nil {
+		t.Fatalf("Expected no error flushing, got %s", err)
+	}
+	pressure := CacheMachine.DiskPressure()
+	if pressure.Used < 5+entry.HeaderSize || pressure.Capacity != 1024 || pressure.UnderPressure {
+		t.Errorf("Expected the file of key1 to be used and no pressure, got %+v", pressure)
+	}
+
+	// The filesystem cannot keep an exabyte free.
+	CacheMachine.EphemeralStorageLimit = 1 << 62
+	CacheMachine.EphemeralStorageHeadroomPercent = 50
+	pressure = CacheMachine.DiskPressure()
+	if pressure.Free >= 0 && !pressure.UnderPressure {
+		t.Errorf("Expected pressure when the filesystem is short of headroom, got %+v", pressure)
+	}
+	if CacheMachine.Stats().DiskUnderPressure != pressure.UnderPressure {
+		t.Errorf("Expected the stats to report the disk pressure")
+	}
+}
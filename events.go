@@ -0,0 +1,185 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of the events emitted by a cache machine.
+const (
+	// EventSyncFailure is emitted when an entry cannot be synced to disk.
+	EventSyncFailure = "sync_failure"
+
+	// EventBigEviction is emitted when an entry of at least
+	// BigEvictionSizeInBytes is evicted from disk.
+	EventBigEviction = "big_eviction"
+
+	// EventUnsyncedEvictions is emitted when a sync finds entries that were
+	// evicted from RAM before being synced, and are therefore lost.
+	EventUnsyncedEvictions = "unsynced_evictions"
+
+	// EventPolicyTrip is emitted when a policy of the cache machine rejects
+	// or alters an operation.
+	EventPolicyTrip = "policy_trip"
+
+	// EventPurge is emitted when the entries of a data subject are purged.
+	EventPurge = "purge"
+
+	// EventDiskMoved is emitted when the disk tier has been moved to a new
+	// directory.
+	EventDiskMoved = "disk_moved"
+
+	// EventLeaseLost is emitted when another owner takes over the lease of
+	// the directory of the disk cache, which stops being written to.
+	EventLeaseLost = "lease_lost"
+
+	// EventDiskDrained is emitted when the disk cache replaced by
+	// SwapDiskEngine has been drained and released.
+	EventDiskDrained = "disk_drained"
+
+	// EventTierReordered is emitted when the order in which the disk and S3
+	// tiers are read changes, see EnableTierReordering.
+	EventTierReordered = "tier_reordered"
+
+	// EventHotSetDrained is emitted when DrainToS3 has written the hot set
+	// of the cache machine and its manifest to S3.
+	EventHotSetDrained = "hot_set_drained"
+)
+
+// DefaultBigEvictionSizeInBytes is the default value of
+// CacheMachine.BigEvictionSizeInBytes.
+const DefaultBigEvictionSizeInBytes = 1024 * 1024
+
+// eventQueueSize bounds the number of events waiting to be handled. Events
+// are dropped rather than blocking the cache machine when it is full.
+const eventQueueSize = 256
+
+// Event is a significant cache event, meant to be shipped to a log pipeline.
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+
+	// Dropped is the number of events dropped, because of the rate limit or
+	// of a slow handler, since the previous event was emitted.
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+// JSONLines returns an event handler writing every event to w as a line of
+// JSON. Writes are serialized, so w does not need to be safe for concurrent
+// use.
+func JSONLines(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(event)
+	}
+}
+
+// eventStream rate limits events and hands them to the handler from its own
+// goroutine, so emitting an event never blocks.
+type eventStream struct {
+	queue        chan Event
+	done         chan struct{}
+	maxPerSecond int
+
+	mu          sync.Mutex
+	closed      bool
+	windowStart time.Time
+	windowCount int
+	dropped     int64
+}
+
+func (s *eventStream) emit(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	if event.Time.Sub(s.windowStart) >= time.Second {
+		s.windowStart = event.Time
+		s.windowCount = 0
+	}
+	if s.windowCount >= s.maxPerSecond {
+		s.dropped++
+		return
+	}
+
+	event.Dropped = s.dropped
+	select {
+	case s.queue <- event:
+		s.windowCount++
+		s.dropped = 0
+	default:
+		s.dropped++
+	}
+}
+
+// EnableEvents emits significant cache events to handler, at most
+// maxEventsPerSecond per second. The handler is called from a single
+// goroutine; events are dropped rather than slowing the cache machine down
+// when it cannot keep up, and the number of dropped events is reported with
+// the next event handled.
+func (c *CacheMachine) EnableEvents(handler func(Event), maxEventsPerSecond int) error {
+	if maxEventsPerSecond <= 0 {
+		return fmt.Errorf("maxEventsPerSecond must be greater than 0")
+	}
+	if c.eventStream() != nil {
+		return fmt.Errorf("events are already enabled")
+	}
+
+	stream := &eventStream{
+		queue:        make(chan Event, eventQueueSize),
+		done:         make(chan struct{}),
+		maxPerSecond: maxEventsPerSecond,
+	}
+	go func() {
+		defer close(stream.done)
+		for event := range stream.queue {
+			handler(event)
+		}
+	}()
+	c.events.Store(stream)
+	return nil
+}
+
+// DisableEvents stops emitting events, after the pending ones are handled.
+func (c *CacheMachine) DisableEvents() {
+	stream := c.eventStream()
+	if stream == nil {
+		return
+	}
+	c.events.Store((*eventStream)(nil))
+
+	stream.mu.Lock()
+	stream.closed = true
+	close(stream.queue)
+	stream.mu.Unlock()
+	<-stream.done
+}
+
+func (c *CacheMachine) eventStream() *eventStream {
+	stream, _ := c.events.Load().(*eventStream)
+	return stream
+}
+
+// emitEvent emits an event if events are enabled.
+func (c *CacheMachine) emitEvent(eventType, message string, fields map[string]interface{}) {
+	stream := c.eventStream()
+	if stream == nil {
+		return
+	}
+	stream.emit(Event{
+		Time:    time.Now(),
+		Type:    eventType,
+		Message: message,
+		Fields:  fields,
+	})
+}
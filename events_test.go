@@ -0,0 +1,86 @@
+package cachemachine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_EnableEvents(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(5, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.BigEvictionSizeInBytes = 5
+
+	err = CacheMachine.EnableEvents(func(Event) {}, 0)
+	if err == nil {
+		t.Errorf("Expected error enabling events with a 0 rate")
+	}
+
+	var buf bytes.Buffer
+	err = CacheMachine.EnableEvents(JSONLines(&buf), 100)
+	if err != nil {
+		t.Errorf("Expected no error enabling events, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Set("key3", []byte("abcde"))
+	CacheMachine.RamCache.Del([]byte("key3"))
+	CacheMachine.Flush()
+	CacheMachine.Set("key4", []byte("too large for the disk cache"))
+	CacheMachine.Flush()
+
+	CacheMachine.DisableEvents()
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Errorf("Expected a JSON event, got %q", line)
+		}
+		types = append(types, event.Type)
+	}
+	expected := []string{EventBigEviction, EventUnsyncedEvictions, EventSyncFailure}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected events %v, got %v", expected, types)
+	}
+}
+
+func TestEventStream_RateLimit(t *testing.T) {
+	stream := &eventStream{
+		queue:        make(chan Event, 10),
+		maxPerSecond: 2,
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		stream.emit(Event{Time: now})
+	}
+	stream.emit(Event{Time: now.Add(time.Second)})
+
+	if len(stream.queue) != 3 {
+		t.Errorf("Expected 3 events to be queued, got %d", len(stream.queue))
+	}
+	<-stream.queue
+	<-stream.queue
+	if event := <-stream.queue; event.Dropped != 3 {
+		t.Errorf("Expected the last event to report 3 dropped events, got %d", event.Dropped)
+	}
+}
@@ -0,0 +1,107 @@
+package cachemachine
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEvictionCheckInterval is the interval of EnableEvictionDetection
+// when none is given.
+const DefaultEvictionCheckInterval = time.Second
+
+// evictionHook holds the function EnableEvictionDetection reports the
+// evicted entries to.
+type evictionHook struct {
+	onEvict func(key string)
+}
+
+// EnableEvictionDetection checks every interval for entries evicted from
+// RAM while still waiting to be synced to disk, which are lost. freecache
+// does not report its evictions, so the check only runs when its eviction
+// counter moved since the previous one, and then looks the keys of the sync
+// queue up in the RAM cache. The entries found evicted are dropped from the
+// sync table right away rather than by the next sync, and counted in
+// Stats.UnsyncedEvictions.
+//
+// onEvict, when set, is called with the key of every such entry, whether
+// found by the check or by the sync, so that the application can compute
+// it again. It is called without any lock held. The checks stop on Close.
+func (c *CacheMachine) EnableEvictionDetection(interval time.Duration, onEvict func(key string)) {
+	if interval <= 0 {
+		interval = DefaultEvictionCheckInterval
+	}
+	c.evictionHook.Store(evictionHook{onEvict: onEvict})
+	c.AddSink(&evictionSink{c: c}, interval)
+}
+
+// evictionSink checks for evictions on every push, ignoring the stats.
+type evictionSink struct {
+	c           *CacheMachine
+	evacuations int64
+}
+
+func (s *evictionSink) Push(ctx context.Context, stats Stats) error {
+	evacuations := s.c.RamCache.EvacuateCount()
+	if evacuations == s.evacuations {
+		return nil
+	}
+	s.evacuations = evacuations
+	s.c.detectRamEvictions()
+	return nil
+}
+
+// detectRamEvictions drops the entries of the sync queue that were evicted
+// from RAM before being synced.
+func (c *CacheMachine) detectRamEvictions() {
+	now := time.Now()
+	var evicted []string
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		dirty := c.dirtyKeys(shard)
+		queue := dirty[:0]
+		for _, key := range dirty {
+			if cacheSync, ok := shard.entries[key]; ok && c.evictedUnsynced(key, cacheSync, now) {
+				c.stats.ramEvictionAges.record(now.Sub(cacheSync.SetAt))
+				delete(shard.entries, key)
+				evicted = append(evicted, key)
+				continue
+			}
+			queue = append(queue, key)
+		}
+		atomic.AddInt64(&c.stats.syncQueueDepth, int64(len(queue)-len(dirty)))
+		shard.dirty = queue
+		shard.Unlock()
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	c.emitEvent(EventUnsyncedEvictions, "entries evicted from RAM before being synced to disk", map[string]interface{}{
+		"count": len(evicted),
+	})
+	c.reportUnsyncedEvictions(evicted)
+}
+
+// evictedUnsynced reports whether the entry for key, waiting to be synced,
+// was evicted from RAM. It must be called with the stripe of the key locked.
+func (c *CacheMachine) evictedUnsynced(key string, cacheSync CacheSyncTable, now time.Time) bool {
+	if cacheSync.Negative || cacheSync.DiskSynced || cacheSync.cold || cacheSync.expired(now) {
+		return false
+	}
+	_, err := c.RamCache.TTL([]byte(key))
+	return err != nil
+}
+
+// reportUnsyncedEvictions counts the entries evicted from RAM before being
+// synced, and reports them to the hook of EnableEvictionDetection.
+func (c *CacheMachine) reportUnsyncedEvictions(keys []string) {
+	atomic.AddInt64(&c.stats.unsyncedEvictions, int64(len(keys)))
+	hook, _ := c.evictionHook.Load().(evictionHook)
+	if hook.onEvict == nil {
+		return
+	}
+	for _, key := range keys {
+		hook.onEvict(key)
+	}
+}
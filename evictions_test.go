@@ -0,0 +1,78 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_EvictionDetection(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(10*1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	var mu sync.Mutex
+	evicted := make(map[string]bool)
+	CacheMachine.EnableEvictionDetection(time.Hour, func(key string) {
+		mu.Lock()
+		evicted[key] = true
+		mu.Unlock()
+	})
+	defer CacheMachine.Close()
+
+	fill := func(round int) {
+		filler := make([]byte, 100)
+		for i := 0; i < 10000; i++ {
+			CacheMachine.Set(fmt.Sprintf("filler%d-%d", round, i), filler)
+		}
+	}
+
+	// The check finds the entries evicted while waiting to be synced.
+	CacheMachine.Set("key1", []byte("12345"))
+	fill(1)
+	sink := &evictionSink{c: CacheMachine}
+	sink.Push(context.Background(), Stats{})
+	if !evicted["key1"] {
+		t.Errorf("Expected key1 to be reported evicted")
+	}
+	if _, ok := CacheMachine.SyncState("key1"); ok {
+		t.Errorf("Expected key1 to be dropped from the sync table")
+	}
+	stats := CacheMachine.Stats()
+	if stats.RamEvictions == 0 || stats.UnsyncedEvictions != int64(len(evicted)) {
+		t.Errorf("Expected %d unsynced evictions, got %d out of %d evictions", len(evicted), stats.UnsyncedEvictions, stats.RamEvictions)
+	}
+	if stats.SyncQueueDepth != int64(stats.TrackedKeys) {
+		t.Errorf("Expected the %d keys left to be queued, got %d", stats.TrackedKeys, stats.SyncQueueDepth)
+	}
+
+	// Nothing is checked until the RAM cache evicts again.
+	CacheMachine.Set("key2", []byte("12345"))
+	sink.Push(context.Background(), Stats{})
+	CacheMachine.RamCache.Del([]byte("key2"))
+	sink.Push(context.Background(), Stats{})
+	if evicted["key2"] {
+		t.Errorf("Expected no check without a new eviction")
+	}
+
+	// The entries found by the sync are reported too.
+	CacheMachine.Flush()
+	if !evicted["key2"] {
+		t.Errorf("Expected key2 to be reported evicted by the sync")
+	}
+}
@@ -0,0 +1,98 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+)
+
+// Mechanisms removing expired entries from the tiers, as reported by
+// ExpiryLags and the mechanism label of the expiry lag metrics.
+const (
+	// ExpiryLazy is the removal of an expired entry by the Get finding it.
+	ExpiryLazy = "lazy"
+	// ExpirySweeper is the removal of an expired entry by the sweep of the
+	// sync table, done along with the background sync, or by the partial
+	// sweep done by Set.
+	ExpirySweeper = "sweeper"
+)
+
+// ExpiryLags holds the histograms of the time between the expiry of entries
+// and their removal from a tier, per removal mechanism. The RAM lags of lazy
+// removals are upper bounds, since freecache may have dropped the entry on
+// its own before.
+type ExpiryLags struct {
+	Lazy    AgeHistogram
+	Sweeper AgeHistogram
+}
+
+type expiryLagHistograms struct {
+	lazy    ageHistogram
+	sweeper ageHistogram
+}
+
+func (h *expiryLagHistograms) record(mechanism string, lag time.Duration) {
+	if mechanism == ExpiryLazy {
+		h.lazy.record(lag)
+	} else {
+		h.sweeper.record(lag)
+	}
+}
+
+func (h *expiryLagHistograms) snapshot() ExpiryLags {
+	return ExpiryLags{
+		Lazy:    h.lazy.snapshot(),
+		Sweeper: h.sweeper.snapshot(),
+	}
+}
+
+// expireLazily removes the entry for key from every tier if it is still the
+// expired entry described by cacheSync, as found by a Get.
+func (c *CacheMachine) expireLazily(key string, cacheSync CacheSyncTable) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	current, ok := shard.entries[key]
+	if !ok || !current.SetAt.Equal(cacheSync.SetAt) {
+		return
+	}
+	c.expireLocked(shard, key, current, time.Now(), ExpiryLazy)
+}
+
+// expireLocked removes the expired entry for key from every tier, and records
+// how late it is removed from each. It must be called with the stripe of the
+// key locked.
+func (c *CacheMachine) expireLocked(shard *syncTableShard, key string, cacheSync CacheSyncTable, now time.Time, mechanism string) {
+	if cacheSync.Negative {
+		delete(shard.entries, key)
+		return
+	}
+	if c.legalHolds.held(key) {
+		return
+	}
+	c.preserveForSnapshots(shard, key)
+	lag := now.Sub(cacheSync.ExpiresAt)
+
+	// A lazy removal follows a RAM miss, freecache dropping expired entries
+	// as it finds them.
+	if c.RamCache.Del([]byte(key)) || mechanism == ExpiryLazy {
+		c.stats.ramExpiryLags.record(mechanism, lag)
+	}
+	if c.DiskCache != nil {
+		deleted, err := c.DiskCache.Delete(key)
+		if err != nil {
+			c.logError("error deleting expired entry from disk", "key", key, "error", err)
+		} else if deleted {
+			c.stats.diskExpiryLags.record(mechanism, lag)
+		}
+	}
+	if cacheSync.S3Sync && c.S3Cache != nil {
+		if err := c.S3Cache.Delete(context.Background(), key); err != nil {
+			c.logError("error deleting expired entry from S3", "key", key, "error", err)
+		} else {
+			c.stats.s3ExpiryLags.record(mechanism, lag)
+		}
+	}
+	delete(shard.entries, key)
+	c.subjects.forget(key)
+}
@@ -0,0 +1,72 @@
+package cachemachine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_ExpiryLags(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	// freecache expires entries with a one second precision, so the entries
+	// are expired in the sync table only, and lazy is already gone from RAM.
+	CacheMachine.SetWithTTL("lazy", []byte("12345"), time.Hour)
+	CacheMachine.SetWithTTL("swept", []byte("67890"), time.Hour)
+	CacheMachine.Flush()
+	for _, key := range []string{"lazy", "swept"} {
+		shard := CacheMachine.syncTable.shard(key)
+		shard.Lock()
+		cacheSync := shard.entries[key]
+		cacheSync.ExpiresAt = time.Now().Add(-time.Second)
+		shard.entries[key] = cacheSync
+		shard.Unlock()
+	}
+	CacheMachine.RamCache.Del([]byte("lazy"))
+
+	if _, ok := CacheMachine.Get("lazy"); ok {
+		t.Errorf("Expected lazy to be expired")
+	}
+	for i := 0; i < syncTableShards/sweepShardsPerSync; i++ {
+		CacheMachine.sweep()
+	}
+
+	for _, key := range []string{"lazy", "swept"} {
+		if _, ok := CacheMachine.SyncState(key); ok {
+			t.Errorf("Expected %s to be forgotten", key)
+		}
+		if _, ok := CacheMachine.DiskCache.EntrySize(key); ok {
+			t.Errorf("Expected %s to be removed from disk", key)
+		}
+	}
+
+	stats := CacheMachine.Stats()
+	if stats.RamExpiryLags.Lazy.Count != 1 || stats.DiskExpiryLags.Lazy.Count != 1 {
+		t.Errorf("Expected lazy removals from RAM and disk, got %+v and %+v", stats.RamExpiryLags, stats.DiskExpiryLags)
+	}
+	if stats.RamExpiryLags.Sweeper.Count != 1 || stats.DiskExpiryLags.Sweeper.Count != 1 {
+		t.Errorf("Expected swept removals from RAM and disk, got %+v and %+v", stats.RamExpiryLags, stats.DiskExpiryLags)
+	}
+
+	var buf bytes.Buffer
+	CacheMachine.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `cachemachine_expiry_lag_seconds_count{tier="disk",mechanism="sweeper"} 1`) {
+		t.Errorf("Expected the expiry lag of the disk tier in the metrics")
+	}
+}
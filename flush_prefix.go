@@ -0,0 +1,67 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// FlushPrefix synchronously syncs the entries waiting to be synced whose key
+// starts with prefix, and only those, so that a component can make its own
+// keys durable at a checkpoint without flushing the whole cache machine.
+// It returns an error when some of them could not be written, or were
+// evicted from RAM before being synced and are lost, and an error wrapping
+// the error of ctx when ctx is done first. The entries left unsynced stay
+// queued for the background sync.
+func (c *CacheMachine) FlushPrefix(ctx context.Context, prefix string) error {
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Flush)
+	defer cancel()
+	s3Cache := c.S3Cache
+
+	var result syncResult
+	var failed int
+	for i := range c.syncTable {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("flush of %s interrupted: %w", prefix, err)
+		}
+		shard := &c.syncTable[i]
+		shard.Lock()
+		queue := c.dirtyKeys(shard)
+		kept := make([]string, 0, len(queue))
+		for _, key := range queue {
+			if !strings.HasPrefix(key, prefix) || ctx.Err() != nil {
+				kept = append(kept, key)
+				continue
+			}
+			cacheSync, ok := shard.entries[key]
+			if !ok || cacheSync.Negative || (cacheSync.DiskSynced && (s3Cache == nil || cacheSync.S3Sync)) {
+				continue
+			}
+			if _, retry := c.syncEntry(ctx, shard, key, cacheSync, s3Cache, &result); retry {
+				kept = append(kept, key)
+				failed++
+			}
+		}
+		shard.dirty = kept
+		atomic.AddInt64(&c.stats.syncQueueDepth, int64(len(kept)-len(queue)))
+		shard.Unlock()
+	}
+
+	if result.lost > 0 {
+		c.emitEvent(EventUnsyncedEvictions, "entries evicted from RAM before being synced to disk", map[string]interface{}{
+			"count": result.lost,
+		})
+		c.reportUnsyncedEvictions(result.lostKeys)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("flush of %s interrupted: %w", prefix, err)
+	}
+	if failed > 0 || result.lost > 0 {
+		return fmt.Errorf("%d entries of %s failed to sync, %d were lost", failed, prefix, result.lost)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCacheMachine_FlushPrefix(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if err := CacheMachine.FlushPrefix(context.Background(), "orders:"); err == nil {
+		t.Errorf("Expected an error flushing without a disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("orders:1", []byte("12345"))
+	CacheMachine.Set("orders:2", []byte("67890"))
+	CacheMachine.Set("users:1", []byte("abcde"))
+	if err := CacheMachine.FlushPrefix(context.Background(), "orders:"); err != nil {
+		t.Fatalf("Expected no error flushing orders, got %s", err)
+	}
+	for _, key := range []string{"orders:1", "orders:2"} {
+		if state, _ := CacheMachine.SyncState(key); !state.DiskSynced {
+			t.Errorf("Expected %s to be synced", key)
+		}
+	}
+	if state, _ := CacheMachine.SyncState("users:1"); state.DiskSynced {
+		t.Errorf("Expected users:1 not to be synced")
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 1 {
+		t.Errorf("Expected users:1 to be left queued, got a depth of %d", depth)
+	}
+
+	// Entries evicted from RAM before being synced are reported as lost.
+	CacheMachine.Set("orders:3", []byte("12345"))
+	CacheMachine.RamCache.Del([]byte("orders:3"))
+	if err := CacheMachine.FlushPrefix(context.Background(), "orders:"); err == nil {
+		t.Errorf("Expected an error flushing a lost entry")
+	}
+	if stats := CacheMachine.Stats(); stats.UnsyncedEvictions != 1 || stats.SyncQueueDepth != 1 {
+		t.Errorf("Expected 1 unsynced eviction and users:1 still queued, got %d and %d", stats.UnsyncedEvictions, stats.SyncQueueDepth)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CacheMachine.FlushPrefix(ctx, "users:"); err == nil {
+		t.Errorf("Expected an error flushing with a canceled context")
+	}
+	CacheMachine.Flush()
+	if state, _ := CacheMachine.SyncState("users:1"); !state.DiskSynced {
+		t.Errorf("Expected users:1 to be synced by the next flush")
+	}
+}
@@ -0,0 +1,306 @@
+package cachemachine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sketchDepth is the number of rows of the count-min sketch.
+	sketchDepth = 4
+
+	// sketchMinWidth and sketchMaxWidth bound the number of counters per row
+	// of the count-min sketch, derived from the size of the RAM cache.
+	sketchMinWidth = 1 << 10
+	sketchMaxWidth = 1 << 20
+
+	// hotKeysCapacity is the number of most requested keys tracked for
+	// HotKeys.
+	hotKeysCapacity = 128
+)
+
+// HotKey is a key returned by HotKeys, with the estimated number of times it
+// was recently requested.
+type HotKey struct {
+	Key      string
+	Requests int64
+}
+
+// frequencySketch estimates how often keys are requested with a count-min
+// sketch. Counters are halved every time the number of requests recorded
+// reaches ten times the width of the sketch, so that the estimates favor
+// recent requests. Counters are updated atomically without a lock; the
+// updates lost to concurrent halving only make the estimates a bit lower.
+type frequencySketch struct {
+	counters []uint32
+	mask     uint32
+
+	additions int64
+	resetAt   int64
+	resetting int32
+
+	// The hot keys are the most requested keys among the ones recorded.
+	// Their estimates are read from the sketch when needed.
+	hotMu  sync.Mutex
+	hot    sync.Map
+	hotLen int32
+	hotMin int64
+}
+
+func newFrequencySketch(width int) *frequencySketch {
+	w := sketchMinWidth
+	for w < width && w < sketchMaxWidth {
+		w <<= 1
+	}
+	return &frequencySketch{
+		counters: make([]uint32, sketchDepth*w),
+		mask:     uint32(w - 1),
+		resetAt:  int64(10 * w),
+	}
+}
+
+// indexes returns the index of the counter of key in every row.
+func (f *frequencySketch) indexes(key string) [sketchDepth]int {
+	// FNV-1a, whose low bits are poorly mixed for short keys, followed by
+	// the murmur3 finalizer, and split in two halves combined as in double
+	// hashing.
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	h1, h2 := uint32(hash), uint32(hash>>32)|1
+
+	var indexes [sketchDepth]int
+	width := int(f.mask) + 1
+	for i := range indexes {
+		indexes[i] = i*width + int((h1+uint32(i)*h2)&f.mask)
+	}
+	return indexes
+}
+
+// estimate returns the estimated number of recent requests of key.
+func (f *frequencySketch) estimate(key string) int64 {
+	min := uint32(0)
+	for i, index := range f.indexes(key) {
+		count := atomic.LoadUint32(&f.counters[index])
+		if i == 0 || count < min {
+			min = count
+		}
+	}
+	return int64(min)
+}
+
+// record records a request of key and returns its new estimate.
+func (f *frequencySketch) record(key string) int64 {
+	min := uint32(0)
+	for i, index := range f.indexes(key) {
+		count := atomic.AddUint32(&f.counters[index], 1)
+		if i == 0 || count < min {
+			min = count
+		}
+	}
+	if atomic.AddInt64(&f.additions, 1) >= f.resetAt && atomic.CompareAndSwapInt32(&f.resetting, 0, 1) {
+		f.halve()
+	}
+	f.recordHot(key, int64(min))
+	return int64(min)
+}
+
+// halve halves every counter, so that old requests weigh less than recent
+// ones.
+func (f *frequencySketch) halve() {
+	for i := range f.counters {
+		atomic.StoreUint32(&f.counters[i], atomic.LoadUint32(&f.counters[i])/2)
+	}
+	atomic.StoreInt64(&f.additions, 0)
+	f.hotMu.Lock()
+	f.updateHotMin()
+	f.hotMu.Unlock()
+	atomic.StoreInt32(&f.resetting, 0)
+}
+
+// recordHot adds key to the hot keys if it is requested more than the least
+// requested of them.
+func (f *frequencySketch) recordHot(key string, estimate int64) {
+	if _, ok := f.hot.Load(key); ok {
+		return
+	}
+	if atomic.LoadInt32(&f.hotLen) >= hotKeysCapacity && estimate <= atomic.LoadInt64(&f.hotMin) {
+		return
+	}
+
+	f.hotMu.Lock()
+	defer f.hotMu.Unlock()
+	if _, ok := f.hot.Load(key); ok {
+		return
+	}
+	if f.hotLen >= hotKeysCapacity {
+		var coldest string
+		coldestEstimate := int64(-1)
+		f.hot.Range(func(k, _ interface{}) bool {
+			if e := f.estimate(k.(string)); coldestEstimate < 0 || e < coldestEstimate {
+				coldest, coldestEstimate = k.(string), e
+			}
+			return true
+		})
+		if estimate <= coldestEstimate {
+			// The hot keys got requested since hotMin was updated:
+			// raise it, so that the next keys as cold as this one
+			// are turned away without the lock.
+			atomic.StoreInt64(&f.hotMin, coldestEstimate)
+			return
+		}
+		f.hot.Delete(coldest)
+		atomic.AddInt32(&f.hotLen, -1)
+	}
+	f.hot.Store(key, struct{}{})
+	atomic.AddInt32(&f.hotLen, 1)
+	f.updateHotMin()
+}
+
+// updateHotMin refreshes the lowest estimate of the hot keys. It must be
+// called with hotMu locked.
+func (f *frequencySketch) updateHotMin() {
+	min := int64(-1)
+	f.hot.Range(func(k, _ interface{}) bool {
+		if e := f.estimate(k.(string)); min < 0 || e < min {
+			min = e
+		}
+		return true
+	})
+	atomic.StoreInt64(&f.hotMin, min)
+}
+
+// hotKeys returns the n most requested of the hot keys, most requested
+// first.
+func (f *frequencySketch) hotKeys(n int) []HotKey {
+	var keys []HotKey
+	f.hot.Range(func(k, _ interface{}) bool {
+		keys = append(keys, HotKey{Key: k.(string), Requests: f.estimate(k.(string))})
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Requests != keys[j].Requests {
+			return keys[i].Requests > keys[j].Requests
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n >= 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// EnableFrequencyTracking starts estimating how often every key is requested
+// by Get, which HotKeys and AdmissionMinFrequency rely on. The estimates use
+// a fixed amount of memory, proportional to the size of the RAM cache.
+func (c *CacheMachine) EnableFrequencyTracking() {
+	if c.frequencySketch() == nil {
+		c.frequency.Store(newFrequencySketch(c.RamCacheSizeInBytes / 1024))
+	}
+}
+
+// HotKeys returns the n most requested keys, most requested first, along with
+// their estimated number of recent requests. It returns nil when frequency
+// tracking is not enabled.
+func (c *CacheMachine) HotKeys(n int) []HotKey {
+	sketch := c.frequencySketch()
+	if sketch == nil {
+		return nil
+	}
+	return sketch.hotKeys(n)
+}
+
+func (c *CacheMachine) frequencySketch() *frequencySketch {
+	sketch, _ := c.frequency.Load().(*frequencySketch)
+	return sketch
+}
+
+// admit reports whether a value set for key should be stored in RAM, or
+// whether it is too rarely requested and would only evict more useful
+// entries. Keys already in RAM are always admitted.
+func (c *CacheMachine) admit(key string) bool {
+	sketch := c.frequencySketch()
+	if sketch == nil || c.AdmissionMinFrequency <= 0 {
+		return true
+	}
+	if sketch.estimate(key) >= int64(c.AdmissionMinFrequency) {
+		return true
+	}
+	_, err := c.RamCache.TTL([]byte(key))
+	return err == nil
+}
+
+// setCold stores a value that was not admitted to RAM. It is written straight
+// to disk when the disk cache is enabled, and otherwise not cached at all.
+// Any previous value of key is removed from RAM, unless the value cannot be
+// written to disk, in which case the previous entry is left as it was.
+func (c *CacheMachine) setCold(shard *syncTableShard, key string, val []byte, now, expiresAt time.Time, thrash thrashState) error {
+	if c.DiskCache == nil {
+		c.RamCache.Del([]byte(key))
+		delete(shard.entries, key)
+		c.invalidate(key)
+		return nil
+	}
+
+	err := c.putDisk(c.DiskCache, key, val)
+	if err != nil {
+		return fmt.Errorf("error setting key %s: %s", key, err)
+	}
+	c.RamCache.Del([]byte(key))
+	c.stats.recordDiskWrite(len(val))
+	previous, ok := shard.entries[key]
+	if c.S3Cache != nil && (!ok || previous.DiskSynced || previous.Negative) {
+		c.enqueueDirty(shard, key)
+	}
+	shard.entries[key] = CacheSyncTable{
+		DiskSynced: true,
+		SetAt:      now,
+		ExpiresAt:  expiresAt,
+		cold:       true,
+		thrash:     thrash,
+		generation: c.nextGeneration(),
+	}
+	c.stats.recordSet(len(val))
+	c.namespaceCounters(key).recordSet(len(val))
+	c.invalidate(key)
+	return nil
+}
+
+// promote copies a value read from disk or S3 to RAM, once its key is
+// requested often enough to be admitted, unless key was set again since the
+// value was read.
+func (c *CacheMachine) promote(key string, val []byte, cacheSync CacheSyncTable) {
+	if c.frequencySketch() == nil || c.AdmissionMinFrequency <= 0 || !c.admit(key) {
+		return
+	}
+	expireSeconds := 0
+	if !cacheSync.ExpiresAt.IsZero() {
+		remaining := time.Until(cacheSync.ExpiresAt)
+		if remaining <= 0 {
+			return
+		}
+		expireSeconds = int((remaining + time.Second - 1) / time.Second)
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	current, ok := shard.entries[key]
+	if !ok || !current.SetAt.Equal(cacheSync.SetAt) || time.Now().Before(current.thrash.divertedUntil) {
+		return
+	}
+	if c.RamCache.Set([]byte(key), val, expireSeconds) == nil {
+		current.cold = false
+		shard.entries[key] = current
+	}
+}
@@ -0,0 +1,112 @@
+package cachemachine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCacheMachine_HotKeys(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	if keys := CacheMachine.HotKeys(10); keys != nil {
+		t.Errorf("Expected no hot keys without frequency tracking, got %v", keys)
+	}
+
+	CacheMachine.EnableFrequencyTracking()
+	for i := 0; i < 3; i++ {
+		CacheMachine.Get("key1")
+	}
+	CacheMachine.Get("key2")
+	for i := 0; i < 2; i++ {
+		CacheMachine.Get("key3")
+	}
+	for i := 0; i < 1000; i++ {
+		CacheMachine.Get(fmt.Sprintf("once%d", i))
+	}
+	for i := 0; i < 5; i++ {
+		CacheMachine.Get("key1")
+	}
+
+	keys := CacheMachine.HotKeys(2)
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 hot keys, got %v", keys)
+	}
+	if keys[0].Key != "key1" || keys[0].Requests < 8 {
+		t.Errorf("Expected key1 to be the hottest key with at least 8 requests, got %v", keys[0])
+	}
+	if keys[1].Key != "key3" || keys[1].Requests < 2 {
+		t.Errorf("Expected key3 to be the second hottest key with at least 2 requests, got %v", keys[1])
+	}
+}
+
+func TestCacheMachine_AdmissionMinFrequency(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.EnableFrequencyTracking()
+	CacheMachine.AdmissionMinFrequency = 2
+
+	CacheMachine.Set("key1", []byte("12345"))
+	if CacheMachine.Classify("key1") != Warm {
+		t.Errorf("Expected key1 to be written straight to disk, got %s", CacheMachine.Classify("key1"))
+	}
+	if stats := CacheMachine.Stats(); stats.AdmissionRejections != 1 {
+		t.Errorf("Expected 1 admission rejection, got %d", stats.AdmissionRejections)
+	}
+
+	value, ok := CacheMachine.Get("key1")
+	if !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 from disk, got %q, %v", value, ok)
+	}
+	if CacheMachine.Classify("key1") != Warm {
+		t.Errorf("Expected key1 not to be promoted after one request, got %s", CacheMachine.Classify("key1"))
+	}
+	CacheMachine.Get("key1")
+	if CacheMachine.Classify("key1") != Hot {
+		t.Errorf("Expected key1 to be promoted to RAM, got %s", CacheMachine.Classify("key1"))
+	}
+
+	CacheMachine.Set("key1", []byte("67890"))
+	if value, _ := CacheMachine.Get("key1"); string(value) != "67890" {
+		t.Errorf("Expected 67890, got %q", value)
+	}
+	if stats := CacheMachine.Stats(); stats.AdmissionRejections != 1 {
+		t.Errorf("Expected key1 to be admitted, got %d admission rejections", stats.AdmissionRejections)
+	}
+}
+
+func TestCacheMachine_AdmissionWithoutDisk(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	CacheMachine.EnableFrequencyTracking()
+	CacheMachine.AdmissionMinFrequency = 1
+
+	CacheMachine.Set("key1", []byte("12345"))
+	if _, ok := CacheMachine.Get("key1"); ok {
+		t.Errorf("Expected key1 not to be cached")
+	}
+	CacheMachine.Set("key1", []byte("12345"))
+	if _, ok := CacheMachine.Get("key1"); !ok {
+		t.Errorf("Expected key1 to be cached once requested")
+	}
+}
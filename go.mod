@@ -1,10 +1,7 @@
 module github.com/cdemers/cachemachine
 
-go 1.17
+go 1.18
 
-require (
-	github.com/coocood/freecache v1.2.1
-	gopkg.in/stash.v1 v1.0.0-20171203055659-1129c19e46ec
-)
+require github.com/coocood/freecache v1.2.1
 
 require github.com/cespare/xxhash/v2 v2.1.2 // indirect
@@ -0,0 +1,127 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// tierRead reads a key from one of the cold tiers.
+type tierRead struct {
+	tier tier
+	read func(ctx context.Context) ([]byte, error)
+}
+
+// readColdTiers reads key from the disk and S3 tiers that hold it, according
+// to its sync state, in that order unless S3 is preferred, see
+// EnableTierReordering. When HedgeDelay is set and both tiers
+// hold the key, the reads are hedged, see hedgedRead. It returns an empty
+// tier when no tier has the key, and an error only when ctx is done.
+func (c *CacheMachine) readColdTiers(ctx context.Context, key string, cacheSync CacheSyncTable) ([]byte, tier, error) {
+	var reads []tierRead
+	if diskCache := c.DiskCache; cacheSync.DiskSynced && diskCache != nil {
+		reads = append(reads, c.timedRead(tierRead{tierDisk, func(ctx context.Context) ([]byte, error) {
+			value, err := withContext(ctx, func() ([]byte, error) {
+				return diskCache.GetContext(ctx, key)
+			})
+			if err == diskcache.ErrNotFound {
+				if moved := c.movedDiskCache(key, diskCache); moved != nil {
+					value, err = withContext(ctx, func() ([]byte, error) {
+						return moved.GetContext(ctx, key)
+					})
+				}
+			}
+			if err == diskcache.ErrNotFound {
+				value, err = c.readDrainingDisk(key)
+			}
+			if err == diskcache.ErrCorrupt {
+				c.Logger.Warn("deleted corrupt entry from disk", "key", key)
+			}
+			return value, err
+		}}))
+	}
+	if s3Cache := c.S3Cache; cacheSync.S3Sync && s3Cache != nil {
+		reads = append(reads, c.timedRead(tierRead{tierS3, func(ctx context.Context) ([]byte, error) {
+			value, err := c.getObject(ctx, s3Cache, key)
+			if err != nil && err != ErrObjectNotFound && err != ErrCorruptObject && err != ErrUnsupportedObject && ctx.Err() == nil {
+				c.Logger.Warn("error reading from S3", "key", key, "error", err)
+			}
+			return value, err
+		}}))
+	}
+	if len(reads) > 1 && c.preferS3() {
+		reads[0], reads[1] = reads[1], reads[0]
+	}
+
+	if c.HedgeDelay > 0 && len(reads) > 1 {
+		return hedgedRead(ctx, c.HedgeDelay, reads[0], reads[1])
+	}
+	for _, r := range reads {
+		value, err := r.read(ctx)
+		if err == nil {
+			return value, r.tier, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+	}
+	return nil, "", nil
+}
+
+// hedgedRead starts the primary read, and the secondary read if the primary
+// one has not succeeded after delay, either because it is slow or because it
+// failed. It returns the first successful answer, and cancels the read still
+// in flight, if any, so that it stops its IO.
+func hedgedRead(ctx context.Context, delay time.Duration, primary, secondary tierRead) ([]byte, tier, error) {
+	type result struct {
+		value []byte
+		tier  tier
+		err   error
+	}
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Both reads can always deliver their result, so they return as soon as
+	// they notice the cancellation even once nobody waits for them anymore.
+	results := make(chan result, 2)
+	start := func(r tierRead) {
+		go func() {
+			value, err := r.read(readCtx)
+			results <- result{value, r.tier, err}
+		}()
+	}
+
+	start(primary)
+	pending := 1
+	hedged := false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.value, r.tier, nil
+			}
+			if !hedged {
+				hedged = true
+				pending++
+				start(secondary)
+			}
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				start(secondary)
+			}
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,69 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHedgedRead(t *testing.T) {
+	read := func(value string, err error, delay time.Duration) func(context.Context) ([]byte, error) {
+		return func(ctx context.Context) ([]byte, error) {
+			time.Sleep(delay)
+			return []byte(value), err
+		}
+	}
+	notFound := errors.New("not found")
+
+	for i, c := range []struct {
+		primary   tierRead
+		secondary tierRead
+		tier      tier
+	}{
+		// The primary tier answers before the hedge.
+		{tierRead{tierDisk, read("disk", nil, 0)}, tierRead{tierS3, read("s3", nil, 0)}, tierDisk},
+		// The primary tier is slow, the hedge wins.
+		{tierRead{tierDisk, read("disk", nil, time.Second)}, tierRead{tierS3, read("s3", nil, 0)}, tierS3},
+		// The primary tier fails, the secondary one is read right away.
+		{tierRead{tierDisk, read("", notFound, 0)}, tierRead{tierS3, read("s3", nil, 0)}, tierS3},
+		// Both tiers fail.
+		{tierRead{tierDisk, read("", notFound, 0)}, tierRead{tierS3, read("", notFound, 0)}, ""},
+	} {
+		value, tier, err := hedgedRead(context.Background(), 10*time.Millisecond, c.primary, c.secondary)
+		if err != nil {
+			t.Errorf("#%d: Expected no error, got %s", i+1, err)
+		}
+		if tier != c.tier || (tier != "" && string(value) != string(tier)) {
+			t.Errorf("#%d: Expected an answer from %q, got %q from %q", i+1, c.tier, value, tier)
+		}
+	}
+
+	// The read still in flight is cancelled once the other one answers.
+	cancelled := make(chan error, 1)
+	blocked := tierRead{tierDisk, func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}}
+	_, tier, err := hedgedRead(context.Background(), time.Millisecond, blocked, tierRead{tierS3, read("s3", nil, 0)})
+	if err != nil || tier != tierS3 {
+		t.Errorf("Expected an answer from S3, got %q, %v", tier, err)
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("Expected the disk read to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the disk read to be cancelled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := tierRead{tierDisk, read("disk", nil, time.Second)}
+	_, _, err = hedgedRead(ctx, time.Millisecond, slow, slow)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
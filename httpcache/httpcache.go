@@ -0,0 +1,480 @@
+// Package httpcache provides HTTP caching on top of a CacheMachine: Transport
+// is an http.RoundTripper caching the responses received by a client, and
+// Middleware caches the responses produced by a server-side handler.
+//
+// Freshness follows the Cache-Control (max-age, s-maxage, no-store, no-cache,
+// private) and Expires headers of the responses, and stale entries carrying
+// an ETag or a Last-Modified header are revalidated with conditional
+// requests. Only GET requests are cached, and responses carrying a Vary
+// header are not cached since the key does not take it into account, but
+// for Vary: Accept-Encoding. Responses compressed by the origin, such as
+// gzipped bodies, are stored compressed, and served as is to the clients
+// accepting their Content-Encoding, and decompressed to the others.
+//
+// Set-Cookie headers are never stored, so that they are not replayed to
+// other clients. Middleware being a shared cache, it does not store the
+// responses to requests carrying an Authorization header unless they are
+// explicitly public, as RFC 9111 section 3.5 requires.
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdemers/cachemachine"
+	cmentry "github.com/cdemers/cachemachine/entry"
+)
+
+// KeyFunc computes the cache key of a request.
+type KeyFunc func(req *http.Request) string
+
+// DefaultKeyFunc uses the method and the full URL of the request as its key.
+func DefaultKeyFunc(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// entry is a cached response, along with the time until which it is fresh.
+type entry struct {
+	expires  time.Time
+	response *http.Response
+	body     []byte
+}
+
+func (e *entry) fresh() bool {
+	return time.Now().Before(e.expires)
+}
+
+func (e *entry) hasValidators() bool {
+	return e.response.Header.Get("ETag") != "" || e.response.Header.Get("Last-Modified") != ""
+}
+
+// bodyFor returns the header and the body of the entry to serve to req,
+// decompressed when req does not accept the Content-Encoding of the entry,
+// or false when it cannot be decompressed.
+func (e *entry) bodyFor(req *http.Request) (http.Header, []byte, bool) {
+	header := e.response.Header.Clone()
+	header.Del("Set-Cookie")
+	contentEncoding := header.Get("Content-Encoding")
+	if contentEncoding == "" || acceptsEncoding(req, contentEncoding) {
+		return header, e.body, true
+	}
+	codec, ok := cmentry.ContentEncodingCodec(contentEncoding)
+	if !ok {
+		return nil, nil, false
+	}
+	body, err := cmentry.Decompress(codec, e.body)
+	if err != nil {
+		return nil, nil, false
+	}
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return header, body, true
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of req accepts
+// contentEncoding.
+func acceptsEncoding(req *http.Request, contentEncoding string) bool {
+	contentEncoding = strings.ToLower(contentEncoding)
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			if coding != contentEncoding && coding != "*" {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					return err == nil && q > 0
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// encode serializes the entry as its expiry time, in unix nanoseconds, on a
+// line of its own followed by the response in wire format.
+func (e *entry) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", e.expires.UnixNano())
+
+	response := *e.response
+	response.Header = e.response.Header.Clone()
+	response.Header.Del("Set-Cookie")
+	response.Body = ioutil.NopCloser(bytes.NewReader(e.body))
+	response.ContentLength = int64(len(e.body))
+	response.TransferEncoding = nil
+	if err := response.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeEntry(data []byte, req *http.Request) (*entry, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	expires, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &entry{
+		expires:  time.Unix(0, expires),
+		response: response,
+		body:     body,
+	}, nil
+}
+
+// load returns the cached entry for key, or nil.
+func load(cache *cachemachine.CacheMachine, key string, req *http.Request) *entry {
+	data, ok := cache.Get(key)
+	if !ok {
+		return nil
+	}
+	cached, err := decodeEntry(data, req)
+	if err != nil {
+		cache.Delete(key)
+		return nil
+	}
+	return cached
+}
+
+// store caches the entry until it goes stale, or staleTTL later for the
+// entries that can be revalidated, ignoring entries that are too large for
+// the cache.
+func store(cache *cachemachine.CacheMachine, key string, cached *entry, staleTTL time.Duration) {
+	ttl := time.Until(cached.expires)
+	if cached.hasValidators() {
+		ttl += staleTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	data, err := cached.encode()
+	if err != nil {
+		return
+	}
+	cache.SetWithTTL(key, data, ttl)
+}
+
+// parseCacheControl returns the directives of a Cache-Control header, with
+// their value if they have one.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = value
+	}
+	return directives
+}
+
+// cacheableRequest reports whether a request can be answered from the cache and its
+// response stored in it.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	_, noStore := parseCacheControl(req.Header)["no-store"]
+	return !noStore
+}
+
+// expiry returns the time until which a response is fresh, and false if the
+// response must not be stored at all.
+func expiry(statusCode int, header http.Header) (time.Time, bool) {
+	if statusCode != http.StatusOK || !varyCacheable(header) {
+		return time.Time{}, false
+	}
+
+	directives := parseCacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := directives["private"]; ok {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return now, true
+			}
+			return now.Add(time.Duration(seconds) * time.Second), true
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return now, true
+		}
+		return t, true
+	}
+	return now, true
+}
+
+// sharedStorable reports whether a shared cache may store the response to
+// req carrying header: the responses to requests carrying an Authorization
+// header are only stored when they are marked public, s-maxage or
+// must-revalidate.
+func sharedStorable(req *http.Request, header http.Header) bool {
+	if req.Header.Get("Authorization") == "" {
+		return true
+	}
+	directives := parseCacheControl(header)
+	for _, directive := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[directive]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// varyCacheable reports whether the responses carrying header can be cached
+// under a key ignoring their Vary header: the only header they vary on is
+// Accept-Encoding, as bodies are decompressed for the clients that do not
+// accept their encoding.
+func varyCacheable(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// storable reports whether an entry is worth storing: it is either fresh, or
+// it can be revalidated.
+func storable(cached *entry) bool {
+	return cached.fresh() || cached.hasValidators()
+}
+
+// Transport is an http.RoundTripper that caches responses in a CacheMachine.
+type Transport struct {
+	// Cache stores the responses.
+	Cache *cachemachine.CacheMachine
+
+	// Transport performs the requests that cannot be answered from the
+	// cache. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// KeyFunc computes the cache key of a request. If nil, DefaultKeyFunc
+	// is used.
+	KeyFunc KeyFunc
+
+	// StaleTTL is how long the responses that can be revalidated are kept
+	// once stale. If 0, DefaultStaleTTL is used.
+	StaleTTL time.Duration
+}
+
+// DefaultStaleTTL is the StaleTTL of a Transport leaving it unset.
+const DefaultStaleTTL = 24 * time.Hour
+
+// NewTransport returns a Transport caching responses in cache.
+func NewTransport(cache *cachemachine.CacheMachine) *Transport {
+	return &Transport{Cache: cache}
+}
+
+// Client returns an http.Client using the Transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if !cacheableRequest(req) {
+		return transport.RoundTrip(req)
+	}
+
+	key := t.key(req)
+	cached := load(t.Cache, key, req)
+	_, noCache := parseCacheControl(req.Header)["no-cache"]
+
+	if cached != nil && cached.fresh() && !noCache {
+		if resp := cachedResponse(cached, req); resp != nil {
+			return resp, nil
+		}
+	}
+
+	outgoing := req
+	if cached != nil && cached.hasValidators() {
+		outgoing = req.Clone(req.Context())
+		if etag := cached.response.Header.Get("ETag"); etag != "" {
+			outgoing.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.response.Header.Get("Last-Modified"); lastModified != "" {
+			outgoing.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		for name, values := range resp.Header {
+			cached.response.Header[name] = values
+		}
+		if expires, ok := expiry(http.StatusOK, cached.response.Header); ok {
+			cached.expires = expires
+			store(t.Cache, key, cached, t.staleTTL())
+		} else {
+			t.Cache.Delete(key)
+		}
+		if resp := cachedResponse(cached, req); resp != nil {
+			return resp, nil
+		}
+		return transport.RoundTrip(req)
+	}
+
+	expires, ok := expiry(resp.StatusCode, resp.Header)
+	if !ok {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	fetched := &entry{expires: expires, response: resp, body: body}
+	if storable(fetched) {
+		store(t.Cache, key, fetched, t.staleTTL())
+	}
+	return resp, nil
+}
+
+func (t *Transport) staleTTL() time.Duration {
+	if t.StaleTTL > 0 {
+		return t.StaleTTL
+	}
+	return DefaultStaleTTL
+}
+
+func (t *Transport) key(req *http.Request) string {
+	if t.KeyFunc != nil {
+		return t.KeyFunc(req)
+	}
+	return DefaultKeyFunc(req)
+}
+
+// cachedResponse returns a copy of the cached response for req, or nil when
+// its body cannot be decompressed for req.
+func cachedResponse(cached *entry, req *http.Request) *http.Response {
+	header, body, ok := cached.bodyFor(req)
+	if !ok {
+		return nil
+	}
+	response := *cached.response
+	response.Header = header
+	response.Header.Set("X-From-Cache", "1")
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
+	response.Request = req
+	return &response
+}
+
+// Middleware returns a middleware caching the responses of the wrapped
+// handler in cache, according to the Cache-Control and Expires headers the
+// handler sets. If keyFunc is nil, DefaultKeyFunc is used.
+func Middleware(cache *cachemachine.CacheMachine, keyFunc KeyFunc) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = DefaultKeyFunc
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !cacheableRequest(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			key := keyFunc(req)
+			if cached := load(cache, key, req); cached != nil && cached.fresh() {
+				if header, body, ok := cached.bodyFor(req); ok {
+					for name, values := range header {
+						w.Header()[name] = values
+					}
+					w.Header().Set("X-From-Cache", "1")
+					w.WriteHeader(cached.response.StatusCode)
+					w.Write(body)
+					return
+				}
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, req)
+
+			expires, ok := expiry(recorder.statusCode, w.Header())
+			if !ok || !time.Now().Before(expires) || !sharedStorable(req, w.Header()) {
+				return
+			}
+			store(cache, key, &entry{
+				expires: expires,
+				response: &http.Response{
+					StatusCode: recorder.statusCode,
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     w.Header().Clone(),
+				},
+				body: recorder.body.Bytes(),
+			}, 0)
+		})
+	}
+}
+
+// responseRecorder forwards a response to the client while keeping a copy
+// of its status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -0,0 +1,261 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cdemers/cachemachine"
+	cmentry "github.com/cdemers/cachemachine/entry"
+)
+
+func newCacheMachine(t *testing.T) *cachemachine.CacheMachine {
+	cacheMachine, err := cachemachine.NewCacheMachine(1024*1024, 1024)
+	if err != nil {
+		t.Fatalf("Error creating cache machine: %s", err)
+	}
+	return cacheMachine
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, *http.Response) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Error getting %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading body of %s: %s", url, err)
+	}
+	return string(body), resp
+}
+
+func TestTransport_MaxAge(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client := NewTransport(newCacheMachine(t)).Client()
+
+	for i := 0; i < 3; i++ {
+		body, _ := get(t, client, server.URL+"/fresh")
+		if body != "hello" {
+			t.Errorf("Expected body to be hello, got %s", body)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request to the origin, got %d", requests)
+	}
+
+	get(t, client, server.URL+"/private")
+	get(t, client, server.URL+"/private")
+	if requests != 3 {
+		t.Errorf("Expected private responses not to be cached, got %d requests", requests)
+	}
+}
+
+func TestTransport_ETagRevalidation(t *testing.T) {
+	var requests, revalidations int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client := NewTransport(newCacheMachine(t)).Client()
+
+	get(t, client, server.URL)
+	body, resp := get(t, client, server.URL)
+	if body != "hello" {
+		t.Errorf("Expected body to be hello, got %s", body)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-From-Cache") != "1" {
+		t.Errorf("Expected a cached 200 response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if requests != 2 || revalidations != 1 {
+		t.Errorf("Expected 2 requests with 1 revalidation, got %d and %d", requests, revalidations)
+	}
+}
+
+func TestTransport_TTL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/revalidated":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	cache := newCacheMachine(t)
+	transport := NewTransport(cache)
+	transport.StaleTTL = time.Hour
+	client := transport.Client()
+
+	get(t, client, server.URL+"/fresh")
+	left := time.Until(cache.Where("GET " + server.URL + "/fresh").ExpiresAt)
+	if left <= 0 || left > time.Minute {
+		t.Errorf("Expected /fresh to expire with its freshness, got %s", left)
+	}
+
+	get(t, client, server.URL+"/revalidated")
+	left = time.Until(cache.Where("GET " + server.URL + "/revalidated").ExpiresAt)
+	if left <= time.Hour || left > time.Hour+time.Minute {
+		t.Errorf("Expected /revalidated to be kept for the stale TTL, got %s", left)
+	}
+}
+
+func TestTransport_KeyFunc(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	transport := NewTransport(newCacheMachine(t))
+	transport.KeyFunc = func(req *http.Request) string {
+		return req.URL.Path
+	}
+	client := transport.Client()
+
+	get(t, client, server.URL+"/page?utm_source=a")
+	get(t, client, server.URL+"/page?utm_source=b")
+	if requests != 1 {
+		t.Errorf("Expected requests with the same key to share a cache entry, got %d requests", requests)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls int32
+	handler := Middleware(newCacheMachine(t), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/cached" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get(t, http.DefaultClient, server.URL+"/cached")
+	body, resp := get(t, http.DefaultClient, server.URL+"/cached")
+	if body != "hello" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected cached body and headers, got %s %v", body, resp.Header)
+	}
+	if resp.Header.Get("X-From-Cache") != "1" {
+		t.Errorf("Expected response to be served from the cache")
+	}
+
+	get(t, http.DefaultClient, server.URL+"/uncached")
+	get(t, http.DefaultClient, server.URL+"/uncached")
+	if calls != 3 {
+		t.Errorf("Expected handler to be called 3 times, got %d", calls)
+	}
+}
+
+func TestMiddleware_ContentEncoding(t *testing.T) {
+	var calls int32
+	handler := Middleware(newCacheMachine(t), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := cmentry.Compress(cmentry.CodecGzip, []byte("hello"))
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write(body)
+	}))
+
+	serve := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/gzipped", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	serve("gzip")
+	resp := serve("br, gzip;q=0.8")
+	if resp.Header().Get("X-From-Cache") != "1" || resp.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the gzipped response from the cache, got %v", resp.Header())
+	}
+	if body, err := cmentry.Decompress(cmentry.CodecGzip, resp.Body.Bytes()); err != nil || string(body) != "hello" {
+		t.Errorf("Expected a gzipped hello, got %q, %v", body, err)
+	}
+
+	for _, acceptEncoding := range []string{"", "gzip;q=0", "br"} {
+		resp := serve(acceptEncoding)
+		if resp.Header().Get("Content-Encoding") != "" || resp.Body.String() != "hello" {
+			t.Errorf("Expected hello decompressed for %q, got %q, %v", acceptEncoding, resp.Body.String(), resp.Header())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected handler to be called once, got %d", calls)
+	}
+}
+
+func TestMiddleware_AuthorizationAndCookies(t *testing.T) {
+	var calls int32
+	handler := Middleware(newCacheMachine(t), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("hello"))
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// The response to an authorized request is not stored, unless public.
+	serve("/private")
+	if resp := serve("/private"); resp.Header().Get("X-From-Cache") != "" {
+		t.Errorf("Expected the response to an authorized request not to be cached")
+	}
+	if resp := serve("/public"); resp.Header().Get("Set-Cookie") == "" {
+		t.Errorf("Expected Set-Cookie to reach the client of the handler")
+	}
+	resp := serve("/public")
+	if resp.Header().Get("X-From-Cache") != "1" {
+		t.Errorf("Expected a public response to an authorized request to be cached")
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected Set-Cookie not to be replayed, got %s", cookie)
+	}
+	if calls != 3 {
+		t.Errorf("Expected handler to be called 3 times, got %d", calls)
+	}
+}
@@ -0,0 +1,98 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// idleAccessSlots is the number of slots of the table of the last accesses
+// to the keys, 256KiB in total.
+const idleAccessSlots = 1 << 16
+
+// idleTracker records when the keys were last read. The keys are hashed to
+// slots holding the second of their last read, counted from start, which
+// the readers update without a lock. Keys sharing a slot pass for read as
+// recently as the most recent of them, which only makes eviction more
+// lenient.
+type idleTracker struct {
+	timeout time.Duration
+	start   time.Time
+	reads   []uint32
+}
+
+// EnableIdleEviction makes the sweeper remove from every tier the entries
+// that were neither set nor read for timeout, regardless of their TTL, for
+// caches where serving old values is fine but values nobody reads must not
+// linger. Entries under legal hold and negative entries are kept.
+//
+// Idle entries are removed by the sweep done along with the background sync
+// to disk, and by the partial sweep done by Set, so they may linger for a
+// while past timeout.
+func (c *CacheMachine) EnableIdleEviction(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("timeout must be greater than 0")
+	}
+	c.idle.Store(&idleTracker{
+		timeout: timeout,
+		start:   time.Now(),
+		reads:   make([]uint32, idleAccessSlots),
+	})
+	return nil
+}
+
+func (c *CacheMachine) idleTracker() *idleTracker {
+	tracker, _ := c.idle.Load().(*idleTracker)
+	return tracker
+}
+
+// recordIdleRead records that key was read, when idle eviction is enabled.
+func (c *CacheMachine) recordIdleRead(key string) {
+	if tracker := c.idleTracker(); tracker != nil {
+		tracker.recordRead(key, time.Now())
+	}
+}
+
+func (t *idleTracker) slot(key string) *uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &t.reads[hash%idleAccessSlots]
+}
+
+func (t *idleTracker) recordRead(key string, now time.Time) {
+	slot := t.slot(key)
+	// Zero means never read, hence the offset.
+	second := uint32(now.Sub(t.start)/time.Second) + 1
+	if atomic.LoadUint32(slot) < second {
+		atomic.StoreUint32(slot, second)
+	}
+}
+
+// idle reports whether the entry for key was neither set nor read for the
+// timeout.
+func (t *idleTracker) idle(key string, cacheSync CacheSyncTable, now time.Time) bool {
+	last := cacheSync.SetAt
+	if second := atomic.LoadUint32(t.slot(key)); second != 0 {
+		if read := t.start.Add(time.Duration(second) * time.Second); read.After(last) {
+			last = read
+		}
+	}
+	return now.Sub(last) > t.timeout
+}
+
+// evictIfIdle removes the entry for key from every tier if it is idle, and
+// reports whether it did. It must be called with the stripe of the key
+// locked.
+func (c *CacheMachine) evictIfIdle(shard *syncTableShard, key string, cacheSync CacheSyncTable, now time.Time) bool {
+	tracker := c.idleTracker()
+	if tracker == nil || cacheSync.Negative || !tracker.idle(key, cacheSync, now) || c.legalHolds.held(key) {
+		return false
+	}
+	c.deleteLocked(context.Background(), shard, key)
+	atomic.AddInt64(&c.stats.idleEvictions, 1)
+	return true
+}
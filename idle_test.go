@@ -0,0 +1,70 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_IdleEviction(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if err := CacheMachine.EnableIdleEviction(0); err == nil {
+		t.Errorf("Expected an error enabling idle eviction without a timeout")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	for _, key := range []string{"read", "unread", "held", "recent"} {
+		CacheMachine.SetWithTTL(key, []byte("12345"), time.Hour)
+	}
+	CacheMachine.Flush()
+	CacheMachine.SetLegalHold(context.Background(), "held", true)
+	if err := CacheMachine.EnableIdleEviction(time.Minute); err != nil {
+		t.Fatalf("Expected no error enabling idle eviction, got %s", err)
+	}
+
+	// Every entry but recent was set long ago, and only read was read since.
+	for _, key := range []string{"read", "unread", "held"} {
+		shard := CacheMachine.syncTable.shard(key)
+		shard.Lock()
+		cacheSync := shard.entries[key]
+		cacheSync.SetAt = time.Now().Add(-2 * time.Minute)
+		shard.entries[key] = cacheSync
+		shard.Unlock()
+	}
+	CacheMachine.ClearRamCache()
+	CacheMachine.Get("read")
+	for i := 0; i < syncTableShards/sweepShardsPerSync; i++ {
+		CacheMachine.sweep()
+	}
+
+	if _, ok := CacheMachine.SyncState("unread"); ok {
+		t.Errorf("Expected unread to be evicted")
+	}
+	if _, err := store.Get(context.Background(), "unread"); err != ErrObjectNotFound {
+		t.Errorf("Expected unread to be deleted from S3, got %v", err)
+	}
+	for _, key := range []string{"read", "held", "recent"} {
+		if value, ok := CacheMachine.Get(key); !ok || string(value) != "12345" {
+			t.Errorf("Expected %s to be kept, got %q, %v", key, value, ok)
+		}
+	}
+	if stats := CacheMachine.Stats(); stats.IdleEvictions != 1 {
+		t.Errorf("Expected 1 idle eviction, got %d", stats.IdleEvictions)
+	}
+}
@@ -0,0 +1,338 @@
+package cachemachine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// DefaultIndexKey is the key of the object holding the index uploaded by
+// EnableIndexSnapshots when none is given. It must not be used as the key
+// of an entry.
+const DefaultIndexKey = "_cachemachine/index"
+
+// indexMagic starts every index, followed by the format version.
+const (
+	indexMagic   = "CMINDX"
+	indexVersion = 1
+)
+
+const (
+	indexFlagDiskSynced = 1 << iota
+	indexFlagS3Synced
+	indexFlagInRam
+)
+
+// IndexEntry describes an entry of the cache machine in an index, see
+// ExportIndex.
+type IndexEntry struct {
+	Key       string
+	Size      int64
+	SetAt     time.Time
+	ExpiresAt time.Time
+
+	// Requests is the estimated number of recent requests of the key, or
+	// 0 when frequency tracking is not enabled.
+	Requests int64
+
+	InRam      bool
+	DiskSynced bool
+	S3Synced   bool
+}
+
+// ExportIndex writes the metadata of every entry of the cache machine to w,
+// without their values, in a compact binary format that ReadIndex can read
+// back. It tells another cache machine what this one had cached, and which
+// entries were the hottest, see PrefetchFromIndex. Exporting does not
+// affect the access statistics.
+//
+// The format is the "CMINDX" magic and a version byte, followed by one
+// record per entry made of the key prefixed by its length as a uvarint,
+// the size of the value and the estimated number of requests as uvarints,
+// the set and expiry times as varint unix nanoseconds (0 meaning no
+// expiry), and a flags byte. The last record is followed by a zero uvarint.
+func (c *CacheMachine) ExportIndex(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	writer.WriteString(indexMagic)
+	writer.WriteByte(indexVersion)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		writer.Write(buf[:binary.PutUvarint(buf, v)])
+	}
+	writeVarint := func(v int64) {
+		writer.Write(buf[:binary.PutVarint(buf, v)])
+	}
+
+	for _, entry := range c.indexEntries() {
+		var expiresAt int64
+		if !entry.ExpiresAt.IsZero() {
+			expiresAt = entry.ExpiresAt.UnixNano()
+		}
+		var flags byte
+		if entry.DiskSynced {
+			flags |= indexFlagDiskSynced
+		}
+		if entry.S3Synced {
+			flags |= indexFlagS3Synced
+		}
+		if entry.InRam {
+			flags |= indexFlagInRam
+		}
+
+		// Keys are never empty, so a zero length marks the end.
+		writeUvarint(uint64(len(entry.Key)))
+		writer.WriteString(entry.Key)
+		writeUvarint(uint64(entry.Size))
+		writeUvarint(uint64(entry.Requests))
+		writeVarint(entry.SetAt.UnixNano())
+		writeVarint(expiresAt)
+		writer.WriteByte(flags)
+	}
+	writeUvarint(0)
+
+	return writer.Flush()
+}
+
+// indexEntries returns the metadata of the live entries of the cache
+// machine, sorted by key.
+func (c *CacheMachine) indexEntries() []IndexEntry {
+	sketch := c.frequencySketch()
+	now := time.Now()
+	keys := c.keys()
+	entries := make([]IndexEntry, 0, len(keys))
+	for _, key := range keys {
+		shard := c.syncTable.shard(key)
+		shard.Lock()
+		cacheSync, ok := shard.entries[key]
+		if !ok || cacheSync.Negative || cacheSync.expired(now) {
+			shard.Unlock()
+			continue
+		}
+		size := c.entrySize(key)
+		_, err := c.RamCache.TTL([]byte(key))
+		shard.Unlock()
+
+		entry := IndexEntry{
+			Key:        key,
+			Size:       size,
+			SetAt:      cacheSync.SetAt,
+			ExpiresAt:  cacheSync.ExpiresAt,
+			InRam:      err == nil,
+			DiskSynced: cacheSync.DiskSynced,
+			S3Synced:   cacheSync.S3Sync,
+		}
+		if sketch != nil {
+			entry.Requests = sketch.estimate(key)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// rankIndexEntries sorts entries from the hottest to the coldest: by
+// estimated requests, then by residency in RAM, then by recency.
+func rankIndexEntries(entries []IndexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Requests != entries[j].Requests {
+			return entries[i].Requests > entries[j].Requests
+		}
+		if entries[i].InRam != entries[j].InRam {
+			return entries[i].InRam
+		}
+		return entries[i].SetAt.After(entries[j].SetAt)
+	})
+}
+
+// ReadIndex reads an index written by ExportIndex from r.
+func ReadIndex(r io.Reader) ([]IndexEntry, error) {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(indexMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("error reading index header: %s", err)
+	}
+	if string(header[:len(indexMagic)]) != indexMagic {
+		return nil, fmt.Errorf("not a cache machine index")
+	}
+	if header[len(indexMagic)] != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", header[len(indexMagic)])
+	}
+
+	entries := make([]IndexEntry, 0)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		if length == 0 {
+			return entries, nil
+		}
+		key := make([]byte, length)
+		if _, err := io.ReadFull(reader, key); err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		entry := IndexEntry{Key: string(key)}
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		requests, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		setAt, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		expiresAt, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+		flags, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %s", unexpectedEOF(err))
+		}
+
+		entry.Size = int64(size)
+		entry.Requests = int64(requests)
+		entry.SetAt = time.Unix(0, setAt)
+		if expiresAt != 0 {
+			entry.ExpiresAt = time.Unix(0, expiresAt)
+		}
+		entry.DiskSynced = flags&indexFlagDiskSynced != 0
+		entry.S3Synced = flags&indexFlagS3Synced != 0
+		entry.InRam = flags&indexFlagInRam != 0
+		entries = append(entries, entry)
+	}
+}
+
+// EnableIndexSnapshots uploads the index of the cache machine, see
+// ExportIndex, to the S3 tier under key every interval, and a last time on
+// Close, so that a cache machine replacing this one can learn what it had
+// cached with LoadIndex, and prefetch its hot subset with
+// PrefetchFromIndex. The index is small, as the values are not part of it.
+// The S3 tier must be enabled first.
+func (c *CacheMachine) EnableIndexSnapshots(key string, interval time.Duration) error {
+	if c.S3Cache == nil {
+		return fmt.Errorf("S3 cache is not enabled")
+	}
+	if key == "" {
+		key = DefaultIndexKey
+	}
+	c.AddSink(&indexSink{c: c, key: key}, interval)
+	return nil
+}
+
+// indexSink uploads the index on every push, ignoring the stats.
+type indexSink struct {
+	c   *CacheMachine
+	key string
+}
+
+func (s *indexSink) Push(ctx context.Context, stats Stats) error {
+	s3Cache := s.c.S3Cache
+	if s3Cache == nil {
+		return nil
+	}
+	return s.c.uploadIndex(ctx, s3Cache, s.key)
+}
+
+// uploadIndex uploads the index of the cache machine to s3Cache under key.
+func (c *CacheMachine) uploadIndex(ctx context.Context, s3Cache ObjectStore, key string) error {
+	var buf bytes.Buffer
+	if err := c.ExportIndex(&buf); err != nil {
+		return fmt.Errorf("error exporting index: %s", err)
+	}
+	if err := s3Cache.Put(ctx, key, c.sealObject(key, buf.Bytes())); err != nil {
+		return fmt.Errorf("error uploading index to %s: %s", key, err)
+	}
+	return nil
+}
+
+// LoadIndex downloads the index uploaded under key by EnableIndexSnapshots
+// from the S3 tier.
+func (c *CacheMachine) LoadIndex(ctx context.Context, key string) ([]IndexEntry, error) {
+	s3Cache := c.S3Cache
+	if s3Cache == nil {
+		return nil, fmt.Errorf("S3 cache is not enabled")
+	}
+	if key == "" {
+		key = DefaultIndexKey
+	}
+	object, err := c.getBackgroundObject(ctx, s3Cache, key)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading index %s: %w", key, err)
+	}
+	return ReadIndex(bytes.NewReader(object))
+}
+
+// PrefetchFromIndex downloads the index uploaded under key by
+// EnableIndexSnapshots, and reads the n hottest of its entries from the S3
+// tier into the cache machine, keeping their remaining TTL and the time
+// they were originally set. The entries are ranked by estimated requests,
+// then by residency in RAM, then by recency. The entries that expired, that
+// were not synced to S3, or that this cache machine already has, are
+// skipped. It returns the number of entries prefetched. Its downloads yield
+// to those of the serving path, see S3DownloadMaxBytesPerSecond.
+func (c *CacheMachine) PrefetchFromIndex(ctx context.Context, key string, n int) (int, error) {
+	entries, err := c.LoadIndex(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	rankIndexEntries(entries)
+
+	s3Cache := c.S3Cache
+	prefetched := 0
+	for _, entry := range entries {
+		if prefetched >= n {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return prefetched, err
+		}
+		var ttl time.Duration
+		if !entry.ExpiresAt.IsZero() {
+			ttl = time.Until(entry.ExpiresAt)
+			if ttl <= 0 {
+				continue
+			}
+		}
+		if !entry.S3Synced || c.Has(entry.Key) {
+			continue
+		}
+		value, err := c.getBackgroundObject(ctx, s3Cache, entry.Key)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return prefetched, ctxErr
+			}
+			continue
+		}
+
+		shard := c.syncTable.shard(entry.Key)
+		shard.Lock()
+		if _, ok := shard.entries[entry.Key]; ok {
+			// Set meanwhile.
+			shard.Unlock()
+			continue
+		}
+		err = c.set(shard, entry.Key, value, ttl)
+		if err == nil {
+			cacheSync := shard.entries[entry.Key]
+			cacheSync.SetAt = entry.SetAt
+			cacheSync.S3Sync = true
+			shard.entries[entry.Key] = cacheSync
+		}
+		shard.Unlock()
+		if err != nil {
+			return prefetched, fmt.Errorf("error prefetching %s: %s", entry.Key, err)
+		}
+		prefetched++
+	}
+	return prefetched, nil
+}
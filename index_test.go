@@ -0,0 +1,121 @@
+package cachemachine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_ExportIndex(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.EnableFrequencyTracking()
+
+	CacheMachine.SetWithTTL("key1", []byte("12345"), time.Hour)
+	CacheMachine.Set("key2", []byte("678"))
+	CacheMachine.SetNegative("key3", time.Hour)
+	CacheMachine.Get("key1")
+	CacheMachine.Get("key1")
+
+	var buf bytes.Buffer
+	if err := CacheMachine.ExportIndex(&buf); err != nil {
+		t.Fatalf("Expected no error exporting the index, got %s", err)
+	}
+	entries, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("Expected no error reading the index, got %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, without the negative one, got %+v", entries)
+	}
+	key1, key2 := entries[0], entries[1]
+	if key1.Key != "key1" || key1.Size != 5 || key1.Requests != 2 || !key1.InRam || key1.ExpiresAt.IsZero() {
+		t.Errorf("Expected the metadata of key1, got %+v", key1)
+	}
+	if key2.Key != "key2" || key2.Size != 3 || !key2.ExpiresAt.IsZero() || key2.SetAt.IsZero() {
+		t.Errorf("Expected the metadata of key2, got %+v", key2)
+	}
+
+	if _, err := ReadIndex(bytes.NewReader(buf.Bytes()[:0])); err == nil {
+		t.Errorf("Expected an error reading an empty index")
+	}
+	var truncated bytes.Buffer
+	CacheMachine.ExportIndex(&truncated)
+	if _, err := ReadIndex(bytes.NewReader(truncated.Bytes()[:truncated.Len()-3])); err == nil {
+		t.Errorf("Expected an error reading a truncated index")
+	}
+}
+
+func TestCacheMachine_PrefetchFromIndex(t *testing.T) {
+	store := newMemoryStore()
+	newMachine := func() *CacheMachine {
+		CacheMachine, err := NewCacheMachine(1024*1024, 1024)
+		if err != nil {
+			t.Errorf("Error creating cache machine: %s", err)
+		}
+		tmpFolder, err := createTempFolder()
+		if err != nil {
+			t.Errorf("Error creating temp folder: %s", err)
+		}
+		t.Cleanup(func() { removeTempFolder(tmpFolder) })
+		if err := CacheMachine.EnableDiskCache(1024*1024, tmpFolder); err != nil {
+			t.Errorf("Expected no error enabling disk cache, got %s", err)
+		}
+		t.Cleanup(CacheMachine.DisableDiskCache)
+		CacheMachine.EnableS3Cache(store)
+		return CacheMachine
+	}
+
+	previous := newMachine()
+	if _, err := previous.PrefetchFromIndex(context.Background(), "", 10); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound without an index, got %v", err)
+	}
+
+	previous.EnableFrequencyTracking()
+	if err := previous.EnableIndexSnapshots("", time.Hour); err != nil {
+		t.Fatalf("Expected no error enabling index snapshots, got %s", err)
+	}
+	previous.SetWithTTL("hot", []byte("hot"), time.Hour)
+	previous.Set("warm", []byte("warm"))
+	previous.Set("cold", []byte("cold"))
+	for i := 0; i < 3; i++ {
+		previous.Get("hot")
+	}
+	previous.Get("warm")
+	if err := previous.Flush(); err != nil {
+		t.Fatalf("Expected no error flushing, got %s", err)
+	}
+	previous.Set("late", []byte("late"))
+	for i := 0; i < 5; i++ {
+		previous.Get("late")
+	}
+	// The index is uploaded a last time on Close, after the entries set
+	// since the last sync are flushed.
+	if err := previous.Close(); err != nil {
+		t.Fatalf("Expected no error closing, got %s", err)
+	}
+
+	replacement := newMachine()
+	prefetched, err := replacement.PrefetchFromIndex(context.Background(), "", 2)
+	if err != nil || prefetched != 2 {
+		t.Fatalf("Expected 2 entries prefetched, got %d, %v", prefetched, err)
+	}
+	for _, key := range []string{"late", "hot"} {
+		if value, ok := replacement.Peek(key); !ok || string(value) != key {
+			t.Errorf("Expected %s to be prefetched, got %q, %v", key, value, ok)
+		}
+	}
+	for _, key := range []string{"warm", "cold"} {
+		if replacement.Has(key) {
+			t.Errorf("Expected %s not to be prefetched", key)
+		}
+	}
+	residency := replacement.Where("hot")
+	if !residency.S3Synced || residency.ExpiresAt.IsZero() {
+		t.Errorf("Expected hot to keep its sync state and expiry, got %+v", residency)
+	}
+}
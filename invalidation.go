@@ -0,0 +1,128 @@
+package cachemachine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+// InvalidationBus broadcasts invalidation messages between the cache machines
+// of a multi-replica deployment. Implementations must deliver every message
+// published by any instance, including itself, to the handler of every
+// instance. The invalidation package provides Redis and NATS buses.
+type InvalidationBus interface {
+	Publish(message []byte) error
+	Subscribe(handler func(message []byte)) error
+	Close() error
+}
+
+// invalidationQueueSize bounds the number of invalidations waiting to be
+// published. Set and Delete drop the invalidations when it is full, such as
+// when the bus is down, rather than stalling every writer of the stripe;
+// the dropped invalidations leave stale copies on the other instances until
+// they expire, and are counted in Stats.InvalidationsDropped.
+const invalidationQueueSize = 1024
+
+// EnableInvalidation subscribes to bus, and from then on broadcasts the key
+// of every Set and Delete on this cache machine so that other instances
+// evict their local RAM and disk copies. Invalidations received from other
+// instances evict the local copies without being broadcast again. ClearAll,
+// ClearRamCache and ClearDiskCache only affect the local instance.
+func (c *CacheMachine) EnableInvalidation(bus InvalidationBus) error {
+	c.invalidationMu.Lock()
+	defer c.invalidationMu.Unlock()
+	if c.invalidationBus != nil {
+		return fmt.Errorf("invalidation is already enabled")
+	}
+
+	id := make([]byte, 8)
+	if err := c.randomRead(id); err != nil {
+		return fmt.Errorf("error generating instance id: %s", err)
+	}
+	c.instanceID = []byte(hex.EncodeToString(id))
+
+	if err := bus.Subscribe(c.handleInvalidation); err != nil {
+		return fmt.Errorf("error subscribing to invalidations: %s", err)
+	}
+
+	queue := make(chan string, invalidationQueueSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for key := range queue {
+			message := append(append(append([]byte{}, c.instanceID...), '\n'), key...)
+			if err := bus.Publish(message); err != nil {
+				c.logError("error publishing invalidation", "key", key, "error", err)
+			}
+		}
+	}()
+
+	c.invalidationBus = bus
+	c.invalidationQueue = queue
+	c.invalidationDone = done
+	return nil
+}
+
+// DisableInvalidation publishes the pending invalidations, then stops
+// broadcasting and receiving them and closes the bus.
+func (c *CacheMachine) DisableInvalidation() error {
+	c.invalidationMu.Lock()
+	bus, done := c.invalidationBus, c.invalidationDone
+	if bus == nil {
+		c.invalidationMu.Unlock()
+		return fmt.Errorf("invalidation is not enabled")
+	}
+	// No sender holds the read lock anymore, so the queue can be closed.
+	close(c.invalidationQueue)
+	c.invalidationBus = nil
+	c.invalidationQueue = nil
+	c.invalidationDone = nil
+	c.invalidationMu.Unlock()
+
+	<-done
+	return bus.Close()
+}
+
+// invalidate broadcasts the key to the other instances, if invalidation is
+// enabled. It never blocks, since it is called with the stripe of the key
+// locked.
+func (c *CacheMachine) invalidate(key string) {
+	c.invalidationMu.RLock()
+	defer c.invalidationMu.RUnlock()
+	if c.invalidationQueue == nil {
+		return
+	}
+	select {
+	case c.invalidationQueue <- key:
+	default:
+		atomic.AddInt64(&c.stats.droppedInvalidations, 1)
+	}
+}
+
+// handleInvalidation evicts the local copies of the key carried by a message
+// published by another instance.
+func (c *CacheMachine) handleInvalidation(message []byte) {
+	i := bytes.IndexByte(message, '\n')
+	if i < 0 || bytes.Equal(message[:i], c.instanceID) {
+		return
+	}
+	key := string(message[i+1:])
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	// Entries under legal hold are kept, as they are by Delete.
+	if c.legalHolds.held(key) {
+		return
+	}
+	c.preserveForSnapshots(shard, key)
+	c.RamCache.Del([]byte(key))
+	if c.DiskCache != nil {
+		if _, err := c.DiskCache.Delete(key); err != nil {
+			c.logError("error deleting from disk", "key", key, "error", err)
+		}
+	}
+	delete(shard.entries, key)
+}
@@ -0,0 +1,92 @@
+// Package invalidation provides cachemachine.InvalidationBus implementations
+// on top of Redis pub/sub and NATS. Both speak the wire protocol of their
+// server directly, so using them does not pull any client library.
+//
+// Subscriptions survive connection losses: the subscriber reconnects with
+// ReconnectDelay between attempts until the bus is closed. Invalidations
+// published by other instances while disconnected are lost, as both servers
+// only deliver messages to connected subscribers.
+package invalidation
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when publishing on a closed bus.
+var ErrClosed = errors.New("invalidation bus is closed")
+
+const (
+	// DefaultDialTimeout bounds the time spent connecting to the server.
+	DefaultDialTimeout = 5 * time.Second
+
+	// DefaultIOTimeout bounds the time spent publishing a message, reply
+	// included.
+	DefaultIOTimeout = 5 * time.Second
+
+	// DefaultReconnectDelay is the time waited between two attempts to
+	// reconnect a lost subscription.
+	DefaultReconnectDelay = time.Second
+)
+
+// subscription runs a subscriber connection, reconnecting it until closed.
+type subscription struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	done   chan struct{}
+}
+
+// run calls serve with a new connection from dial until the subscription is
+// closed. The first connection is established by the caller, so errors
+// preventing any subscription are reported synchronously.
+func (s *subscription) run(conn net.Conn, dial func() (net.Conn, error), serve func(net.Conn) error, delay time.Duration) {
+	s.done = make(chan struct{})
+	s.conn = conn
+	go func() {
+		defer close(s.done)
+		for {
+			serve(conn)
+			conn.Close()
+
+			for {
+				s.mu.Lock()
+				closed := s.closed
+				s.mu.Unlock()
+				if closed {
+					return
+				}
+
+				time.Sleep(delay)
+				var err error
+				conn, err = dial()
+				if err == nil {
+					break
+				}
+			}
+
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.conn = conn
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.closed = true
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+		<-s.done
+	}
+}
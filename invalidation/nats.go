@@ -0,0 +1,187 @@
+package invalidation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSBus is an invalidation bus on top of NATS core publish/subscribe. Like
+// RedisBus, it uses one connection to publish and one to subscribe, so that
+// publishing never waits behind the delivery of received messages.
+type NATSBus struct {
+	// Addr is the host:port of the NATS server.
+	Addr string
+
+	// Subject is the subject carrying the invalidations.
+	Subject string
+
+	// User and Password, if set, are sent in the CONNECT message.
+	User     string
+	Password string
+
+	DialTimeout    time.Duration
+	ReconnectDelay time.Duration
+
+	mu           sync.Mutex
+	publisher    net.Conn
+	closed       bool
+	subscription subscription
+}
+
+// NewNATSBus returns a bus publishing and subscribing to subject on the NATS
+// server at addr.
+func NewNATSBus(addr, subject string) *NATSBus {
+	return &NATSBus{
+		Addr:           addr,
+		Subject:        subject,
+		DialTimeout:    DefaultDialTimeout,
+		ReconnectDelay: DefaultReconnectDelay,
+	}
+}
+
+// Publish sends message to the subject. A broken connection is replaced once
+// before giving up.
+func (b *NATSBus) Publish(message []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.publisher == nil {
+			var reader *bufio.Reader
+			b.publisher, reader, err = b.dial()
+			if err != nil {
+				return err
+			}
+			go answerPings(b.publisher, reader)
+		}
+		command := "PUB " + b.Subject + " " + strconv.Itoa(len(message)) + "\r\n"
+		_, err = b.publisher.Write(append(append([]byte(command), message...), "\r\n"...))
+		if err == nil {
+			return nil
+		}
+		b.publisher.Close()
+		b.publisher = nil
+	}
+	return fmt.Errorf("error publishing to nats: %s", err)
+}
+
+// Subscribe subscribes to the subject and calls handler with every message
+// received, from a single goroutine.
+func (b *NATSBus) Subscribe(handler func(message []byte)) error {
+	var reader *bufio.Reader
+	dial := func() (net.Conn, error) {
+		conn, connReader, err := b.dial()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write([]byte("SUB " + b.Subject + " 1\r\n")); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		reader = connReader
+		return conn, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return fmt.Errorf("error subscribing to nats: %s", err)
+	}
+	b.subscription.run(conn, dial, func(conn net.Conn) error {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch strings.ToUpper(fields[0]) {
+			case "PING":
+				if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+					return err
+				}
+			case "MSG":
+				// MSG <subject> <sid> [reply-to] <#bytes>
+				if len(fields) < 4 {
+					return fmt.Errorf("invalid nats message %q", line)
+				}
+				length, err := strconv.Atoi(fields[len(fields)-1])
+				if err != nil {
+					return err
+				}
+				data := make([]byte, length+2)
+				if _, err := io.ReadFull(reader, data); err != nil {
+					return err
+				}
+				handler(data[:length])
+			}
+		}
+	}, b.ReconnectDelay)
+	return nil
+}
+
+// Close closes the connections of the bus.
+func (b *NATSBus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	if b.publisher != nil {
+		b.publisher.Close()
+		b.publisher = nil
+	}
+	b.mu.Unlock()
+
+	b.subscription.close()
+	return nil
+}
+
+// answerPings keeps a publisher connection alive by answering the PINGs of
+// the server, until the connection is closed.
+func answerPings(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "PING") {
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dial connects to the server, reads its INFO and sends CONNECT.
+func (b *NATSBus) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.Addr, b.DialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(b.DialTimeout))
+	line, err := reader.ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("invalid nats server greeting %q: %v", line, err)
+	}
+
+	connect := fmt.Sprintf(`CONNECT {"verbose":false,"pedantic":false,"name":"cachemachine","user":%q,"pass":%q}`+"\r\n", b.User, b.Password)
+	if _, err := conn.Write([]byte(connect)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
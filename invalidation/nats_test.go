@@ -0,0 +1,103 @@
+package invalidation
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a minimal NATS server supporting CONNECT, SUB, PUB and PING.
+type fakeNATS struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers map[string]map[net.Conn]string
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	server := &fakeNATS{listener: listener, subscribers: make(map[string]map[net.Conn]string)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte(`INFO {"server_id":"fake"}` + "\r\n"))
+	// Check that clients answer server PINGs.
+	conn.Write([]byte("PING\r\n"))
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "SUB":
+			s.mu.Lock()
+			if s.subscribers[fields[1]] == nil {
+				s.subscribers[fields[1]] = make(map[net.Conn]string)
+			}
+			s.subscribers[fields[1]][conn] = fields[2]
+			s.mu.Unlock()
+		case "PUB":
+			length, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, length+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			for subscriber, sid := range s.subscribers[fields[1]] {
+				subscriber.Write([]byte("MSG " + fields[1] + " " + sid + " " + fields[2] + "\r\n" + string(payload)))
+			}
+			s.mu.Unlock()
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+func TestNATSBus(t *testing.T) {
+	server := newFakeNATS(t)
+
+	subscriber := NewNATSBus(server.listener.Addr().String(), "invalidations")
+	defer subscriber.Close()
+	messages := make(chan []byte, 10)
+	err := subscriber.Subscribe(func(message []byte) {
+		messages <- message
+	})
+	if err != nil {
+		t.Fatalf("Expected no error subscribing, got %s", err)
+	}
+
+	publisher := NewNATSBus(server.listener.Addr().String(), "invalidations")
+	defer publisher.Close()
+	// Wait for the subscription to be registered.
+	time.Sleep(50 * time.Millisecond)
+
+	for _, message := range []string{"key1", "key2\r\nwith a newline"} {
+		err = publisher.Publish([]byte(message))
+		if err != nil {
+			t.Errorf("Expected no error publishing, got %s", err)
+		}
+		receive(t, messages, message)
+	}
+}
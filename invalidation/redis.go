@@ -0,0 +1,238 @@
+package invalidation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisBus is an invalidation bus on top of Redis pub/sub. It uses one
+// connection to publish and one to subscribe, as Redis does not allow
+// publishing on a subscribed connection.
+type RedisBus struct {
+	// Addr is the host:port of the Redis server.
+	Addr string
+
+	// Password, if set, is sent with AUTH on every new connection.
+	Password string
+
+	// Channel is the pub/sub channel carrying the invalidations.
+	Channel string
+
+	DialTimeout    time.Duration
+	ReconnectDelay time.Duration
+
+	// IOTimeout bounds the time spent writing a command and reading its
+	// reply. A connection timing out is replaced like a broken one.
+	IOTimeout time.Duration
+
+	mu           sync.Mutex
+	publisher    net.Conn
+	reader       *bufio.Reader
+	closed       bool
+	subscription subscription
+}
+
+// NewRedisBus returns a bus publishing and subscribing to channel on the
+// Redis server at addr.
+func NewRedisBus(addr, channel string) *RedisBus {
+	return &RedisBus{
+		Addr:           addr,
+		Channel:        channel,
+		DialTimeout:    DefaultDialTimeout,
+		ReconnectDelay: DefaultReconnectDelay,
+		IOTimeout:      DefaultIOTimeout,
+	}
+}
+
+// Publish sends message to the channel. A broken or timed out connection is
+// replaced once before giving up.
+func (b *RedisBus) Publish(message []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.publisher == nil {
+			b.publisher, err = b.dial()
+			if err != nil {
+				return err
+			}
+			b.reader = bufio.NewReader(b.publisher)
+		}
+		b.publisher.SetDeadline(time.Now().Add(b.ioTimeout()))
+		err = writeRedisCommand(b.publisher, "PUBLISH", []byte(b.Channel), message)
+		if err == nil {
+			_, err = readRedisReply(b.reader)
+			if _, ok := err.(redisError); ok {
+				return err
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		b.publisher.Close()
+		b.publisher = nil
+	}
+	return fmt.Errorf("error publishing to redis: %s", err)
+}
+
+// Subscribe subscribes to the channel and calls handler with every message
+// received, from a single goroutine.
+func (b *RedisBus) Subscribe(handler func(message []byte)) error {
+	dial := func() (net.Conn, error) {
+		conn, err := b.dial()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeRedisCommand(conn, "SUBSCRIBE", []byte(b.Channel)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return fmt.Errorf("error subscribing to redis: %s", err)
+	}
+	b.subscription.run(conn, dial, func(conn net.Conn) error {
+		reader := bufio.NewReader(conn)
+		for {
+			reply, err := readRedisReply(reader)
+			if err != nil {
+				return err
+			}
+			parts, ok := reply.([]interface{})
+			if !ok || len(parts) != 3 {
+				continue
+			}
+			if kind, _ := parts[0].([]byte); string(kind) != "message" {
+				continue
+			}
+			if message, ok := parts[2].([]byte); ok {
+				handler(message)
+			}
+		}
+	}, b.ReconnectDelay)
+	return nil
+}
+
+// Close closes the connections of the bus.
+func (b *RedisBus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	if b.publisher != nil {
+		b.publisher.Close()
+		b.publisher = nil
+	}
+	b.mu.Unlock()
+
+	b.subscription.close()
+	return nil
+}
+
+// dial connects to the server and authenticates if needed.
+func (b *RedisBus) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", b.Addr, b.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if b.Password != "" {
+		conn.SetDeadline(time.Now().Add(b.ioTimeout()))
+		err = writeRedisCommand(conn, "AUTH", []byte(b.Password))
+		if err == nil {
+			_, err = readRedisReply(bufio.NewReader(conn))
+		}
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (b *RedisBus) ioTimeout() time.Duration {
+	if b.IOTimeout > 0 {
+		return b.IOTimeout
+	}
+	return DefaultIOTimeout
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// writeRedisCommand writes a command as a RESP array of bulk strings.
+func writeRedisCommand(conn net.Conn, name string, args ...[]byte) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range append([][]byte{[]byte(name)}, args...) {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+// readRedisReply reads a RESP reply. Simple strings and bulk strings are
+// returned as []byte, integers as int64 and arrays as []interface{}.
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(payload), nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(payload)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:length], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
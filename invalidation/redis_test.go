@@ -0,0 +1,178 @@
+package invalidation
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal Redis server supporting AUTH, SUBSCRIBE and PUBLISH.
+type fakeRedis struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	conns       []net.Conn
+	subscribers map[string][]net.Conn
+
+	// stalls is the number of PUBLISH commands left unanswered.
+	stalls int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	server := &fakeRedis{listener: listener, subscribers: make(map[string][]net.Conn)}
+	t.Cleanup(server.close)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		args := reply.([]interface{})
+		switch string(args[0].([]byte)) {
+		case "AUTH":
+			conn.Write([]byte("+OK\r\n"))
+		case "SUBSCRIBE":
+			channel := string(args[1].([]byte))
+			s.mu.Lock()
+			s.subscribers[channel] = append(s.subscribers[channel], conn)
+			s.mu.Unlock()
+			conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n:1\r\n"))
+		case "PUBLISH":
+			channel, message := string(args[1].([]byte)), string(args[2].([]byte))
+			s.mu.Lock()
+			subscribers := s.subscribers[channel]
+			stall := s.stalls > 0
+			if stall {
+				s.stalls--
+			}
+			s.mu.Unlock()
+			if stall {
+				continue
+			}
+			for _, subscriber := range subscribers {
+				subscriber.Write([]byte("*3\r\n$7\r\nmessage\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel +
+					"\r\n$" + strconv.Itoa(len(message)) + "\r\n" + message + "\r\n"))
+			}
+			conn.Write([]byte(":" + strconv.Itoa(len(subscribers)) + "\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+// dropConnections closes every connection, as a server restart would.
+func (s *fakeRedis) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	s.subscribers = make(map[string][]net.Conn)
+}
+
+func (s *fakeRedis) close() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+func receive(t *testing.T, messages chan []byte, expected string) {
+	select {
+	case message := <-messages:
+		if string(message) != expected {
+			t.Errorf("Expected message %q, got %q", expected, message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for message %q", expected)
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	server := newFakeRedis(t)
+
+	subscriber := NewRedisBus(server.listener.Addr().String(), "invalidations")
+	subscriber.Password = "secret"
+	subscriber.ReconnectDelay = 10 * time.Millisecond
+	defer subscriber.Close()
+	messages := make(chan []byte, 10)
+	err := subscriber.Subscribe(func(message []byte) {
+		messages <- message
+	})
+	if err != nil {
+		t.Fatalf("Expected no error subscribing, got %s", err)
+	}
+
+	publisher := NewRedisBus(server.listener.Addr().String(), "invalidations")
+	defer publisher.Close()
+	// Wait for the subscription to be registered.
+	time.Sleep(50 * time.Millisecond)
+
+	err = publisher.Publish([]byte("key1\r\nwith a newline"))
+	if err != nil {
+		t.Errorf("Expected no error publishing, got %s", err)
+	}
+	receive(t, messages, "key1\r\nwith a newline")
+
+	server.dropConnections()
+	time.Sleep(100 * time.Millisecond)
+
+	err = publisher.Publish([]byte("key2"))
+	if err != nil {
+		t.Errorf("Expected no error publishing after a reconnection, got %s", err)
+	}
+	receive(t, messages, "key2")
+
+	publisher.Close()
+	if err := publisher.Publish([]byte("key3")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed publishing on a closed bus, got %v", err)
+	}
+}
+
+func TestRedisBus_IOTimeout(t *testing.T) {
+	server := newFakeRedis(t)
+
+	publisher := NewRedisBus(server.listener.Addr().String(), "invalidations")
+	publisher.IOTimeout = 50 * time.Millisecond
+	defer publisher.Close()
+
+	// A publish left unanswered is retried on a new connection.
+	server.mu.Lock()
+	server.stalls = 1
+	server.mu.Unlock()
+	if err := publisher.Publish([]byte("key1")); err != nil {
+		t.Errorf("Expected no error publishing after a timeout, got %s", err)
+	}
+
+	server.mu.Lock()
+	server.stalls = 2
+	server.mu.Unlock()
+	start := time.Now()
+	if err := publisher.Publish([]byte("key2")); err == nil {
+		t.Errorf("Expected an error publishing to a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected publishing to time out, took %s", elapsed)
+	}
+}
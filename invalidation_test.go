@@ -0,0 +1,168 @@
+package cachemachine
+
+import (
+	"sync"
+	"testing"
+)
+
+// memoryBus is an in-process InvalidationBus delivering messages
+// synchronously to every subscriber.
+type memoryBus struct {
+	mu       sync.Mutex
+	handlers []func(message []byte)
+}
+
+type memoryBusClient struct {
+	bus *memoryBus
+}
+
+func (c memoryBusClient) Publish(message []byte) error {
+	c.bus.mu.Lock()
+	handlers := c.bus.handlers
+	c.bus.mu.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (c memoryBusClient) Subscribe(handler func(message []byte)) error {
+	c.bus.mu.Lock()
+	defer c.bus.mu.Unlock()
+	c.bus.handlers = append(c.bus.handlers, handler)
+	return nil
+}
+
+func (c memoryBusClient) Close() error {
+	return nil
+}
+
+func TestCacheMachine_EnableInvalidation(t *testing.T) {
+	bus := &memoryBus{}
+
+	instance1, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	instance2, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	instance2.Set("key1", []byte("stale"))
+	instance2.Set("key2", []byte("stale"))
+
+	for _, instance := range []*CacheMachine{instance1, instance2} {
+		err = instance.EnableInvalidation(memoryBusClient{bus})
+		if err != nil {
+			t.Errorf("Expected no error enabling invalidation, got %s", err)
+		}
+	}
+	err = instance1.EnableInvalidation(memoryBusClient{bus})
+	if err == nil {
+		t.Errorf("Expected error enabling invalidation twice")
+	}
+
+	instance1.Set("key1", []byte("fresh"))
+	instance1.Delete("key2")
+
+	for _, instance := range []*CacheMachine{instance1, instance2} {
+		err = instance.DisableInvalidation()
+		if err != nil {
+			t.Errorf("Expected no error disabling invalidation, got %s", err)
+		}
+	}
+
+	if value, ok := instance1.Get("key1"); !ok || string(value) != "fresh" {
+		t.Errorf("Expected instance1 to keep its own value for key1, got %s", value)
+	}
+	if _, ok := instance2.Get("key1"); ok {
+		t.Errorf("Expected key1 to be invalidated on instance2")
+	}
+	if _, ok := instance2.Get("key2"); ok {
+		t.Errorf("Expected key2 to be invalidated on instance2")
+	}
+}
+
+// blockingBusClient is an InvalidationBus whose Publish blocks until release
+// is closed, like a bus that is down.
+type blockingBusClient struct {
+	release chan struct{}
+}
+
+func (c blockingBusClient) Publish(message []byte) error {
+	<-c.release
+	return nil
+}
+
+func (c blockingBusClient) Subscribe(handler func(message []byte)) error {
+	return nil
+}
+
+func (c blockingBusClient) Close() error {
+	return nil
+}
+
+func TestCacheMachine_InvalidationQueueFull(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	bus := blockingBusClient{release: make(chan struct{})}
+	err = CacheMachine.EnableInvalidation(bus)
+	if err != nil {
+		t.Errorf("Expected no error enabling invalidation, got %s", err)
+	}
+
+	// The publisher holds one invalidation and the queue the next ones, the
+	// others are dropped rather than blocking Set.
+	for i := 0; i < invalidationQueueSize+10; i++ {
+		CacheMachine.Set("key", []byte("value"))
+	}
+	if dropped := CacheMachine.Stats().InvalidationsDropped; dropped < 9 {
+		t.Errorf("Expected at least 9 dropped invalidations, got %d", dropped)
+	}
+
+	close(bus.release)
+	err = CacheMachine.DisableInvalidation()
+	if err != nil {
+		t.Errorf("Expected no error disabling invalidation, got %s", err)
+	}
+}
+
+func TestCacheMachine_DisableInvalidationConcurrentSets(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	err = CacheMachine.EnableInvalidation(memoryBusClient{&memoryBus{}})
+	if err != nil {
+		t.Errorf("Expected no error enabling invalidation, got %s", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					CacheMachine.Set("key", []byte("value"))
+				}
+			}
+		}()
+	}
+
+	err = CacheMachine.DisableInvalidation()
+	if err != nil {
+		t.Errorf("Expected no error disabling invalidation, got %s", err)
+	}
+	close(stop)
+	wg.Wait()
+}
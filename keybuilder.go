@@ -0,0 +1,115 @@
+package cachemachine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxKeySegmentLength is the length of the segments of a composite
+// key from which they are hashed, when KeyBuilder.MaxSegmentLength is zero.
+const DefaultMaxKeySegmentLength = 64
+
+// hashedSegmentPrefix marks the segments of a composite key that were
+// hashed. The segments that were not are escaped so that they never start
+// with it.
+const hashedSegmentPrefix = "#"
+
+// keySegmentEscaper escapes the characters of a segment that would make two
+// different lists of segments build the same key.
+var keySegmentEscaper = strings.NewReplacer(
+	"%", "%25",
+	NamespaceSeparator, "%3A",
+	hashedSegmentPrefix, "%23",
+)
+
+var keySegmentUnescaper = strings.NewReplacer(
+	"%3A", NamespaceSeparator,
+	"%23", hashedSegmentPrefix,
+	"%25", "%",
+)
+
+// KeyBuilder builds composite keys out of segments, such as
+//
+//	cachemachine.Key("users", id, "profile")
+//
+// rather than with fmt.Sprintf. The segments are joined with
+// NamespaceSeparator, the first one being the namespace of the key, and are
+// escaped so that different lists of segments always build different keys.
+// Segments longer than MaxSegmentLength, but the namespace, are replaced by
+// their SHA-256 hash, so that keys stay short whatever they are built from.
+type KeyBuilder struct {
+	// MaxSegmentLength is the length, after escaping, beyond which segments
+	// are hashed, DefaultMaxKeySegmentLength when zero. Segments are never
+	// hashed when it is negative.
+	MaxSegmentLength int
+}
+
+// Key builds a composite key out of segments with the default KeyBuilder.
+func Key(segments ...interface{}) string {
+	return KeyBuilder{}.Key(segments...)
+}
+
+// Key builds a composite key out of segments. Strings, byte slices,
+// integers, booleans and fmt.Stringers are written as is, and any other
+// value as formatted by fmt.Sprint.
+func (b KeyBuilder) Key(segments ...interface{}) string {
+	maxLength := b.MaxSegmentLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxKeySegmentLength
+	}
+	var key strings.Builder
+	for i, segment := range segments {
+		if i > 0 {
+			key.WriteString(NamespaceSeparator)
+		}
+		s := keySegmentEscaper.Replace(keySegment(segment))
+		if i > 0 && maxLength > 0 && len(s) > maxLength {
+			sum := sha256.Sum256([]byte(s))
+			s = hashedSegmentPrefix + hex.EncodeToString(sum[:16])
+		}
+		key.WriteString(s)
+	}
+	return key.String()
+}
+
+func keySegment(segment interface{}) string {
+	switch s := segment.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case int:
+		return strconv.Itoa(s)
+	case int64:
+		return strconv.FormatInt(s, 10)
+	case int32:
+		return strconv.FormatInt(int64(s), 10)
+	case uint:
+		return strconv.FormatUint(uint64(s), 10)
+	case uint64:
+		return strconv.FormatUint(s, 10)
+	case uint32:
+		return strconv.FormatUint(uint64(s), 10)
+	case bool:
+		return strconv.FormatBool(s)
+	case fmt.Stringer:
+		return s.String()
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// SplitKey returns the segments of a key built by a KeyBuilder, unescaped.
+// Hashed segments are returned as is, starting with "#".
+func SplitKey(key string) []string {
+	segments := strings.Split(key, NamespaceSeparator)
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, hashedSegmentPrefix) {
+			segments[i] = keySegmentUnescaper.Replace(segment)
+		}
+	}
+	return segments
+}
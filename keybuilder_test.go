@@ -0,0 +1,70 @@
+package cachemachine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	if key := Key("users", 123, "profile"); key != "users:123:profile" {
+		t.Errorf("Expected users:123:profile, got %s", key)
+	}
+	if namespace := KeyNamespace(Key("users", 123)); namespace != "users" {
+		t.Errorf("Expected the first segment to be the namespace, got %s", namespace)
+	}
+	if key := Key("a", []byte("b"), int64(-1), uint32(2), true, time.Second); key != "a:b:-1:2:true:1s" {
+		t.Errorf("Unexpected key %s", key)
+	}
+
+	// Different segments never build the same key.
+	keys := map[string][]interface{}{}
+	for _, segments := range [][]interface{}{
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{"a", "b", "c"},
+		{"a%3Ab", "c"},
+		{"#a", "c"},
+		{"a", ""},
+		{"a"},
+	} {
+		key := Key(segments...)
+		if other, ok := keys[key]; ok {
+			t.Errorf("Expected %v and %v to build different keys, both built %s", segments, other, key)
+		}
+		keys[key] = segments
+		if split := SplitKey(key); !reflect.DeepEqual(split, toStrings(segments)) {
+			t.Errorf("Expected %s to split into %v, got %v", key, segments, split)
+		}
+	}
+}
+
+func TestKeyBuilder_LongSegments(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	key := Key("blobs", long)
+	if len(key) != len("blobs:#")+32 || !strings.HasPrefix(key, "blobs:#") {
+		t.Errorf("Expected the long segment to be hashed, got %s", key)
+	}
+	if Key("blobs", long+"y") == key {
+		t.Errorf("Expected different long segments to hash differently")
+	}
+	if split := SplitKey(key); split[1] != key[len("blobs:"):] {
+		t.Errorf("Expected the hashed segment to be split as is, got %v", split)
+	}
+
+	if key := (KeyBuilder{MaxSegmentLength: -1}).Key("blobs", long); key != "blobs:"+long {
+		t.Errorf("Expected the long segment not to be hashed, got %s", key)
+	}
+	if key := (KeyBuilder{MaxSegmentLength: 4}).Key("blobs", "abcd"); key != "blobs:abcd" {
+		t.Errorf("Expected the namespace and the segments up to MaxSegmentLength not to be hashed, got %s", key)
+	}
+}
+
+func toStrings(segments []interface{}) []string {
+	strs := make([]string, len(segments))
+	for i, segment := range segments {
+		strs[i] = keySegment(segment)
+	}
+	return strs
+}
@@ -0,0 +1,36 @@
+package cachemachine
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keys returns, in order, up to limit keys starting with prefix that are in
+// the RAM, disk or S3 tiers, starting after cursor. A limit of 0 or less
+// means no limit. It also returns the cursor of the next page, which is empty
+// when there is no next page. Pass an empty cursor to get the first page.
+//
+// Pages are computed from the current content of the cache, so keys set or
+// deleted while paginating may or may not be returned, but no key present
+// for the whole pagination is returned twice or skipped.
+func (c *CacheMachine) Keys(prefix string, limit int, cursor string) (keys []string, next string) {
+	now := time.Now()
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		for key, cacheSync := range shard.entries {
+			if strings.HasPrefix(key, prefix) && key > cursor && !cacheSync.Negative && c.live(key, cacheSync, now) {
+				keys = append(keys, key)
+			}
+		}
+		shard.Unlock()
+	}
+	sort.Strings(keys)
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	return keys, next
+}
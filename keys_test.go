@@ -0,0 +1,57 @@
+package cachemachine
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCacheMachine_Keys(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for i := 1; i <= 5; i++ {
+		CacheMachine.Set(fmt.Sprintf("user:123:%d", i), []byte("12345"))
+	}
+	CacheMachine.Set("user:456:1", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("user:123:2"))
+
+	var pages [][]string
+	cursor := ""
+	for {
+		keys, next := CacheMachine.Keys("user:123:", 2, cursor)
+		pages = append(pages, keys)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	expected := [][]string{
+		{"user:123:1", "user:123:2"},
+		{"user:123:3", "user:123:4"},
+		{"user:123:5"},
+	}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+
+	keys, next := CacheMachine.Keys("", 0, "")
+	if len(keys) != 6 || next != "" {
+		t.Errorf("Expected all 6 keys in a single page, got %v, %q", keys, next)
+	}
+}
@@ -0,0 +1,122 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLegalHold is returned when an operation would delete or overwrite an
+// entry under legal hold.
+var ErrLegalHold = errors.New("entry under legal hold")
+
+// LegalHolder is implemented by the object stores that can place a legal
+// hold on their objects, such as S3 with object lock enabled. The cache
+// machine mirrors its legal holds to such an S3 tier.
+type LegalHolder interface {
+	SetLegalHold(ctx context.Context, key string, hold bool) error
+}
+
+// legalHolds is the set of keys under legal hold. It has its own lock, so
+// that it can be checked from the disk eviction hooks.
+type legalHolds struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+
+	// count is the number of keys, read without the lock to spare the hot
+	// paths the lock while no key is held.
+	count int64
+}
+
+func (h *legalHolds) held(key string) bool {
+	if atomic.LoadInt64(&h.count) == 0 {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.keys[key]
+	return ok
+}
+
+func (h *legalHolds) set(key string, hold bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.keys[key]
+	switch {
+	case hold && !ok:
+		if h.keys == nil {
+			h.keys = make(map[string]struct{})
+		}
+		h.keys[key] = struct{}{}
+		atomic.AddInt64(&h.count, 1)
+	case !hold && ok:
+		delete(h.keys, key)
+		atomic.AddInt64(&h.count, -1)
+	}
+}
+
+func (h *legalHolds) list() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.keys))
+	for key := range h.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetLegalHold places the entry for key under legal hold, or releases it.
+// Like an S3 object lock legal hold, it has no expiration: until it is
+// released, the entry does not expire from disk nor S3, and Delete,
+// PurgeBySubject, SetNegative and Set fail with ErrLegalHold instead of
+// deleting or overwriting it. Expired entries under hold are not served, but
+// kept. The disk cache vetoes their eviction, within the limit of
+// MaxEvictionVetoes. The hold is also placed on the S3 copy of the entry when
+// the S3 tier is a LegalHolder.
+//
+// The entry must be cached to be placed under hold. Holds are not persisted,
+// and are lost when the process exits.
+func (c *CacheMachine) SetLegalHold(ctx context.Context, key string, hold bool) error {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Delete)
+	defer cancel()
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	cacheSync, ok := shard.entries[key]
+	if hold && (!ok || cacheSync.Negative || !c.live(key, cacheSync, time.Now())) {
+		return fmt.Errorf("error placing legal hold on key %s: key not found", key)
+	}
+	if holder, ok := c.S3Cache.(LegalHolder); ok && cacheSync.S3Sync {
+		if err := holder.SetLegalHold(ctx, key, hold); err != nil {
+			return fmt.Errorf("error setting legal hold of key %s on S3: %s", key, err)
+		}
+	}
+	c.legalHolds.set(key, hold)
+	return nil
+}
+
+// LegalHold reports whether the entry for key is under legal hold.
+func (c *CacheMachine) LegalHold(key string) bool {
+	return c.legalHolds.held(key)
+}
+
+// LegalHolds returns the sorted list of the keys under legal hold.
+func (c *CacheMachine) LegalHolds() []string {
+	return c.legalHolds.list()
+}
+
+// syncLegalHold places the legal hold of key on its copy just written to
+// S3, if any.
+func (c *CacheMachine) syncLegalHold(ctx context.Context, store ObjectStore, key string) error {
+	holder, ok := store.(LegalHolder)
+	if !ok || !c.legalHolds.held(key) {
+		return nil
+	}
+	return holder.SetLegalHold(ctx, key, true)
+}
@@ -0,0 +1,241 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holdingStore is an in-memory ObjectStore supporting legal holds.
+type holdingStore struct {
+	*memoryStore
+	mu    sync.Mutex
+	holds map[string]bool
+}
+
+func (s *holdingStore) SetLegalHold(ctx context.Context, key string, hold bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holds[key] = hold
+	return nil
+}
+
+func (s *holdingStore) held(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holds[key]
+}
+
+func TestCacheMachine_LegalHold(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := &holdingStore{memoryStore: newMemoryStore(), holds: make(map[string]bool)}
+	CacheMachine.EnableS3Cache(store)
+
+	ctx := context.Background()
+	if err := CacheMachine.SetLegalHold(ctx, "key1", true); err == nil {
+		t.Errorf("Expected an error placing a legal hold on a missing key")
+	}
+
+	CacheMachine.SetWithOptions("key1", []byte("12345"), WithSubject("user1"), WithTTL(time.Hour))
+	if err := CacheMachine.SetLegalHold(ctx, "key1", true); err != nil {
+		t.Errorf("Expected no error placing a legal hold, got %s", err)
+	}
+	CacheMachine.Flush()
+	if !store.held("key1") {
+		t.Errorf("Expected the legal hold to be placed on S3")
+	}
+
+	if _, err := CacheMachine.DeleteCtx(ctx, "key1"); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold deleting key1, got %v", err)
+	}
+	if err := CacheMachine.Set("key1", []byte("67890")); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold setting key1, got %v", err)
+	}
+	if err := CacheMachine.SetNegative("key1", time.Hour); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold setting key1 as negative, got %v", err)
+	}
+	report, err := CacheMachine.PurgeBySubject(ctx, "user1")
+	if err == nil || len(report.Failures) != 1 || report.Failures[0].Error != ErrLegalHold.Error() {
+		t.Errorf("Expected the purge to fail with ErrLegalHold, got %+v", report)
+	}
+
+	// freecache expires entries with a one second precision, so key1 is
+	// expired in the sync table only, and dropped from RAM.
+	shard := CacheMachine.syncTable.shard("key1")
+	shard.Lock()
+	cacheSync := shard.entries["key1"]
+	cacheSync.ExpiresAt = time.Now().Add(-time.Second)
+	shard.entries["key1"] = cacheSync
+	shard.Unlock()
+	CacheMachine.RamCache.Del([]byte("key1"))
+	if _, ok := CacheMachine.Get("key1"); ok {
+		t.Errorf("Expected key1 to be expired")
+	}
+	for i := 0; i < syncTableShards/sweepShardsPerSync; i++ {
+		CacheMachine.sweep()
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key1"); !ok {
+		t.Errorf("Expected key1 to be kept on disk while under legal hold")
+	}
+	if _, err := store.Get(ctx, "key1"); err != nil {
+		t.Errorf("Expected key1 to be kept on S3 while under legal hold, got %s", err)
+	}
+
+	if holds := CacheMachine.LegalHolds(); len(holds) != 1 || holds[0] != "key1" {
+		t.Errorf("Expected key1 to be under legal hold, got %v", holds)
+	}
+	if err := CacheMachine.SetLegalHold(ctx, "key1", false); err != nil {
+		t.Errorf("Expected no error releasing the legal hold, got %s", err)
+	}
+	if CacheMachine.LegalHold("key1") || store.held("key1") {
+		t.Errorf("Expected the legal hold to be released")
+	}
+	if _, ok := CacheMachine.Get("key1"); ok {
+		t.Errorf("Expected key1 to be expired")
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key1"); ok {
+		t.Errorf("Expected key1 to be removed from disk once released")
+	}
+}
+
+func TestCacheMachine_LegalHoldClear(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := &holdingStore{memoryStore: newMemoryStore(), holds: make(map[string]bool)}
+	CacheMachine.EnableS3Cache(store)
+
+	ctx := context.Background()
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Flush()
+	if err := CacheMachine.SetLegalHold(ctx, "key1", true); err != nil {
+		t.Errorf("Expected no error placing a legal hold, got %s", err)
+	}
+
+	// Invalidations from other instances do not delete held entries.
+	CacheMachine.handleInvalidation([]byte("other-instance\nkey1"))
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "12345" {
+		t.Errorf("Expected key1 to survive an invalidation, got %q, %t", value, ok)
+	}
+
+	CacheMachine.ClearRamCache()
+	if _, err := CacheMachine.RamCache.Peek([]byte("key1")); err != nil {
+		t.Errorf("Expected key1 to be kept in RAM, got %s", err)
+	}
+	if _, err := CacheMachine.RamCache.Peek([]byte("key2")); err == nil {
+		t.Errorf("Expected key2 to be cleared from RAM")
+	}
+
+	if err := CacheMachine.ClearDiskCache(); err != nil {
+		t.Errorf("Expected no error clearing the disk cache, got %s", err)
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key1"); !ok {
+		t.Errorf("Expected key1 to be kept on disk")
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key2"); ok {
+		t.Errorf("Expected key2 to be cleared from disk")
+	}
+
+	if err := CacheMachine.ClearAll(); err != nil {
+		t.Errorf("Expected no error clearing every tier, got %s", err)
+	}
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "12345" {
+		t.Errorf("Expected key1 to survive ClearAll, got %q, %t", value, ok)
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key1"); !ok {
+		t.Errorf("Expected key1 to be kept on disk")
+	}
+	if _, err := store.Get(ctx, "key1"); err != nil {
+		t.Errorf("Expected key1 to be kept on S3, got %s", err)
+	}
+	if _, err := store.Get(ctx, "key2"); err == nil {
+		t.Errorf("Expected key2 to be deleted from S3")
+	}
+	if keys, _ := CacheMachine.Keys("", 0, ""); len(keys) != 1 || keys[0] != "key1" {
+		t.Errorf("Expected only key1 to be left, got %v", keys)
+	}
+}
+
+func TestCacheMachine_AdminHandlerLegalHold(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+
+	handler := CacheMachine.AdminHandler()
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+
+	if recorder := serve(http.MethodPut, "/holds/key2"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected placing a hold on a missing key to fail, got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPut, "/holds/key1"); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected placing a hold to succeed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := serve(http.MethodGet, "/holds")
+	var holds []string
+	json.Unmarshal(recorder.Body.Bytes(), &holds)
+	if len(holds) != 1 || holds[0] != "key1" {
+		t.Errorf("Expected key1 to be under legal hold, got %s", recorder.Body.String())
+	}
+
+	var info EntryInfo
+	json.Unmarshal(serve(http.MethodGet, "/keys/key1").Body.Bytes(), &info)
+	if !info.LegalHold {
+		t.Errorf("Expected the metadata of key1 to report the legal hold")
+	}
+	if recorder := serve(http.MethodDelete, "/keys/key1"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected deleting a held key to fail, got %d", recorder.Code)
+	}
+
+	if recorder := serve(http.MethodDelete, "/holds/key1"); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected releasing the hold to succeed, got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodDelete, "/holds/key1"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected releasing a missing hold to fail, got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodDelete, "/keys/key1"); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected deleting a released key to succeed, got %d", recorder.Code)
+	}
+}
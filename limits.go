@@ -0,0 +1,64 @@
+package cachemachine
+
+import "fmt"
+
+const (
+	// MaxKeySize is the size of the largest key the RAM cache accepts.
+	MaxKeySize = 65535
+
+	// freecacheMinSize is the size freecache raises smaller caches to, and
+	// freecacheEntryHeader the size of the header it stores with every
+	// entry.
+	freecacheMinSize     = 512 * 1024
+	freecacheEntryHeader = 24
+)
+
+// LimitError is returned by Set when StrictLimits is set and the key, or the
+// key and the value together, exceed what the RAM cache accepts.
+type LimitError struct {
+	Key string
+
+	// Size is the size of the key, or of the key and the value, and Limit
+	// the largest size accepted.
+	Size  int
+	Limit int
+
+	// MinRamCacheSize is the size the RAM cache would need to accept the
+	// entry, or 0 when the key is too large for any RAM cache.
+	MinRamCacheSize int
+}
+
+func (e *LimitError) Error() string {
+	if e.MinRamCacheSize == 0 {
+		return fmt.Sprintf("key of %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+	}
+	return fmt.Sprintf("key and value of %d bytes exceed the limit of %d bytes, 1/1024 of the RAM cache size less %d bytes of header: the RAM cache needs at least %d bytes to store them",
+		e.Size, e.Limit, freecacheEntryHeader, e.MinRamCacheSize)
+}
+
+// MaxEntrySize returns the largest size of a key and its value that the RAM
+// cache accepts.
+func (c *CacheMachine) MaxEntrySize() int {
+	size := c.RamCacheSizeInBytes
+	if size < freecacheMinSize {
+		size = freecacheMinSize
+	}
+	return size/1024 - freecacheEntryHeader
+}
+
+// checkLimits returns a LimitError when key or val exceed the limits of the
+// RAM cache, see StrictLimits.
+func (c *CacheMachine) checkLimits(key string, val []byte) error {
+	if len(key) > MaxKeySize {
+		return &LimitError{Key: key, Size: len(key), Limit: MaxKeySize}
+	}
+	if size, limit := len(key)+len(val), c.MaxEntrySize(); size > limit {
+		return &LimitError{
+			Key:             key,
+			Size:            size,
+			Limit:           limit,
+			MinRamCacheSize: (size + freecacheEntryHeader) * 1024,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package cachemachine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCacheMachine_StrictLimits(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.StrictLimits = true
+
+	// freecache raises the size of the cache to 512 KiB.
+	if limit := CacheMachine.MaxEntrySize(); limit != 512-24 {
+		t.Errorf("Expected a limit of 488 bytes, got %d", limit)
+	}
+	if err := CacheMachine.Set("key1", make([]byte, 484)); err != nil {
+		t.Errorf("Expected no error setting an entry at the limit, got %s", err)
+	}
+
+	err = CacheMachine.Set("key2", make([]byte, 485))
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Expected a LimitError, got %v", err)
+	}
+	if limitErr.Key != "key2" || limitErr.Size != 489 || limitErr.Limit != 488 || limitErr.MinRamCacheSize != (489+24)*1024 {
+		t.Errorf("Expected the size, limit and minimum RAM cache size, got %+v", limitErr)
+	}
+	if !strings.Contains(err.Error(), "525312 bytes") {
+		t.Errorf("Expected the error to tell the RAM cache size needed, got %s", err)
+	}
+	if _, ok := CacheMachine.syncTable.get("key2"); ok {
+		t.Errorf("Expected the sync table to be left untouched")
+	}
+
+	err = CacheMachine.Set(strings.Repeat("k", MaxKeySize+1), nil)
+	if !errors.As(err, &limitErr) || limitErr.Limit != MaxKeySize || limitErr.MinRamCacheSize != 0 {
+		t.Errorf("Expected a LimitError on the key, got %v", err)
+	}
+}
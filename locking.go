@@ -0,0 +1,116 @@
+package cachemachine
+
+import "sync"
+
+// syncTableShards is the number of stripes the sync table and the per-key
+// locks are split into. Operations on keys that hash to different stripes
+// never contend with each other.
+const syncTableShards = 256
+
+// syncTableShard is one stripe of the sync table. Holding its lock gives
+// exclusive access to every key that hashes to it, both to their sync state
+// and to their values in every tier.
+type syncTableShard struct {
+	sync.Mutex
+	entries map[string]CacheSyncTable
+
+	// dirty queues the keys of the stripe that need to be synced, in the
+	// order they were set. It may hold keys that were deleted or synced
+	// since they were queued, which the sync skips.
+	dirty []string
+
+	// overflowed is set when a key could not be queued because dirty was
+	// full. The queue is then rebuilt from the entries of the stripe before
+	// it is next used, see dirtyKeys.
+	overflowed bool
+}
+
+// syncTable maps every key known to the cache machine to its sync state,
+// split in independently locked stripes.
+type syncTable [syncTableShards]syncTableShard
+
+func newSyncTable() *syncTable {
+	table := &syncTable{}
+	for i := range table {
+		table[i].entries = make(map[string]CacheSyncTable)
+	}
+	return table
+}
+
+// shard returns the stripe of the given key.
+func (t *syncTable) shard(key string) *syncTableShard {
+	return &t[shardIndex(key)]
+}
+
+// shardIndex returns the index of the stripe of the given key, using FNV-1a
+// to spread keys.
+func shardIndex(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash % syncTableShards
+}
+
+// lockPair locks the stripes of two keys, in order like lockAll, and
+// returns them along with the function unlocking them.
+func (t *syncTable) lockPair(a, b string) (*syncTableShard, *syncTableShard, func()) {
+	i, j := shardIndex(a), shardIndex(b)
+	if i == j {
+		t[i].Lock()
+		return &t[i], &t[i], t[i].Unlock
+	}
+	if i < j {
+		t[i].Lock()
+		t[j].Lock()
+	} else {
+		t[j].Lock()
+		t[i].Lock()
+	}
+	return &t[i], &t[j], func() {
+		t[i].Unlock()
+		t[j].Unlock()
+	}
+}
+
+// get returns the sync state of the given key.
+func (t *syncTable) get(key string) (CacheSyncTable, bool) {
+	shard := t.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	cacheSync, ok := shard.entries[key]
+	return cacheSync, ok
+}
+
+// len returns the number of keys in the table.
+func (t *syncTable) len() int {
+	var count int
+	for i := range t {
+		t[i].Lock()
+		count += len(t[i].entries)
+		t[i].Unlock()
+	}
+	return count
+}
+
+// lockAll locks every stripe, in order, for operations that must be atomic
+// across all keys.
+func (t *syncTable) lockAll() {
+	for i := range t {
+		t[i].Lock()
+	}
+}
+
+func (t *syncTable) unlockAll() {
+	for i := range t {
+		t[i].Unlock()
+	}
+}
+
+// SyncState returns the sync state of the given key, and false if the key is
+// unknown to the cache machine.
+func (c *CacheMachine) SyncState(key string) (CacheSyncTable, bool) {
+	return c.syncTable.get(key)
+}
@@ -0,0 +1,159 @@
+package cachemachine
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncTable_Shard(t *testing.T) {
+	table := newSyncTable()
+
+	if table.shard("key1") != table.shard("key1") {
+		t.Errorf("Expected a key to always map to the same stripe")
+	}
+
+	used := make(map[*syncTableShard]bool)
+	for i := 0; i < 10000; i++ {
+		used[table.shard("key"+strconv.Itoa(i))] = true
+	}
+	if len(used) != syncTableShards {
+		t.Errorf("Expected keys to spread over %d stripes, got %d", syncTableShards, len(used))
+	}
+}
+
+func TestCacheMachine_ConcurrentAccess(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(1024*1024, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := "key" + strconv.Itoa(i*100+j)
+				CacheMachine.Set(key, []byte(key))
+				CacheMachine.Get(key)
+				if j%10 == 0 {
+					CacheMachine.SyncRamCacheToDiskCache()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if CacheMachine.syncTable.len() != 800 {
+		t.Errorf("Expected 800 keys in the sync table, got %d", CacheMachine.syncTable.len())
+	}
+}
+
+func BenchmarkCacheMachine_SetParallel(b *testing.B) {
+	CacheMachine, err := NewCacheMachine(64*1024*1024, 1024)
+	if err != nil {
+		b.Fatalf("Error creating cache machine: %s", err)
+	}
+	value := []byte("12345")
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			CacheMachine.Set("key"+strconv.Itoa(i%10000), value)
+			i++
+		}
+	})
+}
+
+func TestCacheMachine_RamHitLockFree(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.EnableFrequencyTracking()
+	CacheMachine.EnableUsageTracking(1)
+	CacheMachine.EnableThrashProtection(2, 0)
+	CacheMachine.MaxMetricsNamespaces = 1
+	CacheMachine.DefaultTimeouts.Get = time.Second
+	CacheMachine.Set("users:1", []byte("12345"))
+	CacheMachine.Set("orders:1", []byte("67890"))
+	CacheMachine.Get("users:1")
+	CacheMachine.Get("orders:1")
+
+	// Every lock of the cache machine is held, yet RAM hits are served,
+	// including for a namespace past MaxMetricsNamespaces. Only the first
+	// hit of a prefix or namespace may lock, to register it.
+	CacheMachine.syncTable.lockAll()
+	defer CacheMachine.syncTable.unlockAll()
+	CacheMachine.stats.namespaces.mu.Lock()
+	defer CacheMachine.stats.namespaces.mu.Unlock()
+	CacheMachine.usage.mu.Lock()
+	defer CacheMachine.usage.mu.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		_, ok1 := CacheMachine.Get("users:1")
+		_, ok2 := CacheMachine.Get("orders:1")
+		done <- ok1 && ok2
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Errorf("Expected RAM hits")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected RAM hits not to wait on a lock")
+	}
+}
+
+func BenchmarkCacheMachine_GetParallel(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		enable func(c *CacheMachine)
+	}{
+		{"plain", func(c *CacheMachine) {}},
+		{"instrumented", func(c *CacheMachine) {
+			c.EnableFrequencyTracking()
+			c.EnableUsageTracking(1)
+			c.EnableThrashProtection(2, 0)
+			c.MaxMetricsNamespaces = 1
+			c.DefaultTimeouts.Get = time.Second
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			CacheMachine, err := NewCacheMachine(64*1024*1024, 1024)
+			if err != nil {
+				b.Fatalf("Error creating cache machine: %s", err)
+			}
+			bench.enable(CacheMachine)
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = "ns" + strconv.Itoa(i%100) + ":" + strconv.Itoa(i)
+				CacheMachine.Set(keys[i], []byte("12345"))
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					CacheMachine.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package cachemachine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the minimum interface that a logger must implement. It is used
+// to log messages. The idea here is to decouple the logger from the library,
+// so that the library can be used in contexts with any logging library.
+// Messages are logged with alternating keys and values, keys being strings.
+//
+// *slog.Logger implements Logger as is, ZapLogger adapts a zap
+// SugaredLogger and LoggerFunc adapts anything else, such as logrus.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// Level is the severity of a log message.
+type Level int
+
+// The levels are ordered by increasing severity, LevelInfo being the zero
+// value.
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// DefaultLogger logs the messages of at least Level with the standard log
+// package, as a line of key=value pairs.
+type DefaultLogger struct {
+	Level Level
+}
+
+func (l DefaultLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l DefaultLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l DefaultLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l DefaultLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l DefaultLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < l.Level {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[cachemachine] level=%s msg=%q", level, msg)
+	forEachField(keysAndValues, func(key string, value interface{}) {
+		fmt.Fprintf(&b, " %s=%v", key, value)
+	})
+	log.Print(b.String())
+}
+
+// LoggerFunc adapts a function to the Logger interface, the keys and values
+// of every message being collected in fields. It allows plugging in logging
+// libraries that Logger does not fit, such as logrus:
+//
+//	cachemachine.LoggerFunc(func(level cachemachine.Level, msg string, fields map[string]interface{}) {
+//		logrusLevel, _ := logrus.ParseLevel(level.String())
+//		logger.WithFields(fields).Log(logrusLevel, msg)
+//	})
+type LoggerFunc func(level Level, msg string, fields map[string]interface{})
+
+func (f LoggerFunc) Debug(msg string, keysAndValues ...interface{}) {
+	f(LevelDebug, msg, fields(keysAndValues))
+}
+
+func (f LoggerFunc) Info(msg string, keysAndValues ...interface{}) {
+	f(LevelInfo, msg, fields(keysAndValues))
+}
+
+func (f LoggerFunc) Warn(msg string, keysAndValues ...interface{}) {
+	f(LevelWarn, msg, fields(keysAndValues))
+}
+
+func (f LoggerFunc) Error(msg string, keysAndValues ...interface{}) {
+	f(LevelError, msg, fields(keysAndValues))
+}
+
+// SugaredLogger is the subset of the methods of a zap SugaredLogger used by
+// ZapLogger.
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// ZapLogger adapts a zap SugaredLogger, as returned by zap.Logger.Sugar, to
+// the Logger interface.
+func ZapLogger(logger SugaredLogger) Logger {
+	return zapLogger{logger}
+}
+
+type zapLogger struct {
+	logger SugaredLogger
+}
+
+func (l zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, keysAndValues...)
+}
+
+func (l zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
+
+// fields collects alternating keys and values in a map.
+func fields(keysAndValues []interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(keysAndValues)/2)
+	forEachField(keysAndValues, func(key string, value interface{}) {
+		fields[key] = value
+	})
+	return fields
+}
+
+// forEachField calls fn with every pair of alternating keys and values, in
+// order. A value without a key is reported under the "!BADKEY" key, like slog
+// does.
+func forEachField(keysAndValues []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(keysAndValues); i++ {
+		key, ok := keysAndValues[i].(string)
+		if !ok || i+1 == len(keysAndValues) {
+			fn("!BADKEY", keysAndValues[i])
+			continue
+		}
+		fn(key, keysAndValues[i+1])
+		i++
+	}
+}
@@ -0,0 +1,8 @@
+//go:build go1.21
+
+package cachemachine
+
+import "log/slog"
+
+// A *slog.Logger can be passed to SetLogger as is.
+var _ Logger = (*slog.Logger)(nil)
@@ -0,0 +1,80 @@
+package cachemachine
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDefaultLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	logger := DefaultLogger{Level: LevelWarn}
+	logger.Info("routine message", "count", 1)
+	logger.Error("error syncing to disk", "key", "key1", "error", "disk full", "dangling")
+
+	expected := "[cachemachine] level=error msg=\"error syncing to disk\" key=key1 error=disk full !BADKEY=dangling\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestLoggerFunc(t *testing.T) {
+	var levels []Level
+	var logged []map[string]interface{}
+	logger := LoggerFunc(func(level Level, msg string, fields map[string]interface{}) {
+		levels = append(levels, level)
+		logged = append(logged, fields)
+	})
+
+	logger.Debug("message", "key", "key1")
+	logger.Warn("message", "count", 2)
+
+	if len(levels) != 2 || levels[0] != LevelDebug || levels[1] != LevelWarn {
+		t.Errorf("Expected debug and warn messages, got %v", levels)
+	}
+	if logged[0]["key"] != "key1" || logged[1]["count"] != 2 {
+		t.Errorf("Unexpected fields %v", logged)
+	}
+}
+
+// sugaredLogger records the calls of ZapLogger.
+type sugaredLogger struct {
+	calls []string
+}
+
+func (l *sugaredLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.calls = append(l.calls, "debug "+msg)
+}
+
+func (l *sugaredLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.calls = append(l.calls, "info "+msg)
+}
+
+func (l *sugaredLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.calls = append(l.calls, "warn "+msg)
+}
+
+func (l *sugaredLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.calls = append(l.calls, "error "+msg)
+}
+
+func TestZapLogger(t *testing.T) {
+	sugared := &sugaredLogger{}
+	logger := ZapLogger(sugared)
+
+	logger.Debug("a")
+	logger.Info("b")
+	logger.Warn("c")
+	logger.Error("d")
+
+	if calls := strings.Join(sugared.calls, ","); calls != "debug a,info b,warn c,error d" {
+		t.Errorf("Unexpected calls %s", calls)
+	}
+}
@@ -0,0 +1,138 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+)
+
+// MGet returns the values of keys that are found in any tier, keyed by key.
+// The values that are not in RAM are read from disk in one batch, see
+// diskcache.Cache.GetMulti, which is much faster than as many Get calls
+// for batch consumers. The access statistics are updated as if every key
+// was read with Get.
+func (c *CacheMachine) MGet(keys []string) map[string][]byte {
+	values, _ := c.MGetCtx(context.Background(), keys)
+	return values
+}
+
+// MGetCtx is like MGet, but gives up waiting on the cold tiers when ctx is
+// done, returning the values found so far and the error of ctx.
+func (c *CacheMachine) MGetCtx(ctx context.Context, keys []string) (map[string][]byte, error) {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Get)
+	defer cancel()
+	values := make(map[string][]byte, len(keys))
+	sketch := c.frequencySketch()
+	now := time.Now()
+
+	type coldRead struct {
+		key       string
+		cacheSync CacheSyncTable
+	}
+	var diskReads, s3Reads []coldRead
+	miss := func(key string) {
+		c.stats.recordMiss()
+		c.namespaceCounters(key).recordMiss()
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if sketch != nil {
+			sketch.record(key)
+		}
+		c.recordReaccessRead(key)
+		value, err := c.RamCache.Get([]byte(key))
+		if err == nil {
+			c.stats.recordRamHit(len(value))
+			c.namespaceCounters(key).recordRamHit()
+			c.recordUsageHit(key)
+			c.recordRamRead(key)
+			c.recordIdleRead(key)
+			c.slideExpiration(key)
+			values[key] = value
+			continue
+		}
+
+		cacheSync, _ := c.syncTable.get(key)
+		switch {
+		case cacheSync.expired(now):
+			c.expireLazily(key, cacheSync)
+			miss(key)
+		case cacheSync.Negative:
+			c.stats.recordNegativeHit()
+		case cacheSync.DiskSynced && c.DiskCache != nil:
+			diskReads = append(diskReads, coldRead{key, cacheSync})
+		case cacheSync.S3Sync && c.S3Cache != nil:
+			s3Reads = append(s3Reads, coldRead{key, cacheSync})
+		default:
+			miss(key)
+		}
+	}
+
+	if diskCache := c.DiskCache; len(diskReads) > 0 && diskCache != nil {
+		diskKeys := make([]string, len(diskReads))
+		for i, r := range diskReads {
+			diskKeys[i] = r.key
+		}
+		found, err := diskCache.GetMulti(ctx, diskKeys)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return values, ctxErr
+			}
+			c.Logger.Warn("error reading from disk", "error", err)
+		}
+		for _, r := range diskReads {
+			value, ok := found[r.key]
+			if !ok {
+				value, err = c.readDrainingDisk(r.key)
+				ok = err == nil
+			}
+			if !ok {
+				if r.cacheSync.S3Sync && c.S3Cache != nil {
+					s3Reads = append(s3Reads, r)
+				} else {
+					miss(r.key)
+				}
+				continue
+			}
+			c.stats.recordDiskHit(len(value))
+			c.namespaceCounters(r.key).recordDiskHit()
+			c.recordUsageHit(r.key)
+			c.promote(r.key, value, r.cacheSync)
+			c.recordIdleRead(r.key)
+			c.slideExpiration(r.key)
+			values[r.key] = value
+		}
+	}
+
+	s3Cache := c.S3Cache
+	for _, r := range s3Reads {
+		if s3Cache == nil {
+			miss(r.key)
+			continue
+		}
+		value, err := c.getObject(ctx, s3Cache, r.key)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return values, ctxErr
+			}
+			if err != ErrObjectNotFound && err != ErrCorruptObject && err != ErrUnsupportedObject {
+				c.Logger.Warn("error reading from S3", "key", r.key, "error", err)
+			}
+			miss(r.key)
+			continue
+		}
+		c.stats.recordS3Hit(len(value))
+		c.namespaceCounters(r.key).recordS3Hit()
+		c.recordUsageHit(r.key)
+		c.refreshStale(r.key, r.cacheSync)
+		c.promote(r.key, value, r.cacheSync)
+		c.recordIdleRead(r.key)
+		c.slideExpiration(r.key)
+		values[r.key] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,65 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_MGet(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	for i := 0; i < 10; i++ {
+		CacheMachine.Set(fmt.Sprintf("disk%d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	CacheMachine.Set("s3", []byte("cold"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+	CacheMachine.DiskCache.Delete("s3")
+	CacheMachine.Set("ram", []byte("hot"))
+	CacheMachine.SetNegative("negative", time.Hour)
+
+	keys := []string{"ram", "s3", "missing", "negative", "ram"}
+	for i := 9; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("disk%d", i))
+	}
+	values := CacheMachine.MGet(keys)
+	if len(values) != 12 || string(values["ram"]) != "hot" || string(values["s3"]) != "cold" {
+		t.Errorf("Expected 12 values, got %q", values)
+	}
+	for i := 0; i < 10; i++ {
+		if value := values[fmt.Sprintf("disk%d", i)]; string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %q", i, value)
+		}
+	}
+	stats := CacheMachine.Stats()
+	if stats.RamHits != 1 || stats.DiskHits != 10 || stats.S3Hits != 1 || stats.Misses != 1 || stats.NegativeHits != 1 {
+		t.Errorf("Expected 1 RAM hit, 10 disk hits, 1 S3 hit, 1 miss and 1 negative hit, got %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	values, err = CacheMachine.MGetCtx(ctx, []string{"ram", "disk1"})
+	if err != context.Canceled || string(values["ram"]) != "hot" {
+		t.Errorf("Expected the RAM value and the error of ctx, got %q, %v", values, err)
+	}
+}
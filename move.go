@@ -0,0 +1,146 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// moveRounds is the number of catch-up rounds MoveDiskCache runs without
+// blocking the cache machine, to copy the entries written during the
+// previous round, before it switches over.
+const moveRounds = 3
+
+// MoveDiskCache moves the disk tier to newPath, such as a directory on a
+// bigger volume, while the cache machine keeps serving from the current
+// one. The entries are copied in the background, from the least to the
+// most recently used so that the new directory keeps their recency, then
+// the entries written during the copy are caught up. Finally the cache
+// machine is briefly blocked while the last changes are reconciled and the
+// disk tier is switched over to newPath. The old directory is then emptied
+// and released.
+//
+// When ctx is done before the switch over, the partial copy is removed and
+// the disk tier is left where it was.
+func (c *CacheMachine) MoveDiskCache(ctx context.Context, newPath string) error {
+	if !c.moveMu.TryLock() {
+		return fmt.Errorf("disk cache move already in progress")
+	}
+	defer c.moveMu.Unlock()
+
+	oldCache := c.DiskCache
+	if oldCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	if newPath == "" {
+		return fmt.Errorf("newPath must be set")
+	}
+	if filepath.Clean(newPath) == filepath.Clean(c.DiskCachePath) {
+		return fmt.Errorf("disk cache is already in %s", newPath)
+	}
+	oldPath := c.DiskCachePath
+	start := time.Now()
+
+	newCache, err := c.newDiskCache(newPath, c.DiskCacheSizeInBytes)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		if clearErr := newCache.Clear(); clearErr != nil {
+			c.logError("error clearing partial disk cache move", "path", newPath, "error", clearErr)
+		}
+		if closeErr := newCache.Close(); closeErr != nil {
+			c.logError("error closing partial disk cache move", "path", newPath, "error", closeErr)
+		}
+		return err
+	}
+
+	// copied holds the creation time of the entries of the old cache
+	// when they were copied, to tell those rewritten since.
+	copied := make(map[string]time.Time)
+	for round := 0; round < moveRounds; round++ {
+		n, err := c.copyDiskEntries(ctx, oldCache, newCache, copied)
+		if err != nil {
+			return abort(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	if err := ctx.Err(); err != nil {
+		return abort(err)
+	}
+	if _, err := c.copyDiskEntries(context.Background(), oldCache, newCache, copied); err != nil {
+		return abort(err)
+	}
+	for _, key := range newCache.Keys() {
+		if _, ok := oldCache.EntrySize(key); !ok {
+			if _, err := newCache.Delete(key); err != nil {
+				return abort(fmt.Errorf("error deleting %s from new disk cache: %s", key, err))
+			}
+		}
+	}
+
+	c.DiskCache = newCache
+	c.DiskCachePath = newPath
+	if err := oldCache.Clear(); err != nil {
+		c.logError("error clearing old disk cache", "path", oldPath, "error", err)
+	}
+	if err := oldCache.Close(); err != nil {
+		c.logError("error closing old disk cache", "path", oldPath, "error", err)
+	}
+	c.emitEvent(EventDiskMoved, "disk cache moved", map[string]interface{}{
+		"from":     oldPath,
+		"to":       newPath,
+		"entries":  newCache.Len(),
+		"duration": time.Since(start).String(),
+	})
+	return nil
+}
+
+// movedDiskCache returns the disk cache that replaced diskCache, when the
+// disk cache was moved since diskCache was read, or nil. Reads racing with a
+// move find their entry gone from the old directory, and read it again from
+// the new one.
+func (c *CacheMachine) movedDiskCache(key string, diskCache *diskcache.Cache) *diskcache.Cache {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if c.DiskCache == diskCache {
+		return nil
+	}
+	return c.DiskCache
+}
+
+// copyDiskEntries copies to dst the entries of src that were not copied
+// yet, or that were rewritten since, and records them in copied. It returns
+// the number of entries it copied. The entries removed from src while they
+// are being copied are skipped.
+func (c *CacheMachine) copyDiskEntries(ctx context.Context, src, dst *diskcache.Cache, copied map[string]time.Time) (int, error) {
+	var n int
+	for _, meta := range src.Entries() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if createdAt, ok := copied[meta.Key]; ok && createdAt.Equal(meta.CreatedAt) {
+			continue
+		}
+		value, err := src.Peek(meta.Key)
+		if err != nil {
+			continue
+		}
+		if err := c.putDisk(dst, meta.Key, value); err != nil {
+			return n, fmt.Errorf("error copying %s to new disk cache: %s", meta.Key, err)
+		}
+		copied[meta.Key] = meta.CreatedAt
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,152 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+func TestCacheMachine_MoveDiskCache(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+	oldPath := filepath.Join(tmpFolder, "old")
+	newPath := filepath.Join(tmpFolder, "new")
+
+	err = CacheMachine.EnableDiskCache(1024*1024, oldPath)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for i := 0; i < 100; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	if err := CacheMachine.Flush(); err != nil {
+		t.Fatalf("Expected no error flushing, got %s", err)
+	}
+	// Only the disk copies are left.
+	CacheMachine.RamCache.Clear()
+
+	// The cache machine keeps serving during the move.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			CacheMachine.Set(fmt.Sprintf("new%d", i), []byte("new"))
+			if value, ok := CacheMachine.Get(fmt.Sprintf("key%d", i)); !ok || string(value) != fmt.Sprintf("value%d", i) {
+				t.Errorf("Expected key%d to be served during the move, got %q, %v", i, value, ok)
+			}
+		}
+	}()
+	err = CacheMachine.MoveDiskCache(context.Background(), newPath)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Expected no error moving the disk cache, got %s", err)
+	}
+
+	if CacheMachine.DiskCachePath != newPath {
+		t.Errorf("Expected the disk cache to be in %s, got %s", newPath, CacheMachine.DiskCachePath)
+	}
+	if err := CacheMachine.Flush(); err != nil {
+		t.Fatalf("Expected no error flushing, got %s", err)
+	}
+	CacheMachine.RamCache.Clear()
+	for i := 0; i < 100; i++ {
+		if value, ok := CacheMachine.Get(fmt.Sprintf("key%d", i)); !ok || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected key%d to be moved, got %q, %v", i, value, ok)
+		}
+		if value, ok := CacheMachine.Get(fmt.Sprintf("new%d", i)); !ok || string(value) != "new" {
+			t.Errorf("Expected new%d to be moved, got %q, %v", i, value, ok)
+		}
+	}
+
+	files, err := ioutil.ReadDir(oldPath)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", oldPath, err)
+	}
+	for _, file := range files {
+		if file.Name() != diskcache.LockFileName {
+			t.Errorf("Expected the old directory to be emptied, found %s", file.Name())
+		}
+	}
+
+	// The old directory is released.
+	oldCache, err := diskcache.New(oldPath, 1024, 10)
+	if err != nil {
+		t.Errorf("Expected the old directory to be released, got %s", err)
+	} else {
+		oldCache.Close()
+	}
+
+	if err := CacheMachine.MoveDiskCache(context.Background(), newPath); err == nil {
+		t.Errorf("Expected an error moving the disk cache to its own directory")
+	}
+}
+
+func TestCacheMachine_MoveDiskCacheCanceled(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	if err := CacheMachine.MoveDiskCache(context.Background(), "somewhere"); err == nil {
+		t.Errorf("Expected an error moving a disabled disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+	oldPath := filepath.Join(tmpFolder, "old")
+	newPath := filepath.Join(tmpFolder, "new")
+
+	err = CacheMachine.EnableDiskCache(1024*1024, oldPath)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("value1"))
+	if err := CacheMachine.Flush(); err != nil {
+		t.Fatalf("Expected no error flushing, got %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CacheMachine.MoveDiskCache(ctx, newPath); err != context.Canceled {
+		t.Errorf("Expected the move to be canceled, got %v", err)
+	}
+	if CacheMachine.DiskCachePath != oldPath {
+		t.Errorf("Expected the disk cache to stay in %s, got %s", oldPath, CacheMachine.DiskCachePath)
+	}
+
+	CacheMachine.RamCache.Clear()
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "value1" {
+		t.Errorf("Expected key1 to be served from the old directory, got %q, %v", value, ok)
+	}
+
+	// The partial copy is removed, and its directory released.
+	newCache, err := diskcache.New(newPath, 1024, 10)
+	if err != nil {
+		t.Fatalf("Expected the new directory to be released, got %s", err)
+	}
+	defer newCache.Close()
+	if newCache.Len() != 0 {
+		t.Errorf("Expected the partial copy to be removed")
+	}
+}
@@ -0,0 +1,153 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ErrSchemaMismatch is returned by the typed namespace views when a value
+// was not written by a view of the same type and schema version.
+var ErrSchemaMismatch = errors.New("value written with another schema")
+
+// NamespaceOption configures a typed namespace view opened with NamespaceOf.
+type NamespaceOption func(*namespaceOptions)
+
+type namespaceOptions struct {
+	codec         Codec
+	ttl           time.Duration
+	ttlPolicy     TTLPolicy
+	schemaVersion uint32
+}
+
+// WithNamespaceCodec makes the view encode values with codec instead of
+// JSONCodec.
+func WithNamespaceCodec(codec Codec) NamespaceOption {
+	return func(o *namespaceOptions) {
+		o.codec = codec
+	}
+}
+
+// WithNamespaceTTL makes the values set through the view expire after ttl.
+func WithNamespaceTTL(ttl time.Duration) NamespaceOption {
+	return func(o *namespaceOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithNamespaceTTLPolicy rewrites the TTL of the values set through the
+// view, before the TTLPolicy of the cache machine.
+func WithNamespaceTTLPolicy(policy TTLPolicy) NamespaceOption {
+	return func(o *namespaceOptions) {
+		o.ttlPolicy = policy
+	}
+}
+
+// WithSchemaVersion sets the version of the schema of the values of the
+// view. Bumping it when the layout of the type changes incompatibly makes
+// the values written with the previous layout read as ErrSchemaMismatch.
+func WithSchemaVersion(version uint32) NamespaceOption {
+	return func(o *namespaceOptions) {
+		o.schemaVersion = version
+	}
+}
+
+// Namespace is a typed view of the keys of a namespace, see NamespaceOf.
+type Namespace[T any] struct {
+	c    *CacheMachine
+	name string
+	opts namespaceOptions
+
+	// schema is written before every value, and identifies the type and
+	// schema version of the view.
+	schema uint32
+}
+
+// namespaceSchemaSize is the size of the schema fingerprint written before
+// the encoded values of the typed namespace views.
+const namespaceSchemaSize = 4
+
+// NamespaceOf opens the namespace name of c as a view of values of type T,
+// encoded with a fixed codec and set with a fixed TTL policy. The keys of
+// the view are relative to the namespace: the key "123" of the view of
+// "users" is "users:123" in c. A namespace can only be opened with one type
+// per cache machine; the values written by a view of another type or schema
+// version, such as one in another process, read as ErrSchemaMismatch.
+func NamespaceOf[T any](c *CacheMachine, name string, opts ...NamespaceOption) (*Namespace[T], error) {
+	if name == "" || KeyNamespace(name+NamespaceSeparator) != name {
+		return nil, fmt.Errorf("invalid namespace name %q", name)
+	}
+	n := &Namespace[T]{
+		c:    c,
+		name: name,
+		opts: namespaceOptions{codec: JSONCodec},
+	}
+	for _, opt := range opts {
+		opt(&n.opts)
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if opened, loaded := c.namespaces.LoadOrStore(name, typ); loaded && opened != typ {
+		return nil, fmt.Errorf("namespace %s is already opened with type %s", name, opened)
+	}
+	n.schema = crc32.Checksum([]byte(typ.PkgPath()+"."+typ.String()+"/"+strconv.FormatUint(uint64(n.opts.schemaVersion), 10)), crc32c)
+	return n, nil
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace[T]) Name() string {
+	return n.name
+}
+
+// Key returns the key of the cache machine for the key id of the view.
+func (n *Namespace[T]) Key(id string) string {
+	return n.name + NamespaceSeparator + id
+}
+
+// Get returns the value of id, and whether it was found.
+func (n *Namespace[T]) Get(ctx context.Context, id string) (T, bool, error) {
+	var value T
+	key := n.Key(id)
+	data, ok, err := n.c.GetCtx(ctx, key)
+	if err != nil || !ok {
+		return value, false, err
+	}
+	if len(data) < namespaceSchemaSize || binary.BigEndian.Uint32(data) != n.schema {
+		return value, false, ErrSchemaMismatch
+	}
+	if err := n.opts.codec.Unmarshal(data[namespaceSchemaSize:], &value); err != nil {
+		return value, false, fmt.Errorf("error decoding key %s: %s", key, err)
+	}
+	return value, true, nil
+}
+
+// Set sets the value of id, with the TTL of the view.
+func (n *Namespace[T]) Set(ctx context.Context, id string, value T) error {
+	key := n.Key(id)
+	encoded, err := n.opts.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding key %s: %s", key, err)
+	}
+	data := make([]byte, namespaceSchemaSize+len(encoded))
+	binary.BigEndian.PutUint32(data, n.schema)
+	copy(data[namespaceSchemaSize:], encoded)
+
+	ttl := n.opts.ttl
+	if n.opts.ttlPolicy != nil {
+		ttl = n.opts.ttlPolicy(key, ttl)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.c.SetWithTTL(key, data, ttl)
+}
+
+// Delete deletes id from every tier, and reports whether it existed.
+func (n *Namespace[T]) Delete(ctx context.Context, id string) (bool, error) {
+	return n.c.DeleteCtx(ctx, n.Key(id))
+}
@@ -0,0 +1,144 @@
+package cachemachine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// NamespaceSeparator separates the namespace of a key from the rest of it:
+// the namespace of "users:123:profile" is "users".
+const NamespaceSeparator = ":"
+
+// DefaultNamespace is the namespace of the keys without a separator.
+const DefaultNamespace = "default"
+
+// OtherNamespace is the namespace under which the stats of the namespaces
+// exceeding MaxMetricsNamespaces are aggregated.
+const OtherNamespace = "other"
+
+// DefaultMaxMetricsNamespaces is the default value of
+// CacheMachine.MaxMetricsNamespaces.
+const DefaultMaxMetricsNamespaces = 100
+
+// KeyNamespace returns the namespace of a key.
+func KeyNamespace(key string) string {
+	i := strings.Index(key, NamespaceSeparator)
+	if i <= 0 {
+		return DefaultNamespace
+	}
+	return key[:i]
+}
+
+// NamespaceStats holds the counters of a namespace.
+type NamespaceStats struct {
+	RamHits  int64
+	DiskHits int64
+	S3Hits   int64
+	Misses   int64
+	SetCount int64
+	SetBytes int64
+}
+
+// HitRate returns the fraction of Get calls on the namespace answered by any
+// tier. It returns 0 when nothing was requested yet.
+func (s NamespaceStats) HitRate() float64 {
+	hits := s.RamHits + s.DiskHits + s.S3Hits
+	if hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+s.Misses)
+}
+
+type namespaceCounters struct {
+	ramHits  int64
+	diskHits int64
+	s3Hits   int64
+	misses   int64
+	setCount int64
+	setBytes int64
+}
+
+func (n *namespaceCounters) recordRamHit()  { atomic.AddInt64(&n.ramHits, 1) }
+func (n *namespaceCounters) recordDiskHit() { atomic.AddInt64(&n.diskHits, 1) }
+func (n *namespaceCounters) recordS3Hit()   { atomic.AddInt64(&n.s3Hits, 1) }
+func (n *namespaceCounters) recordMiss()    { atomic.AddInt64(&n.misses, 1) }
+
+func (n *namespaceCounters) recordSet(size int) {
+	atomic.AddInt64(&n.setCount, 1)
+	atomic.AddInt64(&n.setBytes, int64(size))
+}
+
+func (n *namespaceCounters) snapshot() NamespaceStats {
+	return NamespaceStats{
+		RamHits:  atomic.LoadInt64(&n.ramHits),
+		DiskHits: atomic.LoadInt64(&n.diskHits),
+		S3Hits:   atomic.LoadInt64(&n.s3Hits),
+		Misses:   atomic.LoadInt64(&n.misses),
+		SetCount: atomic.LoadInt64(&n.setCount),
+		SetBytes: atomic.LoadInt64(&n.setBytes),
+	}
+}
+
+// namespaceStatsTable holds the counters of every namespace, up to a maximum
+// number of namespaces past which the counters are aggregated under
+// OtherNamespace, so that a key space with unbounded prefixes cannot blow up
+// the cardinality of the metrics.
+type namespaceStatsTable struct {
+	namespaces sync.Map
+	count      int64
+	other      namespaceCounters
+	mu         sync.Mutex
+}
+
+// namespaceCounters returns the counters of the namespace of key.
+func (c *CacheMachine) namespaceCounters(key string) *namespaceCounters {
+	table := &c.stats.namespaces
+	namespace := KeyNamespace(key)
+	if counters, ok := table.namespaces.Load(namespace); ok {
+		return counters.(*namespaceCounters)
+	}
+	// Once the table is full, the namespaces left out are counted without
+	// a lock.
+	if atomic.LoadInt64(&table.count) >= int64(c.MaxMetricsNamespaces) {
+		return &table.other
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if counters, ok := table.namespaces.Load(namespace); ok {
+		return counters.(*namespaceCounters)
+	}
+	if atomic.LoadInt64(&table.count) >= int64(c.MaxMetricsNamespaces) {
+		return &table.other
+	}
+	counters := &namespaceCounters{}
+	table.namespaces.Store(namespace, counters)
+	atomic.AddInt64(&table.count, 1)
+	return counters
+}
+
+// snapshot returns the stats of every namespace, including OtherNamespace if
+// some namespaces exceeded the maximum.
+func (t *namespaceStatsTable) snapshot() map[string]NamespaceStats {
+	namespaces := make(map[string]NamespaceStats)
+	t.namespaces.Range(func(namespace, counters interface{}) bool {
+		namespaces[namespace.(string)] = counters.(*namespaceCounters).snapshot()
+		return true
+	})
+	if other := t.other.snapshot(); other != (NamespaceStats{}) {
+		namespaces[OtherNamespace] = other
+	}
+	return namespaces
+}
+
+// sortedNamespaces returns the namespaces of a stats snapshot in order.
+func sortedNamespaces(namespaces map[string]NamespaceStats) []string {
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
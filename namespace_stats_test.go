@@ -0,0 +1,52 @@
+package cachemachine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKeyNamespace(t *testing.T) {
+	for key, expected := range map[string]string{
+		"users:123:profile": "users",
+		"users":             DefaultNamespace,
+		":123":              DefaultNamespace,
+	} {
+		if namespace := KeyNamespace(key); namespace != expected {
+			t.Errorf("Expected namespace of %s to be %s, got %s", key, expected, namespace)
+		}
+	}
+}
+
+func TestCacheMachine_NamespaceStats(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.MaxMetricsNamespaces = 2
+
+	CacheMachine.Set("users:1", []byte("12345"))
+	CacheMachine.Get("users:1")
+	CacheMachine.Get("users:2")
+	CacheMachine.Set("orders:1", []byte("67890"))
+	CacheMachine.Get("sessions:1")
+	CacheMachine.Get("carts:1")
+
+	stats := CacheMachine.Stats()
+	if len(stats.Namespaces) != 3 {
+		t.Errorf("Expected users, orders and other namespaces, got %v", stats.Namespaces)
+	}
+	users := stats.Namespaces["users"]
+	if users.SetCount != 1 || users.SetBytes != 5 || users.RamHits != 1 || users.Misses != 1 || users.HitRate() != 0.5 {
+		t.Errorf("Unexpected stats for users: %+v", users)
+	}
+	if other := stats.Namespaces[OtherNamespace]; other.Misses != 2 {
+		t.Errorf("Expected 2 misses in the other namespace, got %+v", other)
+	}
+
+	var buf bytes.Buffer
+	CacheMachine.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `cachemachine_namespace_misses_total{namespace="other"} 2`) {
+		t.Errorf("Expected namespace metrics, got %s", buf.String())
+	}
+}
@@ -0,0 +1,80 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNamespaceOf(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	ctx := context.Background()
+	users, err := NamespaceOf[user](CacheMachine, "users", WithNamespaceTTL(time.Hour))
+	if err != nil {
+		t.Fatalf("Expected no error opening users, got %s", err)
+	}
+	if users.Key("1") != "users:1" {
+		t.Errorf("Expected users:1, got %s", users.Key("1"))
+	}
+
+	if err := users.Set(ctx, "1", user{Name: "alice", Age: 42}); err != nil {
+		t.Errorf("Expected no error setting user 1, got %s", err)
+	}
+	u, ok, err := users.Get(ctx, "1")
+	if err != nil || !ok || u.Name != "alice" || u.Age != 42 {
+		t.Errorf("Expected alice, got %+v, %v, %v", u, ok, err)
+	}
+	if ttl, err := CacheMachine.RamCache.TTL([]byte("users:1")); err != nil || ttl == 0 {
+		t.Errorf("Expected users:1 to expire, got %d, %v", ttl, err)
+	}
+	if _, ok, err := users.Get(ctx, "2"); ok || err != nil {
+		t.Errorf("Expected a miss getting user 2, got %v, %v", ok, err)
+	}
+
+	if _, err := NamespaceOf[string](CacheMachine, "users"); err == nil {
+		t.Errorf("Expected an error opening users with another type")
+	}
+	if _, err := NamespaceOf[user](CacheMachine, "users"); err != nil {
+		t.Errorf("Expected no error opening users again with the same type, got %s", err)
+	}
+	if _, err := NamespaceOf[user](CacheMachine, "users:admins"); err == nil {
+		t.Errorf("Expected an error opening a namespace with a separator")
+	}
+
+	CacheMachine.Set("users:3", []byte(`{"Name":"bob"}`))
+	if _, _, err := users.Get(ctx, "3"); err != ErrSchemaMismatch {
+		t.Errorf("Expected ErrSchemaMismatch reading an untyped value, got %v", err)
+	}
+	usersV2, _ := NamespaceOf[user](CacheMachine, "users", WithSchemaVersion(2))
+	if _, _, err := usersV2.Get(ctx, "1"); err != ErrSchemaMismatch {
+		t.Errorf("Expected ErrSchemaMismatch reading a value of another schema version, got %v", err)
+	}
+
+	if deleted, err := users.Delete(ctx, "1"); !deleted || err != nil {
+		t.Errorf("Expected user 1 to be deleted, got %v, %v", deleted, err)
+	}
+}
+
+func TestNamespaceOf_TTLPolicy(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	var policyKey string
+	sessions, _ := NamespaceOf[string](CacheMachine, "sessions", WithNamespaceTTLPolicy(func(key string, ttl time.Duration) time.Duration {
+		policyKey = key
+		return time.Minute
+	}))
+	sessions.Set(context.Background(), "abc", "token")
+	if policyKey != "sessions:abc" {
+		t.Errorf("Expected the policy to be called with sessions:abc, got %q", policyKey)
+	}
+	if cacheSync, _ := CacheMachine.SyncState("sessions:abc"); cacheSync.ExpiresAt.IsZero() {
+		t.Errorf("Expected sessions:abc to expire")
+	}
+}
@@ -0,0 +1,41 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNegativeHit is returned by GetCtx for keys cached as not found with
+// SetNegative.
+var ErrNegativeHit = errors.New("negative cache hit")
+
+// SetNegative caches that key does not exist for ttl, so that repeated
+// lookups of nonexistent keys do not hit the backing store. Any value of key
+// is deleted from every tier, unless it is under legal hold, in which case
+// SetNegative returns ErrLegalHold. Until ttl elapses or key is set, Get reports a
+// miss and GetCtx returns ErrNegativeHit. Negative entries only live in
+// memory, and their TTL is not subject to the TTL policy.
+func (c *CacheMachine) SetNegative(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be greater than 0")
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if c.legalHolds.held(key) {
+		return ErrLegalHold
+	}
+	c.deleteLocked(context.Background(), shard, key)
+	now := time.Now()
+	shard.entries[key] = CacheSyncTable{
+		Negative:   true,
+		SetAt:      now,
+		ExpiresAt:  now.Add(ttl),
+		generation: c.nextGeneration(),
+	}
+	return nil
+}
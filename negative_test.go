@@ -0,0 +1,71 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SetNegative(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	err = CacheMachine.SetNegative("key1", 0)
+	if err == nil {
+		t.Errorf("Expected an error setting a negative entry without TTL")
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+	err = CacheMachine.SetNegative("key1", time.Hour)
+	if err != nil {
+		t.Errorf("Expected no error setting a negative entry, got %s", err)
+	}
+	CacheMachine.Flush()
+
+	_, ok, err := CacheMachine.GetCtx(context.Background(), "key1")
+	if ok || err != ErrNegativeHit {
+		t.Errorf("Expected ErrNegativeHit getting key1, got %v, %v", ok, err)
+	}
+	if _, ok := CacheMachine.Get("key1"); ok {
+		t.Errorf("Expected a miss getting key1")
+	}
+	if CacheMachine.Has("key1") {
+		t.Errorf("Expected key1 not to be in the cache")
+	}
+	if _, ok := CacheMachine.DiskCache.EntrySize("key1"); ok {
+		t.Errorf("Expected key1 to be deleted from disk")
+	}
+	if keys, _ := CacheMachine.Keys("", 0, ""); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+	stats := CacheMachine.Stats()
+	if stats.NegativeHits != 2 || stats.Misses != 0 {
+		t.Errorf("Expected 2 negative hits and no miss, got %d and %d", stats.NegativeHits, stats.Misses)
+	}
+
+	// Setting the key replaces the negative entry, and syncs it again.
+	CacheMachine.Set("key1", []byte("67890"))
+	CacheMachine.Flush()
+	value, ok, err := CacheMachine.GetCtx(context.Background(), "key1")
+	if !ok || err != nil || string(value) != "67890" {
+		t.Errorf("Expected value to be 67890, got %s, %v, %v", value, ok, err)
+	}
+	if state, _ := CacheMachine.SyncState("key1"); !state.DiskSynced || state.Negative {
+		t.Errorf("Expected key1 to be synced to disk, got %+v", state)
+	}
+}
@@ -0,0 +1,55 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+)
+
+// Has reports whether the entry for the given key is in any tier, without
+// reading nor copying its value, and without affecting the access statistics
+// of the tiers. The S3 tier is not queried: an entry synced to S3 is assumed
+// to still be there.
+func (c *CacheMachine) Has(key string) bool {
+	cacheSync, ok := c.syncTable.get(key)
+	if ok && cacheSync.expired(time.Now()) {
+		return false
+	}
+	if _, err := c.RamCache.TTL([]byte(key)); err == nil {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	if diskCache := c.DiskCache; cacheSync.DiskSynced && diskCache != nil {
+		if _, ok := diskCache.EntrySize(key); ok {
+			return true
+		}
+	}
+	return cacheSync.S3Sync && c.S3Cache != nil
+}
+
+// Peek is like Get, but does not promote the entry in any tier nor affect
+// the stats of the cache machine, so that entries can be inspected without
+// perturbing the cache.
+func (c *CacheMachine) Peek(key string) ([]byte, bool) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	cacheSync := shard.entries[key]
+	if cacheSync.expired(time.Now()) {
+		shard.Unlock()
+		return nil, false
+	}
+	value, ok := c.peek(shard, key)
+	shard.Unlock()
+	if ok {
+		return value, true
+	}
+
+	if s3Cache := c.S3Cache; cacheSync.S3Sync && s3Cache != nil {
+		value, err := c.getObject(context.Background(), s3Cache, key)
+		if err == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,60 @@
+package cachemachine
+
+import "testing"
+
+func TestCacheMachine_HasPeek(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(newMemoryStore())
+
+	CacheMachine.Set("ram", []byte("12345"))
+	CacheMachine.Set("disk", []byte("67890"))
+	CacheMachine.Set("s3", []byte("abcde"))
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("disk"))
+	CacheMachine.RamCache.Del([]byte("s3"))
+	CacheMachine.DiskCache.Delete("s3")
+	ramHits := CacheMachine.RamCache.HitCount()
+
+	for key, expected := range map[string]string{
+		"ram":  "12345",
+		"disk": "67890",
+		"s3":   "abcde",
+	} {
+		if !CacheMachine.Has(key) {
+			t.Errorf("Expected %s to be in the cache", key)
+		}
+		value, ok := CacheMachine.Peek(key)
+		if !ok || string(value) != expected {
+			t.Errorf("Expected value of %s to be %s, got %s, %v", key, expected, value, ok)
+		}
+	}
+	if CacheMachine.Has("missing") {
+		t.Errorf("Expected missing not to be in the cache")
+	}
+	if _, ok := CacheMachine.Peek("missing"); ok {
+		t.Errorf("Expected missing not to be peeked")
+	}
+
+	stats := CacheMachine.Stats()
+	if stats.RamHits != 0 || stats.DiskHits != 0 || stats.S3Hits != 0 || stats.Misses != 0 {
+		t.Errorf("Expected Has and Peek not to affect stats, got %+v", stats)
+	}
+	if hits := CacheMachine.RamCache.HitCount(); hits != ramHits {
+		t.Errorf("Expected Has and Peek not to affect RAM cache hits, got %d", hits-ramHits)
+	}
+}
@@ -0,0 +1,67 @@
+package peers
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the default number of consecutive failed
+	// requests to a node that open its circuit breaker.
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is the default time a circuit breaker stays
+	// open before a request is let through to probe the node.
+	DefaultBreakerCooldown = 10 * time.Second
+)
+
+// breaker is the circuit breaker of a node. It opens after threshold
+// consecutive failures, and lets a single probe through once cooldown has
+// elapsed: the breaker closes if the probe succeeds, and opens again for
+// cooldown otherwise.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a request can be sent to the node.
+func (b *breaker) allow(threshold int, now time.Time) bool {
+	if threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *breaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) failed(threshold int, cooldown time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if threshold > 0 && b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+func (b *breaker) open(threshold int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return threshold > 0 && b.failures >= threshold && (now.Before(b.openUntil) || b.probing)
+}
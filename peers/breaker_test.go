@@ -0,0 +1,43 @@
+package peers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var b breaker
+	now := time.Now()
+	b.failed(2, time.Minute, now)
+	if !b.allow(2, now) {
+		t.Errorf("Expected the breaker to be closed after 1 failure")
+	}
+	b.failed(2, time.Minute, now)
+	if b.allow(2, now) || !b.open(2, now) {
+		t.Errorf("Expected the breaker to be open after 2 failures")
+	}
+
+	// A single probe is let through once the cooldown has elapsed.
+	later := now.Add(time.Minute)
+	if !b.allow(2, later) {
+		t.Errorf("Expected a probe to be let through after the cooldown")
+	}
+	if b.allow(2, later) {
+		t.Errorf("Expected a single probe to be let through")
+	}
+	b.failed(2, time.Minute, later)
+	if b.allow(2, later.Add(time.Second)) {
+		t.Errorf("Expected the breaker to open again after a failed probe")
+	}
+	if !b.allow(2, later.Add(time.Minute)) {
+		t.Errorf("Expected another probe to be let through")
+	}
+	b.succeeded()
+	if !b.allow(2, later.Add(time.Minute)) || b.open(2, later.Add(time.Minute)) {
+		t.Errorf("Expected the breaker to close after a successful probe")
+	}
+
+	if !b.allow(0, now) {
+		t.Errorf("Expected a threshold of 0 to disable the breaker")
+	}
+}
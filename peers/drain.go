@@ -0,0 +1,79 @@
+package peers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Drain prepares the decommissioning of this node: it stops routing keys to
+// this node, and sends the n hottest entries of its cache, see
+// cachemachine.CacheMachine.HottestEntries, to the nodes taking over their
+// keys, so that a scale-down does not cost the fleet the entries it reads
+// the most. The nodes taking over are peers, or every other node of the
+// pool when none is given. The entries keep their remaining TTL, rounded up
+// to the second. It returns the number of entries sent, and fails when
+// some could not be sent, or with the error of ctx when ctx is done first.
+//
+// The other nodes keep routing keys to this node until their peers are
+// updated, so it should keep serving until they are.
+func (p *Pool) Drain(ctx context.Context, n int, peers ...string) (int, error) {
+	if len(peers) == 0 {
+		p.mu.RLock()
+		for _, node := range p.ring.Nodes() {
+			if node != p.Self {
+				peers = append(peers, node)
+			}
+		}
+		p.mu.RUnlock()
+	}
+	if len(peers) == 0 {
+		return 0, fmt.Errorf("no peer to drain to")
+	}
+	p.SetPeers(peers...)
+
+	var drained, failed int
+	for _, entry := range p.Cache.HottestEntries(n) {
+		if err := ctx.Err(); err != nil {
+			return drained, err
+		}
+		var ttl time.Duration
+		if !entry.ExpiresAt.IsZero() {
+			if ttl = time.Until(entry.ExpiresAt); ttl <= 0 {
+				continue
+			}
+		}
+		value, ok := p.Cache.Peek(entry.Key)
+		if !ok {
+			continue
+		}
+		if err := p.drainEntry(ctx, p.Owner(entry.Key), entry.Key, value, ttl); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return drained, ctxErr
+			}
+			failed++
+			continue
+		}
+		drained++
+	}
+	if failed > 0 {
+		return drained, fmt.Errorf("%d entries failed to drain", failed)
+	}
+	return drained, nil
+}
+
+// drainEntry sends the entry for key to owner.
+func (p *Pool) drainEntry(ctx context.Context, owner, key string, value []byte, ttl time.Duration) error {
+	target := keyURL(owner, key)
+	if ttl > 0 {
+		target += "?ttl=" + strconv.Itoa(int((ttl+time.Second-1)/time.Second))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	return p.send(req, owner, key)
+}
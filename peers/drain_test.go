@@ -0,0 +1,53 @@
+package peers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPool_Drain(t *testing.T) {
+	pools := newFleet(t, 3)
+	leaving := pools[0]
+	leaving.Cache.EnableFrequencyTracking()
+
+	key1 := ownedKey(leaving, leaving.Self, "key1")
+	key2 := ownedKey(leaving, leaving.Self, "key2")
+	key3 := ownedKey(leaving, leaving.Self, "key3")
+	leaving.Cache.SetWithTTL(key1, []byte("12345"), time.Hour)
+	leaving.Cache.Set(key2, []byte("67890"))
+	leaving.Cache.Set(key3, []byte("abcde"))
+	for i := 0; i < 3; i++ {
+		leaving.Cache.Get(key1)
+	}
+	leaving.Cache.Get(key2)
+
+	n, err := leaving.Drain(context.Background(), 2)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 entries drained, got %d, %v", n, err)
+	}
+
+	// The keys are routed to, and stored on, the nodes taking over.
+	for key, drained := range map[string]bool{key1: true, key2: true, key3: false} {
+		owner := leaving.Owner(key)
+		if owner == leaving.Self {
+			t.Fatalf("Expected %s not to be owned by the leaving node", key)
+		}
+		for _, pool := range pools[1:] {
+			if pool.Self != owner {
+				continue
+			}
+			if _, ok := pool.Cache.Peek(key); ok != drained {
+				t.Errorf("Expected %s to be drained to %s %v, got %v", key, owner, drained, ok)
+			}
+			if residency := pool.Cache.Where(key); drained && (key == key1) == residency.ExpiresAt.IsZero() {
+				t.Errorf("Expected %s to keep its TTL, got %+v", key, residency)
+			}
+		}
+	}
+
+	alone := newFleet(t, 1)[0]
+	if _, err := alone.Drain(context.Background(), 10); err == nil {
+		t.Errorf("Expected an error draining a node without peers")
+	}
+}
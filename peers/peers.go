@@ -0,0 +1,419 @@
+// Package peers lets a fleet of nodes share one logical cache, in the way of
+// groupcache: a consistent hash ring assigns every key to an owning node,
+// which stores it in its CacheMachine, and the other nodes reach it over
+// HTTP. Values fetched from other nodes are kept in a small local hot cache
+// for HotCacheTTL, so popular keys do not cost a network round trip on every
+// Get. Hot copies are not invalidated when the owner's value changes, so
+// they can be stale for up to HotCacheTTL.
+//
+// In read-through mode, a node missing a key asks only the RAM and disk of
+// its owner, then reads the S3 tier shared by the fleet and the origin
+// itself, so that a slow or unreachable owner does not fail the read.
+//
+// A node being decommissioned hands its hottest entries over to the nodes
+// taking over its keys with Drain.
+package peers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdemers/cachemachine"
+	"github.com/coocood/freecache"
+)
+
+// BasePath is the path under which Handler serves the keys of a node.
+const BasePath = "/_cachemachine/"
+
+const (
+	// DefaultHotCacheSizeInBytes is the default size of the hot cache.
+	DefaultHotCacheSizeInBytes = 16 * 1024 * 1024
+
+	// DefaultHotCacheTTL is the default time hot copies are kept.
+	DefaultHotCacheTTL = 10 * time.Second
+
+	// DefaultTimeout bounds the requests made to other nodes.
+	DefaultTimeout = 2 * time.Second
+
+	// DefaultReadThroughTimeout is the default value of
+	// Pool.ReadThroughTimeout.
+	DefaultReadThroughTimeout = 250 * time.Millisecond
+)
+
+// Pool routes the operations on every key to the node owning it.
+type Pool struct {
+	// Self is the base URL of this node, as listed in the peers.
+	Self string
+
+	// Cache stores the keys owned by this node.
+	Cache *cachemachine.CacheMachine
+
+	// HotCacheTTL is the time values fetched from other nodes are kept. It
+	// is rounded up to the second, and 0 disables the hot cache.
+	HotCacheTTL time.Duration
+
+	// ReadThrough makes Get ask the owner of a key only for the value held
+	// in its RAM and disk, within ReadThroughTimeout, and fall back on a
+	// miss or a failure to the S3 tier of Cache, which every node must
+	// share, then to Loader. The nodes failing repeatedly are skipped for
+	// a while, see BreakerThreshold.
+	ReadThrough        bool
+	ReadThroughTimeout time.Duration
+
+	// Loader, when set along with ReadThrough, loads the keys found in no
+	// node nor in S3 from the origin. It returns cachemachine.ErrNotFound
+	// for the keys that do not exist. The values loaded are stored on the
+	// owner of the key.
+	Loader func(ctx context.Context, key string) ([]byte, error)
+
+	// BreakerThreshold is the number of consecutive failed reads from a
+	// node after which it is skipped for BreakerCooldown, and then probed
+	// with a single read until it answers again. The circuit breakers are
+	// disabled when it is 0.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	client *http.Client
+	hot    *freecache.Cache
+
+	mu   sync.RWMutex
+	ring *Ring
+
+	breakersMu sync.Mutex
+	breakers   map[string]*breaker
+}
+
+// NewPool returns a pool for the node reachable at self, storing the keys it
+// owns in cache. Peers must be set with SetPeers.
+func NewPool(self string, cache *cachemachine.CacheMachine) *Pool {
+	return &Pool{
+		Self:        strings.TrimRight(self, "/"),
+		Cache:       cache,
+		HotCacheTTL: DefaultHotCacheTTL,
+
+		ReadThroughTimeout: DefaultReadThroughTimeout,
+		BreakerThreshold:   DefaultBreakerThreshold,
+		BreakerCooldown:    DefaultBreakerCooldown,
+
+		client:   &http.Client{Timeout: DefaultTimeout},
+		hot:      freecache.NewCache(DefaultHotCacheSizeInBytes),
+		ring:     NewRing(DefaultReplicas),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// SetPeers sets the base URLs of every node of the fleet, including this
+// one. Every node must be given the same list.
+func (p *Pool) SetPeers(peers ...string) {
+	for i := range peers {
+		peers[i] = strings.TrimRight(peers[i], "/")
+	}
+	ring := NewRing(DefaultReplicas, peers...)
+
+	p.mu.Lock()
+	p.ring = ring
+	p.mu.Unlock()
+	p.hot.Clear()
+}
+
+// Owner returns the base URL of the node owning key.
+func (p *Pool) Owner(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	owner := p.ring.Owner(key)
+	if owner == "" {
+		return p.Self
+	}
+	return owner
+}
+
+// Get returns the value for the given key from the node owning it.
+func (p *Pool) Get(key string) ([]byte, bool, error) {
+	return p.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get, ctx bounding the reads from other nodes, S3 and
+// Loader. A key cached as missing on its owner, see
+// cachemachine.SetNegative, is reported as a miss, whichever node owns it.
+func (p *Pool) GetCtx(ctx context.Context, key string) ([]byte, bool, error) {
+	owner := p.Owner(key)
+	if owner == p.Self {
+		value, ok, err := p.Cache.GetCtx(ctx, key)
+		if err == cachemachine.ErrNegativeHit {
+			return nil, false, nil
+		}
+		if ok || err != nil || !p.ReadThrough || p.Loader == nil {
+			return value, ok, err
+		}
+		return p.load(ctx, owner, key, nil)
+	}
+
+	if value, err := p.hot.Get([]byte(key)); err == nil {
+		return value, true, nil
+	}
+	if p.ReadThrough {
+		return p.readThrough(ctx, owner, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL(owner, key), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, resp.Status)
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, err)
+	}
+	p.keepHot(key, value)
+	return value, true, nil
+}
+
+func (p *Pool) keepHot(key string, value []byte) {
+	if p.HotCacheTTL > 0 {
+		p.hot.Set([]byte(key), value, int((p.HotCacheTTL+time.Second-1)/time.Second))
+	}
+}
+
+// readThrough reads key from the RAM and disk of its owner, then from the
+// S3 tier of Cache, then from Loader. It only returns an error when every
+// source failed or was missing, and one of them failed.
+func (p *Pool) readThrough(ctx context.Context, owner, key string) ([]byte, bool, error) {
+	var failure error
+	if b := p.breaker(owner); b.allow(p.BreakerThreshold, time.Now()) {
+		value, ok, err := p.getLocal(ctx, owner, key)
+		if err != nil && ctx.Err() == nil {
+			b.failed(p.BreakerThreshold, p.BreakerCooldown, time.Now())
+		} else if err == nil {
+			b.succeeded()
+		}
+		if ok {
+			p.keepHot(key, value)
+			return value, true, nil
+		}
+		failure = err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if remote := p.Cache.RemoteTier(); remote != nil {
+		value, err := remote.Get(ctx, key)
+		if err == nil {
+			p.keepHot(key, value)
+			return value, true, nil
+		}
+		if err != cachemachine.ErrNotFound {
+			failure = fmt.Errorf("error getting key %s from S3: %s", key, err)
+		}
+	}
+	if p.Loader != nil {
+		return p.load(ctx, owner, key, failure)
+	}
+	return nil, false, failure
+}
+
+// getLocal reads key from the RAM and disk of owner.
+func (p *Pool) getLocal(ctx context.Context, owner, key string) ([]byte, bool, error) {
+	timeout := p.ReadThroughTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadThroughTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL(owner, key)+"?local=1", nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, resp.Status)
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting key %s from %s: %s", key, owner, err)
+	}
+	return value, true, nil
+}
+
+// load loads key from Loader, and stores it on its owner. failure is the
+// error of the sources read before, returned if the key does not exist.
+func (p *Pool) load(ctx context.Context, owner, key string, failure error) ([]byte, bool, error) {
+	value, err := p.Loader(ctx, key)
+	if err == cachemachine.ErrNotFound {
+		return nil, false, failure
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error loading key %s: %s", key, err)
+	}
+	if owner == p.Self {
+		p.Cache.Set(key, value)
+		return value, true, nil
+	}
+	p.keepHot(key, value)
+	if b := p.breaker(owner); b.allow(p.BreakerThreshold, time.Now()) {
+		if err := p.do(http.MethodPut, owner, key, value); err != nil {
+			b.failed(p.BreakerThreshold, p.BreakerCooldown, time.Now())
+		} else {
+			b.succeeded()
+		}
+	}
+	return value, true, nil
+}
+
+func (p *Pool) breaker(node string) *breaker {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+	b, ok := p.breakers[node]
+	if !ok {
+		b = &breaker{}
+		p.breakers[node] = b
+	}
+	return b
+}
+
+// OpenBreakers returns the sorted base URLs of the nodes currently skipped
+// by the read-through, their circuit breaker being open.
+func (p *Pool) OpenBreakers() []string {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+	var open []string
+	now := time.Now()
+	for node, b := range p.breakers {
+		if b.open(p.BreakerThreshold, now) {
+			open = append(open, node)
+		}
+	}
+	sort.Strings(open)
+	return open
+}
+
+// Set sets the value for the given key on the node owning it.
+func (p *Pool) Set(key string, val []byte) error {
+	owner := p.Owner(key)
+	if owner == p.Self {
+		return p.Cache.Set(key, val)
+	}
+
+	p.hot.Del([]byte(key))
+	return p.do(http.MethodPut, owner, key, val)
+}
+
+// Delete deletes the value for the given key from the node owning it.
+func (p *Pool) Delete(key string) error {
+	owner := p.Owner(key)
+	if owner == p.Self {
+		p.Cache.Delete(key)
+		return nil
+	}
+
+	p.hot.Del([]byte(key))
+	return p.do(http.MethodDelete, owner, key, nil)
+}
+
+func (p *Pool) do(method, owner, key string, body []byte) error {
+	req, err := http.NewRequest(method, keyURL(owner, key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return p.send(req, owner, key)
+}
+
+func (p *Pool) send(req *http.Request, owner, key string) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending %s of key %s to %s: %s", req.Method, key, owner, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("error sending %s of key %s to %s: %s", req.Method, key, owner, resp.Status)
+	}
+	return nil
+}
+
+// Handler serves the keys owned by this node to the other nodes, under
+// BasePath. It only reads and writes the local cache, and never forwards
+// requests, so a disagreement on the peers list cannot create loops. The
+// reads of the read-through only consult the RAM and disk tiers, and the
+// writes of Drain keep the remaining TTL of the entries.
+func (p *Pool) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), BasePath))
+		if err != nil || !strings.HasPrefix(r.URL.Path, BasePath) {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			var value []byte
+			var ok bool
+			if r.URL.Query().Get("local") == "1" {
+				value, ok, _ = p.Cache.GetLocalCtx(r.Context(), key)
+			} else {
+				value, ok = p.Cache.Get(key)
+			}
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(value)
+		case http.MethodPut:
+			value, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if ttl, err := strconv.Atoi(r.URL.Query().Get("ttl")); err == nil && ttl > 0 {
+				err = p.Cache.SetWithTTL(key, value, time.Duration(ttl)*time.Second)
+			} else {
+				err = p.Cache.Set(key, value)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			p.Cache.Delete(key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func keyURL(node, key string) string {
+	return node + BasePath + url.PathEscape(key)
+}
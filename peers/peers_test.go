@@ -0,0 +1,211 @@
+package peers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdemers/cachemachine"
+)
+
+func newFleet(t *testing.T, size int) []*Pool {
+	var pools []*Pool
+	var urls []string
+	for i := 0; i < size; i++ {
+		cacheMachine, err := cachemachine.NewCacheMachine(1024*1024, 1024)
+		if err != nil {
+			t.Fatalf("Error creating cache machine: %s", err)
+		}
+		var pool *Pool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pool.Handler().ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		pool = NewPool(server.URL, cacheMachine)
+		pools = append(pools, pool)
+		urls = append(urls, server.URL)
+	}
+	for _, pool := range pools {
+		pool.SetPeers(append([]string{}, urls...)...)
+	}
+	return pools
+}
+
+func TestPool(t *testing.T) {
+	pools := newFleet(t, 3)
+
+	// Find a key owned by the second node.
+	key := "key"
+	for pools[0].Owner(key) != pools[1].Self {
+		key += "x"
+	}
+
+	err := pools[0].Set(key, []byte("12345"))
+	if err != nil {
+		t.Errorf("Expected no error setting %s, got %s", key, err)
+	}
+	if _, ok := pools[1].Cache.Get(key); !ok {
+		t.Errorf("Expected %s to be stored on its owner", key)
+	}
+	if _, ok := pools[0].Cache.Get(key); ok {
+		t.Errorf("Expected %s not to be stored on the node that set it", key)
+	}
+
+	value, ok, err := pools[2].Get(key)
+	if err != nil || !ok || string(value) != "12345" {
+		t.Errorf("Expected value to be 12345, got %s, %v, %v", value, ok, err)
+	}
+	if _, err := pools[2].hot.Get([]byte(key)); err != nil {
+		t.Errorf("Expected %s to be kept in the hot cache", key)
+	}
+
+	err = pools[2].Delete(key)
+	if err != nil {
+		t.Errorf("Expected no error deleting %s, got %s", key, err)
+	}
+	_, ok, err = pools[0].Get(key)
+	if err != nil || ok {
+		t.Errorf("Expected %s to be deleted, got %v, %v", key, ok, err)
+	}
+}
+
+func TestPool_NegativeHit(t *testing.T) {
+	pools := newFleet(t, 2)
+	key := ownedKey(pools[0], pools[0].Self, "key")
+
+	if err := pools[0].Cache.SetNegative(key, time.Hour); err != nil {
+		t.Fatalf("Expected no error caching %s as missing, got %s", key, err)
+	}
+	for i, pool := range pools {
+		value, ok, err := pool.Get(key)
+		if err != nil || ok || value != nil {
+			t.Errorf("Expected a miss for %s from node %d, got %q, %v, %v", key, i, value, ok, err)
+		}
+	}
+}
+
+func TestPool_GetCtx(t *testing.T) {
+	pools := newFleet(t, 2)
+	key := ownedKey(pools[0], pools[1].Self, "key")
+	pools[1].Cache.Set(key, []byte("12345"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := pools[0].GetCtx(ctx, key); err == nil {
+		t.Errorf("Expected an error getting %s with a canceled context", key)
+	}
+	if value, ok, err := pools[0].GetCtx(context.Background(), key); err != nil || !ok || string(value) != "12345" {
+		t.Errorf("Expected value to be 12345, got %s, %v, %v", value, ok, err)
+	}
+}
+
+// memoryStore is an in-memory ObjectStore, shared by the nodes of a fleet.
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.objects[key]
+	if !ok {
+		return nil, cachemachine.ErrObjectNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), val...)
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// ownedKey returns a key owned by owner.
+func ownedKey(pool *Pool, owner, prefix string) string {
+	key := prefix
+	for pool.Owner(key) != owner {
+		key += "x"
+	}
+	return key
+}
+
+func TestPool_ReadThrough(t *testing.T) {
+	pools := newFleet(t, 2)
+	store := &memoryStore{objects: make(map[string][]byte)}
+	for _, pool := range pools {
+		if err := pool.Cache.EnableDiskCache(1024*1024, t.TempDir()); err != nil {
+			t.Fatalf("Error enabling disk cache: %s", err)
+		}
+		defer pool.Cache.DisableDiskCache()
+		pool.Cache.EnableS3Cache(store)
+	}
+	pool := pools[0]
+	pool.ReadThrough = true
+	pool.HotCacheTTL = 0
+	pool.BreakerThreshold = 2
+	pool.Loader = func(ctx context.Context, key string) ([]byte, error) {
+		if strings.HasPrefix(key, "load") {
+			return []byte("loaded"), nil
+		}
+		return nil, cachemachine.ErrNotFound
+	}
+	ctx := context.Background()
+
+	// Values held by the owner are read from it.
+	ram := ownedKey(pool, pools[1].Self, "ram")
+	pools[1].Cache.Set(ram, []byte("12345"))
+	if value, ok, err := pool.Get(ram); err != nil || !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 from the owner, got %q, %v, %v", value, ok, err)
+	}
+
+	// Values the owner only has in S3 are read from S3 directly.
+	remote := ownedKey(pool, pools[1].Self, "s3")
+	pools[1].Cache.RemoteTier().Put(ctx, remote, []byte("67890"))
+	s3Hits := pools[1].Cache.Stats().S3Hits
+	if value, ok, err := pool.Get(remote); err != nil || !ok || string(value) != "67890" {
+		t.Errorf("Expected 67890 from S3, got %q, %v, %v", value, ok, err)
+	}
+	if hits := pools[1].Cache.Stats().S3Hits; hits != s3Hits {
+		t.Errorf("Expected the owner not to read S3, got %d S3 hits", hits)
+	}
+
+	// Values found nowhere are loaded, and stored on their owner.
+	load := ownedKey(pool, pools[1].Self, "load")
+	if value, ok, err := pool.Get(load); err != nil || !ok || string(value) != "loaded" {
+		t.Errorf("Expected the value to be loaded, got %q, %v, %v", value, ok, err)
+	}
+	if value, ok := pools[1].Cache.Get(load); !ok || string(value) != "loaded" {
+		t.Errorf("Expected the loaded value to be stored on the owner, got %q, %v", value, ok)
+	}
+	if _, ok, err := pool.Get(ownedKey(pool, pools[1].Self, "missing")); ok || err != nil {
+		t.Errorf("Expected a miss, got %v, %v", ok, err)
+	}
+
+	// An unreachable owner is skipped once its breaker opens, S3 still
+	// answering.
+	down := "http://127.0.0.1:1"
+	pool.SetPeers(pool.Self, down)
+	key := ownedKey(pool, down, "s3")
+	pool.Cache.RemoteTier().Put(ctx, key, []byte("abcde"))
+	for i := 0; i < 3; i++ {
+		if value, ok, err := pool.Get(key); err != nil || !ok || string(value) != "abcde" {
+			t.Errorf("Expected abcde from S3, got %q, %v, %v", value, ok, err)
+		}
+	}
+	if open := pool.OpenBreakers(); len(open) != 1 || open[0] != down {
+		t.Errorf("Expected the breaker of %s to be open, got %v", down, open)
+	}
+}
@@ -0,0 +1,66 @@
+package peers
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the default number of points each node gets on a Ring.
+const DefaultReplicas = 50
+
+// Ring is a consistent hash ring mapping keys to nodes. Adding or removing a
+// node only moves the keys owned by that node.
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	nodes    map[uint32]string
+}
+
+// NewRing returns a ring of nodes, each placed replicas times on the ring to
+// spread the keys evenly.
+func NewRing(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	ring := &Ring{
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+	}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			ring.hashes = append(ring.hashes, hash)
+			ring.nodes[hash] = node
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// Owner returns the node owning key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+// Nodes returns the nodes of the ring, sorted.
+func (r *Ring) Nodes() []string {
+	seen := make(map[string]bool)
+	nodes := make([]string, 0)
+	for _, node := range r.nodes {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,32 @@
+package peers
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing_Owner(t *testing.T) {
+	if owner := NewRing(10).Owner("key1"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %s", owner)
+	}
+
+	ring := NewRing(50, "node1", "node2", "node3")
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[ring.Owner("key"+strconv.Itoa(i))]++
+	}
+	for _, node := range []string{"node1", "node2", "node3"} {
+		if counts[node] < 500 {
+			t.Errorf("Expected keys to be spread evenly, got %v", counts)
+		}
+	}
+
+	// Removing a node only moves the keys it owned.
+	smaller := NewRing(50, "node1", "node2")
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if owner := ring.Owner(key); owner != "node3" && smaller.Owner(key) != owner {
+			t.Errorf("Expected %s to stay on %s, got %s", key, owner, smaller.Owner(key))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// StatsFileName is the name of the file, in the disk cache directory, where
+// the cumulative stats are persisted when persistent stats are enabled.
+const StatsFileName = "cachemachine-stats.json"
+
+// persistedStats holds the cumulative counters that survive restarts.
+// Gauges, such as sync durations, only describe the current process and are
+// not persisted.
+type persistedStats struct {
+	RamHits             int64 `json:"ram_hits"`
+	DiskHits            int64 `json:"disk_hits"`
+	S3Hits              int64 `json:"s3_hits"`
+	Misses              int64 `json:"misses"`
+	NegativeHits        int64 `json:"negative_hits"`
+	BytesServed         int64 `json:"bytes_served"`
+	SetCount            int64 `json:"set_count"`
+	SetBytes            int64 `json:"set_bytes"`
+	AdmissionRejections int64 `json:"admission_rejections"`
+	SyncCoalesced       int64 `json:"sync_coalesced"`
+	ThrashDiversions    int64 `json:"thrash_diversions"`
+	UnsyncedEvictions   int64 `json:"unsynced_evictions"`
+	DiskWriteCount      int64 `json:"disk_write_count"`
+	DiskWriteBytes      int64 `json:"disk_write_bytes"`
+	S3WriteCount        int64 `json:"s3_write_count"`
+	S3WriteBytes        int64 `json:"s3_write_bytes"`
+}
+
+func (p *persistedStats) counters(s *statsCounters) []struct {
+	persisted *int64
+	counter   *int64
+} {
+	return []struct {
+		persisted *int64
+		counter   *int64
+	}{
+		{&p.RamHits, &s.ramHits},
+		{&p.DiskHits, &s.diskHits},
+		{&p.S3Hits, &s.s3Hits},
+		{&p.Misses, &s.misses},
+		{&p.NegativeHits, &s.negativeHits},
+		{&p.BytesServed, &s.bytesServed},
+		{&p.SetCount, &s.setCount},
+		{&p.SetBytes, &s.setBytes},
+		{&p.AdmissionRejections, &s.admissionRejections},
+		{&p.SyncCoalesced, &s.syncCoalesced},
+		{&p.ThrashDiversions, &s.thrashDiversions},
+		{&p.UnsyncedEvictions, &s.unsyncedEvictions},
+		{&p.DiskWriteCount, &s.diskWriteCount},
+		{&p.DiskWriteBytes, &s.diskWriteBytes},
+		{&p.S3WriteCount, &s.s3WriteCount},
+		{&p.S3WriteBytes, &s.s3WriteBytes},
+	}
+}
+
+// EnablePersistentStats restores the cumulative stats saved in the disk cache
+// directory by a previous process, and saves them there after every sync and
+// when the disk cache is disabled, so that long term effectiveness graphs
+// survive restarts. The disk cache must be enabled first.
+func (c *CacheMachine) EnablePersistentStats() error {
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+
+	if atomic.CompareAndSwapInt32(&c.statsRestored, 0, 1) {
+		data, err := ioutil.ReadFile(c.statsFilePath())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading persisted stats: %s", err)
+		}
+		if err == nil {
+			var persisted persistedStats
+			if err := json.Unmarshal(data, &persisted); err != nil {
+				return fmt.Errorf("error decoding persisted stats: %s", err)
+			}
+			for _, counter := range persisted.counters(&c.stats) {
+				atomic.AddInt64(counter.counter, *counter.persisted)
+			}
+		}
+	}
+
+	atomic.StoreInt32(&c.persistStats, 1)
+	return nil
+}
+
+// saveStats writes the cumulative stats to the disk cache directory if
+// persistent stats are enabled. The file is replaced atomically so a crash
+// never leaves truncated stats behind. Saves are serialized, so that
+// concurrent ones neither tear the temporary file nor replace newer stats
+// with older ones.
+func (c *CacheMachine) saveStats() error {
+	if atomic.LoadInt32(&c.persistStats) == 0 {
+		return nil
+	}
+
+	c.saveStatsMu.Lock()
+	defer c.saveStatsMu.Unlock()
+
+	var persisted persistedStats
+	for _, counter := range persisted.counters(&c.stats) {
+		*counter.persisted = atomic.LoadInt64(counter.counter)
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+
+	path := c.statsFilePath()
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing persisted stats: %s", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error writing persisted stats: %s", err)
+	}
+	return nil
+}
+
+func (c *CacheMachine) statsFilePath() string {
+	return filepath.Join(c.DiskCachePath, StatsFileName)
+}
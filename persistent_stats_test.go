@@ -0,0 +1,109 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestCacheMachine_EnablePersistentStats(t *testing.T) {
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	err = CacheMachine.EnablePersistentStats()
+	if err == nil {
+		t.Errorf("Expected error enabling persistent stats without a disk cache")
+	}
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	err = CacheMachine.EnablePersistentStats()
+	if err != nil {
+		t.Errorf("Expected no error enabling persistent stats, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Get("key1")
+	CacheMachine.Get("key2")
+	CacheMachine.DisableDiskCache()
+
+	// Simulate a restart with a new cache machine on the same directory.
+	CacheMachine, err = NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	err = CacheMachine.EnablePersistentStats()
+	if err != nil {
+		t.Errorf("Expected no error enabling persistent stats, got %s", err)
+	}
+
+	CacheMachine.Get("key3")
+
+	stats := CacheMachine.Stats()
+	if stats.SetCount != 1 || stats.RamHits != 1 || stats.Misses != 2 || stats.BytesServed != 5 {
+		t.Errorf("Expected stats to be restored, got %+v", stats)
+	}
+}
+
+func TestCacheMachine_SaveStatsConcurrently(t *testing.T) {
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	err = CacheMachine.EnablePersistentStats()
+	if err != nil {
+		t.Errorf("Expected no error enabling persistent stats, got %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			CacheMachine.Set("key1", []byte("12345"))
+			if err := CacheMachine.saveStats(); err != nil {
+				t.Errorf("Expected no error saving stats, got %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := ioutil.ReadFile(CacheMachine.statsFilePath())
+	if err != nil {
+		t.Fatalf("Error reading persisted stats: %s", err)
+	}
+	var persisted persistedStats
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		t.Errorf("Expected the persisted stats to be valid, got %s: %s", err, data)
+	}
+	if persisted.SetCount != 20 {
+		t.Errorf("Expected the last save to hold every set, got %d", persisted.SetCount)
+	}
+}
@@ -0,0 +1,235 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetOption configures a value set with SetWithOptions.
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	ttl      time.Duration
+	subjects []string
+}
+
+// WithTTL makes the entry expire after ttl, as with SetWithTTL.
+func WithTTL(ttl time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithSubject relates the entry to the given data subjects, such as the IDs
+// of the users whose personal data it holds, so that PurgeBySubject deletes
+// it. A key stays related to its subjects until it is deleted, even when it
+// is set again without them.
+func WithSubject(subjectIDs ...string) SetOption {
+	return func(o *setOptions) {
+		o.subjects = append(o.subjects, subjectIDs...)
+	}
+}
+
+// SetWithOptions is like Set, configured by opts.
+func (c *CacheMachine) SetWithOptions(key string, val []byte, opts ...SetOption) error {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl < 0 {
+		o.ttl = 0
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	// The key is indexed first, so that a value is never stored without
+	// being reachable by PurgeBySubject. It is forgotten if no value ends
+	// up stored.
+	c.subjects.add(key, o.subjects)
+	err := c.set(shard, key, val, o.ttl)
+	if _, ok := shard.entries[key]; err != nil && !ok {
+		c.subjects.forget(key)
+	}
+	return err
+}
+
+// subjectIndex maps data subjects to the keys related to them, and back.
+type subjectIndex struct {
+	mu          sync.Mutex
+	keys        map[string]map[string]struct{}
+	keySubjects map[string][]string
+
+	// indexed is the number of keys in keySubjects, read without the lock
+	// to spare deletions the lock while no key is indexed.
+	indexed int64
+}
+
+func (s *subjectIndex) add(key string, subjects []string) {
+	if len(subjects) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]map[string]struct{})
+		s.keySubjects = make(map[string][]string)
+	}
+	for _, subject := range subjects {
+		keys, ok := s.keys[subject]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.keys[subject] = keys
+		}
+		if _, ok := keys[key]; !ok {
+			keys[key] = struct{}{}
+			if _, ok := s.keySubjects[key]; !ok {
+				atomic.AddInt64(&s.indexed, 1)
+			}
+			s.keySubjects[key] = append(s.keySubjects[key], subject)
+		}
+	}
+}
+
+// copy relates dst to the subjects of src.
+func (s *subjectIndex) copy(src, dst string) {
+	if atomic.LoadInt64(&s.indexed) == 0 {
+		return
+	}
+	s.mu.Lock()
+	subjects := append([]string(nil), s.keySubjects[src]...)
+	s.mu.Unlock()
+	s.add(dst, subjects)
+}
+
+// forget removes key from the index, once it is deleted from every tier.
+func (s *subjectIndex) forget(key string) {
+	if atomic.LoadInt64(&s.indexed) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subjects, ok := s.keySubjects[key]
+	if !ok {
+		return
+	}
+	for _, subject := range subjects {
+		delete(s.keys[subject], key)
+		if len(s.keys[subject]) == 0 {
+			delete(s.keys, subject)
+		}
+	}
+	delete(s.keySubjects, key)
+	atomic.AddInt64(&s.indexed, -1)
+}
+
+// subjectKeys returns the keys related to subject, sorted.
+func (s *subjectIndex) subjectKeys(subject string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.keys[subject]))
+	for key := range s.keys[subject] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PurgeReport is the outcome of a PurgeBySubject, meant to be kept as
+// evidence of the erasure of the data of a subject.
+type PurgeReport struct {
+	SubjectID   string    `json:"subject_id"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+
+	// Keys are the keys related to the subject that were deleted from every
+	// tier, or that were already gone.
+	Keys []string `json:"keys"`
+
+	// Failures are the deletions that failed. Their keys stay related to the
+	// subject, so that the purge can be retried.
+	Failures []PurgeFailure `json:"failures,omitempty"`
+}
+
+// PurgeFailure is a key that could not be deleted from a tier, or from any
+// tier when it is under legal hold.
+type PurgeFailure struct {
+	Key   string `json:"key"`
+	Tier  string `json:"tier,omitempty"`
+	Error string `json:"error"`
+}
+
+// PurgeBySubject hard deletes every entry related to subjectID with
+// WithSubject from RAM, disk and S3, whether or not the cache machine
+// believes a tier holds a copy, and returns a report of the deletions. It
+// returns an error along with the report when any deletion failed. Entries
+// set for the subject while the purge runs may survive it.
+func (c *CacheMachine) PurgeBySubject(ctx context.Context, subjectID string) (*PurgeReport, error) {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Purge)
+	defer cancel()
+	report := &PurgeReport{
+		SubjectID: subjectID,
+		StartedAt: time.Now(),
+		Keys:      []string{},
+	}
+	for _, key := range c.subjects.subjectKeys(subjectID) {
+		if err := ctx.Err(); err != nil {
+			report.CompletedAt = time.Now()
+			return report, err
+		}
+		failures := c.purge(ctx, key)
+		if len(failures) > 0 {
+			report.Failures = append(report.Failures, failures...)
+			continue
+		}
+		report.Keys = append(report.Keys, key)
+	}
+	report.CompletedAt = time.Now()
+
+	c.emitEvent(EventPurge, "entries of a subject purged", map[string]interface{}{
+		"subject":  subjectID,
+		"keys":     len(report.Keys),
+		"failures": len(report.Failures),
+	})
+	if len(report.Failures) > 0 {
+		return report, fmt.Errorf("error purging %d entries of subject %s", len(report.Failures), subjectID)
+	}
+	return report, nil
+}
+
+// purge deletes key from every tier, and returns the deletions that failed.
+func (c *CacheMachine) purge(ctx context.Context, key string) []PurgeFailure {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if c.legalHolds.held(key) {
+		return []PurgeFailure{{Key: key, Error: ErrLegalHold.Error()}}
+	}
+	var failures []PurgeFailure
+	c.RamCache.Del([]byte(key))
+	if c.DiskCache != nil {
+		if _, err := c.DiskCache.Delete(key); err != nil {
+			failures = append(failures, PurgeFailure{Key: key, Tier: string(tierDisk), Error: err.Error()})
+		}
+	}
+	if c.S3Cache != nil {
+		err := c.S3Cache.Delete(ctx, key)
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			failures = append(failures, PurgeFailure{Key: key, Tier: string(tierS3), Error: err.Error()})
+		}
+	}
+	delete(shard.entries, key)
+	c.forgetInSnapshots(key)
+	c.invalidate(key)
+	if len(failures) == 0 {
+		c.subjects.forget(key)
+	}
+	return failures
+}
@@ -0,0 +1,132 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_PurgeBySubject(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	err = CacheMachine.EnableS3Cache(store)
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.SetWithOptions("profile:1", []byte("alice"), WithSubject("user1"))
+	CacheMachine.SetWithOptions("orders:1", []byte("books"), WithSubject("user1"), WithTTL(time.Hour))
+	CacheMachine.SetWithOptions("shared", []byte("both"), WithSubject("user1", "user2"))
+	CacheMachine.SetWithOptions("profile:2", []byte("bob"), WithSubject("user2"))
+	CacheMachine.SetWithOptions("gone", []byte("gone"), WithSubject("user1"))
+	CacheMachine.Flush()
+	CacheMachine.Delete("gone")
+
+	report, err := CacheMachine.PurgeBySubject(context.Background(), "user1")
+	if err != nil {
+		t.Errorf("Expected no error purging user1, got %s", err)
+	}
+	expected := []string{"orders:1", "profile:1", "shared"}
+	if len(report.Keys) != len(expected) {
+		t.Fatalf("Expected %v to be purged, got %v", expected, report.Keys)
+	}
+	for i, key := range expected {
+		if report.Keys[i] != key {
+			t.Errorf("Expected %v to be purged, got %v", expected, report.Keys)
+		}
+	}
+	if report.SubjectID != "user1" || report.CompletedAt.Before(report.StartedAt) {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	for _, key := range expected {
+		if CacheMachine.Has(key) {
+			t.Errorf("Expected %s to be purged", key)
+		}
+		if _, ok := CacheMachine.DiskCache.EntrySize(key); ok {
+			t.Errorf("Expected %s to be purged from disk", key)
+		}
+		if _, err := store.Get(context.Background(), key); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected %s to be purged from S3, got %v", key, err)
+		}
+	}
+	if value, ok := CacheMachine.Get("profile:2"); !ok || string(value) != "bob" {
+		t.Errorf("Expected profile:2 to survive the purge, got %q, %v", value, ok)
+	}
+
+	report, err = CacheMachine.PurgeBySubject(context.Background(), "user2")
+	if err != nil {
+		t.Errorf("Expected no error purging user2, got %s", err)
+	}
+	if len(report.Keys) != 1 || report.Keys[0] != "profile:2" {
+		t.Errorf("Expected profile:2 to be purged, got %v", report.Keys)
+	}
+
+	report, err = CacheMachine.PurgeBySubject(context.Background(), "user1")
+	if err != nil || len(report.Keys) != 0 {
+		t.Errorf("Expected nothing left to purge, got %v, %v", report.Keys, err)
+	}
+}
+
+type failingDeleteStore struct {
+	*memoryStore
+}
+
+func (s failingDeleteStore) Delete(ctx context.Context, key string) error {
+	return errors.New("S3 is down")
+}
+
+func TestCacheMachine_PurgeBySubjectFailure(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := failingDeleteStore{newMemoryStore()}
+	CacheMachine.EnableS3Cache(store)
+	CacheMachine.SetWithOptions("profile:1", []byte("alice"), WithSubject("user1"))
+	CacheMachine.Flush()
+
+	report, err := CacheMachine.PurgeBySubject(context.Background(), "user1")
+	if err == nil {
+		t.Errorf("Expected an error purging user1")
+	}
+	if len(report.Keys) != 0 || len(report.Failures) != 1 || report.Failures[0].Tier != "s3" {
+		t.Errorf("Expected an S3 failure, got %+v", report)
+	}
+
+	CacheMachine.S3Cache = store.memoryStore
+	report, err = CacheMachine.PurgeBySubject(context.Background(), "user1")
+	if err != nil || len(report.Keys) != 1 {
+		t.Errorf("Expected the purge to be retried, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,90 @@
+package cachemachine
+
+import (
+	cryptorand "crypto/rand"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// seededRandom is the source of every random choice of a cache machine
+// once SetRandomSeed was called.
+type seededRandom struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// SetRandomSeed makes every random choice of the cache machine derive from
+// seed, so that the integration tests of the programs using it are
+// reproducible: the keys sampled by VerifyConsistency, the entries swept by
+// Set, and the instance id of EnableInvalidation, which must then be given
+// a different seed on every instance sharing a bus. The choices still
+// depend on the timing of the calls made concurrently.
+//
+// It is meant for tests: with a seed, Set sorts the keys of the stripe it
+// sweeps, which costs more the more entries the cache holds.
+func (c *CacheMachine) SetRandomSeed(seed int64) {
+	c.random.Store(&seededRandom{rand: rand.New(rand.NewSource(seed))})
+}
+
+func (c *CacheMachine) seededRandom() *seededRandom {
+	random, _ := c.random.Load().(*seededRandom)
+	return random
+}
+
+// randomFloat64 returns a random number in [0, 1).
+func (c *CacheMachine) randomFloat64() float64 {
+	random := c.seededRandom()
+	if random == nil {
+		return rand.Float64()
+	}
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	return random.rand.Float64()
+}
+
+// randomIntn returns a random number in [0, n).
+func (c *CacheMachine) randomIntn(n int) int {
+	random := c.seededRandom()
+	if random == nil {
+		return rand.Intn(n)
+	}
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	return random.rand.Intn(n)
+}
+
+// randomRead fills p with random bytes, cryptographically secure unless
+// a seed was set.
+func (c *CacheMachine) randomRead(p []byte) error {
+	random := c.seededRandom()
+	if random == nil {
+		_, err := cryptorand.Read(p)
+		return err
+	}
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	_, err := random.rand.Read(p)
+	return err
+}
+
+// sweepSeeded sweeps a few entries of a stripe, chosen with the seed set
+// by SetRandomSeed rather than by the order of the map. It must be called
+// with the stripe locked.
+func (c *CacheMachine) sweepSeeded(shard *syncTableShard, now time.Time) {
+	keys := make([]string, 0, len(shard.entries))
+	for key := range shard.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for n := 0; n < sweepEntriesPerSet && len(keys) > 0; n++ {
+		i := c.randomIntn(len(keys))
+		key := keys[i]
+		keys[i] = keys[len(keys)-1]
+		keys = keys[:len(keys)-1]
+		if cacheSync, ok := shard.entries[key]; ok {
+			c.sweepEntry(shard, key, cacheSync, now)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SetRandomSeed(t *testing.T) {
+	// run returns the keys sampled by VerifyConsistency and the keys left
+	// after a seeded sweep of a stripe holding expired entries.
+	run := func(seed int64) ([]string, []string) {
+		CacheMachine, err := NewCacheMachine(10, 1024)
+		if err != nil {
+			t.Errorf("Error creating cache machine: %s", err)
+		}
+		CacheMachine.SetRandomSeed(seed)
+
+		var sampled []string
+		for i := 0; i < 100; i++ {
+			if CacheMachine.randomFloat64() < 0.2 {
+				sampled = append(sampled, fmt.Sprintf("key%d", i))
+			}
+		}
+
+		shard := &CacheMachine.syncTable[0]
+		shard.Lock()
+		defer shard.Unlock()
+		for i := 0; i < 10; i++ {
+			shard.entries[fmt.Sprintf("key%d", i)] = CacheSyncTable{ExpiresAt: time.Now().Add(-time.Second)}
+		}
+		CacheMachine.sweepSome(shard)
+		var left []string
+		for key := range shard.entries {
+			left = append(left, key)
+		}
+		sort.Strings(left)
+		return sampled, left
+	}
+
+	sampled1, left1 := run(42)
+	sampled2, left2 := run(42)
+	if !reflect.DeepEqual(sampled1, sampled2) {
+		t.Errorf("Expected the same samples with the same seed, got %v and %v", sampled1, sampled2)
+	}
+	if len(left1) != 10-sweepEntriesPerSet || !reflect.DeepEqual(left1, left2) {
+		t.Errorf("Expected the same entries to be swept with the same seed, got %v and %v", left1, left2)
+	}
+	if sampled3, _ := run(43); reflect.DeepEqual(sampled1, sampled3) {
+		t.Errorf("Expected different samples with different seeds, got %v", sampled3)
+	}
+}
+
+func TestCacheMachine_SetRandomSeed_VerifyConsistency(t *testing.T) {
+	sample := func() int {
+		CacheMachine, err := NewCacheMachine(10, 1024)
+		if err != nil {
+			t.Errorf("Error creating cache machine: %s", err)
+		}
+
+		tmpFolder, err := createTempFolder()
+		if err != nil {
+			t.Errorf("Error creating temp folder: %s", err)
+		}
+		defer removeTempFolder(tmpFolder)
+
+		err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+		if err != nil {
+			t.Errorf("Expected no error enabling disk cache, got %s", err)
+		}
+		defer CacheMachine.DisableDiskCache()
+
+		CacheMachine.SetRandomSeed(7)
+		for i := 0; i < 50; i++ {
+			CacheMachine.Set(fmt.Sprintf("key%d", i), []byte("12345"))
+		}
+		CacheMachine.Flush()
+		report, err := CacheMachine.VerifyConsistency(context.Background(), 0.5)
+		if err != nil {
+			t.Fatalf("Expected no error verifying consistency, got %s", err)
+		}
+		return report.Sampled
+	}
+
+	if n1, n2 := sample(), sample(); n1 != n2 {
+		t.Errorf("Expected the same number of keys sampled with the same seed, got %d and %d", n1, n2)
+	}
+}
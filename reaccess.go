@@ -0,0 +1,383 @@
+package cachemachine
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reaccessSlots is the number of slots of the table of the last reads of
+// the keys, 512KiB in total.
+const reaccessSlots = 1 << 16
+
+// reaccessBuckets is the number of buckets of the histograms of the
+// re-access intervals. Each bucket is a quarter of a power of two wider
+// than the previous one, from 1ms up to about 49 days, so that a quantile
+// is known to within 19%.
+const reaccessBuckets = 128
+
+// reaccessDecaySamples is the number of intervals past which the histogram
+// of a namespace is halved, so that the suggestions follow the recent
+// access patterns.
+const reaccessDecaySamples = 1 << 16
+
+// adaptiveTTLMinSamples is the number of intervals a namespace needs before
+// its suggested TTL is applied by EnableAdaptiveTTL.
+const adaptiveTTLMinSamples = 100
+
+// reaccessTagShift positions in the slots the tag of the key, which sits
+// above the millisecond of its last read.
+const reaccessTagShift = 48
+
+// TTLSuggestion is the TTL suggested for a namespace from the intervals
+// between the reads of its keys, see EnableTTLSuggestions.
+type TTLSuggestion struct {
+	// TTL is the configured quantile of the re-access intervals.
+	TTL time.Duration
+
+	// P50, P95 and P99 are the median, the 95th and the 99th percentiles
+	// of the re-access intervals.
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+
+	// Samples is the number of intervals the suggestion is based on. The
+	// older intervals are halved away as new ones come in.
+	Samples int64
+}
+
+// reaccessTracker records the intervals between the reads of the keys. The
+// keys are hashed to slots holding a tag of the key and the millisecond of
+// its last read, counted from start, which the readers swap without a lock.
+// A read finding the slot holding another key records no interval.
+type reaccessTracker struct {
+	quantile      float64
+	start         time.Time
+	slots         []uint64
+	maxNamespaces int64
+
+	mu         sync.Mutex
+	namespaces sync.Map
+	count      int64
+}
+
+type reaccessHistogram struct {
+	buckets [reaccessBuckets]int64
+	total   int64
+}
+
+// EnableTTLSuggestions makes the cache machine track, per namespace, see
+// KeyNamespace, the intervals between the reads of a key, the first read of
+// a key being timed from when it was set, and suggest as the TTL of the
+// namespace the given quantile of them, such as 0.95. A TTL covering most
+// re-access intervals keeps the entries about as long as they are useful.
+// Misses count as reads, so that the intervals cut short by the current
+// TTLs are seen.
+//
+// Up to MaxMetricsNamespaces namespaces are tracked. The suggestions are
+// read with SuggestedTTL and SuggestedTTLs, and applied by
+// EnableAdaptiveTTL. Enabling the suggestions again starts them over.
+func (c *CacheMachine) EnableTTLSuggestions(quantile float64) error {
+	if quantile <= 0 || quantile > 1 {
+		return fmt.Errorf("quantile must be greater than 0 and at most 1")
+	}
+	maxNamespaces := c.MaxMetricsNamespaces
+	if maxNamespaces <= 0 {
+		maxNamespaces = DefaultMaxMetricsNamespaces
+	}
+	c.reaccess.Store(&reaccessTracker{
+		quantile:      quantile,
+		start:         time.Now(),
+		slots:         make([]uint64, reaccessSlots),
+		maxNamespaces: int64(maxNamespaces),
+	})
+	return nil
+}
+
+// DisableTTLSuggestions stops tracking the re-access intervals, which also
+// stops the adaptive TTLs.
+func (c *CacheMachine) DisableTTLSuggestions() {
+	c.reaccess.Store((*reaccessTracker)(nil))
+}
+
+func (c *CacheMachine) reaccessTracker() *reaccessTracker {
+	tracker, _ := c.reaccess.Load().(*reaccessTracker)
+	return tracker
+}
+
+// SuggestedTTL returns the TTL suggested for namespace. It returns false if
+// the suggestions are not enabled or if no interval was recorded for
+// namespace.
+func (c *CacheMachine) SuggestedTTL(namespace string) (TTLSuggestion, bool) {
+	tracker := c.reaccessTracker()
+	if tracker == nil {
+		return TTLSuggestion{}, false
+	}
+	histogram, ok := tracker.namespaces.Load(namespace)
+	if !ok {
+		return TTLSuggestion{}, false
+	}
+	return histogram.(*reaccessHistogram).suggestion(tracker.quantile)
+}
+
+// SuggestedTTLs returns the TTL suggested for every namespace with recorded
+// intervals, or nil if the suggestions are not enabled.
+func (c *CacheMachine) SuggestedTTLs() map[string]TTLSuggestion {
+	tracker := c.reaccessTracker()
+	if tracker == nil {
+		return nil
+	}
+	suggestions := make(map[string]TTLSuggestion)
+	tracker.namespaces.Range(func(namespace, histogram interface{}) bool {
+		if suggestion, ok := histogram.(*reaccessHistogram).suggestion(tracker.quantile); ok {
+			suggestions[namespace.(string)] = suggestion
+		}
+		return true
+	})
+	return suggestions
+}
+
+// recordReaccessRead records that key was read, when the suggestions are
+// enabled.
+func (c *CacheMachine) recordReaccessRead(key string) {
+	if tracker := c.reaccessTracker(); tracker != nil {
+		tracker.recordRead(key, time.Now())
+	}
+}
+
+// recordReaccessSet records that key was set, when the suggestions are
+// enabled.
+func (c *CacheMachine) recordReaccessSet(key string, now time.Time) {
+	if tracker := c.reaccessTracker(); tracker != nil {
+		tracker.recordSet(key, now)
+	}
+}
+
+// slot returns the slot of key and the tag of key in it.
+func (t *reaccessTracker) slot(key string) (*uint64, uint64) {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	return &t.slots[hash%reaccessSlots], hash >> reaccessTagShift << reaccessTagShift
+}
+
+// stamp returns the content of the slot of a key with tag accessed at now.
+// Zero means never accessed, hence the offset.
+func (t *reaccessTracker) stamp(tag uint64, now time.Time) uint64 {
+	return tag | uint64(now.Sub(t.start)/time.Millisecond) + 1
+}
+
+func (t *reaccessTracker) recordRead(key string, now time.Time) {
+	slot, tag := t.slot(key)
+	stamp := t.stamp(tag, now)
+	previous := atomic.SwapUint64(slot, stamp)
+	if previous == 0 || previous>>reaccessTagShift<<reaccessTagShift != tag || previous > stamp {
+		return
+	}
+	histogram := t.histogram(KeyNamespace(key))
+	if histogram != nil {
+		histogram.record(time.Duration(stamp-previous) * time.Millisecond)
+	}
+}
+
+// recordSet times the first read of key from now, unless key was already
+// read.
+func (t *reaccessTracker) recordSet(key string, now time.Time) {
+	slot, tag := t.slot(key)
+	stamp := t.stamp(tag, now)
+	for {
+		previous := atomic.LoadUint64(slot)
+		if previous != 0 && previous>>reaccessTagShift<<reaccessTagShift == tag {
+			return
+		}
+		if atomic.CompareAndSwapUint64(slot, previous, stamp) {
+			return
+		}
+	}
+}
+
+// histogram returns the histogram of namespace, or nil once the maximum
+// number of namespaces is tracked.
+func (t *reaccessTracker) histogram(namespace string) *reaccessHistogram {
+	if histogram, ok := t.namespaces.Load(namespace); ok {
+		return histogram.(*reaccessHistogram)
+	}
+	if atomic.LoadInt64(&t.count) >= t.maxNamespaces {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if histogram, ok := t.namespaces.Load(namespace); ok {
+		return histogram.(*reaccessHistogram)
+	}
+	if atomic.LoadInt64(&t.count) >= t.maxNamespaces {
+		return nil
+	}
+	histogram := &reaccessHistogram{}
+	t.namespaces.Store(namespace, histogram)
+	atomic.AddInt64(&t.count, 1)
+	return histogram
+}
+
+// reaccessBucket returns the bucket of the histograms counting gap.
+func reaccessBucket(gap time.Duration) int {
+	ms := float64(gap) / float64(time.Millisecond)
+	if ms <= 1 {
+		return 0
+	}
+	bucket := int(4 * math.Log2(ms))
+	if bucket >= reaccessBuckets {
+		return reaccessBuckets - 1
+	}
+	return bucket
+}
+
+// reaccessBucketBound returns the upper bound of the intervals counted by
+// bucket.
+func reaccessBucketBound(bucket int) time.Duration {
+	return time.Duration(math.Exp2(float64(bucket+1)/4) * float64(time.Millisecond))
+}
+
+func (h *reaccessHistogram) record(gap time.Duration) {
+	atomic.AddInt64(&h.buckets[reaccessBucket(gap)], 1)
+	// The recording that reaches the limit halves the histogram. The
+	// recordings done meanwhile may escape the halving, which is fine.
+	if atomic.AddInt64(&h.total, 1) != reaccessDecaySamples {
+		return
+	}
+	var removed int64
+	for i := range h.buckets {
+		for {
+			count := atomic.LoadInt64(&h.buckets[i])
+			if atomic.CompareAndSwapInt64(&h.buckets[i], count, count/2) {
+				removed += count - count/2
+				break
+			}
+		}
+	}
+	atomic.AddInt64(&h.total, -removed)
+}
+
+// suggestion returns the suggestion made from the histogram, or false if it
+// is empty. The quantiles are rounded up to the bound of their bucket, so
+// that the TTLs cover the intervals.
+func (h *reaccessHistogram) suggestion(quantile float64) (TTLSuggestion, bool) {
+	var buckets [reaccessBuckets]int64
+	var total int64
+	for i := range h.buckets {
+		buckets[i] = atomic.LoadInt64(&h.buckets[i])
+		total += buckets[i]
+	}
+	if total == 0 {
+		return TTLSuggestion{}, false
+	}
+	at := func(q float64) time.Duration {
+		rank := int64(math.Ceil(q * float64(total)))
+		var seen int64
+		for i, count := range buckets {
+			seen += count
+			if seen >= rank {
+				return reaccessBucketBound(i)
+			}
+		}
+		return reaccessBucketBound(reaccessBuckets - 1)
+	}
+	return TTLSuggestion{
+		TTL:     at(quantile),
+		P50:     at(0.5),
+		P95:     at(0.95),
+		P99:     at(0.99),
+		Samples: total,
+	}, true
+}
+
+// adaptiveTTL bounds the TTL suggested for a namespace applied to its
+// entries.
+type adaptiveTTL struct {
+	minTTL time.Duration
+	maxTTL time.Duration
+}
+
+// adaptiveTTLPolicies maps namespaces to their adaptive TTL. The map is
+// replaced rather than updated, so that reads do not lock.
+type adaptiveTTLPolicies struct {
+	mu       sync.Mutex
+	policies atomic.Value
+}
+
+func (p *adaptiveTTLPolicies) get(namespace string) (adaptiveTTL, bool) {
+	policies, _ := p.policies.Load().(map[string]adaptiveTTL)
+	policy, ok := policies[namespace]
+	return policy, ok
+}
+
+func (p *adaptiveTTLPolicies) set(namespace string, policy *adaptiveTTL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous, _ := p.policies.Load().(map[string]adaptiveTTL)
+	policies := make(map[string]adaptiveTTL, len(previous)+1)
+	for name, policy := range previous {
+		policies[name] = policy
+	}
+	if policy == nil {
+		delete(policies, namespace)
+	} else {
+		policies[namespace] = *policy
+	}
+	p.policies.Store(policies)
+}
+
+// EnableAdaptiveTTL makes the entries set in namespace, see KeyNamespace,
+// get the TTL suggested for it, see EnableTTLSuggestions, clamped between
+// minTTL and maxTTL, in place of the TTL they are set with. A maxTTL of 0
+// leaves the suggested TTL unbounded from above. The entries keep the TTL
+// they are set with until the namespace has recorded 100 intervals.
+//
+// The adaptive TTL is applied before TTLPolicy, MinTTL and MaxTTL, and every
+// entry getting it is reported as an EventPolicyTrip.
+func (c *CacheMachine) EnableAdaptiveTTL(namespace string, minTTL, maxTTL time.Duration) error {
+	if c.reaccessTracker() == nil {
+		return fmt.Errorf("TTL suggestions are not enabled")
+	}
+	if minTTL <= 0 {
+		return fmt.Errorf("minTTL must be greater than 0")
+	}
+	if maxTTL != 0 && maxTTL < minTTL {
+		return fmt.Errorf("maxTTL must be at least minTTL")
+	}
+	c.adaptiveTTLs.set(namespace, &adaptiveTTL{minTTL: minTTL, maxTTL: maxTTL})
+	return nil
+}
+
+// DisableAdaptiveTTL makes the entries set in namespace keep the TTL they
+// are set with again. The TTLs already applied are kept.
+func (c *CacheMachine) DisableAdaptiveTTL(namespace string) {
+	c.adaptiveTTLs.set(namespace, nil)
+}
+
+// adaptiveTTL returns the TTL of an entry of key set with ttl, which is the
+// TTL suggested for its namespace if it has an adaptive TTL.
+func (c *CacheMachine) adaptiveTTL(key string, ttl time.Duration) time.Duration {
+	namespace := KeyNamespace(key)
+	policy, ok := c.adaptiveTTLs.get(namespace)
+	if !ok {
+		return ttl
+	}
+	suggestion, ok := c.SuggestedTTL(namespace)
+	if !ok || suggestion.Samples < adaptiveTTLMinSamples {
+		return ttl
+	}
+	ttl = suggestion.TTL
+	if ttl < policy.minTTL {
+		ttl = policy.minTTL
+	}
+	if policy.maxTTL > 0 && ttl > policy.maxTTL {
+		ttl = policy.maxTTL
+	}
+	return ttl
+}
@@ -0,0 +1,115 @@
+package cachemachine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_TTLSuggestions(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	if err = CacheMachine.EnableTTLSuggestions(0); err == nil {
+		t.Errorf("Expected an error enabling TTL suggestions with a quantile of 0")
+	}
+	if err = CacheMachine.EnableAdaptiveTTL("users", time.Second, time.Minute); err == nil {
+		t.Errorf("Expected an error enabling adaptive TTL without TTL suggestions")
+	}
+	if CacheMachine.SuggestedTTLs() != nil {
+		t.Errorf("Expected no suggestions before enabling them")
+	}
+
+	err = CacheMachine.EnableTTLSuggestions(0.95)
+	if err != nil {
+		t.Errorf("Expected no error enabling TTL suggestions, got %s", err)
+	}
+
+	// A key read right after being set records an interval, a key only set
+	// does not.
+	CacheMachine.Set("users:1", []byte("value"))
+	CacheMachine.Set("users:2", []byte("value"))
+	CacheMachine.Get("users:1")
+	CacheMachine.MGet([]string{"users:1"})
+	suggestion, ok := CacheMachine.SuggestedTTL("users")
+	if !ok || suggestion.Samples != 2 {
+		t.Errorf("Expected 2 samples for users, got %+v, %t", suggestion, ok)
+	}
+	if _, ok = CacheMachine.SuggestedTTL("orders"); ok {
+		t.Errorf("Expected no suggestion for orders")
+	}
+
+	// Intervals of 10s for 90% of the reads and of 10m for the others.
+	tracker := CacheMachine.reaccessTracker()
+	now := tracker.start
+	for i := 0; i < 200; i++ {
+		tracker.recordRead("orders:1", now)
+		if i%10 == 9 {
+			now = now.Add(10 * time.Minute)
+		} else {
+			now = now.Add(10 * time.Second)
+		}
+	}
+	suggestion, ok = CacheMachine.SuggestedTTL("orders")
+	if !ok || suggestion.Samples != 199 {
+		t.Errorf("Expected 199 samples for orders, got %+v, %t", suggestion, ok)
+	}
+	if suggestion.P50 < 10*time.Second || suggestion.P50 > 12*time.Second {
+		t.Errorf("Expected a median of about 10s, got %s", suggestion.P50)
+	}
+	if suggestion.TTL < 10*time.Minute || suggestion.TTL > 12*time.Minute {
+		t.Errorf("Expected a suggested TTL of about 10m, got %s", suggestion.TTL)
+	}
+	if suggestion.P95 != suggestion.TTL || suggestion.P99 != suggestion.TTL {
+		t.Errorf("Expected the 95th and 99th percentiles to be the TTL, got %+v", suggestion)
+	}
+	if suggestions := CacheMachine.SuggestedTTLs(); len(suggestions) != 2 {
+		t.Errorf("Expected suggestions for 2 namespaces, got %v", suggestions)
+	}
+
+	// The suggested TTL replaces the requested one, within bounds.
+	err = CacheMachine.EnableAdaptiveTTL("orders", time.Second, 5*time.Minute)
+	if err != nil {
+		t.Errorf("Expected no error enabling adaptive TTL, got %s", err)
+	}
+	err = CacheMachine.SetWithTTL("orders:2", []byte("value"), time.Hour)
+	if err != nil {
+		t.Errorf("Expected no error setting orders:2, got %s", err)
+	}
+	if left := time.Until(CacheMachine.Where("orders:2").ExpiresAt); left > 5*time.Minute || left < 4*time.Minute {
+		t.Errorf("Expected orders:2 to expire in about 5m, got %s", left)
+	}
+
+	// Namespaces without enough samples keep the requested TTL.
+	err = CacheMachine.EnableAdaptiveTTL("users", time.Second, 0)
+	if err != nil {
+		t.Errorf("Expected no error enabling adaptive TTL, got %s", err)
+	}
+	CacheMachine.SetWithTTL("users:3", []byte("value"), time.Hour)
+	if left := time.Until(CacheMachine.Where("users:3").ExpiresAt); left < 59*time.Minute {
+		t.Errorf("Expected users:3 to expire in about 1h, got %s", left)
+	}
+
+	CacheMachine.DisableAdaptiveTTL("orders")
+	CacheMachine.SetWithTTL("orders:3", []byte("value"), time.Hour)
+	if left := time.Until(CacheMachine.Where("orders:3").ExpiresAt); left < 59*time.Minute {
+		t.Errorf("Expected orders:3 to expire in about 1h, got %s", left)
+	}
+
+	CacheMachine.DisableTTLSuggestions()
+	if _, ok = CacheMachine.SuggestedTTL("orders"); ok {
+		t.Errorf("Expected no suggestion once disabled")
+	}
+}
+
+func TestReaccessHistogram_Decay(t *testing.T) {
+	var histogram reaccessHistogram
+	for i := 0; i < reaccessDecaySamples; i++ {
+		histogram.record(time.Second)
+	}
+	suggestion, ok := histogram.suggestion(0.5)
+	if !ok || suggestion.Samples != reaccessDecaySamples/2 {
+		t.Errorf("Expected %d samples once halved, got %+v, %t", reaccessDecaySamples/2, suggestion, ok)
+	}
+}
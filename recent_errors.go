@@ -0,0 +1,89 @@
+package cachemachine
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRecentErrorsSize is the default value of
+// CacheMachine.RecentErrorsSize.
+const DefaultRecentErrorsSize = 64
+
+// RecentError is an error met by the cache machine in the background, such
+// as a failure to sync an entry or to push the stats to a sink.
+type RecentError struct {
+	Time    time.Time              `json:"time"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// recentErrors is a bounded buffer of the last background errors, the
+// oldest first. Background errors are rare enough for the buffer to be
+// shifted rather than used as a ring, which keeps resizing it trivial.
+type recentErrors struct {
+	mu      sync.Mutex
+	entries []RecentError
+}
+
+// record adds an error to the buffer, dropping the oldest ones beyond size.
+func (r *recentErrors) record(size int, e RecentError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size <= 0 {
+		r.entries = nil
+		return
+	}
+	r.entries = append(r.entries, e)
+	if excess := len(r.entries) - size; excess > 0 {
+		n := copy(r.entries, r.entries[excess:])
+		for i := n; i < len(r.entries); i++ {
+			r.entries[i] = RecentError{}
+		}
+		r.entries = r.entries[:n]
+	}
+}
+
+// snapshot returns a copy of the errors, the most recent first.
+func (r *recentErrors) snapshot() []RecentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) == 0 {
+		return nil
+	}
+	snapshot := make([]RecentError, len(r.entries))
+	for i, e := range r.entries {
+		snapshot[len(r.entries)-1-i] = e
+	}
+	return snapshot
+}
+
+// RecentErrors returns the last RecentErrorsSize errors met by the cache
+// machine in the background, the most recent first, so they can be looked
+// at without access to its logs. They are also part of the Stats, and
+// served by the AdminHandler.
+func (c *CacheMachine) RecentErrors() []RecentError {
+	return c.recentErrors.snapshot()
+}
+
+// logError logs an error met in the background, and records it in the
+// recent errors.
+func (c *CacheMachine) logError(msg string, keysAndValues ...interface{}) {
+	c.Logger.Error(msg, keysAndValues...)
+	c.recordError(msg, keysAndValues...)
+}
+
+// recordError records an error in the recent errors, the error values of
+// the fields as their message.
+func (c *CacheMachine) recordError(msg string, keysAndValues ...interface{}) {
+	e := RecentError{Time: time.Now(), Message: msg}
+	if len(keysAndValues) > 0 {
+		e.Fields = make(map[string]interface{}, len(keysAndValues)/2)
+		forEachField(keysAndValues, func(key string, value interface{}) {
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			e.Fields[key] = value
+		})
+	}
+	c.recentErrors.record(c.RecentErrorsSize, e)
+}
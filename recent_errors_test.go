@@ -0,0 +1,77 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type failingPutStore struct {
+	*memoryStore
+}
+
+func (s failingPutStore) Put(ctx context.Context, key string, val []byte) error {
+	return errors.New("S3 is down")
+}
+
+func TestCacheMachine_RecentErrors(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.SetLogger(DefaultLogger{Level: LevelError + 1})
+	CacheMachine.RecentErrorsSize = 2
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(failingPutStore{newMemoryStore()})
+
+	if errs := CacheMachine.RecentErrors(); len(errs) != 0 {
+		t.Errorf("Expected no recent errors, got %v", errs)
+	}
+
+	// The keys failing to sync are retried by every Flush. Only the last
+	// RecentErrorsSize errors are kept, the most recent first.
+	for _, key := range []string{"key1", "key2", "key3"} {
+		CacheMachine.Set(key, []byte("12345"))
+		CacheMachine.Flush()
+	}
+	errs := CacheMachine.Stats().RecentErrors
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 recent errors, got %v", errs)
+	}
+	if errs[0].Message != "error syncing to S3" || errs[0].Fields["error"] != "S3 is down" {
+		t.Errorf("Expected an S3 sync error, got %+v", errs[0])
+	}
+	if errs[0].Time.Before(errs[1].Time) {
+		t.Errorf("Expected the most recent error first, got %+v", errs)
+	}
+
+	recorder := httptest.NewRecorder()
+	CacheMachine.AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/errors", nil))
+	var served []RecentError
+	if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the recent errors to be served, got %d, %v", recorder.Code, err)
+	}
+	if len(served) == 0 || served[0].Message != "error syncing to S3" {
+		t.Errorf("Expected the S3 sync errors to be served, got %+v", served)
+	}
+
+	CacheMachine.RecentErrorsSize = 0
+	CacheMachine.Flush()
+	if errs := CacheMachine.RecentErrors(); len(errs) != 0 {
+		t.Errorf("Expected no recent errors to be kept, got %v", errs)
+	}
+}
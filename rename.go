@@ -0,0 +1,117 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// CopyKey copies the value of src to dst, as if it had been set against dst
+// with the TTL left to src. dst is related to the data subjects of src, see
+// WithSubject. The value of src is read from whichever tier holds it, and
+// any value of dst is replaced. It returns ErrNotFound when src is not
+// cached, and ErrLegalHold when dst is under legal hold.
+func (c *CacheMachine) CopyKey(src, dst string) error {
+	return c.CopyKeyCtx(context.Background(), src, dst)
+}
+
+// CopyKeyCtx is like CopyKey, ctx bounding the read of src from the disk
+// and S3 tiers.
+func (c *CacheMachine) CopyKeyCtx(ctx context.Context, src, dst string) error {
+	return c.copyKey(ctx, src, dst, false)
+}
+
+// Rename moves the value of oldKey to newKey, like CopyKey followed by the
+// deletion of oldKey from every tier, both keys being locked throughout so
+// that no other operation on them is interleaved. It returns ErrLegalHold
+// when either key is under legal hold.
+//
+// Applications changing their key naming scheme can rename the keys they
+// still use rather than losing the warm cache.
+func (c *CacheMachine) Rename(oldKey, newKey string) error {
+	return c.RenameCtx(context.Background(), oldKey, newKey)
+}
+
+// RenameCtx is like Rename, ctx bounding the read of oldKey from the disk
+// and S3 tiers, and its deletion from S3.
+func (c *CacheMachine) RenameCtx(ctx context.Context, oldKey, newKey string) error {
+	return c.copyKey(ctx, oldKey, newKey, true)
+}
+
+func (c *CacheMachine) copyKey(ctx context.Context, src, dst string, move bool) error {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Get)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if src == dst {
+		return nil
+	}
+
+	srcShard, dstShard, unlock := c.syncTable.lockPair(src, dst)
+	defer unlock()
+
+	if c.legalHolds.held(dst) || (move && c.legalHolds.held(src)) {
+		return ErrLegalHold
+	}
+	cacheSync, ok := srcShard.entries[src]
+	now := time.Now()
+	if !ok || cacheSync.Negative || cacheSync.expired(now) {
+		return ErrNotFound
+	}
+	value, err := c.readLocked(ctx, srcShard, src, cacheSync)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if !cacheSync.ExpiresAt.IsZero() {
+		ttl = cacheSync.ExpiresAt.Sub(now)
+	}
+	if err := c.set(dstShard, dst, value, ttl); err != nil {
+		return err
+	}
+	c.subjects.copy(src, dst)
+	if move {
+		c.deleteLocked(ctx, srcShard, src)
+	}
+	return nil
+}
+
+// readLocked returns the value of key from the first tier that holds it,
+// or ErrNotFound. Unlike get, it counts no hit and does not promote the
+// value to RAM. It must be called with the stripe of the key locked.
+func (c *CacheMachine) readLocked(ctx context.Context, shard *syncTableShard, key string, cacheSync CacheSyncTable) ([]byte, error) {
+	if value, err := c.RamCache.Get([]byte(key)); err == nil {
+		return value, nil
+	}
+	if cacheSync.DiskSynced && c.DiskCache != nil {
+		diskCache := c.DiskCache
+		value, err := withContext(ctx, func() ([]byte, error) {
+			return diskCache.GetContext(ctx, key)
+		})
+		if err == diskcache.ErrNotFound {
+			value, err = c.migrateEntry(shard, key)
+		}
+		if err == nil {
+			return value, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+	}
+	if cacheSync.S3Sync && c.S3Cache != nil {
+		value, err := c.getObject(ctx, c.S3Cache, key)
+		if err == nil {
+			return value, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != ErrObjectNotFound && err != ErrCorruptObject && err != ErrUnsupportedObject {
+			return nil, err
+		}
+	}
+	return nil, ErrNotFound
+}
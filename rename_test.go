@@ -0,0 +1,82 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_Rename(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	err = CacheMachine.SetWithOptions("old1", []byte("12345"), WithTTL(time.Hour), WithSubject("user1"))
+	if err != nil {
+		t.Fatalf("Expected no error setting old1, got %s", err)
+	}
+	CacheMachine.Set("old2", []byte("67890"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+	before, _ := CacheMachine.SyncState("old1")
+
+	if err := CacheMachine.Rename("old1", "new1"); err != nil {
+		t.Fatalf("Expected no error renaming old1, got %s", err)
+	}
+	if value, ok := CacheMachine.Get("old1"); ok {
+		t.Errorf("Expected old1 to be gone, got %q", value)
+	}
+	if _, err := store.Get(context.Background(), "old1"); err != ErrObjectNotFound {
+		t.Errorf("Expected old1 to be deleted from S3, got %v", err)
+	}
+	if value, ok := CacheMachine.Get("new1"); !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 for new1, got %q, %v", value, ok)
+	}
+	after, _ := CacheMachine.SyncState("new1")
+	if d := after.ExpiresAt.Sub(before.ExpiresAt); d < -time.Second || d > time.Second {
+		t.Errorf("Expected the TTL of old1 to be kept, got %s instead of %s", after.ExpiresAt, before.ExpiresAt)
+	}
+	if report, err := CacheMachine.PurgeBySubject(context.Background(), "user1"); err != nil || len(report.Keys) != 1 || report.Keys[0] != "new1" {
+		t.Errorf("Expected new1 to be related to user1, got %v, %v", report, err)
+	}
+
+	// The value is read from S3 when it is not on disk anymore.
+	CacheMachine.DiskCache.Delete("old2")
+	if err := CacheMachine.CopyKey("old2", "new2"); err != nil {
+		t.Fatalf("Expected no error copying old2, got %s", err)
+	}
+	for _, key := range []string{"old2", "new2"} {
+		if value, ok := CacheMachine.Get(key); !ok || string(value) != "67890" {
+			t.Errorf("Expected 67890 for %s, got %q, %v", key, value, ok)
+		}
+	}
+
+	if err := CacheMachine.CopyKey("missing", "new3"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound copying a missing key, got %v", err)
+	}
+	CacheMachine.SetLegalHold(context.Background(), "old2", true)
+	if err := CacheMachine.Rename("old2", "new3"); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold renaming a key under legal hold, got %v", err)
+	}
+	if err := CacheMachine.CopyKey("new2", "old2"); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold copying to a key under legal hold, got %v", err)
+	}
+	if _, ok := CacheMachine.SyncState("new3"); ok {
+		t.Errorf("Expected new3 not to be set")
+	}
+}
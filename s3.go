@@ -0,0 +1,54 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrObjectNotFound must be returned by ObjectStore.Get for unknown keys.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is the interface of the S3 tier. It is kept minimal so that it
+// can be implemented by a thin wrapper around the S3 client of the
+// application, which keeps the AWS SDK out of the dependencies of the
+// library. Implementations must be safe for concurrent use.
+type ObjectStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, val []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// EnableS3Cache adds store as the slowest tier of the cache machine. The S3
+// tier is filled by the background sync, along with the disk cache, so the
+// disk cache must be enabled first. Get falls back to it when an entry is not
+// found in RAM nor on disk.
+func (c *CacheMachine) EnableS3Cache(store ObjectStore) error {
+	if store == nil {
+		return fmt.Errorf("store must be set")
+	}
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	c.S3Cache = store
+	c.enqueueAll(true)
+	return nil
+}
+
+// DisableS3Cache removes the S3 tier, and forgets about the S3 sync state of
+// every entry so that they are synced again if a store is enabled later.
+func (c *CacheMachine) DisableS3Cache() {
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+
+	c.S3Cache = nil
+	for i := range c.syncTable {
+		for key, cacheSync := range c.syncTable[i].entries {
+			cacheSync.S3Sync = false
+			c.syncTable[i].entries[key] = cacheSync
+		}
+	}
+}
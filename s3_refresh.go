@@ -0,0 +1,87 @@
+package cachemachine
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxS3Refreshes bounds the number of refreshes running at once. Stale
+	// entries read while it is reached are refreshed on a later read.
+	maxS3Refreshes = 4
+
+	// s3RefreshTimeout bounds the duration of a refresh.
+	s3RefreshTimeout = 30 * time.Second
+)
+
+// S3RefreshFunc returns the current value for key, typically by fetching it
+// from the origin of the data. It returns ErrObjectNotFound when the key
+// does not exist anymore.
+type S3RefreshFunc func(ctx context.Context, key string) ([]byte, error)
+
+// refreshStale schedules a background refresh of the entry for key, read
+// from S3, if it is older than S3MaxAge. The entry is served as is in the
+// meantime.
+func (c *CacheMachine) refreshStale(key string, cacheSync CacheSyncTable) {
+	refresh := c.S3Refresh
+	if refresh == nil || c.S3MaxAge <= 0 || time.Since(cacheSync.SetAt) < c.S3MaxAge {
+		return
+	}
+
+	c.refreshMu.Lock()
+	if c.refreshing == nil {
+		c.refreshing = make(map[string]struct{})
+	}
+	_, running := c.refreshing[key]
+	if running || len(c.refreshing) >= maxS3Refreshes {
+		c.refreshMu.Unlock()
+		return
+	}
+	c.refreshing[key] = struct{}{}
+	c.refreshMu.Unlock()
+
+	go func() {
+		defer func() {
+			c.refreshMu.Lock()
+			delete(c.refreshing, key)
+			c.refreshMu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s3RefreshTimeout)
+		defer cancel()
+		value, err := refresh(ctx, key)
+		if err != nil && err != ErrObjectNotFound {
+			c.Logger.Warn("error refreshing stale entry", "key", key, "error", err)
+			c.recordError("error refreshing stale entry", "key", key, "error", err)
+			return
+		}
+		atomic.AddInt64(&c.stats.s3Refreshes, 1)
+
+		shard := c.syncTable.shard(key)
+		shard.Lock()
+		defer shard.Unlock()
+
+		// The entry was set or deleted while being refreshed, the refreshed
+		// value would be older. Entries under legal hold are left as is.
+		current, ok := shard.entries[key]
+		if !ok || !current.SetAt.Equal(cacheSync.SetAt) || c.legalHolds.held(key) {
+			return
+		}
+		if err == ErrObjectNotFound {
+			c.deleteLocked(ctx, shard, key)
+			return
+		}
+		var ttl time.Duration
+		if !cacheSync.ExpiresAt.IsZero() {
+			ttl = time.Until(cacheSync.ExpiresAt)
+			if ttl <= 0 {
+				return
+			}
+		}
+		if err := c.set(shard, key, value, ttl); err != nil {
+			c.Logger.Warn("error setting refreshed entry", "key", key, "error", err)
+			c.recordError("error setting refreshed entry", "key", key, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_S3Refresh(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(newMemoryStore())
+
+	refreshed := make(chan string, 2)
+	CacheMachine.S3MaxAge = time.Millisecond
+	CacheMachine.S3Refresh = func(ctx context.Context, key string) ([]byte, error) {
+		defer func() { refreshed <- key }()
+		if key == "gone" {
+			return nil, ErrObjectNotFound
+		}
+		return []byte("fresh"), nil
+	}
+
+	CacheMachine.Set("stale", []byte("12345"))
+	CacheMachine.Set("gone", []byte("67890"))
+	CacheMachine.Flush()
+	for _, key := range []string{"stale", "gone"} {
+		CacheMachine.RAMTier().Delete(context.Background(), key)
+		CacheMachine.DiskTier().Delete(context.Background(), key)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	value, ok := CacheMachine.Get("stale")
+	if !ok || string(value) != "12345" {
+		t.Errorf("Expected the stale value to be served, got %s, %v", value, ok)
+	}
+	CacheMachine.Get("gone")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected stale entries to be refreshed")
+		}
+	}
+
+	// The refreshed entries are stored once the refresh returns.
+	deadline := time.Now().Add(time.Second)
+	for (CacheMachine.Has("gone") || CacheMachine.Stats().S3Refreshes != 2) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for value, _ := CacheMachine.Peek("stale"); string(value) != "fresh" && time.Now().Before(deadline); value, _ = CacheMachine.Peek("stale") {
+		time.Sleep(time.Millisecond)
+	}
+
+	value, ok = CacheMachine.Get("stale")
+	if !ok || string(value) != "fresh" {
+		t.Errorf("Expected the refreshed value, got %s, %v", value, ok)
+	}
+	if CacheMachine.Has("gone") {
+		t.Errorf("Expected gone to be deleted")
+	}
+}
@@ -0,0 +1,113 @@
+package cachemachine
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// memoryStore is an in-memory ObjectStore.
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string][]byte)}
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), val...)
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func TestCacheMachine_S3Cache(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	store := newMemoryStore()
+	err = CacheMachine.EnableS3Cache(store)
+	if err == nil {
+		t.Errorf("Expected an error enabling S3 cache without disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+
+	err = CacheMachine.EnableS3Cache(store)
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.RAMTier().Delete(context.Background(), "key1")
+	CacheMachine.Flush()
+
+	if store.len() != 2 {
+		t.Errorf("Expected key1 and key2 to be synced to S3, got %d objects", store.len())
+	}
+	if state, _ := CacheMachine.SyncState("key1"); !state.DiskSynced || !state.S3Sync {
+		t.Errorf("Expected key1 to be synced to disk and S3, got %+v", state)
+	}
+
+	CacheMachine.RAMTier().Delete(context.Background(), "key2")
+	CacheMachine.DiskTier().Delete(context.Background(), "key2")
+	value, ok := CacheMachine.Get("key2")
+	if !ok || string(value) != "67890" {
+		t.Errorf("Expected value to be 67890 from S3, got %s, %v", value, ok)
+	}
+	stats := CacheMachine.Stats()
+	if stats.S3Hits != 1 || stats.S3WriteCount != 2 || stats.S3WriteBytes != 10 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	if !CacheMachine.Delete("key2") {
+		t.Errorf("Expected key2 to be deleted")
+	}
+	if store.len() != 1 {
+		t.Errorf("Expected key2 to be deleted from S3, got %d objects", store.len())
+	}
+
+	CacheMachine.DisableS3Cache()
+	if state, _ := CacheMachine.SyncState("key1"); state.S3Sync {
+		t.Errorf("Expected key1 to be no longer synced to S3, got %+v", state)
+	}
+}
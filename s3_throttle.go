@@ -0,0 +1,53 @@
+package cachemachine
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// downloadPollInterval is how often the background downloads check whether
+// the downloads of the serving path are done.
+const downloadPollInterval = 5 * time.Millisecond
+
+// s3DownloadLimiter returns the limiter of the downloads from S3, or nil
+// when they are not limited.
+func (c *CacheMachine) s3DownloadLimiter() *byteRateLimiter {
+	if c.S3DownloadMaxBytesPerSecond <= 0 {
+		return nil
+	}
+	c.downloadLimiterMu.Lock()
+	defer c.downloadLimiterMu.Unlock()
+	if c.downloadLimiter == nil || c.downloadLimiter.rate != float64(c.S3DownloadMaxBytesPerSecond) {
+		c.downloadLimiter = newByteRateLimiter(c.S3DownloadMaxBytesPerSecond)
+	}
+	return c.downloadLimiter
+}
+
+// waitDownload waits until a download may start: once the downloads made
+// so far are paid for, and, for background downloads, once no download of
+// the serving path is in flight. The size of a download is only known once
+// it is done, so it is paid for by the following ones.
+func (c *CacheMachine) waitDownload(ctx context.Context, limiter *byteRateLimiter, background bool) error {
+	throttled := false
+	for {
+		wait := limiter.wait()
+		if background && atomic.LoadInt32(&c.interactiveDownloads) > 0 && wait < downloadPollInterval {
+			wait = downloadPollInterval
+		}
+		if wait <= 0 {
+			return nil
+		}
+		if !throttled {
+			throttled = true
+			atomic.AddInt64(&c.stats.s3Throttled, 1)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cachemachine
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedStore is an ObjectStore whose reads of gated keys wait for gate to
+// be closed.
+type gatedStore struct {
+	*memoryStore
+	gated string
+	gate  chan struct{}
+}
+
+func (s *gatedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if key == s.gated {
+		<-s.gate
+	}
+	return s.memoryStore.Get(ctx, key)
+}
+
+func TestCacheMachine_S3DownloadMaxBytesPerSecond(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.S3DownloadMaxBytesPerSecond = 100000
+
+	// The bucket holds one second worth of downloads, so the fourth
+	// download of 40KB waits for 200ms.
+	store := newMemoryStore()
+	store.Put(context.Background(), "key", sealObject(bytes.Repeat([]byte("x"), 40000)))
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := CacheMachine.getObject(context.Background(), store, "key"); err != nil {
+			t.Fatalf("Expected no error downloading key, got %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected the downloads to be throttled, took %s", elapsed)
+	}
+	if throttled := CacheMachine.Stats().S3DownloadsThrottled; throttled != 1 {
+		t.Errorf("Expected 1 download throttled, got %d", throttled)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CacheMachine.getObject(ctx, store, "key"); err != context.Canceled {
+		t.Errorf("Expected a throttled download to be interrupted, got %v", err)
+	}
+}
+
+func TestCacheMachine_S3DownloadPriority(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.S3DownloadMaxBytesPerSecond = 1 << 30
+
+	store := &gatedStore{memoryStore: newMemoryStore(), gated: "live", gate: make(chan struct{})}
+	store.Put(context.Background(), "live", sealObject([]byte("12345")))
+	store.Put(context.Background(), "warmup", sealObject([]byte("67890")))
+
+	live := make(chan error)
+	go func() {
+		_, err := CacheMachine.getObject(context.Background(), store, "live")
+		live <- err
+	}()
+	for atomic.LoadInt32(&CacheMachine.interactiveDownloads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The background download waits for the live one.
+	warmup := make(chan error)
+	go func() {
+		_, err := CacheMachine.getBackgroundObject(context.Background(), store, "warmup")
+		warmup <- err
+	}()
+	select {
+	case err := <-warmup:
+		t.Fatalf("Expected the background download to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(store.gate)
+	if err := <-live; err != nil {
+		t.Errorf("Expected no error downloading live, got %s", err)
+	}
+	if err := <-warmup; err != nil {
+		t.Errorf("Expected no error downloading warmup, got %s", err)
+	}
+}
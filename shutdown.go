@@ -0,0 +1,126 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// pendingSync is an entry waiting to be synced, with its priority.
+type pendingSync struct {
+	key      string
+	requests int64
+	setAt    time.Time
+}
+
+// pendingSyncs returns the entries waiting to be synced, the most valuable
+// first: the most requested according to the frequency sketch, when
+// frequency tracking is enabled, then the most recently set.
+func (c *CacheMachine) pendingSyncs() []pendingSync {
+	sketch := c.frequencySketch()
+	var pending []pendingSync
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		dirty := c.dirtyKeys(shard)
+		seen := make(map[string]bool, len(dirty))
+		for _, key := range dirty {
+			cacheSync, ok := shard.entries[key]
+			if !ok || seen[key] {
+				continue
+			}
+			seen[key] = true
+			item := pendingSync{key: key, setAt: cacheSync.SetAt}
+			if sketch != nil {
+				item.requests = sketch.estimate(key)
+			}
+			pending = append(pending, item)
+		}
+		shard.Unlock()
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].requests != pending[j].requests {
+			return pending[i].requests > pending[j].requests
+		}
+		return pending[i].setAt.After(pending[j].setAt)
+	})
+	return pending
+}
+
+// FlushCtx is like Flush, but syncs the most valuable entries first, and
+// gives up when ctx is done, so that a time-boxed shutdown saves the
+// entries that matter the most. The entries are synced from the most to
+// the least requested, according to the frequency sketch when frequency
+// tracking is enabled, then from the most to the least recently set. The
+// entries left unsynced when ctx is done stay queued, and FlushCtx returns
+// an error wrapping the error of ctx. The writes to S3, including the
+// retries of the failed ones, are bound by ctx too.
+func (c *CacheMachine) FlushCtx(ctx context.Context) error {
+	if c.DiskCache == nil {
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Flush)
+	defer cancel()
+	s3Cache := c.S3Cache
+	pending := c.pendingSyncs()
+
+	var result syncResult
+	for i, item := range pending {
+		if err := ctx.Err(); err != nil {
+			c.Logger.Warn("flush interrupted", "synced", atomic.LoadInt64(&result.synced), "left", len(pending)-i)
+			return fmt.Errorf("flush interrupted with %d entries left: %w", len(pending)-i, err)
+		}
+		shard := c.syncTable.shard(item.key)
+		shard.Lock()
+		cacheSync, ok := shard.entries[item.key]
+		if ok && !cacheSync.Negative && (!cacheSync.DiskSynced || (s3Cache != nil && !cacheSync.S3Sync)) {
+			// Failed writes are left to the sync below, as the
+			// entries are still queued.
+			c.syncEntry(ctx, shard, item.key, cacheSync, s3Cache, &result)
+		}
+		shard.Unlock()
+	}
+
+	// Drains the queue of the entries synced above, and retries the
+	// failed writes.
+	c.syncRamCacheToDiskCache(ctx, nil, 0)
+	if err := ctx.Err(); err != nil {
+		left := atomic.LoadInt64(&c.stats.syncQueueDepth)
+		c.Logger.Warn("flush interrupted", "synced", atomic.LoadInt64(&result.synced), "left", left)
+		return fmt.Errorf("flush interrupted with %d entries left: %w", left, err)
+	}
+	return nil
+}
+
+// Close stops the background sync, flushes the disk tier, when enabled, see
+// FlushCtx, and closes it. It then stops pushing stats to the sinks, after a
+// final push so that the last counters are not lost, and closes the sinks
+// implementing io.Closer. It returns the first error.
+func (c *CacheMachine) Close() error {
+	return c.CloseCtx(context.Background())
+}
+
+// CloseCtx is like Close, but gives up flushing the disk tier when ctx is
+// done, such as when the grace period of a shutdown is about to run out.
+// The most valuable entries are flushed first. The disk tier is left open
+// when ctx is done before the sync running in the background completes.
+// The sinks are closed in any case.
+func (c *CacheMachine) CloseCtx(ctx context.Context) error {
+	var flushErr error
+	if c.DiskCache != nil {
+		select {
+		case c.DiskCacheSyncQuit <- 1:
+			c.DiskCacheSyncTicker.Stop()
+			flushErr = c.FlushCtx(ctx)
+			c.closeDiskCache()
+		case <-ctx.Done():
+			flushErr = fmt.Errorf("close interrupted waiting for the background sync: %w", ctx.Err())
+		}
+	}
+	if err := c.closeSinks(); err != nil && flushErr == nil {
+		return err
+	}
+	return flushErr
+}
@@ -0,0 +1,160 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cancelingStore is an in-memory ObjectStore calling cancel once it stored
+// limit objects, as a shutdown running out of time would.
+type cancelingStore struct {
+	*memoryStore
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingStore) Put(ctx context.Context, key string, val []byte) error {
+	if err := s.memoryStore.Put(ctx, key, val); err != nil {
+		return err
+	}
+	if s.len() >= s.limit {
+		s.cancel()
+	}
+	return nil
+}
+
+// stallingStore is an in-memory ObjectStore whose first Put fails, and whose
+// next ones never complete before the context is done, like an S3 endpoint
+// going down during a shutdown.
+type stallingStore struct {
+	*memoryStore
+	puts int32
+}
+
+func (s *stallingStore) Put(ctx context.Context, key string, val []byte) error {
+	if atomic.AddInt32(&s.puts, 1) == 1 {
+		return errors.New("connection reset")
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCacheMachine_FlushCtxDeadline(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(1024*1024, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	store := &stallingStore{memoryStore: newMemoryStore()}
+	CacheMachine.EnableS3Cache(store)
+
+	CacheMachine.Set("key1", []byte("12345"))
+
+	// The retry of the failed write is bound by the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = CacheMachine.FlushCtx(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the flush to run out of time, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the flush to stop at its deadline, took %s", elapsed)
+	}
+	if cacheSync, _ := CacheMachine.syncTable.get("key1"); !cacheSync.DiskSynced || cacheSync.S3Sync {
+		t.Errorf("Expected key1 to be synced to disk only, got %+v", cacheSync)
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 1 {
+		t.Errorf("Expected key1 to stay queued, got a depth of %d", depth)
+	}
+}
+
+func TestCacheMachine_FlushCtx(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(1024*1024, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.EnableFrequencyTracking()
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &cancelingStore{memoryStore: newMemoryStore(), limit: 2, cancel: cancel}
+	CacheMachine.EnableS3Cache(store)
+
+	// key3 is the most requested, then key1.
+	for i := 0; i < 5; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte("12345"))
+	}
+	for i := 0; i < 3; i++ {
+		CacheMachine.Get("key3")
+	}
+	CacheMachine.Get("key1")
+
+	err = CacheMachine.FlushCtx(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the flush to be interrupted, got %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", i)
+		cacheSync, _ := CacheMachine.syncTable.get(key)
+		flushed := key == "key3" || key == "key1"
+		if cacheSync.DiskSynced != flushed || cacheSync.S3Sync != flushed {
+			t.Errorf("Expected %s to be flushed: %v, got %+v", key, flushed, cacheSync)
+		}
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 5 {
+		t.Errorf("Expected the entries to stay queued, got a depth of %d", depth)
+	}
+
+	// Without a deadline, every entry is flushed, and the queue drained.
+	if err := CacheMachine.CloseCtx(context.Background()); err != nil {
+		t.Fatalf("Expected no error closing, got %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if cacheSync, _ := CacheMachine.syncTable.get(fmt.Sprintf("key%d", i)); !cacheSync.DiskSynced || !cacheSync.S3Sync {
+			t.Errorf("Expected key%d to be flushed, got %+v", i, cacheSync)
+		}
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 0 {
+		t.Errorf("Expected the queue to be drained, got a depth of %d", depth)
+	}
+
+	// The disk tier is closed, releasing its directory.
+	if CacheMachine.DiskCache != nil {
+		t.Errorf("Expected the disk cache to be closed")
+	}
+	reopened, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if err := reopened.EnableDiskCache(1024*1024, tmpFolder); err != nil {
+		t.Errorf("Expected the directory to be released, got %s", err)
+	}
+	reopened.DisableDiskCache()
+}
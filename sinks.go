@@ -0,0 +1,334 @@
+package cachemachine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSinkInterval is the push interval of the sinks added with an
+// interval of 0.
+const DefaultSinkInterval = 10 * time.Second
+
+// sinkPushTimeout bounds every push to a sink.
+const sinkPushTimeout = 10 * time.Second
+
+// Sink receives the stats of a cache machine at a regular interval, see
+// AddSink. Sinks that also implement io.Closer are closed by Close, after a
+// final push.
+type Sink interface {
+	Push(ctx context.Context, stats Stats) error
+}
+
+// sinkRunner pushes the stats to a sink at a regular interval.
+type sinkRunner struct {
+	sink Sink
+	quit chan struct{}
+	done chan struct{}
+}
+
+// AddSink pushes the stats of the cache machine to sink every interval,
+// until Close is called. Failed pushes are logged.
+func (c *CacheMachine) AddSink(sink Sink, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSinkInterval
+	}
+	runner := &sinkRunner{
+		sink: sink,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	c.sinksMu.Lock()
+	c.sinks = append(c.sinks, runner)
+	c.sinksMu.Unlock()
+
+	go func() {
+		defer close(runner.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.pushStats(runner.sink)
+			case <-runner.quit:
+				return
+			}
+		}
+	}()
+}
+
+// closeSinks stops pushing stats to the sinks, after a final push so that
+// the last counters are not lost, and closes the sinks implementing
+// io.Closer. It returns the first error closing a sink.
+func (c *CacheMachine) closeSinks() error {
+	c.sinksMu.Lock()
+	sinks := c.sinks
+	c.sinks = nil
+	c.sinksMu.Unlock()
+
+	var firstErr error
+	for _, runner := range sinks {
+		close(runner.quit)
+		<-runner.done
+		c.pushStats(runner.sink)
+		if closer, ok := runner.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("error closing sink: %s", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (c *CacheMachine) pushStats(sink Sink) {
+	ctx, cancel := context.WithTimeout(context.Background(), sinkPushTimeout)
+	defer cancel()
+	if err := sink.Push(ctx, c.Stats()); err != nil {
+		c.Logger.Warn("error pushing stats", "error", err)
+		c.recordError("error pushing stats", "error", err)
+	}
+}
+
+// PrometheusSink keeps the stats last pushed to it, and serves them to
+// Prometheus scrapes in the text exposition format. Its push interval should
+// not exceed the scrape interval.
+type PrometheusSink struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Push implements Sink.
+func (s *PrometheusSink) Push(ctx context.Context, stats Stats) error {
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w, stats)
+}
+
+// statsdMaxPacketSize keeps the statsd packets within the usual MTU.
+const statsdMaxPacketSize = 1432
+
+// StatsdSink pushes the stats to a statsd server over UDP. Counters are sent
+// as the increments since the previous push, and the others as gauges.
+type StatsdSink struct {
+	prefix string
+	conn   net.Conn
+
+	mu       sync.Mutex
+	previous map[string]float64
+}
+
+// NewStatsdSink returns a StatsdSink sending to the statsd server at addr,
+// with metric names starting with prefix, such as "cachemachine".
+func NewStatsdSink(addr, prefix string) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to statsd at %s: %s", addr, err)
+	}
+	return &StatsdSink{
+		prefix:   prefix,
+		conn:     conn,
+		previous: make(map[string]float64),
+	}, nil
+}
+
+// Push implements Sink.
+func (s *StatsdSink) Push(ctx context.Context, stats Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var packet bytes.Buffer
+	for _, metric := range stats.metrics() {
+		name := s.prefix + "." + strings.TrimSuffix(strings.TrimPrefix(metric.name, "cachemachine_"), "_total")
+		var line string
+		if metric.kind == "counter" {
+			delta := metric.value - s.previous[metric.name]
+			s.previous[metric.name] = metric.value
+			if delta <= 0 {
+				continue
+			}
+			line = name + ":" + strconv.FormatFloat(delta, 'g', -1, 64) + "|c\n"
+		} else {
+			line = name + ":" + strconv.FormatFloat(metric.value, 'g', -1, 64) + "|g\n"
+		}
+		if packet.Len()+len(line) > statsdMaxPacketSize {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return fmt.Errorf("error sending to statsd: %s", err)
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			return fmt.Errorf("error sending to statsd: %s", err)
+		}
+	}
+	return nil
+}
+
+// Close implements io.Closer.
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
+
+// OTLPSink pushes the stats to an OpenTelemetry collector with OTLP over
+// HTTP, using the JSON encoding. Counters are sent as cumulative monotonic
+// sums, and the others as gauges.
+type OTLPSink struct {
+	// Endpoint is the URL the metrics are posted to, such as
+	// "http://localhost:4318/v1/metrics".
+	Endpoint string
+
+	// Client sends the requests, http.DefaultClient when nil, and Headers
+	// are added to them, such as authentication headers.
+	Client  *http.Client
+	Headers map[string]string
+
+	// ServiceName is the service.name attribute of the resource the metrics
+	// are reported for.
+	ServiceName string
+
+	start time.Time
+}
+
+// NewOTLPSink returns an OTLPSink posting to endpoint.
+func NewOTLPSink(endpoint string) *OTLPSink {
+	return &OTLPSink{
+		Endpoint:    endpoint,
+		ServiceName: "cachemachine",
+		start:       time.Now(),
+	}
+}
+
+// Push implements Sink.
+func (s *OTLPSink) Push(ctx context.Context, stats Stats) error {
+	type value struct {
+		StringValue string `json:"stringValue"`
+	}
+	type attribute struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	type dataPoint struct {
+		StartTimeUnixNano string  `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string  `json:"timeUnixNano"`
+		AsDouble          float64 `json:"asDouble"`
+	}
+	type sum struct {
+		DataPoints             []dataPoint `json:"dataPoints"`
+		AggregationTemporality int         `json:"aggregationTemporality"`
+		IsMonotonic            bool        `json:"isMonotonic"`
+	}
+	type gauge struct {
+		DataPoints []dataPoint `json:"dataPoints"`
+	}
+	type otlpMetric struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Sum         *sum   `json:"sum,omitempty"`
+		Gauge       *gauge `json:"gauge,omitempty"`
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := strconv.FormatInt(s.start.UnixNano(), 10)
+	var metrics []otlpMetric
+	for _, metric := range stats.metrics() {
+		m := otlpMetric{
+			Name:        "cachemachine." + strings.TrimSuffix(strings.TrimPrefix(metric.name, "cachemachine_"), "_total"),
+			Description: metric.help,
+		}
+		if metric.kind == "counter" {
+			// 2 is AGGREGATION_TEMPORALITY_CUMULATIVE.
+			m.Sum = &sum{
+				DataPoints:             []dataPoint{{StartTimeUnixNano: start, TimeUnixNano: now, AsDouble: metric.value}},
+				AggregationTemporality: 2,
+				IsMonotonic:            true,
+			}
+		} else {
+			m.Gauge = &gauge{DataPoints: []dataPoint{{TimeUnixNano: now, AsDouble: metric.value}}}
+		}
+		metrics = append(metrics, m)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []attribute{{Key: "service.name", Value: value{s.ServiceName}}},
+			},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]string{"name": "github.com/cdemers/cachemachine"},
+				"metrics": metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding metrics: %s", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.Headers {
+		req.Header.Set(key, value)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting metrics to %s: %s", s.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error posting metrics to %s: %s", s.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// JSONFileSink writes the stats last pushed to it to a file, as JSON. The
+// file is replaced atomically, so that readers never see a partial write.
+type JSONFileSink struct {
+	Path string
+}
+
+// Push implements Sink.
+func (s *JSONFileSink) Push(ctx context.Context, stats Stats) error {
+	data, err := json.MarshalIndent(struct {
+		Time  time.Time `json:"time"`
+		Stats Stats     `json:"stats"`
+	}{time.Now(), stats}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding stats: %s", err)
+	}
+	tmpPath := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing stats to %s: %s", s.Path, err)
+	}
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		return fmt.Errorf("error writing stats to %s: %s", s.Path, err)
+	}
+	return nil
+}
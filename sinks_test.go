@@ -0,0 +1,166 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_JSONFileSink(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	path := filepath.Join(tmpFolder, "stats.json")
+	CacheMachine.AddSink(&JSONFileSink{Path: path}, time.Hour)
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Get("key1")
+
+	// Nothing is pushed before the interval, but Close flushes.
+	if err := CacheMachine.Close(); err != nil {
+		t.Errorf("Expected no error closing, got %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the stats to be written, got %s", err)
+	}
+	var pushed struct {
+		Stats Stats `json:"stats"`
+	}
+	json.Unmarshal(data, &pushed)
+	if pushed.Stats.RamHits != 1 || pushed.Stats.SetCount != 1 {
+		t.Errorf("Expected 1 RAM hit and 1 set, got %+v", pushed.Stats)
+	}
+}
+
+func TestCacheMachine_PrometheusSink(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	sink := &PrometheusSink{}
+	CacheMachine.AddSink(sink, 10*time.Millisecond)
+	defer CacheMachine.Close()
+	CacheMachine.Get("key1")
+	time.Sleep(50 * time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	sink.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "cachemachine_misses_total 1\n") {
+		t.Errorf("Expected the pushed stats to be served, got %s", recorder.Body.String())
+	}
+}
+
+func TestCacheMachine_StatsdSink(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer conn.Close()
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "app.cache")
+	if err != nil {
+		t.Fatalf("Expected no error creating the statsd sink, got %s", err)
+	}
+	CacheMachine.AddSink(sink, time.Hour)
+
+	CacheMachine.Get("key1")
+	CacheMachine.Get("key2")
+	CacheMachine.Close()
+
+	var received strings.Builder
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		received.Write(buf[:n])
+	}
+	if !strings.Contains(received.String(), "app.cache.misses:2|c\n") {
+		t.Errorf("Expected the misses to be counted, got %s", received.String())
+	}
+	if !strings.Contains(received.String(), "app.cache.sync_queue_depth:0|g\n") {
+		t.Errorf("Expected the sync queue depth gauge, got %s", received.String())
+	}
+	if strings.Contains(received.String(), "app.cache.ram_hits:") {
+		t.Errorf("Expected unchanged counters not to be sent, got %s", received.String())
+	}
+}
+
+func TestCacheMachine_OTLPSink(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	var mu sync.Mutex
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	sink := NewOTLPSink(server.URL + "/v1/metrics")
+	sink.Headers = map[string]string{"Authorization": "Bearer token"}
+	CacheMachine.AddSink(sink, time.Hour)
+	CacheMachine.Get("key1")
+	CacheMachine.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if header.Get("Authorization") != "Bearer token" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+	var request struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name string `json:"name"`
+					Sum  *struct {
+						DataPoints []struct {
+							AsDouble float64 `json:"asDouble"`
+						} `json:"dataPoints"`
+						IsMonotonic bool `json:"isMonotonic"`
+					} `json:"sum"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.ResourceMetrics) != 1 {
+		t.Fatalf("Unexpected request %s", body)
+	}
+	var found bool
+	for _, m := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == "cachemachine.misses" {
+			found = m.Sum != nil && m.Sum.IsMonotonic && m.Sum.DataPoints[0].AsDouble == 1
+		}
+	}
+	if !found {
+		t.Errorf("Expected the misses to be sent as a sum of 1, got %s", body)
+	}
+}
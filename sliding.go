@@ -0,0 +1,119 @@
+package cachemachine
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slidingGranularity is the smallest extension of the TTL of an entry by a
+// read, the RAM cache counting TTLs in seconds.
+const slidingGranularity = time.Second
+
+// slidingExpiration is the sliding expiration of a namespace.
+type slidingExpiration struct {
+	ttl         time.Duration
+	maxLifetime time.Duration
+}
+
+// slidingPolicies maps namespaces to their sliding expiration. The map is
+// replaced rather than updated, so that reads do not lock.
+type slidingPolicies struct {
+	mu       sync.Mutex
+	policies atomic.Value
+}
+
+func (p *slidingPolicies) get(namespace string) (slidingExpiration, bool) {
+	policies, _ := p.policies.Load().(map[string]slidingExpiration)
+	policy, ok := policies[namespace]
+	return policy, ok
+}
+
+func (p *slidingPolicies) set(namespace string, policy *slidingExpiration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous, _ := p.policies.Load().(map[string]slidingExpiration)
+	policies := make(map[string]slidingExpiration, len(previous)+1)
+	for name, policy := range previous {
+		policies[name] = policy
+	}
+	if policy == nil {
+		delete(policies, namespace)
+	} else {
+		policies[namespace] = *policy
+	}
+	p.policies.Store(policies)
+}
+
+// EnableSlidingExpiration makes the entries of namespace, see KeyNamespace,
+// expire after ttl without being read rather than after a fixed TTL, which
+// suits session-like data. The entries set without a TTL get ttl, and
+// every Get or MGet finding an entry pushes its expiry back to ttl from
+// then, but no further than maxLifetime after it was set. A maxLifetime of
+// 0 lets the entries read often enough live forever.
+//
+// Reads of the namespace take the lock of the key when they extend its TTL,
+// which they do at most once per second. TryGet never extends TTLs.
+func (c *CacheMachine) EnableSlidingExpiration(namespace string, ttl, maxLifetime time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be greater than 0")
+	}
+	if maxLifetime != 0 && maxLifetime < ttl {
+		return fmt.Errorf("maxLifetime must be at least ttl")
+	}
+	c.sliding.set(namespace, &slidingExpiration{ttl: ttl, maxLifetime: maxLifetime})
+	return nil
+}
+
+// DisableSlidingExpiration restores the fixed expiration of the entries of
+// namespace. The TTLs extended so far are kept.
+func (c *CacheMachine) DisableSlidingExpiration(namespace string) {
+	c.sliding.set(namespace, nil)
+}
+
+// slidingTTL returns the TTL of an entry of key set without one.
+func (c *CacheMachine) slidingTTL(key string) time.Duration {
+	policy, ok := c.sliding.get(KeyNamespace(key))
+	if !ok {
+		return 0
+	}
+	return policy.ttl
+}
+
+// slideExpiration extends the TTL of the entry for key, just read, if its
+// namespace has a sliding expiration.
+func (c *CacheMachine) slideExpiration(key string) {
+	policy, ok := c.sliding.get(KeyNamespace(key))
+	if !ok {
+		return
+	}
+	// The RAM cache tells without locking the key whether the TTL was
+	// extended less than a second ago.
+	if left, err := c.RamCache.TTL([]byte(key)); err == nil && left > 0 &&
+		time.Duration(left)*time.Second+slidingGranularity > policy.ttl {
+		return
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	now := time.Now()
+	cacheSync, ok := shard.entries[key]
+	if !ok || cacheSync.Negative || cacheSync.ExpiresAt.IsZero() || cacheSync.expired(now) {
+		return
+	}
+	expiresAt := now.Add(policy.ttl)
+	if policy.maxLifetime > 0 {
+		if limit := cacheSync.SetAt.Add(policy.maxLifetime); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	if expiresAt.Sub(cacheSync.ExpiresAt) < slidingGranularity {
+		return
+	}
+	cacheSync.ExpiresAt = expiresAt
+	shard.entries[key] = cacheSync
+	c.RamCache.Touch([]byte(key), int((expiresAt.Sub(now)+time.Second-1)/time.Second))
+}
@@ -0,0 +1,69 @@
+package cachemachine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SlidingExpiration(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if err := CacheMachine.EnableSlidingExpiration("sessions", time.Minute, time.Second); err == nil {
+		t.Errorf("Expected an error with a max lifetime shorter than the TTL")
+	}
+	if err := CacheMachine.EnableSlidingExpiration("sessions", time.Minute, 5*time.Minute); err != nil {
+		t.Fatalf("Expected no error enabling sliding expiration, got %s", err)
+	}
+
+	// Entries set without a TTL get the sliding TTL of their namespace.
+	CacheMachine.Set("sessions:1", []byte("12345"))
+	CacheMachine.Set("users:1", []byte("67890"))
+	state, _ := CacheMachine.SyncState("sessions:1")
+	if d := time.Until(state.ExpiresAt); d < 59*time.Second || d > time.Minute {
+		t.Errorf("Expected sessions:1 to expire in a minute, got %s", d)
+	}
+	if state, _ := CacheMachine.SyncState("users:1"); !state.ExpiresAt.IsZero() {
+		t.Errorf("Expected users:1 never to expire, got %s", state.ExpiresAt)
+	}
+
+	// Reads push the expiry back, the RAM cache included.
+	backdate := func(key string, set, expires time.Duration) {
+		shard := CacheMachine.syncTable.shard(key)
+		shard.Lock()
+		cacheSync := shard.entries[key]
+		cacheSync.SetAt = time.Now().Add(-set)
+		cacheSync.ExpiresAt = time.Now().Add(expires)
+		shard.entries[key] = cacheSync
+		shard.Unlock()
+		CacheMachine.RamCache.Touch([]byte(key), int(expires/time.Second))
+	}
+	backdate("sessions:1", 30*time.Second, 30*time.Second)
+	if _, ok := CacheMachine.Get("sessions:1"); !ok {
+		t.Fatalf("Expected sessions:1 to be found")
+	}
+	state, _ = CacheMachine.SyncState("sessions:1")
+	if d := time.Until(state.ExpiresAt); d < 59*time.Second {
+		t.Errorf("Expected sessions:1 to expire in a minute after being read, got %s", d)
+	}
+	if left, err := CacheMachine.RamCache.TTL([]byte("sessions:1")); err != nil || left < 59 {
+		t.Errorf("Expected the RAM TTL of sessions:1 to be extended, got %d, %v", left, err)
+	}
+
+	// The expiry is not pushed back beyond the max lifetime.
+	backdate("sessions:1", 270*time.Second, 10*time.Second)
+	CacheMachine.MGet([]string{"sessions:1"})
+	state, _ = CacheMachine.SyncState("sessions:1")
+	if d := time.Until(state.ExpiresAt); d < 29*time.Second || d > 30*time.Second {
+		t.Errorf("Expected sessions:1 to expire at the end of its lifetime, in 30s, got %s", d)
+	}
+
+	CacheMachine.DisableSlidingExpiration("sessions")
+	backdate("sessions:1", 0, 10*time.Second)
+	CacheMachine.Get("sessions:1")
+	state, _ = CacheMachine.SyncState("sessions:1")
+	if d := time.Until(state.ExpiresAt); d > 10*time.Second {
+		t.Errorf("Expected the TTL of sessions:1 not to be extended anymore, got %s", d)
+	}
+}
@@ -0,0 +1,191 @@
+package cachemachine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotMagic starts every snapshot, followed by the format version.
+const (
+	snapshotMagic   = "CMSNAP"
+	snapshotVersion = 1
+)
+
+const (
+	snapshotFlagDiskSynced = 1 << iota
+	snapshotFlagS3Synced
+)
+
+// ExportSnapshot writes every entry of the RAM and disk tiers of the cache
+// machine to w in a portable binary format that ImportSnapshot can read
+// back, possibly in another process. The entries only held by S3 are not
+// exported, since they outlive the process anyway. Exporting does not affect
+// the access statistics.
+//
+// The format is the "CMSNAP" magic and a version byte, followed by one record
+// per entry made of the key and the value, each prefixed by its length as a
+// uvarint, the set and expiry times as varint unix nanoseconds (0 meaning no
+// expiry), and a flags byte holding the sync state. The last record is
+// followed by a zero uvarint.
+func (c *CacheMachine) ExportSnapshot(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	writer.WriteString(snapshotMagic)
+	writer.WriteByte(snapshotVersion)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		writer.Write(buf[:binary.PutUvarint(buf, v)])
+	}
+	writeVarint := func(v int64) {
+		writer.Write(buf[:binary.PutVarint(buf, v)])
+	}
+
+	now := time.Now()
+	for _, key := range c.keys() {
+		shard := c.syncTable.shard(key)
+		shard.Lock()
+		cacheSync, ok := shard.entries[key]
+		value, found := c.peek(shard, key)
+		shard.Unlock()
+		if !ok || !found || cacheSync.expired(now) {
+			continue
+		}
+
+		var expiresAt int64
+		if !cacheSync.ExpiresAt.IsZero() {
+			expiresAt = cacheSync.ExpiresAt.UnixNano()
+		}
+		var flags byte
+		if cacheSync.DiskSynced {
+			flags |= snapshotFlagDiskSynced
+		}
+		if cacheSync.S3Sync {
+			flags |= snapshotFlagS3Synced
+		}
+
+		// Keys are never empty, so a zero length marks the end.
+		writeUvarint(uint64(len(key)))
+		writer.WriteString(key)
+		writeUvarint(uint64(len(value)))
+		writer.Write(value)
+		writeVarint(cacheSync.SetAt.UnixNano())
+		writeVarint(expiresAt)
+		writer.WriteByte(flags)
+	}
+	writeUvarint(0)
+
+	return writer.Flush()
+}
+
+// ImportSnapshot reads a snapshot written by ExportSnapshot from r and sets
+// its entries in the RAM cache, keeping their remaining TTL and the time they
+// were originally set. The entries are synced to the disk cache of this
+// cache machine by the next sync, whatever their sync state was when they
+// were exported. Entries that expired since the export are skipped. A
+// snapshot holding a key longer than MaxKeySize or a value larger than
+// MaxEntrySize is rejected.
+func (c *CacheMachine) ImportSnapshot(r io.Reader) error {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("error reading snapshot header: %s", err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("not a cache machine snapshot")
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header[len(snapshotMagic)])
+	}
+
+	// The lengths are checked before allocating, since the snapshot may
+	// not come from a cache machine.
+	readBytes := func(limit int) ([]byte, error) {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(limit) {
+			return nil, fmt.Errorf("record of %d bytes exceeds the limit of %d bytes", length, limit)
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		return data, err
+	}
+
+	for {
+		key, err := readBytes(MaxKeySize)
+		if err != nil {
+			return fmt.Errorf("error reading snapshot: %s", unexpectedEOF(err))
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		value, err := readBytes(c.MaxEntrySize())
+		if err != nil {
+			return fmt.Errorf("error reading snapshot: %s", unexpectedEOF(err))
+		}
+		setAt, err := binary.ReadVarint(reader)
+		if err != nil {
+			return fmt.Errorf("error reading snapshot: %s", unexpectedEOF(err))
+		}
+		expiresAt, err := binary.ReadVarint(reader)
+		if err != nil {
+			return fmt.Errorf("error reading snapshot: %s", unexpectedEOF(err))
+		}
+		if _, err := reader.ReadByte(); err != nil {
+			return fmt.Errorf("error reading snapshot: %s", unexpectedEOF(err))
+		}
+
+		var ttl time.Duration
+		if expiresAt != 0 {
+			ttl = time.Until(time.Unix(0, expiresAt))
+			if ttl <= 0 {
+				continue
+			}
+		}
+
+		shard := c.syncTable.shard(string(key))
+		shard.Lock()
+		err = c.set(shard, string(key), value, ttl)
+		if err == nil {
+			cacheSync := shard.entries[string(key)]
+			cacheSync.SetAt = time.Unix(0, setAt)
+			shard.entries[string(key)] = cacheSync
+		}
+		shard.Unlock()
+		if err != nil {
+			return fmt.Errorf("error importing snapshot: %s", err)
+		}
+	}
+}
+
+// peek returns the value for the given key from the RAM cache, falling back
+// to the disk cache, without affecting the access statistics of the tiers.
+// It must be called with the stripe of the key locked.
+func (c *CacheMachine) peek(shard *syncTableShard, key string) ([]byte, bool) {
+	value, err := c.RamCache.Peek([]byte(key))
+	if err == nil {
+		return value, true
+	}
+	if shard.entries[key].DiskSynced && c.DiskCache != nil {
+		value, err = c.DiskCache.Peek(key)
+		if err == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, since a snapshot must
+// always end with its end marker.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
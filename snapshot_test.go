@@ -0,0 +1,89 @@
+package cachemachine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_Snapshot(t *testing.T) {
+	source, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = source.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer source.DisableDiskCache()
+
+	source.Set("key1", []byte("12345"))
+	source.Flush()
+	// key1 is only on disk, key2 only in RAM, key3 expires in a minute.
+	source.RamCache.Del([]byte("key1"))
+	source.Set("key2", []byte("67890"))
+	shard := source.syncTable.shard("key3")
+	shard.Lock()
+	source.set(shard, "key3", []byte("abcde"), time.Minute)
+	shard.Unlock()
+
+	var buf bytes.Buffer
+	err = source.ExportSnapshot(&buf)
+	if err != nil {
+		t.Errorf("Expected no error exporting snapshot, got %s", err)
+	}
+
+	target, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	err = target.ImportSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Errorf("Expected no error importing snapshot, got %s", err)
+	}
+
+	for key, expected := range map[string]string{"key1": "12345", "key2": "67890", "key3": "abcde"} {
+		value, ok := target.Get(key)
+		if !ok || string(value) != expected {
+			t.Errorf("Expected %s to be %s, got %s", key, expected, value)
+		}
+	}
+
+	source1, _ := source.SyncState("key1")
+	target1, _ := target.SyncState("key1")
+	if !target1.SetAt.Equal(source1.SetAt) || target1.DiskSynced {
+		t.Errorf("Expected key1 to keep its set time and to be dirty, got %+v", target1)
+	}
+	target3, _ := target.SyncState("key3")
+	if ttl := time.Until(target3.ExpiresAt); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected key3 to keep its TTL, got %s", ttl)
+	}
+
+	err = target.ImportSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		t.Errorf("Expected error importing a truncated snapshot")
+	}
+	err = target.ImportSnapshot(bytes.NewReader([]byte("garbage")))
+	if err == nil {
+		t.Errorf("Expected error importing garbage")
+	}
+
+	// A huge length must be rejected rather than allocated.
+	huge := append([]byte("CMSNAP\x01"), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01)
+	err = target.ImportSnapshot(bytes.NewReader(huge))
+	if err == nil {
+		t.Errorf("Expected error importing a snapshot with a huge key length")
+	}
+	huge = append([]byte("CMSNAP\x01\x01k"), 0xff, 0xff, 0xff, 0x7f)
+	err = target.ImportSnapshot(bytes.NewReader(huge))
+	if err == nil {
+		t.Errorf("Expected error importing a snapshot with a huge value length")
+	}
+}
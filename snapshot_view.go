@@ -0,0 +1,251 @@
+package cachemachine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is a read-only view of the entries of a cache machine as they
+// were when it was taken, see CacheMachine.Snapshot. It is safe for
+// concurrent use.
+type Snapshot struct {
+	c          *CacheMachine
+	generation uint64
+	takenAt    time.Time
+
+	// mu guards the fields below. The entries are the metadata of the
+	// live entries when the snapshot was taken, and preserved the state
+	// of the keys set or removed since, before their first change.
+	mu        sync.Mutex
+	entries   map[string]CacheSyncTable
+	keys      []string
+	preserved map[string]preservedEntry
+	closed    bool
+}
+
+// preservedEntry is the state of a key when a snapshot was taken, saved
+// before the key was first changed since.
+type preservedEntry struct {
+	cacheSync CacheSyncTable
+	present   bool
+	value     []byte
+	found     bool
+}
+
+// Snapshot returns a view of the entries of the cache machine as they are
+// now, for iterations, exports and analytics that must neither block the
+// cache machine while they scan it, nor observe the changes made meanwhile.
+// Taking a snapshot briefly locks every stripe of the sync table, and
+// copies the metadata of the entries one stripe at a time. The values are
+// read from the tiers when the snapshot is read, but for the values
+// replaced or deleted since, which are saved by the Set and Delete calls
+// changing them until the snapshot is closed. Such calls therefore read the
+// values they replace while a snapshot is open, from disk if need be, so
+// snapshots must be closed once read.
+//
+// The values only held by S3, and the entries lost to evictions or to
+// ClearRamCache, ClearDiskCache and ClearAll since the snapshot was taken,
+// are missing from it. The entries purged since are removed from it.
+func (c *CacheMachine) Snapshot() *Snapshot {
+	s := &Snapshot{
+		c:         c,
+		takenAt:   time.Now(),
+		entries:   make(map[string]CacheSyncTable),
+		preserved: make(map[string]preservedEntry),
+	}
+
+	// The snapshot is registered with every stripe locked, so that every
+	// change is made either before it, or after it and preserved.
+	c.syncTable.lockAll()
+	s.generation = atomic.LoadUint64(&c.generation)
+	c.snapshotsMu.Lock()
+	c.snapshots = append(c.snapshots, s)
+	atomic.StoreInt32(&c.openSnapshots, int32(len(c.snapshots)))
+	c.snapshotsMu.Unlock()
+	c.syncTable.unlockAll()
+
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		s.mu.Lock()
+		for key, cacheSync := range shard.entries {
+			if _, ok := s.preserved[key]; !ok && cacheSync.generation <= s.generation && s.live(cacheSync) {
+				s.entries[key] = cacheSync
+			}
+		}
+		s.mu.Unlock()
+		shard.Unlock()
+	}
+	s.mu.Lock()
+	for key, entry := range s.preserved {
+		if entry.present && s.live(entry.cacheSync) {
+			s.entries[key] = entry.cacheSync
+		}
+	}
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.keys = keys
+	s.mu.Unlock()
+	return s
+}
+
+func (s *Snapshot) live(cacheSync CacheSyncTable) bool {
+	return !cacheSync.Negative && !cacheSync.expired(s.takenAt)
+}
+
+// Generation returns the generation of the cache machine the snapshot was
+// taken at. Every entry set since has a greater one.
+func (s *Snapshot) Generation() uint64 {
+	return s.generation
+}
+
+// TakenAt returns when the snapshot was taken.
+func (s *Snapshot) TakenAt() time.Time {
+	return s.takenAt
+}
+
+// Len returns the number of entries of the snapshot.
+func (s *Snapshot) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+// Keys returns the sorted keys of the entries of the snapshot.
+func (s *Snapshot) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+// Get returns the value of key as it was when the snapshot was taken,
+// without affecting the access statistics of the cache machine.
+func (s *Snapshot) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	if _, ok := s.entries[key]; !ok || s.closed {
+		s.mu.Unlock()
+		return nil, false
+	}
+	if entry, ok := s.preserved[key]; ok {
+		s.mu.Unlock()
+		return entry.value, entry.found
+	}
+	s.mu.Unlock()
+
+	// The stripe of the key is locked before the snapshot, like the
+	// writers preserving values do.
+	shard := s.c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.preserved[key]; ok {
+		return entry.value, entry.found
+	}
+	if cacheSync, ok := shard.entries[key]; !ok || cacheSync.generation > s.generation {
+		return nil, false
+	}
+	return s.c.peek(shard, key)
+}
+
+// Range calls fn with every entry of the snapshot, in the order of their
+// keys, until fn returns false. The entries missing from the snapshot, see
+// CacheMachine.Snapshot, are skipped.
+func (s *Snapshot) Range(fn func(key string, value []byte) bool) {
+	for _, key := range s.Keys() {
+		value, ok := s.Get(key)
+		if !ok {
+			continue
+		}
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// Close releases the snapshot, after which the changes made to the cache
+// machine stop preserving the values it holds, and it reads no value
+// anymore.
+func (s *Snapshot) Close() {
+	c := s.c
+	c.snapshotsMu.Lock()
+	for i, snapshot := range c.snapshots {
+		if snapshot == s {
+			c.snapshots = append(c.snapshots[:i:i], c.snapshots[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&c.openSnapshots, int32(len(c.snapshots)))
+	c.snapshotsMu.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	s.preserved = nil
+	s.mu.Unlock()
+}
+
+// openSnapshotList returns the open snapshots, or nil.
+func (c *CacheMachine) openSnapshotList() []*Snapshot {
+	if atomic.LoadInt32(&c.openSnapshots) == 0 {
+		return nil
+	}
+	c.snapshotsMu.Lock()
+	defer c.snapshotsMu.Unlock()
+	return append([]*Snapshot(nil), c.snapshots...)
+}
+
+// preserveForSnapshots saves the current state of key in the open
+// snapshots that have not saved it yet, before it is changed. It must be
+// called with the stripe of the key locked.
+func (c *CacheMachine) preserveForSnapshots(shard *syncTableShard, key string) {
+	snapshots := c.openSnapshotList()
+	if snapshots == nil {
+		return
+	}
+	cacheSync, present := shard.entries[key]
+	var value []byte
+	var found, read bool
+	for _, s := range snapshots {
+		s.mu.Lock()
+		if _, ok := s.preserved[key]; !ok && !s.closed {
+			if present && cacheSync.generation > s.generation {
+				// Set after the snapshot was taken, which preserved the
+				// state before.
+				s.mu.Unlock()
+				continue
+			}
+			if present && !read {
+				value, found = c.peek(shard, key)
+				read = true
+			}
+			s.preserved[key] = preservedEntry{cacheSync: cacheSync, present: present, value: value, found: found}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// forgetInSnapshots removes key from the open snapshots. It must be called
+// with the stripe of the key locked.
+func (c *CacheMachine) forgetInSnapshots(key string) {
+	for _, s := range c.openSnapshotList() {
+		s.mu.Lock()
+		if !s.closed {
+			s.preserved[key] = preservedEntry{}
+			delete(s.entries, key)
+			if i := sort.SearchStrings(s.keys, key); i < len(s.keys) && s.keys[i] == key {
+				s.keys = append(s.keys[:i:i], s.keys[i+1:]...)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// nextGeneration returns the generation of an entry being set.
+func (c *CacheMachine) nextGeneration() uint64 {
+	return atomic.AddUint64(&c.generation, 1)
+}
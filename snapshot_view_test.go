@@ -0,0 +1,101 @@
+package cachemachine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCacheMachine_SnapshotView(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("value1"))
+	CacheMachine.Set("key2", []byte("value2"))
+	CacheMachine.SetWithOptions("key3", []byte("value3"), WithSubject("subject"))
+	CacheMachine.Flush()
+
+	snapshot := CacheMachine.Snapshot()
+	defer snapshot.Close()
+
+	if snapshot.Len() != 3 {
+		t.Errorf("Expected 3 entries in the snapshot, got %d", snapshot.Len())
+	}
+
+	// Changes made after the snapshot was taken are not visible in it.
+	CacheMachine.Set("key1", []byte("changed"))
+	CacheMachine.Delete("key2")
+	CacheMachine.Set("key4", []byte("value4"))
+
+	val, ok := snapshot.Get("key1")
+	if !ok || string(val) != "value1" {
+		t.Errorf("Expected key1 to be value1 in the snapshot, got %q, %t", val, ok)
+	}
+	val, ok = snapshot.Get("key2")
+	if !ok || string(val) != "value2" {
+		t.Errorf("Expected key2 to be value2 in the snapshot, got %q, %t", val, ok)
+	}
+	if _, ok = snapshot.Get("key4"); ok {
+		t.Errorf("Expected key4 to be missing from the snapshot")
+	}
+	val, ok = CacheMachine.Get("key1")
+	if !ok || string(val) != "changed" {
+		t.Errorf("Expected key1 to be changed in the cache, got %q, %t", val, ok)
+	}
+
+	// Purged entries are removed from the snapshot.
+	_, err = CacheMachine.PurgeBySubject(context.Background(), "subject")
+	if err != nil {
+		t.Errorf("Expected no error purging subject, got %s", err)
+	}
+	if _, ok = snapshot.Get("key3"); ok {
+		t.Errorf("Expected key3 to be purged from the snapshot")
+	}
+
+	expected := []string{"key1", "key2"}
+	if keys := snapshot.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+
+	ranged := map[string]string{}
+	snapshot.Range(func(key string, value []byte) bool {
+		ranged[key] = string(value)
+		return true
+	})
+	if !reflect.DeepEqual(ranged, map[string]string{"key1": "value1", "key2": "value2"}) {
+		t.Errorf("Unexpected entries ranged over: %v", ranged)
+	}
+
+	later := CacheMachine.Snapshot()
+	defer later.Close()
+	if later.Generation() <= snapshot.Generation() {
+		t.Errorf("Expected a later snapshot to have a higher generation")
+	}
+	if _, ok = later.Get("key4"); !ok {
+		t.Errorf("Expected key4 to be in the later snapshot")
+	}
+
+	// Closing the snapshot stops preserving the values for it.
+	snapshot.Close()
+	CacheMachine.Set("key1", []byte("changed again"))
+	if _, ok = snapshot.Get("key1"); ok {
+		t.Errorf("Expected a closed snapshot to hold no entries")
+	}
+	if len(CacheMachine.openSnapshotList()) != 1 {
+		t.Errorf("Expected 1 open snapshot, got %d", len(CacheMachine.openSnapshotList()))
+	}
+}
@@ -0,0 +1,550 @@
+package cachemachine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time copy of the counters of a CacheMachine. It is
+// meant to quantify the overhead of the write-behind design: every byte
+// accepted by Set may be written to disk several times if it is synced,
+// evicted and set again.
+type Stats struct {
+	RamHits     int64
+	DiskHits    int64
+	S3Hits      int64
+	Misses      int64
+	BytesServed int64
+
+	// NegativeHits is the number of Get calls answered by a negative entry,
+	// see SetNegative. They are not counted as misses.
+	NegativeHits int64
+
+	SetCount int64
+	SetBytes int64
+
+	// AdmissionRejections is the number of values set that were not stored
+	// in RAM, their key being requested less than AdmissionMinFrequency.
+	AdmissionRejections int64
+
+	// SyncCoalesced is the number of values set that replaced a value still
+	// waiting to be synced, which was therefore never written.
+	SyncCoalesced int64
+
+	// ThrashDiversions is the number of values set that were written
+	// straight to disk, their key thrashing the RAM cache, see
+	// EnableThrashProtection.
+	ThrashDiversions int64
+
+	DiskWriteCount int64
+	DiskWriteBytes int64
+	DiskReadBytes  int64
+
+	// DiskRemovedBytes and DiskEvictions account for the IO spent by the
+	// disk engine removing entries, which is the closest thing it has to a
+	// compaction.
+	DiskRemovedBytes int64
+	DiskEvictions    int64
+
+	// DiskEvictionVetoes is the number of evictions from disk vetoed by
+	// OnBeforeEvict.
+	DiskEvictionVetoes int64
+
+	// DiskCorruptions and S3Corruptions are the number of entries read from
+	// disk and S3 that did not match their checksum, and were deleted.
+	DiskCorruptions int64
+	S3Corruptions   int64
+
+	S3WriteCount int64
+	S3WriteBytes int64
+
+	// S3Refreshes is the number of stale entries read from S3 that were
+	// refreshed, see CacheMachine.S3MaxAge.
+	S3Refreshes int64
+
+	SyncCycles        int64
+	SyncDurationTotal time.Duration
+	SyncDurationLast  time.Duration
+	SyncDurationMax   time.Duration
+
+	// SyncQueueDepth is the number of keys waiting to be synced, and
+	// TrackedKeys the number of keys whose sync state is tracked.
+	SyncQueueDepth int64
+	TrackedKeys    int64
+
+	// SyncQueueOverflows is the number of keys not queued to be synced
+	// because the sync queue of their stripe was full. They are still
+	// synced, the stripe being scanned for them instead.
+	SyncQueueOverflows int64
+
+	// DiskUnderPressure is set when the disk tier is running out of
+	// storage, see CacheMachine.DiskPressure.
+	DiskUnderPressure bool
+
+	// SyncLag is the longest time an entry written to disk by the last sync
+	// waited for it since it was set.
+	SyncLag time.Duration
+
+	// RamEvictions is the number of entries evicted from the RAM cache to
+	// make room for new ones, and UnsyncedEvictions the number of those
+	// that were evicted before being synced to disk, and were lost.
+	RamEvictions      int64
+	UnsyncedEvictions int64
+
+	// DiskReadLatency and S3ReadLatency are the moving averages of the
+	// latency of the reads of the disk and S3 tiers that found their entry,
+	// and TierReorders the number of times they were reordered, see
+	// EnableTierReordering.
+	DiskReadLatency time.Duration
+	S3ReadLatency   time.Duration
+	TierReorders    int64
+
+	// S3DownloadsThrottled is the number of downloads from S3 delayed to
+	// stay under S3DownloadMaxBytesPerSecond, or to let the downloads of
+	// the serving path go first.
+	S3DownloadsThrottled int64
+
+	// IdleEvictions is the number of entries removed from every tier for
+	// not being read, see EnableIdleEviction.
+	IdleEvictions int64
+
+	// InvalidationsDropped is the number of invalidations not broadcast
+	// because the queue of the invalidations waiting to be published was
+	// full, see EnableInvalidation.
+	InvalidationsDropped int64
+
+	// RamEvictionAges and DiskEvictionAges report how long entries lived in
+	// each tier before being evicted. RAM evictions are only noticed for
+	// entries that were evicted before being synced to disk, since freecache
+	// does not report its evictions.
+	RamEvictionAges  AgeHistogram
+	DiskEvictionAges AgeHistogram
+
+	// RamExpiryLags, DiskExpiryLags and S3ExpiryLags report how long after
+	// their expiry entries were actually removed from each tier.
+	RamExpiryLags  ExpiryLags
+	DiskExpiryLags ExpiryLags
+	S3ExpiryLags   ExpiryLags
+
+	// Namespaces holds the stats of every namespace, see KeyNamespace.
+	Namespaces map[string]NamespaceStats
+
+	// RecentErrors holds the last errors met in the background, the most
+	// recent first, see CacheMachine.RecentErrors.
+	RecentErrors []RecentError
+}
+
+// evictionAgeBuckets are the upper bounds of the buckets of an AgeHistogram.
+var evictionAgeBuckets = [...]time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// AgeHistogram is a cumulative histogram of entry ages. Counts[i] is the
+// number of ages lower or equal to Buckets[i], and Count is the total number
+// of ages, including the ones larger than the last bucket.
+type AgeHistogram struct {
+	Buckets []time.Duration
+	Counts  []int64
+	Count   int64
+	Sum     time.Duration
+}
+
+// HitRate returns the fraction of Get calls answered by any tier. It returns
+// 0 when nothing was requested yet.
+func (s Stats) HitRate() float64 {
+	hits := s.RamHits + s.DiskHits + s.S3Hits
+	if hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+s.Misses)
+}
+
+// WriteAmplification returns the number of bytes written to disk per byte
+// accepted by Set. It returns 0 when nothing was set yet.
+func (s Stats) WriteAmplification() float64 {
+	if s.SetBytes == 0 {
+		return 0
+	}
+	return float64(s.DiskWriteBytes) / float64(s.SetBytes)
+}
+
+// statsCounters holds the counters updated on the hot paths. They are only
+// accessed through sync/atomic so that recording a Set never needs a lock.
+type statsCounters struct {
+	ramHits              int64
+	diskHits             int64
+	s3Hits               int64
+	misses               int64
+	negativeHits         int64
+	bytesServed          int64
+	setCount             int64
+	setBytes             int64
+	admissionRejections  int64
+	syncCoalesced        int64
+	thrashDiversions     int64
+	diskWriteCount       int64
+	diskWriteBytes       int64
+	s3WriteCount         int64
+	s3WriteBytes         int64
+	s3Refreshes          int64
+	syncCycles           int64
+	syncDurationTotal    int64
+	syncDurationLast     int64
+	syncDurationMax      int64
+	syncQueueDepth       int64
+	syncQueueOverflows   int64
+	unsyncedEvictions    int64
+	syncLag              int64
+	s3Corruptions        int64
+	diskReadLatency      int64
+	s3ReadLatency        int64
+	tierReorders         int64
+	s3Throttled          int64
+	idleEvictions        int64
+	droppedInvalidations int64
+
+	ramEvictionAges  ageHistogram
+	diskEvictionAges ageHistogram
+
+	ramExpiryLags  expiryLagHistograms
+	diskExpiryLags expiryLagHistograms
+	s3ExpiryLags   expiryLagHistograms
+
+	namespaces namespaceStatsTable
+}
+
+// ageHistogram counts ages per bucket of evictionAgeBuckets, the last slot
+// being for ages larger than every bucket.
+type ageHistogram struct {
+	counts [len(evictionAgeBuckets) + 1]int64
+	sum    int64
+}
+
+func (h *ageHistogram) record(age time.Duration) {
+	i := 0
+	for i < len(evictionAgeBuckets) && age > evictionAgeBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(age))
+}
+
+func (h *ageHistogram) snapshot() AgeHistogram {
+	histogram := AgeHistogram{
+		Buckets: evictionAgeBuckets[:],
+		Counts:  make([]int64, len(evictionAgeBuckets)),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		histogram.Count += atomic.LoadInt64(&h.counts[i])
+		if i < len(histogram.Counts) {
+			histogram.Counts[i] = histogram.Count
+		}
+	}
+	return histogram
+}
+
+func (s *statsCounters) recordRamHit(size int) {
+	atomic.AddInt64(&s.ramHits, 1)
+	atomic.AddInt64(&s.bytesServed, int64(size))
+}
+
+func (s *statsCounters) recordDiskHit(size int) {
+	atomic.AddInt64(&s.diskHits, 1)
+	atomic.AddInt64(&s.bytesServed, int64(size))
+}
+
+func (s *statsCounters) recordS3Hit(size int) {
+	atomic.AddInt64(&s.s3Hits, 1)
+	atomic.AddInt64(&s.bytesServed, int64(size))
+}
+
+func (s *statsCounters) recordMiss() {
+	atomic.AddInt64(&s.misses, 1)
+}
+
+func (s *statsCounters) recordNegativeHit() {
+	atomic.AddInt64(&s.negativeHits, 1)
+}
+
+func (s *statsCounters) recordSet(size int) {
+	atomic.AddInt64(&s.setCount, 1)
+	atomic.AddInt64(&s.setBytes, int64(size))
+}
+
+func (s *statsCounters) recordS3Corruption() {
+	atomic.AddInt64(&s.s3Corruptions, 1)
+}
+
+func (s *statsCounters) recordCoalescedSet() {
+	atomic.AddInt64(&s.syncCoalesced, 1)
+}
+
+func (s *statsCounters) recordAdmissionRejection() {
+	atomic.AddInt64(&s.admissionRejections, 1)
+}
+
+func (s *statsCounters) recordThrashDiversion() {
+	atomic.AddInt64(&s.thrashDiversions, 1)
+}
+
+func (s *statsCounters) recordDiskWrite(size int) {
+	atomic.AddInt64(&s.diskWriteCount, 1)
+	atomic.AddInt64(&s.diskWriteBytes, int64(size))
+}
+
+func (s *statsCounters) recordS3Write(size int) {
+	atomic.AddInt64(&s.s3WriteCount, 1)
+	atomic.AddInt64(&s.s3WriteBytes, int64(size))
+}
+
+func (s *statsCounters) recordSyncCycle(duration time.Duration) {
+	atomic.AddInt64(&s.syncCycles, 1)
+	atomic.AddInt64(&s.syncDurationTotal, int64(duration))
+	atomic.StoreInt64(&s.syncDurationLast, int64(duration))
+	for {
+		max := atomic.LoadInt64(&s.syncDurationMax)
+		if int64(duration) <= max || atomic.CompareAndSwapInt64(&s.syncDurationMax, max, int64(duration)) {
+			return
+		}
+	}
+}
+
+// Stats returns a copy of the counters of the cache machine.
+func (c *CacheMachine) Stats() Stats {
+	stats := Stats{
+		RamHits:              atomic.LoadInt64(&c.stats.ramHits),
+		DiskHits:             atomic.LoadInt64(&c.stats.diskHits),
+		S3Hits:               atomic.LoadInt64(&c.stats.s3Hits),
+		Misses:               atomic.LoadInt64(&c.stats.misses),
+		BytesServed:          atomic.LoadInt64(&c.stats.bytesServed),
+		NegativeHits:         atomic.LoadInt64(&c.stats.negativeHits),
+		SetCount:             atomic.LoadInt64(&c.stats.setCount),
+		SetBytes:             atomic.LoadInt64(&c.stats.setBytes),
+		AdmissionRejections:  atomic.LoadInt64(&c.stats.admissionRejections),
+		SyncCoalesced:        atomic.LoadInt64(&c.stats.syncCoalesced),
+		ThrashDiversions:     atomic.LoadInt64(&c.stats.thrashDiversions),
+		DiskWriteCount:       atomic.LoadInt64(&c.stats.diskWriteCount),
+		DiskWriteBytes:       atomic.LoadInt64(&c.stats.diskWriteBytes),
+		S3WriteCount:         atomic.LoadInt64(&c.stats.s3WriteCount),
+		S3WriteBytes:         atomic.LoadInt64(&c.stats.s3WriteBytes),
+		S3Refreshes:          atomic.LoadInt64(&c.stats.s3Refreshes),
+		S3Corruptions:        atomic.LoadInt64(&c.stats.s3Corruptions),
+		SyncCycles:           atomic.LoadInt64(&c.stats.syncCycles),
+		SyncDurationTotal:    time.Duration(atomic.LoadInt64(&c.stats.syncDurationTotal)),
+		SyncDurationLast:     time.Duration(atomic.LoadInt64(&c.stats.syncDurationLast)),
+		SyncDurationMax:      time.Duration(atomic.LoadInt64(&c.stats.syncDurationMax)),
+		SyncQueueDepth:       atomic.LoadInt64(&c.stats.syncQueueDepth),
+		SyncQueueOverflows:   atomic.LoadInt64(&c.stats.syncQueueOverflows),
+		RamEvictions:         c.RamCache.EvacuateCount(),
+		UnsyncedEvictions:    atomic.LoadInt64(&c.stats.unsyncedEvictions),
+		SyncLag:              time.Duration(atomic.LoadInt64(&c.stats.syncLag)),
+		DiskReadLatency:      time.Duration(atomic.LoadInt64(&c.stats.diskReadLatency)),
+		S3ReadLatency:        time.Duration(atomic.LoadInt64(&c.stats.s3ReadLatency)),
+		TierReorders:         atomic.LoadInt64(&c.stats.tierReorders),
+		S3DownloadsThrottled: atomic.LoadInt64(&c.stats.s3Throttled),
+		IdleEvictions:        atomic.LoadInt64(&c.stats.idleEvictions),
+		InvalidationsDropped: atomic.LoadInt64(&c.stats.droppedInvalidations),
+		TrackedKeys:          int64(c.syncTable.len()),
+		RamEvictionAges:      c.stats.ramEvictionAges.snapshot(),
+		DiskEvictionAges:     c.stats.diskEvictionAges.snapshot(),
+		RamExpiryLags:        c.stats.ramExpiryLags.snapshot(),
+		DiskExpiryLags:       c.stats.diskExpiryLags.snapshot(),
+		S3ExpiryLags:         c.stats.s3ExpiryLags.snapshot(),
+		Namespaces:           c.stats.namespaces.snapshot(),
+		RecentErrors:         c.recentErrors.snapshot(),
+	}
+	if diskCache := c.DiskCache; diskCache != nil {
+		diskStats := diskCache.Stats()
+		stats.DiskReadBytes = diskStats.BytesRead
+		stats.DiskRemovedBytes = diskStats.BytesRemoved
+		stats.DiskEvictions = diskStats.Evictions
+		stats.DiskEvictionVetoes = diskStats.Vetoes
+		stats.DiskCorruptions = diskStats.Corruptions
+		stats.DiskUnderPressure = c.DiskPressure().UnderPressure
+	}
+	return stats
+}
+
+// WritePrometheus writes the stats of the cache machine to w using the
+// Prometheus text exposition format, so they can be served from an existing
+// /metrics handler without pulling in the Prometheus client library.
+func (c *CacheMachine) WritePrometheus(w io.Writer) error {
+	return writePrometheus(w, c.Stats())
+}
+
+// metric is a counter or gauge of the stats, named after Prometheus
+// conventions.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value float64
+}
+
+// metrics returns the counters and gauges of the stats, leaving out the
+// histograms and the per namespace stats.
+func (stats Stats) metrics() []metric {
+	return []metric{
+		{"cachemachine_ram_hits_total", "counter", "Number of Get calls answered from RAM.", float64(stats.RamHits)},
+		{"cachemachine_disk_hits_total", "counter", "Number of Get calls answered from disk.", float64(stats.DiskHits)},
+		{"cachemachine_misses_total", "counter", "Number of Get calls answered by no tier.", float64(stats.Misses)},
+		{"cachemachine_negative_hits_total", "counter", "Number of Get calls answered by a negative entry.", float64(stats.NegativeHits)},
+		{"cachemachine_served_bytes_total", "counter", "Bytes returned by Get.", float64(stats.BytesServed)},
+		{"cachemachine_set_total", "counter", "Number of values accepted by Set.", float64(stats.SetCount)},
+		{"cachemachine_set_bytes_total", "counter", "Bytes accepted by Set.", float64(stats.SetBytes)},
+		{"cachemachine_admission_rejections_total", "counter", "Number of values set that were not admitted to RAM.", float64(stats.AdmissionRejections)},
+		{"cachemachine_sync_coalesced_total", "counter", "Number of values set that replaced a value waiting to be synced.", float64(stats.SyncCoalesced)},
+		{"cachemachine_thrash_diversions_total", "counter", "Number of values set that were written straight to disk, their key thrashing the RAM cache.", float64(stats.ThrashDiversions)},
+		{"cachemachine_disk_writes_total", "counter", "Number of values written to disk.", float64(stats.DiskWriteCount)},
+		{"cachemachine_disk_written_bytes_total", "counter", "Bytes written to disk.", float64(stats.DiskWriteBytes)},
+		{"cachemachine_disk_read_bytes_total", "counter", "Bytes read from disk.", float64(stats.DiskReadBytes)},
+		{"cachemachine_disk_removed_bytes_total", "counter", "Bytes removed from disk by eviction, Delete or Clear.", float64(stats.DiskRemovedBytes)},
+		{"cachemachine_disk_evictions_total", "counter", "Number of entries evicted from disk.", float64(stats.DiskEvictions)},
+		{"cachemachine_disk_eviction_vetoes_total", "counter", "Number of evictions from disk vetoed by OnBeforeEvict.", float64(stats.DiskEvictionVetoes)},
+		{"cachemachine_disk_corruptions_total", "counter", "Number of entries read from disk that did not match their checksum.", float64(stats.DiskCorruptions)},
+		{"cachemachine_disk_under_pressure", "gauge", "Whether the disk tier is running out of storage.", boolGauge(stats.DiskUnderPressure)},
+		{"cachemachine_s3_corruptions_total", "counter", "Number of objects read from S3 that did not match their checksum.", float64(stats.S3Corruptions)},
+		{"cachemachine_s3_hits_total", "counter", "Number of Get calls answered from S3.", float64(stats.S3Hits)},
+		{"cachemachine_s3_writes_total", "counter", "Number of values written to S3.", float64(stats.S3WriteCount)},
+		{"cachemachine_s3_written_bytes_total", "counter", "Bytes written to S3.", float64(stats.S3WriteBytes)},
+		{"cachemachine_s3_refreshes_total", "counter", "Number of stale entries read from S3 that were refreshed.", float64(stats.S3Refreshes)},
+		{"cachemachine_write_amplification", "gauge", "Bytes written to disk per byte accepted by Set.", stats.WriteAmplification()},
+		{"cachemachine_sync_cycles_total", "counter", "Number of RAM to disk sync cycles.", float64(stats.SyncCycles)},
+		{"cachemachine_sync_duration_seconds_total", "counter", "Time spent in RAM to disk sync cycles.", stats.SyncDurationTotal.Seconds()},
+		{"cachemachine_sync_duration_seconds_last", "gauge", "Duration of the last RAM to disk sync cycle.", stats.SyncDurationLast.Seconds()},
+		{"cachemachine_sync_duration_seconds_max", "gauge", "Duration of the longest RAM to disk sync cycle.", stats.SyncDurationMax.Seconds()},
+		{"cachemachine_sync_queue_depth", "gauge", "Number of keys waiting to be synced.", float64(stats.SyncQueueDepth)},
+		{"cachemachine_sync_queue_overflows_total", "counter", "Number of keys not queued to be synced because their sync queue was full.", float64(stats.SyncQueueOverflows)},
+		{"cachemachine_ram_evictions_total", "counter", "Number of entries evicted from RAM.", float64(stats.RamEvictions)},
+		{"cachemachine_unsynced_evictions_total", "counter", "Number of entries evicted from RAM before being synced to disk.", float64(stats.UnsyncedEvictions)},
+		{"cachemachine_sync_lag_seconds", "gauge", "Longest wait of an entry written to disk by the last sync.", stats.SyncLag.Seconds()},
+		{"cachemachine_disk_read_latency_seconds", "gauge", "Moving average of the latency of the reads from disk.", stats.DiskReadLatency.Seconds()},
+		{"cachemachine_s3_read_latency_seconds", "gauge", "Moving average of the latency of the reads from S3.", stats.S3ReadLatency.Seconds()},
+		{"cachemachine_tier_reorders_total", "counter", "Number of times the disk and S3 tiers were reordered.", float64(stats.TierReorders)},
+		{"cachemachine_s3_downloads_throttled_total", "counter", "Number of downloads from S3 delayed by the download rate limit.", float64(stats.S3DownloadsThrottled)},
+		{"cachemachine_idle_evictions_total", "counter", "Number of entries removed from every tier for not being read.", float64(stats.IdleEvictions)},
+		{"cachemachine_invalidations_dropped_total", "counter", "Number of invalidations not broadcast because the invalidation queue was full.", float64(stats.InvalidationsDropped)},
+		{"cachemachine_tracked_keys", "gauge", "Number of keys whose sync state is tracked.", float64(stats.TrackedKeys)},
+	}
+}
+
+// boolGauge returns the value of a gauge reporting a condition.
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writePrometheus writes stats to w using the Prometheus text exposition
+// format.
+func writePrometheus(w io.Writer, stats Stats) error {
+	for _, metric := range stats.metrics() {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n",
+			metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprint(w, "# HELP cachemachine_eviction_age_seconds Age of entries when evicted from a tier.\n"+
+		"# TYPE cachemachine_eviction_age_seconds histogram\n")
+	if err != nil {
+		return err
+	}
+	for _, h := range []struct {
+		tier      string
+		histogram AgeHistogram
+	}{
+		{"ram", stats.RamEvictionAges},
+		{"disk", stats.DiskEvictionAges},
+	} {
+		labels := fmt.Sprintf("tier=%q", h.tier)
+		if err := writePrometheusHistogram(w, "cachemachine_eviction_age_seconds", labels, h.histogram); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprint(w, "# HELP cachemachine_expiry_lag_seconds Time between the expiry of entries and their removal from a tier.\n"+
+		"# TYPE cachemachine_expiry_lag_seconds histogram\n")
+	if err != nil {
+		return err
+	}
+	for _, h := range []struct {
+		tier string
+		lags ExpiryLags
+	}{
+		{"ram", stats.RamExpiryLags},
+		{"disk", stats.DiskExpiryLags},
+		{"s3", stats.S3ExpiryLags},
+	} {
+		for _, m := range []struct {
+			mechanism string
+			histogram AgeHistogram
+		}{
+			{ExpiryLazy, h.lags.Lazy},
+			{ExpirySweeper, h.lags.Sweeper},
+		} {
+			labels := fmt.Sprintf("tier=%q,mechanism=%q", h.tier, m.mechanism)
+			if err := writePrometheusHistogram(w, "cachemachine_expiry_lag_seconds", labels, m.histogram); err != nil {
+				return err
+			}
+		}
+	}
+
+	namespaceMetrics := []struct {
+		name  string
+		help  string
+		value func(NamespaceStats) int64
+	}{
+		{"cachemachine_namespace_ram_hits_total", "Number of Get calls answered from RAM, per namespace.", func(s NamespaceStats) int64 { return s.RamHits }},
+		{"cachemachine_namespace_disk_hits_total", "Number of Get calls answered from disk, per namespace.", func(s NamespaceStats) int64 { return s.DiskHits }},
+		{"cachemachine_namespace_s3_hits_total", "Number of Get calls answered from S3, per namespace.", func(s NamespaceStats) int64 { return s.S3Hits }},
+		{"cachemachine_namespace_misses_total", "Number of Get calls answered by no tier, per namespace.", func(s NamespaceStats) int64 { return s.Misses }},
+		{"cachemachine_namespace_set_total", "Number of values accepted by Set, per namespace.", func(s NamespaceStats) int64 { return s.SetCount }},
+		{"cachemachine_namespace_set_bytes_total", "Bytes accepted by Set, per namespace.", func(s NamespaceStats) int64 { return s.SetBytes }},
+	}
+	namespaces := sortedNamespaces(stats.Namespaces)
+	for _, metric := range namespaceMetrics {
+		_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		if err != nil {
+			return err
+		}
+		for _, namespace := range namespaces {
+			_, err = fmt.Fprintf(w, "%s{namespace=\"%s\"} %d\n",
+				metric.name, prometheusLabelEscaper.Replace(namespace), metric.value(stats.Namespaces[namespace]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writePrometheusHistogram writes the samples of a histogram, whose HELP and
+// TYPE lines are already written, with the given labels.
+func writePrometheusHistogram(w io.Writer, name, labels string, histogram AgeHistogram) error {
+	for i, bucket := range histogram.Buckets {
+		_, err := fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bucket.Seconds(), histogram.Counts[i])
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n%s_sum{%s} %g\n%s_count{%s} %d\n",
+		name, labels, histogram.Count, name, labels, histogram.Sum.Seconds(), name, labels, histogram.Count)
+	return err
+}
+
+// prometheusLabelEscaper escapes label values for the text exposition format.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
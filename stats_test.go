@@ -0,0 +1,99 @@
+package cachemachine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCacheMachine_Stats(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	CacheMachine.Set("key1", []byte("abcde"))
+	CacheMachine.SyncRamCacheToDiskCache()
+
+	stats := CacheMachine.Stats()
+	if stats.SetCount != 3 || stats.SetBytes != 15 {
+		t.Errorf("Expected 3 sets totalling 15 bytes, got %d sets totalling %d bytes", stats.SetCount, stats.SetBytes)
+	}
+	if stats.DiskWriteCount != 3 || stats.DiskWriteBytes != 15 {
+		t.Errorf("Expected 3 disk writes totalling 15 bytes, got %d writes totalling %d bytes", stats.DiskWriteCount, stats.DiskWriteBytes)
+	}
+	if stats.WriteAmplification() != 1 {
+		t.Errorf("Expected write amplification to be 1, got %f", stats.WriteAmplification())
+	}
+	if stats.SyncCycles != 2 {
+		t.Errorf("Expected 2 sync cycles, got %d", stats.SyncCycles)
+	}
+	if stats.SyncDurationMax < stats.SyncDurationLast {
+		t.Errorf("Expected max sync duration to be at least the last one, got %s < %s", stats.SyncDurationMax, stats.SyncDurationLast)
+	}
+
+	var buf bytes.Buffer
+	err = CacheMachine.WritePrometheus(&buf)
+	if err != nil {
+		t.Errorf("Expected no error writing prometheus metrics, got %s", err)
+	}
+	if !strings.Contains(buf.String(), "cachemachine_disk_written_bytes_total 15\n") {
+		t.Errorf("Expected disk written bytes metric, got %s", buf.String())
+	}
+}
+
+func TestCacheMachine_EvictionAges(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(5, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.SyncRamCacheToDiskCache()
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Set("key3", []byte("abcde"))
+	// Simulate an eviction of key3 by freecache before it gets synced.
+	CacheMachine.RamCache.Del([]byte("key3"))
+	CacheMachine.SyncRamCacheToDiskCache()
+
+	stats := CacheMachine.Stats()
+	if stats.RamEvictionAges.Count != 1 || stats.RamEvictionAges.Counts[0] != 1 {
+		t.Errorf("Expected one recent RAM eviction, got %+v", stats.RamEvictionAges)
+	}
+	if stats.DiskEvictionAges.Count != 1 || stats.DiskEvictionAges.Counts[0] != 1 {
+		t.Errorf("Expected one recent disk eviction, got %+v", stats.DiskEvictionAges)
+	}
+
+	var buf bytes.Buffer
+	CacheMachine.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `cachemachine_eviction_age_seconds_count{tier="disk"} 1`) {
+		t.Errorf("Expected disk eviction age metric, got %s", buf.String())
+	}
+}
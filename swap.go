@@ -0,0 +1,184 @@
+package cachemachine
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// SwapDiskEngine replaces the disk tier with a new disk cache in newPath,
+// tuned by opts, without blocking the cache machine for longer than the
+// switch itself. Unlike MoveDiskCache, the entries are not copied upfront:
+// the values synced from then on are written to the new disk cache, reads
+// fall back to the old one, which they migrate the entries they find in to
+// the new one, and the entries left are migrated in the background. The old
+// disk cache is emptied and released once drained, and an EventDiskDrained
+// is emitted.
+//
+// Both disk caches are up to DiskCacheSizeInBytes until the old one is
+// drained, so the disk tier can use twice its size in the meantime.
+func (c *CacheMachine) SwapDiskEngine(newPath string, opts ...DiskOption) error {
+	if !c.moveMu.TryLock() {
+		return fmt.Errorf("disk cache move already in progress")
+	}
+	if c.DiskCache == nil {
+		c.moveMu.Unlock()
+		return fmt.Errorf("disk cache is not enabled")
+	}
+	if newPath == "" {
+		c.moveMu.Unlock()
+		return fmt.Errorf("newPath must be set")
+	}
+	if filepath.Clean(newPath) == filepath.Clean(c.DiskCachePath) {
+		c.moveMu.Unlock()
+		return fmt.Errorf("disk cache is already in %s", newPath)
+	}
+
+	previousOptions := c.diskOptions
+	c.diskOptions = newDiskOptions(opts)
+	newCache, err := c.newDiskCache(newPath, c.DiskCacheSizeInBytes)
+	if err != nil {
+		c.diskOptions = previousOptions
+		c.moveMu.Unlock()
+		return err
+	}
+
+	c.syncTable.lockAll()
+	oldCache := c.DiskCache
+	c.DiskCache = newCache
+	c.DiskCachePath = newPath
+	c.drainingDisk = oldCache
+	c.syncTable.unlockAll()
+
+	go func() {
+		defer c.moveMu.Unlock()
+		c.drainDiskCache(oldCache)
+	}()
+	return nil
+}
+
+// DrainDiskEngine migrates the entries of engine, such as the disk tier left
+// by a previous process using another disk engine, to the disk tier, the way
+// SwapDiskEngine drains the disk cache it replaces. The entries of engine
+// whose keys are not known yet are adopted as synced to disk, without an
+// expiry. engine is emptied and closed once drained, and an
+// EventDiskDrained is emitted.
+func (c *CacheMachine) DrainDiskEngine(engine DiskEngine) error {
+	if !c.moveMu.TryLock() {
+		return fmt.Errorf("disk cache move already in progress")
+	}
+	if c.DiskCache == nil {
+		c.moveMu.Unlock()
+		return fmt.Errorf("disk cache is not enabled")
+	}
+
+	entries := engine.Entries()
+	c.syncTable.lockAll()
+	for _, meta := range entries {
+		shard := c.syncTable.shard(meta.Key)
+		if _, ok := shard.entries[meta.Key]; !ok {
+			shard.entries[meta.Key] = CacheSyncTable{DiskSynced: true, SetAt: meta.CreatedAt}
+		}
+	}
+	c.drainingDisk = engine
+	c.syncTable.unlockAll()
+
+	go func() {
+		defer c.moveMu.Unlock()
+		c.drainDiskCache(engine)
+	}()
+	return nil
+}
+
+// drainDiskCache migrates the entries left in oldCache to the current disk
+// cache, from the least to the most recently used, then drops oldCache. It
+// gives up when the disk cache is disabled meanwhile.
+func (c *CacheMachine) drainDiskCache(oldCache DiskEngine) {
+	start := time.Now()
+	var migrated int
+	for _, meta := range oldCache.Entries() {
+		shard := c.syncTable.shard(meta.Key)
+		shard.Lock()
+		if c.drainingDisk != oldCache {
+			shard.Unlock()
+			return
+		}
+		if _, err := c.migrateEntry(shard, meta.Key); err == nil {
+			migrated++
+		}
+		shard.Unlock()
+	}
+
+	c.syncTable.lockAll()
+	defer c.syncTable.unlockAll()
+	if c.drainingDisk != oldCache {
+		return
+	}
+	c.drainingDisk = nil
+	c.closeDrainedDisk(oldCache)
+	c.emitEvent(EventDiskDrained, "disk cache drained", map[string]interface{}{
+		"from":     oldCache.Dir(),
+		"to":       c.DiskCachePath,
+		"migrated": migrated,
+		"duration": time.Since(start).String(),
+	})
+}
+
+// closeDrainedDisk empties and releases a disk engine drained by
+// SwapDiskEngine or DrainDiskEngine.
+func (c *CacheMachine) closeDrainedDisk(oldCache DiskEngine) {
+	if err := oldCache.Clear(); err != nil {
+		c.logError("error clearing old disk cache", "path", oldCache.Dir(), "error", err)
+	}
+	if err := oldCache.Close(); err != nil {
+		c.logError("error closing old disk cache", "path", oldCache.Dir(), "error", err)
+	}
+}
+
+// readDrainingDisk reads key from the disk cache being drained, when it was
+// not found in the current one, and migrates it.
+func (c *CacheMachine) readDrainingDisk(key string) ([]byte, error) {
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	return c.migrateEntry(shard, key)
+}
+
+// migrateEntry moves the entry for key from the disk cache being drained to
+// the current one, and returns its value. The entries that are not synced to
+// disk anymore, or were set again since they were written to the old disk
+// cache, are only removed from it. It must be called with the stripe of the
+// key locked.
+func (c *CacheMachine) migrateEntry(shard *syncTableShard, key string) ([]byte, error) {
+	draining := c.drainingDisk
+	if draining == nil {
+		return nil, diskcache.ErrNotFound
+	}
+	meta, ok := draining.Stat(key)
+	if !ok {
+		return nil, diskcache.ErrNotFound
+	}
+	defer func() {
+		if _, err := draining.Delete(key); err != nil {
+			c.logError("error deleting from old disk cache", "key", key, "error", err)
+		}
+	}()
+
+	cacheSync, ok := shard.entries[key]
+	if !ok || !cacheSync.DiskSynced || cacheSync.expired(time.Now()) || meta.CreatedAt.Before(cacheSync.SetAt) {
+		return nil, diskcache.ErrNotFound
+	}
+	if _, ok := c.DiskCache.EntrySize(key); ok {
+		return nil, diskcache.ErrNotFound
+	}
+	value, err := draining.Peek(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.putDisk(c.DiskCache, key, value); err != nil {
+		c.logError("error migrating to new disk cache", "key", key, "error", err)
+	}
+	return value, nil
+}
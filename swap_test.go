@@ -0,0 +1,250 @@
+package cachemachine
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+func TestCacheMachine_SwapDiskEngine(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	drained := make(chan Event, 1)
+	CacheMachine.EnableEvents(func(event Event) {
+		if event.Type == EventDiskDrained {
+			drained <- event
+		}
+	}, 100)
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+	oldPath := filepath.Join(tmpFolder, "old")
+	newPath := filepath.Join(tmpFolder, "new")
+
+	err = CacheMachine.EnableDiskCache(1024*1024, oldPath)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for i := 0; i < 100; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+	oldCache := CacheMachine.DiskCache
+
+	if err := CacheMachine.SwapDiskEngine(oldPath); err == nil {
+		t.Errorf("Expected an error swapping to the same directory")
+	}
+	if err := CacheMachine.SwapDiskEngine(newPath, WithDiskShardDepth(1)); err != nil {
+		t.Fatalf("Expected no error swapping the disk engine, got %s", err)
+	}
+	select {
+	case event := <-drained:
+		if event.Fields["migrated"] != 100 {
+			t.Errorf("Expected 100 entries to be migrated, got %v", event.Fields["migrated"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the old disk cache to be drained")
+	}
+
+	if CacheMachine.DiskCachePath != newPath || CacheMachine.DiskCache.Len() != 100 {
+		t.Errorf("Expected 100 entries in %s, got %d in %s", newPath, CacheMachine.DiskCache.Len(), CacheMachine.DiskCachePath)
+	}
+	if oldCache.Len() != 0 {
+		t.Errorf("Expected the old disk cache to be emptied, got %d entries", oldCache.Len())
+	}
+	for i := 0; i < 100; i++ {
+		if value, ok := CacheMachine.Get(fmt.Sprintf("key%d", i)); !ok || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected key%d to be migrated, got %q, %v", i, value, ok)
+		}
+	}
+}
+
+func TestCacheMachine_ReadDrainingDisk(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, filepath.Join(tmpFolder, "old"))
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Set("key2", []byte("67890"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+
+	// The old disk cache is left to drain without the background migration.
+	newCache, err := diskcache.New(filepath.Join(tmpFolder, "new"), 1024*1024, 1024)
+	if err != nil {
+		t.Fatalf("Error creating disk cache: %s", err)
+	}
+	CacheMachine.syncTable.lockAll()
+	oldCache := CacheMachine.DiskCache
+	CacheMachine.DiskCache = newCache
+	CacheMachine.drainingDisk = oldCache
+	CacheMachine.syncTable.unlockAll()
+
+	// key1 is read from the old disk cache, and migrated.
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "12345" {
+		t.Errorf("Expected 12345 from the old disk cache, got %q, %v", value, ok)
+	}
+	if _, ok := newCache.EntrySize("key1"); !ok {
+		t.Errorf("Expected key1 to be migrated")
+	}
+	if _, ok := oldCache.EntrySize("key1"); ok {
+		t.Errorf("Expected key1 to be removed from the old disk cache")
+	}
+
+	// key2 is set again and evicted from RAM: its old copy is stale.
+	CacheMachine.Set("key2", []byte("abcde"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+	newCache.Delete("key2")
+	if value, ok := CacheMachine.Get("key2"); ok {
+		t.Errorf("Expected the stale copy of key2 not to be served, got %q", value)
+	}
+}
+
+// mapEngine is an in-memory DiskEngine, standing for a disk engine other
+// than diskcache.
+type mapEngine struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	closed bool
+}
+
+func (e *mapEngine) Dir() string {
+	return "memory"
+}
+
+func (e *mapEngine) Entries() []diskcache.Meta {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entries := make([]diskcache.Meta, 0, len(e.values))
+	for key, value := range e.values {
+		entries = append(entries, diskcache.Meta{Key: key, Size: int64(len(value))})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func (e *mapEngine) Stat(key string) (diskcache.Meta, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.values[key]
+	return diskcache.Meta{Key: key, Size: int64(len(value))}, ok
+}
+
+func (e *mapEngine) Peek(key string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.values[key]
+	if !ok {
+		return nil, diskcache.ErrNotFound
+	}
+	return value, nil
+}
+
+func (e *mapEngine) Delete(key string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.values[key]
+	delete(e.values, key)
+	return ok, nil
+}
+
+func (e *mapEngine) Clear() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = make(map[string][]byte)
+	return nil
+}
+
+func (e *mapEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	return nil
+}
+
+func TestCacheMachine_DrainDiskEngine(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	drained := make(chan Event, 1)
+	CacheMachine.EnableEvents(func(event Event) {
+		if event.Type == EventDiskDrained {
+			drained <- event
+		}
+	}, 100)
+
+	engine := &mapEngine{values: map[string][]byte{
+		"key1": []byte("12345"),
+		"key2": []byte("67890"),
+		"key3": []byte("stale"),
+	}}
+	if err := CacheMachine.DrainDiskEngine(engine); err == nil {
+		t.Errorf("Expected an error draining without a disk cache")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	// key3 is already known, with a newer value.
+	CacheMachine.Set("key3", []byte("fresh"))
+	CacheMachine.Flush()
+
+	if err := CacheMachine.DrainDiskEngine(engine); err != nil {
+		t.Fatalf("Expected no error draining the disk engine, got %s", err)
+	}
+	select {
+	case event := <-drained:
+		if event.Fields["migrated"] != 2 || event.Fields["from"] != "memory" {
+			t.Errorf("Expected 2 entries to be migrated from memory, got %v", event.Fields)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the disk engine to be drained")
+	}
+
+	CacheMachine.ClearRamCache()
+	for key, expected := range map[string]string{"key1": "12345", "key2": "67890", "key3": "fresh"} {
+		if value, ok := CacheMachine.Get(key); !ok || string(value) != expected {
+			t.Errorf("Expected %s to be %s, got %q, %v", key, expected, value, ok)
+		}
+	}
+	if entries := engine.Entries(); len(entries) != 0 || !engine.closed {
+		t.Errorf("Expected the disk engine to be emptied and closed, got %v, %t", entries, engine.closed)
+	}
+}
@@ -0,0 +1,214 @@
+package cachemachine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxDirtyKeys is the default value of CacheMachine.MaxDirtyKeys.
+const DefaultMaxDirtyKeys = 100000
+
+const (
+	// minDirtyKeysPerShard is the least number of keys the sync queue of
+	// a stripe holds before it overflows, whatever MaxDirtyKeys.
+	minDirtyKeysPerShard = 64
+
+	// maxEvictedKeys bounds the number of keys evicted from disk waiting to
+	// be forgotten by the next sync. The keys beyond it are left to the
+	// sweep.
+	maxEvictedKeys = 4096
+
+	// sweepShardsPerSync is the number of stripes of the sync table swept
+	// by every sync, so that the whole table is swept every
+	// syncTableShards/sweepShardsPerSync syncs.
+	sweepShardsPerSync = 16
+
+	// sweepEntriesPerSet is the number of entries of its stripe checked by
+	// every Set, so that the sync table is also kept in check when the disk
+	// cache is disabled.
+	sweepEntriesPerSet = 2
+)
+
+// enqueueDirty queues key to be synced by the next sync, and wakes the sync
+// up early when too many keys are waiting. The queue of a stripe holds at
+// most four times its share of MaxDirtyKeys: beyond that, the key is not
+// queued and the stripe is marked as overflowed, so that the queue is
+// rebuilt by scanning the stripe before it is next used. It must be called
+// with the stripe of the key locked.
+func (c *CacheMachine) enqueueDirty(shard *syncTableShard, key string) {
+	depth := atomic.LoadInt64(&c.stats.syncQueueDepth)
+	if shard.overflowed || len(shard.dirty) >= c.maxDirtyKeysPerShard() {
+		shard.overflowed = true
+		atomic.AddInt64(&c.stats.syncQueueOverflows, 1)
+	} else {
+		shard.dirty = append(shard.dirty, key)
+		depth = atomic.AddInt64(&c.stats.syncQueueDepth, 1)
+	}
+	if (shard.overflowed || depth >= int64(c.MaxDirtyKeys)) && c.syncNow != nil {
+		select {
+		case c.syncNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// maxDirtyKeysPerShard returns the number of keys the sync queue of a stripe
+// holds before it overflows.
+func (c *CacheMachine) maxDirtyKeysPerShard() int {
+	maxDirtyKeys := c.MaxDirtyKeys
+	if maxDirtyKeys <= 0 {
+		maxDirtyKeys = DefaultMaxDirtyKeys
+	}
+	if n := 4 * maxDirtyKeys / syncTableShards; n > minDirtyKeysPerShard {
+		return n
+	}
+	return minDirtyKeysPerShard
+}
+
+// dirtyKeys returns the sync queue of shard, after rebuilding it from the
+// entries of the stripe if it overflowed. It must be called with the stripe
+// locked.
+func (c *CacheMachine) dirtyKeys(shard *syncTableShard) []string {
+	if shard.overflowed {
+		queued := len(shard.dirty)
+		shard.requeueUnsynced(c.S3Cache != nil)
+		atomic.AddInt64(&c.stats.syncQueueDepth, int64(len(shard.dirty)-queued))
+	}
+	return shard.dirty
+}
+
+// enqueueAll queues every entry that still needs to be synced to disk, or to
+// S3 when syncS3 is set. It must be called with every stripe locked, when a
+// tier is enabled.
+func (c *CacheMachine) enqueueAll(syncS3 bool) {
+	var depth int64
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.requeueUnsynced(syncS3)
+		depth += int64(len(shard.dirty))
+	}
+	atomic.StoreInt64(&c.stats.syncQueueDepth, depth)
+}
+
+// requeueUnsynced replaces the sync queue of the stripe with every entry
+// that still needs to be synced to disk, or to S3 when syncS3 is set. It
+// must be called with the stripe locked.
+func (s *syncTableShard) requeueUnsynced(syncS3 bool) {
+	s.dirty = s.dirty[:0]
+	s.overflowed = false
+	for key, cacheSync := range s.entries {
+		if !cacheSync.Negative && (!cacheSync.DiskSynced || (syncS3 && !cacheSync.S3Sync)) {
+			s.dirty = append(s.dirty, key)
+		}
+	}
+}
+
+// recordDiskEviction remembers that key was evicted from disk, so that the
+// next sync forgets about it if it is in no other tier. It is called from
+// the eviction hook of the disk cache, with the disk cache locked, so it
+// cannot lock the stripe of the key itself.
+func (c *CacheMachine) recordDiskEviction(key string) {
+	c.evictedMu.Lock()
+	defer c.evictedMu.Unlock()
+	if len(c.evictedKeys) < maxEvictedKeys {
+		c.evictedKeys = append(c.evictedKeys, key)
+	}
+}
+
+// forgetEvicted drops from the sync table the keys evicted from disk since
+// the previous sync that are in no other tier.
+func (c *CacheMachine) forgetEvicted() {
+	c.evictedMu.Lock()
+	keys := c.evictedKeys
+	c.evictedKeys = nil
+	c.evictedMu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		shard := c.syncTable.shard(key)
+		shard.Lock()
+		if cacheSync, ok := shard.entries[key]; ok {
+			c.sweepEntry(shard, key, cacheSync, now)
+		}
+		shard.Unlock()
+	}
+}
+
+// sweep removes the expired entries of the next stripes of the sync table
+// from every tier, and drops the entries that are in no tier anymore.
+// freecache does not report its evictions, so this is how entries evicted
+// from RAM after being synced are eventually forgotten.
+func (c *CacheMachine) sweep() {
+	now := time.Now()
+	for n := 0; n < sweepShardsPerSync; n++ {
+		shard := &c.syncTable[(atomic.AddUint32(&c.sweepHand, 1)-1)%syncTableShards]
+
+		shard.Lock()
+		for key, cacheSync := range shard.entries {
+			c.sweepEntry(shard, key, cacheSync, now)
+		}
+		shard.Unlock()
+	}
+}
+
+// sweepSome sweeps a few entries of a stripe, at random. It must be called with the stripe locked.
+func (c *CacheMachine) sweepSome(shard *syncTableShard) {
+	now := time.Now()
+	if c.seededRandom() != nil {
+		c.sweepSeeded(shard, now)
+		return
+	}
+	n := 0
+	for key, cacheSync := range shard.entries {
+		if n == sweepEntriesPerSet {
+			return
+		}
+		n++
+		c.sweepEntry(shard, key, cacheSync, now)
+	}
+}
+
+// sweepEntry removes the entry for key from every tier if it is expired or
+// idle, see EnableIdleEviction, and forgets about it if it is in no tier
+// anymore. It must be called with the
+// stripe of the key locked.
+func (c *CacheMachine) sweepEntry(shard *syncTableShard, key string, cacheSync CacheSyncTable, now time.Time) {
+	switch {
+	case cacheSync.expired(now):
+		c.expireLocked(shard, key, cacheSync, now, ExpirySweeper)
+	case c.evictIfIdle(shard, key, cacheSync, now):
+	case !c.live(key, cacheSync, now):
+		delete(shard.entries, key)
+	}
+}
+
+// live reports whether the entry for key can still be read from a tier. An
+// entry waiting to be synced to disk is always live: if it was evicted from
+// RAM in the meantime, the sync notices and accounts for its loss. It must
+// be called with the stripe of the key locked.
+func (c *CacheMachine) live(key string, cacheSync CacheSyncTable, now time.Time) bool {
+	if cacheSync.expired(now) {
+		return false
+	}
+	if cacheSync.Negative {
+		return true
+	}
+	diskCache := c.DiskCache
+	if diskCache != nil && !cacheSync.DiskSynced {
+		return true
+	}
+	if _, err := c.RamCache.TTL([]byte(key)); err == nil {
+		return true
+	}
+	if diskCache != nil {
+		if _, ok := diskCache.EntrySize(key); ok {
+			return true
+		}
+	}
+	if c.drainingDisk != nil {
+		if _, ok := c.drainingDisk.Stat(key); ok {
+			return true
+		}
+	}
+	return cacheSync.S3Sync && c.S3Cache != nil
+}
@@ -0,0 +1,206 @@
+package cachemachine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SyncQueue(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.MaxDirtyKeys = 3
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	CacheMachine.Set("key1", []byte("12345"))
+	err = CacheMachine.EnableDiskCache(10, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key2", []byte("67890"))
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 2 {
+		t.Errorf("Expected 2 keys waiting to be synced, got %d", depth)
+	}
+
+	// Reaching MaxDirtyKeys starts the sync without waiting for the ticker.
+	CacheMachine.Set("key3", []byte("abcde"))
+	deadline := time.Now().Add(time.Second)
+	for CacheMachine.Stats().SyncQueueDepth != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 0 {
+		t.Errorf("Expected no key waiting to be synced, got %d", depth)
+	}
+
+	// key2 and key3 are evicted from disk to make room for key4 and key5,
+	// and are forgotten since they are gone from RAM too.
+	CacheMachine.RamCache.Del([]byte("key2"))
+	CacheMachine.RamCache.Del([]byte("key3"))
+	CacheMachine.Set("key4", []byte("fghij"))
+	CacheMachine.Set("key5", []byte("klmno"))
+	CacheMachine.Flush()
+	for _, key := range []string{"key2", "key3"} {
+		if _, ok := CacheMachine.SyncState(key); ok {
+			t.Errorf("Expected %s to be forgotten", key)
+		}
+	}
+
+	// key1 was evicted from disk to make room for key3 while it was still
+	// in RAM, so it is only forgotten by the sweep.
+	CacheMachine.RamCache.Del([]byte("key1"))
+	for i := 0; i < syncTableShards/sweepShardsPerSync; i++ {
+		CacheMachine.sweep()
+	}
+	if _, ok := CacheMachine.SyncState("key1"); ok {
+		t.Errorf("Expected key1 to be forgotten")
+	}
+	if tracked := CacheMachine.Stats().TrackedKeys; tracked != 2 {
+		t.Errorf("Expected 2 tracked keys, got %d", tracked)
+	}
+}
+
+func TestCacheMachine_Sweep(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		CacheMachine.Set(key, []byte("12345"))
+	}
+	CacheMachine.RamCache.Del([]byte("key1"))
+	CacheMachine.RamCache.Del([]byte("key2"))
+
+	for i := 0; i < syncTableShards/sweepShardsPerSync; i++ {
+		CacheMachine.sweep()
+	}
+	if tracked := CacheMachine.Stats().TrackedKeys; tracked != 1 {
+		t.Errorf("Expected 1 tracked key, got %d", tracked)
+	}
+	if _, ok := CacheMachine.SyncState("key3"); !ok {
+		t.Errorf("Expected key3 to be tracked")
+	}
+}
+
+func TestCacheMachine_SyncCoalesceWindow(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.SyncCoalesceWindow = time.Minute
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	for i := 0; i < 3; i++ {
+		CacheMachine.Set("key1", []byte{byte('0' + i)})
+	}
+	if stats := CacheMachine.Stats(); stats.SyncCoalesced != 2 || stats.SyncQueueDepth != 1 {
+		t.Errorf("Expected 2 coalesced sets and 1 queued key, got %d and %d", stats.SyncCoalesced, stats.SyncQueueDepth)
+	}
+
+	// key1 was queued less than a window ago.
+	CacheMachine.SyncRamCacheToDiskCache()
+	if stats := CacheMachine.Stats(); stats.DiskWriteCount != 0 || stats.SyncQueueDepth != 1 {
+		t.Errorf("Expected key1 to wait for the end of the window, got %d writes and %d queued", stats.DiskWriteCount, stats.SyncQueueDepth)
+	}
+
+	// Updates do not push the end of the window back.
+	shard := CacheMachine.syncTable.shard("key1")
+	shard.Lock()
+	cacheSync := shard.entries["key1"]
+	cacheSync.dirtySince = cacheSync.dirtySince.Add(-time.Minute)
+	shard.entries["key1"] = cacheSync
+	shard.Unlock()
+	CacheMachine.Set("key1", []byte("3"))
+
+	CacheMachine.SyncRamCacheToDiskCache()
+	if stats := CacheMachine.Stats(); stats.DiskWriteCount != 1 || stats.SyncQueueDepth != 0 {
+		t.Errorf("Expected key1 to be written once, got %d writes and %d queued", stats.DiskWriteCount, stats.SyncQueueDepth)
+	}
+	if value, err := CacheMachine.DiskCache.Get("key1"); err != nil || string(value) != "3" {
+		t.Errorf("Expected the latest value of key1 on disk, got %q, %v", value, err)
+	}
+
+	// Flush ignores the window.
+	CacheMachine.Set("key2", []byte("4"))
+	CacheMachine.Flush()
+	if stats := CacheMachine.Stats(); stats.DiskWriteCount != 2 {
+		t.Errorf("Expected key2 to be flushed, got %d writes", stats.DiskWriteCount)
+	}
+}
+
+func TestCacheMachine_SyncQueueBound(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.MaxDirtyKeys = 1
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	shard := CacheMachine.syncTable.shard("key1")
+	other := ""
+	for i := 2; other == ""; i++ {
+		if key := fmt.Sprintf("key%d", i); CacheMachine.syncTable.shard(key) == shard {
+			other = key
+		}
+	}
+
+	shard.Lock()
+	for i := 0; i < 10*minDirtyKeysPerShard; i++ {
+		CacheMachine.enqueueDirty(shard, "key1")
+	}
+	if len(shard.dirty) > minDirtyKeysPerShard || !shard.overflowed {
+		t.Errorf("Expected the queue to overflow at %d keys, got %d, %t", minDirtyKeysPerShard, len(shard.dirty), shard.overflowed)
+	}
+	// A key set once the queue is full is not queued, but still synced.
+	if err := CacheMachine.set(shard, other, []byte("12345"), 0); err != nil {
+		t.Errorf("Expected no error setting %s, got %s", other, err)
+	}
+	for _, key := range shard.dirty {
+		if key == other {
+			t.Errorf("Expected %s not to be queued", other)
+		}
+	}
+	shard.Unlock()
+
+	if overflows := CacheMachine.Stats().SyncQueueOverflows; overflows == 0 {
+		t.Errorf("Expected overflows to be counted")
+	}
+	CacheMachine.Flush()
+	if value, err := CacheMachine.DiskCache.Get(other); err != nil || string(value) != "12345" {
+		t.Errorf("Expected %s to be synced, got %q, %v", other, value, err)
+	}
+	if depth := CacheMachine.Stats().SyncQueueDepth; depth != 0 {
+		t.Errorf("Expected no key waiting to be synced, got %d", depth)
+	}
+}
@@ -0,0 +1,143 @@
+package cachemachine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSyncBatchSize is the default value of CacheMachine.SyncBatchSize.
+const DefaultSyncBatchSize = 64
+
+// syncLimits holds the budgets of a background sync.
+type syncLimits struct {
+	// items is the number of entries the sync may still write, when
+	// limitItems is set.
+	items      int64
+	limitItems bool
+
+	bytes *byteRateLimiter
+}
+
+// newSyncLimits returns the budgets of the next background sync, or nil when
+// it is not limited.
+func (c *CacheMachine) newSyncLimits() *syncLimits {
+	if c.SyncMaxItemsPerTick <= 0 && c.SyncMaxBytesPerSecond <= 0 {
+		return nil
+	}
+	limits := &syncLimits{}
+	if c.SyncMaxItemsPerTick > 0 {
+		limits.items = int64(c.SyncMaxItemsPerTick)
+		limits.limitItems = true
+	}
+	if c.SyncMaxBytesPerSecond > 0 {
+		c.syncLimiterMu.Lock()
+		if c.syncLimiter == nil || c.syncLimiter.rate != float64(c.SyncMaxBytesPerSecond) {
+			c.syncLimiter = newByteRateLimiter(c.SyncMaxBytesPerSecond)
+		}
+		limits.bytes = c.syncLimiter
+		c.syncLimiterMu.Unlock()
+	}
+	return limits
+}
+
+// takeItem reports whether one more entry may be written by the sync.
+func (l *syncLimits) takeItem() bool {
+	if l == nil || !l.limitItems {
+		return true
+	}
+	return atomic.AddInt64(&l.items, -1) >= 0
+}
+
+// wrote accounts for size bytes written by the sync, and returns how long it
+// must pause to stay under its rate.
+func (l *syncLimits) wrote(size int) time.Duration {
+	if l == nil || l.bytes == nil {
+		return 0
+	}
+	return l.bytes.reserve(size)
+}
+
+// byteRateLimiter is a token bucket of bytes, holding up to one second worth
+// of its rate. Writes bigger than the bucket are let through, and paid for by
+// the following pauses.
+type byteRateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteRateLimiter(bytesPerSecond int64) *byteRateLimiter {
+	return &byteRateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes size bytes from the bucket, and returns how long to wait for
+// the bucket not to be in debt anymore.
+func (l *byteRateLimiter) reserve(size int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	l.tokens -= float64(size)
+	return l.debt()
+}
+
+// wait returns how long to wait for the bucket not to be in debt anymore,
+// without taking from it.
+func (l *byteRateLimiter) wait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	return l.debt()
+}
+
+// refill and debt must be called with l.mu held.
+func (l *byteRateLimiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+func (l *byteRateLimiter) debt() time.Duration {
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// syncResult accumulates the outcome of a sync across its workers.
+type syncResult struct {
+	synced int64
+	lost   int64
+	maxLag int64
+
+	lostMu   sync.Mutex
+	lostKeys []string
+}
+
+// recordLost records that the entry for key was evicted from RAM before
+// being synced.
+func (r *syncResult) recordLost(key string) {
+	atomic.AddInt64(&r.lost, 1)
+	r.lostMu.Lock()
+	r.lostKeys = append(r.lostKeys, key)
+	r.lostMu.Unlock()
+}
+
+func (r *syncResult) recordLag(lag time.Duration) {
+	for {
+		max := atomic.LoadInt64(&r.maxLag)
+		if int64(lag) <= max || atomic.CompareAndSwapInt64(&r.maxLag, max, int64(lag)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cachemachine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SyncMaxItemsPerTick(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.SyncMaxItemsPerTick = 4
+	CacheMachine.SyncBatchSize = 1
+	CacheMachine.SyncWorkers = 4
+	for i := 0; i < 10; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), []byte("12345"))
+	}
+
+	CacheMachine.SyncRamCacheToDiskCache()
+	stats := CacheMachine.Stats()
+	if stats.DiskWriteCount != 4 || stats.SyncQueueDepth != 6 {
+		t.Errorf("Expected 4 writes and 6 keys left, got %d and %d", stats.DiskWriteCount, stats.SyncQueueDepth)
+	}
+	if stats.SyncLag <= 0 {
+		t.Errorf("Expected a sync lag, got %s", stats.SyncLag)
+	}
+
+	CacheMachine.SyncRamCacheToDiskCache()
+	CacheMachine.SyncRamCacheToDiskCache()
+	stats = CacheMachine.Stats()
+	if stats.DiskWriteCount != 10 || stats.SyncQueueDepth != 0 {
+		t.Errorf("Expected 10 writes and no key left, got %d and %d", stats.DiskWriteCount, stats.SyncQueueDepth)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := CacheMachine.DiskCache.EntrySize(fmt.Sprintf("key%d", i)); !ok {
+			t.Errorf("Expected key%d to be synced to disk", i)
+		}
+	}
+}
+
+func TestCacheMachine_SyncMaxBytesPerSecond(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	// The bucket starts full with 2000 bytes, and the 1000 bytes that
+	// follow take 500ms.
+	CacheMachine.SyncMaxBytesPerSecond = 2000
+	value := make([]byte, 100)
+	for i := 0; i < 30; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), value)
+	}
+
+	start := time.Now()
+	CacheMachine.SyncRamCacheToDiskCache()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the sync to be throttled, took %s", elapsed)
+	}
+	if stats := CacheMachine.Stats(); stats.DiskWriteCount != 30 {
+		t.Errorf("Expected 30 writes, got %d", stats.DiskWriteCount)
+	}
+
+	for i := 0; i < 30; i++ {
+		CacheMachine.Set(fmt.Sprintf("key%d", i), value)
+	}
+	start = time.Now()
+	CacheMachine.Flush()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected Flush not to be throttled, took %s", elapsed)
+	}
+}
@@ -0,0 +1,145 @@
+package cachemachine
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultThrashCooldown is the cool-down of EnableThrashProtection when none
+// is given.
+const DefaultThrashCooldown = 5 * time.Minute
+
+// thrashReadWords is the number of words of the filter of the keys read
+// from RAM, 512KiB in total.
+const thrashReadWords = 1 << 17
+
+// thrashState tracks the values of an entry that were evicted from RAM
+// without being read.
+type thrashState struct {
+	// unreadEvictions is the number of consecutive values of the entry
+	// evicted from RAM without being read.
+	unreadEvictions int
+
+	// divertedUntil is the end of the cool-down of a thrashing entry,
+	// during which its values are written straight to disk.
+	divertedUntil time.Time
+}
+
+// thrashGuard detects the keys whose values are repeatedly evicted from RAM
+// without being read. The keys read from RAM since their last Set are
+// recorded in a filter of one bit per hash of key, set without a lock by
+// the readers and cleared by the next Set. Keys sharing a bit may pass for
+// read, which only makes the guard more lenient.
+type thrashGuard struct {
+	threshold int
+	cooldown  time.Duration
+	reads     []uint32
+}
+
+// EnableThrashProtection starts watching for keys whose values are evicted
+// from RAM without being read, threshold times in a row. Such keys only
+// evict more useful entries: their values are written straight to disk for
+// cooldown, DefaultThrashCooldown when zero, after which they are stored in
+// RAM again. Keys are not diverted when the disk cache is not enabled.
+func (c *CacheMachine) EnableThrashProtection(threshold int, cooldown time.Duration) error {
+	if threshold <= 0 {
+		return fmt.Errorf("threshold must be greater than 0")
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultThrashCooldown
+	}
+	c.thrash.Store(&thrashGuard{
+		threshold: threshold,
+		cooldown:  cooldown,
+		reads:     make([]uint32, thrashReadWords),
+	})
+	return nil
+}
+
+func (c *CacheMachine) thrashGuard() *thrashGuard {
+	guard, _ := c.thrash.Load().(*thrashGuard)
+	return guard
+}
+
+// recordRamRead records that the value of key was read from RAM.
+func (c *CacheMachine) recordRamRead(key string) {
+	if guard := c.thrashGuard(); guard != nil {
+		guard.recordRead(key)
+	}
+}
+
+// bit returns the word and the bit of key in the filter of the keys read.
+func (g *thrashGuard) bit(key string) (*uint32, uint32) {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &g.reads[(hash>>5)%thrashReadWords], 1 << (hash & 31)
+}
+
+func (g *thrashGuard) recordRead(key string) {
+	word, bit := g.bit(key)
+	for {
+		old := atomic.LoadUint32(word)
+		if old&bit != 0 || atomic.CompareAndSwapUint32(word, old, old|bit) {
+			return
+		}
+	}
+}
+
+// takeRead reports whether key was read since the previous call, and clears
+// its bit.
+func (g *thrashGuard) takeRead(key string) bool {
+	word, bit := g.bit(key)
+	for {
+		old := atomic.LoadUint32(word)
+		if old&bit == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(word, old, old&^bit) {
+			return true
+		}
+	}
+}
+
+// observeThrash returns the thrash state of the entry for key, about to be
+// replaced by a new value: the previous value counts as evicted unread when
+// it was stored in RAM, is not there anymore and was not read. It must be
+// called with the stripe of the key locked.
+func (c *CacheMachine) observeThrash(key string, previous CacheSyncTable, ok bool, now time.Time) thrashState {
+	guard := c.thrashGuard()
+	if guard == nil {
+		return thrashState{}
+	}
+	read := guard.takeRead(key)
+	if !ok || previous.Negative || previous.expired(now) {
+		return thrashState{}
+	}
+	state := previous.thrash
+	if !state.divertedUntil.IsZero() {
+		if now.Before(state.divertedUntil) {
+			return state
+		}
+		return thrashState{}
+	}
+	if read {
+		return thrashState{}
+	}
+	if previous.cold {
+		return state
+	}
+	if _, err := c.RamCache.TTL([]byte(key)); err == nil {
+		return state
+	}
+	state.unreadEvictions++
+	if state.unreadEvictions < guard.threshold {
+		return state
+	}
+	c.emitEvent(EventPolicyTrip, "key diverted to disk after thrashing", map[string]interface{}{
+		"key":      key,
+		"cooldown": guard.cooldown.String(),
+	})
+	return thrashState{divertedUntil: now.Add(guard.cooldown)}
+}
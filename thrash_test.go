@@ -0,0 +1,85 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_ThrashProtection(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if err := CacheMachine.EnableThrashProtection(0, 0); err == nil {
+		t.Errorf("Expected an error enabling thrash protection with no threshold")
+	}
+	CacheMachine.EnableThrashProtection(2, time.Hour)
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	round := 0
+	evict := func() {
+		round++
+		filler := make([]byte, 100)
+		for i := 0; i < 10000; i++ {
+			CacheMachine.Set(fmt.Sprintf("filler%d-%d", round, i), filler)
+		}
+	}
+	inRam := func(key string) bool {
+		_, err := CacheMachine.RAMTier().Get(context.Background(), key)
+		return err == nil
+	}
+
+	// Reading the value resets the count of unread evictions.
+	CacheMachine.Set("read", []byte("12345"))
+	for i := 0; i < 3; i++ {
+		evict()
+		CacheMachine.Set("read", []byte("12345"))
+		CacheMachine.Get("read")
+	}
+	if !inRam("read") {
+		t.Errorf("Expected a key read between its evictions to be stored in RAM")
+	}
+
+	CacheMachine.Set("churn", []byte("12345"))
+	evict()
+	CacheMachine.Set("churn", []byte("12345"))
+	if !inRam("churn") {
+		t.Fatalf("Expected churn to be stored in RAM after one unread eviction")
+	}
+	evict()
+	CacheMachine.Set("churn", []byte("67890"))
+	if inRam("churn") {
+		t.Errorf("Expected churn to be diverted to disk")
+	}
+	if value, ok := CacheMachine.Get("churn"); !ok || string(value) != "67890" {
+		t.Errorf("Expected 67890 from disk, got %q, %v", value, ok)
+	}
+	if stats := CacheMachine.Stats(); stats.ThrashDiversions != 1 {
+		t.Errorf("Expected 1 thrash diversion, got %d", stats.ThrashDiversions)
+	}
+
+	// Once the cool-down is over, the key is stored in RAM again.
+	shard := CacheMachine.syncTable.shard("churn")
+	shard.Lock()
+	cacheSync := shard.entries["churn"]
+	cacheSync.thrash.divertedUntil = time.Now().Add(-time.Second)
+	shard.entries["churn"] = cacheSync
+	shard.Unlock()
+	CacheMachine.Set("churn", []byte("12345"))
+	if !inRam("churn") {
+		t.Errorf("Expected churn to be stored in RAM after its cool-down")
+	}
+}
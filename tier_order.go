@@ -0,0 +1,127 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTierReorderRatio is the ratio of EnableTierReordering when none is
+// given.
+const DefaultTierReorderRatio = 2
+
+// tierLatencyWeight is the inverse of the weight of a read in the moving
+// average of the latency of a tier.
+const tierLatencyWeight = 8
+
+// tierProbeInterval is the number of reads of entries held both on disk
+// and in S3 out of which one still reads the demoted tier first, so that
+// its latency keeps being measured and it can be preferred again once it
+// recovers.
+const tierProbeInterval = 16
+
+// tierOrder holds the order in which the cold tiers are read, disk then S3
+// unless the disk is found slower than S3 by EnableTierReordering.
+type tierOrder struct {
+	// ratio holds the bits of the ratio of EnableTierReordering, zero when
+	// it is not enabled.
+	ratio   uint64
+	reads   uint64
+	s3First int32
+}
+
+// EnableTierReordering makes the cache machine read the entries held both on
+// disk and in S3 from S3 first when reading from disk becomes slower than
+// reading from S3, like when the disk is degraded, and from disk first
+// again once it recovers. The latencies of the tiers are the moving
+// averages reported by Stats, and the order only changes when the tier read
+// first is slower than the other one by more than ratio,
+// DefaultTierReorderRatio when zero, so that it does not flap. Every change
+// is reported as an EventTierReordered.
+func (c *CacheMachine) EnableTierReordering(ratio float64) error {
+	if ratio == 0 {
+		ratio = DefaultTierReorderRatio
+	}
+	if ratio <= 1 {
+		return fmt.Errorf("ratio must be greater than 1")
+	}
+	atomic.StoreUint64(&c.tierOrder.ratio, math.Float64bits(ratio))
+	return nil
+}
+
+// DisableTierReordering makes the cache machine read disk then S3 again.
+func (c *CacheMachine) DisableTierReordering() {
+	atomic.StoreUint64(&c.tierOrder.ratio, 0)
+	atomic.StoreInt32(&c.tierOrder.s3First, 0)
+}
+
+// preferS3 reports whether the entries held both on disk and in S3 are read
+// from S3 first.
+func (c *CacheMachine) preferS3() bool {
+	if atomic.LoadInt32(&c.tierOrder.s3First) == 0 {
+		return false
+	}
+	return atomic.AddUint64(&c.tierOrder.reads, 1)%tierProbeInterval != 0
+}
+
+// timedRead wraps a read from a cold tier so that the latency of its
+// successful reads is measured.
+func (c *CacheMachine) timedRead(r tierRead) tierRead {
+	read := r.read
+	r.read = func(ctx context.Context) ([]byte, error) {
+		start := time.Now()
+		value, err := read(ctx)
+		if err == nil {
+			c.recordTierLatency(r.tier, time.Since(start))
+		}
+		return value, err
+	}
+	return r
+}
+
+// recordTierLatency adds the latency of a read to the moving average of its
+// tier, and reorders the tiers if need be.
+func (c *CacheMachine) recordTierLatency(t tier, latency time.Duration) {
+	average := &c.stats.diskReadLatency
+	if t == tierS3 {
+		average = &c.stats.s3ReadLatency
+	}
+	for {
+		old := atomic.LoadInt64(average)
+		updated := int64(latency)
+		if old != 0 {
+			updated = old + (int64(latency)-old)/tierLatencyWeight
+		}
+		if atomic.CompareAndSwapInt64(average, old, updated) {
+			break
+		}
+	}
+
+	ratio := math.Float64frombits(atomic.LoadUint64(&c.tierOrder.ratio))
+	if ratio == 0 {
+		return
+	}
+	disk := float64(atomic.LoadInt64(&c.stats.diskReadLatency))
+	s3 := float64(atomic.LoadInt64(&c.stats.s3ReadLatency))
+	if disk == 0 || s3 == 0 {
+		return
+	}
+	if disk > s3*ratio && atomic.CompareAndSwapInt32(&c.tierOrder.s3First, 0, 1) {
+		c.reportTierOrder(tierS3, tierDisk, disk, s3)
+	} else if s3 > disk*ratio && atomic.CompareAndSwapInt32(&c.tierOrder.s3First, 1, 0) {
+		c.reportTierOrder(tierDisk, tierS3, disk, s3)
+	}
+}
+
+func (c *CacheMachine) reportTierOrder(first, second tier, disk, s3 float64) {
+	atomic.AddInt64(&c.stats.tierReorders, 1)
+	c.Logger.Info("cold tiers reordered", "first", string(first), "second", string(second))
+	c.emitEvent(EventTierReordered, "cold tiers reordered", map[string]interface{}{
+		"first":        string(first),
+		"second":       string(second),
+		"disk_latency": time.Duration(disk).String(),
+		"s3_latency":   time.Duration(s3).String(),
+	})
+}
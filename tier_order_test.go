@@ -0,0 +1,95 @@
+package cachemachine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_TierReordering(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	reordered := make(chan Event, 2)
+	CacheMachine.EnableEvents(func(event Event) {
+		if event.Type == EventTierReordered {
+			reordered <- event
+		}
+	}, 100)
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(newMemoryStore())
+
+	if err := CacheMachine.EnableTierReordering(1); err == nil {
+		t.Errorf("Expected an error enabling tier reordering with a ratio of 1")
+	}
+	if err := CacheMachine.EnableTierReordering(0); err != nil {
+		t.Fatalf("Expected no error enabling tier reordering, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.ClearRamCache()
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "12345" {
+		t.Fatalf("Expected 12345, got %q, %v", value, ok)
+	}
+	stats := CacheMachine.Stats()
+	if stats.DiskHits != 1 || stats.DiskReadLatency == 0 {
+		t.Errorf("Expected a disk hit with its latency measured, got %+v", stats)
+	}
+
+	// A degraded disk makes S3 read first.
+	CacheMachine.recordTierLatency(tierS3, time.Millisecond)
+	CacheMachine.recordTierLatency(tierDisk, time.Second)
+	select {
+	case event := <-reordered:
+		if event.Fields["first"] != "s3" {
+			t.Errorf("Expected S3 to be read first, got %v", event.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the tiers to be reordered")
+	}
+	CacheMachine.ClearRamCache()
+	CacheMachine.Get("key1")
+	if stats := CacheMachine.Stats(); stats.S3Hits != 1 || stats.DiskHits != 1 {
+		t.Errorf("Expected key1 to be read from S3, got %d disk and %d S3 hits", stats.DiskHits, stats.S3Hits)
+	}
+
+	// A disk barely faster than S3 is not preferred again.
+	CacheMachine.recordTierLatency(tierDisk, 0)
+	for i := 0; i < 100; i++ {
+		CacheMachine.recordTierLatency(tierS3, time.Millisecond)
+		CacheMachine.recordTierLatency(tierDisk, 800*time.Microsecond)
+	}
+	if !CacheMachine.preferS3() {
+		t.Errorf("Expected S3 to still be preferred")
+	}
+
+	// A recovered disk is read first again.
+	for i := 0; i < 100; i++ {
+		CacheMachine.recordTierLatency(tierDisk, 100*time.Microsecond)
+	}
+	select {
+	case event := <-reordered:
+		if event.Fields["first"] != "disk" {
+			t.Errorf("Expected the disk to be read first, got %v", event.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the tiers to be reordered")
+	}
+	CacheMachine.ClearRamCache()
+	CacheMachine.Get("key1")
+	if stats := CacheMachine.Stats(); stats.DiskHits != 2 || stats.TierReorders != 2 {
+		t.Errorf("Expected key1 to be read from disk after 2 reorders, got %d disk hits and %d reorders", stats.DiskHits, stats.TierReorders)
+	}
+}
@@ -0,0 +1,262 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cdemers/cachemachine/diskcache"
+)
+
+// Tier is a single storage tier of a cache machine, as returned by RAMTier,
+// DiskTier and RemoteTier. Unlike the RamCache, DiskCache and S3Cache
+// fields, a Tier keeps the sync table of the cache machine consistent with
+// what it changes.
+//
+// A value put in a tier becomes the value of the entry: the copies held by
+// the faster tiers are dropped, and the slower tiers are written by the
+// next sync. The TTL of the entry, if any, is kept. The stats of the cache
+// machine are not updated.
+type Tier interface {
+	// Name returns the name of the tier: "ram", "disk" or "s3".
+	Name() string
+
+	// Get returns the value of key held by the tier, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Put stores val against key in the tier.
+	Put(ctx context.Context, key string, val []byte) error
+
+	// Delete removes the value of key from the tier. The entry is
+	// forgotten once no tier holds it anymore.
+	Delete(ctx context.Context, key string) error
+}
+
+// RAMTier returns the RAM tier of the cache machine.
+func (c *CacheMachine) RAMTier() Tier {
+	return ramTier{c}
+}
+
+// DiskTier returns the disk tier of the cache machine, or nil when the disk
+// cache is not enabled.
+func (c *CacheMachine) DiskTier() Tier {
+	if c.DiskCache == nil {
+		return nil
+	}
+	return diskTier{c}
+}
+
+// RemoteTier returns the S3 tier of the cache machine, or nil when the S3
+// cache is not enabled.
+func (c *CacheMachine) RemoteTier() Tier {
+	if c.S3Cache == nil {
+		return nil
+	}
+	return remoteTier{c}
+}
+
+type ramTier struct {
+	c *CacheMachine
+}
+
+func (t ramTier) Name() string {
+	return string(tierRAM)
+}
+
+func (t ramTier) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	value, err := t.c.RamCache.Get([]byte(key))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (t ramTier) Put(ctx context.Context, key string, val []byte) error {
+	return t.c.putTier(ctx, tierRAM, key, func(expiresAt time.Time) error {
+		var expireSeconds int
+		if !expiresAt.IsZero() {
+			expireSeconds = int((time.Until(expiresAt) + time.Second - 1) / time.Second)
+		}
+		if err := t.c.RamCache.Set([]byte(key), val, expireSeconds); err != nil {
+			return fmt.Errorf("error setting key %s: %s", key, err)
+		}
+		return nil
+	})
+}
+
+func (t ramTier) Delete(ctx context.Context, key string) error {
+	return t.c.deleteTier(ctx, key, func(*syncTableShard) error {
+		t.c.RamCache.Del([]byte(key))
+		return nil
+	})
+}
+
+type diskTier struct {
+	c *CacheMachine
+}
+
+func (t diskTier) Name() string {
+	return string(tierDisk)
+}
+
+func (t diskTier) Get(ctx context.Context, key string) ([]byte, error) {
+	diskCache := t.c.DiskCache
+	if diskCache == nil {
+		return nil, fmt.Errorf("disk cache is not enabled")
+	}
+	value, err := withContext(ctx, func() ([]byte, error) {
+		return diskCache.GetContext(ctx, key)
+	})
+	if err == diskcache.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (t diskTier) Put(ctx context.Context, key string, val []byte) error {
+	return t.c.putTier(ctx, tierDisk, key, func(time.Time) error {
+		if t.c.DiskCache == nil {
+			return fmt.Errorf("disk cache is not enabled")
+		}
+		return t.c.putDisk(t.c.DiskCache, key, val)
+	})
+}
+
+func (t diskTier) Delete(ctx context.Context, key string) error {
+	return t.c.deleteTier(ctx, key, func(*syncTableShard) error {
+		if t.c.DiskCache == nil {
+			return fmt.Errorf("disk cache is not enabled")
+		}
+		_, err := t.c.DiskCache.Delete(key)
+		return err
+	})
+}
+
+type remoteTier struct {
+	c *CacheMachine
+}
+
+func (t remoteTier) Name() string {
+	return string(tierS3)
+}
+
+func (t remoteTier) Get(ctx context.Context, key string) ([]byte, error) {
+	s3Cache := t.c.S3Cache
+	if s3Cache == nil {
+		return nil, fmt.Errorf("S3 cache is not enabled")
+	}
+	value, err := t.c.getObject(ctx, s3Cache, key)
+	if err == ErrObjectNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (t remoteTier) Put(ctx context.Context, key string, val []byte) error {
+	return t.c.putTier(ctx, tierS3, key, func(time.Time) error {
+		if t.c.S3Cache == nil {
+			return fmt.Errorf("S3 cache is not enabled")
+		}
+		return t.c.S3Cache.Put(ctx, key, t.c.sealObject(key, val))
+	})
+}
+
+func (t remoteTier) Delete(ctx context.Context, key string) error {
+	return t.c.deleteTier(ctx, key, func(shard *syncTableShard) error {
+		if t.c.S3Cache == nil {
+			return fmt.Errorf("S3 cache is not enabled")
+		}
+		if err := t.c.S3Cache.Delete(ctx, key); err != nil {
+			return err
+		}
+		if cacheSync, ok := shard.entries[key]; ok {
+			cacheSync.S3Sync = false
+			shard.entries[key] = cacheSync
+		}
+		return nil
+	})
+}
+
+// putTier calls put, which writes the value of key to the tier t given the
+// expiry of the entry, then updates the entry: the copies held by the tiers
+// faster than t are dropped, and the entry is queued to be written to the
+// tiers slower than t.
+func (c *CacheMachine) putTier(ctx context.Context, t tier, key string, put func(expiresAt time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if c.legalHolds.held(key) {
+		return ErrLegalHold
+	}
+	now := time.Now()
+	previous, ok := shard.entries[key]
+	if ok && (previous.expired(now) || previous.Negative) {
+		ok = false
+	}
+	cacheSync := CacheSyncTable{SetAt: now, dirtySince: now}
+	if ok {
+		cacheSync.ExpiresAt = previous.ExpiresAt
+	}
+	c.preserveForSnapshots(shard, key)
+	cacheSync.generation = c.nextGeneration()
+	if err := put(cacheSync.ExpiresAt); err != nil {
+		return err
+	}
+
+	switch t {
+	case tierS3:
+		cacheSync.S3Sync = true
+		if c.DiskCache != nil {
+			if _, err := c.DiskCache.Delete(key); err != nil {
+				c.logError("error deleting from disk", "key", key, "error", err)
+			}
+		}
+		fallthrough
+	case tierDisk:
+		// An entry synced to disk but missing from it, like one evicted
+		// from disk, is read from the slower tiers.
+		cacheSync.DiskSynced = true
+		c.RamCache.Del([]byte(key))
+	}
+	pending := ok && !previous.DiskSynced
+	if !pending && ((c.DiskCache != nil && !cacheSync.DiskSynced) || (c.S3Cache != nil && !cacheSync.S3Sync)) {
+		c.enqueueDirty(shard, key)
+	} else if pending {
+		cacheSync.dirtySince = previous.dirtySince
+	}
+	shard.entries[key] = cacheSync
+	c.invalidate(key)
+	return nil
+}
+
+// deleteTier calls del, which deletes the value of key from a tier, then
+// forgets about the entry if no tier holds it anymore.
+func (c *CacheMachine) deleteTier(ctx context.Context, key string, del func(shard *syncTableShard) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if c.legalHolds.held(key) {
+		return ErrLegalHold
+	}
+	c.preserveForSnapshots(shard, key)
+	if err := del(shard); err != nil {
+		return err
+	}
+	if cacheSync, ok := shard.entries[key]; ok {
+		c.sweepEntry(shard, key, cacheSync, time.Now())
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cachemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_Tiers(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	if CacheMachine.DiskTier() != nil || CacheMachine.RemoteTier() != nil {
+		t.Errorf("Expected no disk nor S3 tier before they are enabled")
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	ctx := context.Background()
+	ram, disk, remote := CacheMachine.RAMTier(), CacheMachine.DiskTier(), CacheMachine.RemoteTier()
+	if ram.Name() != "ram" || disk.Name() != "disk" || remote.Name() != "s3" {
+		t.Errorf("Unexpected tier names %s, %s and %s", ram.Name(), disk.Name(), remote.Name())
+	}
+
+	// A value put on disk replaces the RAM copy, and is synced to S3.
+	CacheMachine.SetWithTTL("key1", []byte("12345"), time.Hour)
+	if err := disk.Put(ctx, "key1", []byte("67890")); err != nil {
+		t.Fatalf("Expected no error putting on disk, got %s", err)
+	}
+	if _, err := ram.Get(ctx, "key1"); err != ErrNotFound {
+		t.Errorf("Expected the RAM copy to be dropped, got %v", err)
+	}
+	state, _ := CacheMachine.SyncState("key1")
+	if !state.DiskSynced || state.S3Sync || state.ExpiresAt.IsZero() {
+		t.Errorf("Expected key1 to be on disk only, with its TTL, got %+v", state)
+	}
+	CacheMachine.Flush()
+	if value, err := remote.Get(ctx, "key1"); err != nil || string(value) != "67890" {
+		t.Errorf("Expected 67890 in S3, got %q, %v", value, err)
+	}
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "67890" {
+		t.Errorf("Expected 67890, got %q, %v", value, ok)
+	}
+
+	// A value put in S3 replaces the RAM and disk copies.
+	if err := remote.Put(ctx, "key1", []byte("abcde")); err != nil {
+		t.Fatalf("Expected no error putting in S3, got %s", err)
+	}
+	if _, err := disk.Get(ctx, "key1"); err != ErrNotFound {
+		t.Errorf("Expected the disk copy to be dropped, got %v", err)
+	}
+	if value, ok := CacheMachine.Get("key1"); !ok || string(value) != "abcde" {
+		t.Errorf("Expected abcde from S3, got %q, %v", value, ok)
+	}
+
+	// A value put in RAM is synced to the other tiers.
+	if err := ram.Put(ctx, "key2", []byte("12345")); err != nil {
+		t.Fatalf("Expected no error putting in RAM, got %s", err)
+	}
+	CacheMachine.Flush()
+	if value, err := disk.Get(ctx, "key2"); err != nil || string(value) != "12345" {
+		t.Errorf("Expected 12345 on disk, got %q, %v", value, err)
+	}
+
+	// The entry is forgotten once it is in no tier.
+	for _, tier := range []Tier{ram, disk} {
+		if err := tier.Delete(ctx, "key2"); err != nil {
+			t.Errorf("Expected no error deleting from %s, got %s", tier.Name(), err)
+		}
+	}
+	if _, ok := CacheMachine.SyncState("key2"); !ok {
+		t.Errorf("Expected key2 to be tracked while in S3")
+	}
+	if err := remote.Delete(ctx, "key2"); err != nil {
+		t.Errorf("Expected no error deleting from S3, got %s", err)
+	}
+	if _, ok := CacheMachine.SyncState("key2"); ok {
+		t.Errorf("Expected key2 to be forgotten")
+	}
+
+	CacheMachine.SetLegalHold(ctx, "key1", true)
+	if err := ram.Put(ctx, "key1", []byte("12345")); err != ErrLegalHold {
+		t.Errorf("Expected ErrLegalHold, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package cachemachine
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts are the deadlines applied to the operations of a cache machine
+// whose context has none, so that code forgetting to set a timeout does not
+// wait on a slow disk or S3 tier forever. A zero timeout applies no
+// deadline.
+type Timeouts struct {
+	// Get bounds Get, GetCtx, MGet, MGetCtx, Where and WhereCtx, and the
+	// reads of SetIfAbsent, CompareAndSwap, Increment and Decrement.
+	Get time.Duration
+
+	// Delete bounds Delete, DeleteCtx and SetLegalHold.
+	Delete time.Duration
+
+	// Purge bounds PurgeBySubject.
+	Purge time.Duration
+
+	// Flush bounds FlushCtx, Close and CloseCtx.
+	Flush time.Duration
+}
+
+// withDefaultTimeout returns ctx bounded by timeout, unless ctx already has
+// a deadline or timeout is zero.
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,90 @@
+package cachemachine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// hangingStore is an ObjectStore whose reads never complete before the
+// context is done.
+type hangingStore struct {
+	*memoryStore
+}
+
+func (s *hangingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCacheMachine_DefaultTimeouts(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024*1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+	CacheMachine.EnableS3Cache(&hangingStore{memoryStore: newMemoryStore()})
+	CacheMachine.DefaultTimeouts.Get = 50 * time.Millisecond
+
+	// key1 is only known to be in S3.
+	shard := CacheMachine.syncTable.shard("key1")
+	shard.Lock()
+	shard.entries["key1"] = CacheSyncTable{S3Sync: true, SetAt: time.Now()}
+	shard.Unlock()
+
+	start := time.Now()
+	_, ok, err := CacheMachine.GetCtx(context.Background(), "key1")
+	if ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the default deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Get to return after the default deadline, took %s", elapsed)
+	}
+
+	// A deadline set by the caller is kept.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, _, err = CacheMachine.GetCtx(ctx, "key1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the caller deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected the caller deadline to be kept, took %s", elapsed)
+	}
+}
+
+func TestWithDefaultTimeout(t *testing.T) {
+	ctx, cancel := withDefaultTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("Expected no deadline for a zero timeout")
+	}
+
+	ctx, cancel = withDefaultTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("Expected a deadline to be set")
+	}
+
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	want, _ := parent.Deadline()
+	ctx, cancel = withDefaultTimeout(parent, time.Minute)
+	defer cancel()
+	if got, _ := ctx.Deadline(); !got.Equal(want) {
+		t.Errorf("Expected the parent deadline %s to be kept, got %s", want, got)
+	}
+}
@@ -0,0 +1,81 @@
+package cachemachine
+
+import "context"
+
+// Names of the attributes set on the spans of the cache machine.
+const (
+	AttributeNamespace = "cachemachine.namespace"
+	AttributeTier      = "cachemachine.tier"
+	AttributeHit       = "cachemachine.hit"
+	AttributeBytes     = "cachemachine.bytes"
+	AttributeNegative  = "cachemachine.negative"
+	AttributeDeleted   = "cachemachine.deleted"
+	AttributeSynced    = "cachemachine.synced"
+	AttributeLost      = "cachemachine.lost"
+)
+
+// Tracer starts the spans of the cache machine. It mirrors the subset of the
+// OpenTelemetry trace API the cache machine uses, so that an OpenTelemetry
+// tracer can be plugged in with a small adapter without the library
+// depending on OpenTelemetry:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, cachemachine.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// where otelSpan converts the attributes with attribute.String, Bool and
+// Int64 and sets the span status to codes.Error in RecordError.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. The values of attributes are strings,
+// bools or int64s.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// tracerHolder allows storing a nil Tracer in an atomic.Value.
+type tracerHolder struct {
+	tracer Tracer
+}
+
+// EnableTracing starts a span, through tracer, around every Get, Set and
+// Delete and every background sync. The spans of the context variants, such
+// as GetCtx, are children of the span found in their context.
+func (c *CacheMachine) EnableTracing(tracer Tracer) {
+	c.tracer.Store(tracerHolder{tracer})
+}
+
+// DisableTracing stops starting spans.
+func (c *CacheMachine) DisableTracing() {
+	c.tracer.Store(tracerHolder{})
+}
+
+// startSpan starts a span named name, with the namespace of key as attribute
+// unless key is empty. It returns a nil span when tracing is disabled, so
+// that the hot paths do not pay for building attributes.
+func (c *CacheMachine) startSpan(ctx context.Context, name, key string) (context.Context, Span) {
+	holder, _ := c.tracer.Load().(tracerHolder)
+	if holder.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := holder.tracer.Start(ctx, name)
+	if key != "" {
+		span.SetAttribute(AttributeNamespace, KeyNamespace(key))
+	}
+	return ctx, span
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,96 @@
+package cachemachine
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name       string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordingSpan{name: name, attributes: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordingSpan) RecordError(err error)                      { s.err = err }
+func (s *recordingSpan) End()                                       { s.ended = true }
+
+func TestCacheMachine_EnableTracing(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	tracer := &recordingTracer{}
+	CacheMachine.EnableTracing(tracer)
+
+	CacheMachine.Set("users:1", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("users:1"))
+	CacheMachine.Get("users:1")
+	CacheMachine.Get("users:2")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	CacheMachine.DeleteCtx(cancelled, "users:1")
+
+	CacheMachine.DisableTracing()
+	CacheMachine.Get("users:1")
+
+	expected := []struct {
+		name       string
+		attributes map[string]interface{}
+		err        error
+	}{
+		{"cachemachine.Set", map[string]interface{}{AttributeNamespace: "users", AttributeTier: "ram", AttributeBytes: int64(5)}, nil},
+		{"cachemachine.Sync", map[string]interface{}{AttributeSynced: int64(1), AttributeLost: int64(0)}, nil},
+		{"cachemachine.Get", map[string]interface{}{AttributeNamespace: "users", AttributeTier: "disk", AttributeHit: true, AttributeBytes: int64(5)}, nil},
+		{"cachemachine.Get", map[string]interface{}{AttributeNamespace: "users", AttributeTier: "", AttributeHit: false, AttributeBytes: int64(0)}, nil},
+		{"cachemachine.Delete", map[string]interface{}{AttributeNamespace: "users", AttributeDeleted: false}, context.Canceled},
+	}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("Expected %d spans, got %d", len(expected), len(tracer.spans))
+	}
+	for i, e := range expected {
+		span := tracer.spans[i]
+		if span.name != e.name || span.err != e.err || !span.ended {
+			t.Errorf("#%d: Expected ended span %s with error %v, got %+v", i+1, e.name, e.err, span)
+		}
+		if len(span.attributes) != len(e.attributes) {
+			t.Errorf("#%d: Expected attributes %v, got %v", i+1, e.attributes, span.attributes)
+			continue
+		}
+		for key, value := range e.attributes {
+			if span.attributes[key] != value {
+				t.Errorf("#%d: Expected attribute %s to be %v, got %v", i+1, key, value, span.attributes[key])
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cachemachine
+
+import "errors"
+
+// ErrBusy is returned by TryGet and TrySet when the operation cannot
+// complete without waiting on a lock or on a slow tier.
+var ErrBusy = errors.New("cache machine is busy")
+
+// TryGet returns the value for the given key like Get, but never waits: it
+// only answers from the RAM cache, and returns ErrBusy if the key is locked
+// by another operation or if its value is only available from disk or S3.
+// It returns nil, false and no error on a miss.
+func (c *CacheMachine) TryGet(key string) (value []byte, ok bool, err error) {
+	c.recordReaccessRead(key)
+	value, err = c.RamCache.Get([]byte(key))
+	if err == nil {
+		c.stats.recordRamHit(len(value))
+		c.namespaceCounters(key).recordRamHit()
+		c.recordRamRead(key)
+		c.recordIdleRead(key)
+		return value, true, nil
+	}
+
+	shard := c.syncTable.shard(key)
+	if !shard.TryLock() {
+		return nil, false, ErrBusy
+	}
+	cacheSync := shard.entries[key]
+	shard.Unlock()
+
+	if (cacheSync.DiskSynced && c.DiskCache != nil) || (cacheSync.S3Sync && c.S3Cache != nil) {
+		return nil, false, ErrBusy
+	}
+	c.stats.recordMiss()
+	c.namespaceCounters(key).recordMiss()
+	return nil, false, nil
+}
+
+// TrySet sets the value for the given key like Set, but returns ErrBusy
+// instead of waiting if the key is locked by another operation.
+func (c *CacheMachine) TrySet(key string, val []byte) error {
+	shard := c.syncTable.shard(key)
+	if !shard.TryLock() {
+		return ErrBusy
+	}
+	defer shard.Unlock()
+
+	return c.set(shard, key, val, 0)
+}
@@ -0,0 +1,114 @@
+package cachemachine
+
+import "testing"
+
+func TestCacheMachine_TryGetTrySet(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	err = CacheMachine.TrySet("key1", []byte("12345"))
+	if err != nil {
+		t.Errorf("Expected no error setting key1, got %s", err)
+	}
+
+	value, ok, err := CacheMachine.TryGet("key1")
+	if err != nil || !ok || string(value) != "12345" {
+		t.Errorf("Expected value to be 12345, got %s, %v, %v", value, ok, err)
+	}
+
+	_, ok, err = CacheMachine.TryGet("key2")
+	if err != nil || ok {
+		t.Errorf("Expected a miss getting key2, got %v, %v", ok, err)
+	}
+
+	shard := CacheMachine.syncTable.shard("key2")
+	shard.Lock()
+	err = CacheMachine.TrySet("key2", []byte("67890"))
+	if err != ErrBusy {
+		t.Errorf("Expected ErrBusy setting a locked key, got %v", err)
+	}
+	_, _, err = CacheMachine.TryGet("key2")
+	if err != ErrBusy {
+		t.Errorf("Expected ErrBusy getting a locked key, got %v", err)
+	}
+	shard.Unlock()
+
+	// A locked stripe does not prevent RAM hits.
+	CacheMachine.syncTable.shard("key1").Lock()
+	_, ok, err = CacheMachine.TryGet("key1")
+	if err != nil || !ok {
+		t.Errorf("Expected a RAM hit getting key1, got %v, %v", ok, err)
+	}
+	CacheMachine.syncTable.shard("key1").Unlock()
+}
+
+func TestCacheMachine_TryGetFromDisk(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+	CacheMachine.RamCache.Del([]byte("key1"))
+
+	_, _, err = CacheMachine.TryGet("key1")
+	if err != ErrBusy {
+		t.Errorf("Expected ErrBusy getting a value only on disk, got %v", err)
+	}
+}
+
+func TestCacheMachine_TryGetFromS3(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	err = CacheMachine.EnableS3Cache(newMemoryStore())
+	if err != nil {
+		t.Errorf("Expected no error enabling S3 cache, got %s", err)
+	}
+
+	CacheMachine.Set("key1", []byte("12345"))
+	CacheMachine.Flush()
+	// key1 is only held by S3.
+	CacheMachine.RamCache.Del([]byte("key1"))
+	CacheMachine.DiskCache.Delete("key1")
+	shard := CacheMachine.syncTable.shard("key1")
+	shard.Lock()
+	cacheSync := shard.entries["key1"]
+	cacheSync.DiskSynced = false
+	shard.entries["key1"] = cacheSync
+	shard.Unlock()
+
+	_, _, err = CacheMachine.TryGet("key1")
+	if err != ErrBusy {
+		t.Errorf("Expected ErrBusy getting a value only in S3, got %v", err)
+	}
+}
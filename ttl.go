@@ -0,0 +1,54 @@
+package cachemachine
+
+import "time"
+
+// TTLPolicy rewrites the TTL requested for a key, a TTL of 0 meaning that the
+// entry never expires. It must be safe for concurrent use, and is called
+// with the key locked, so it must not call back into the cache machine.
+type TTLPolicy func(key string, ttl time.Duration) time.Duration
+
+// SetWithTTL is like Set, but the entry expires after ttl. A ttl of 0 means
+// that the entry never expires, unless its namespace has a sliding
+// expiration, see EnableSlidingExpiration. The TTL is subject to the adaptive
+// TTL of its namespace, see EnableAdaptiveTTL, to TTLPolicy, MinTTL and
+// MaxTTL.
+func (c *CacheMachine) SetWithTTL(key string, val []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	return c.set(shard, key, val, ttl)
+}
+
+// applyTTLPolicy returns the TTL of key once replaced by the adaptive TTL of
+// its namespace, rewritten by TTLPolicy and clamped between MinTTL and
+// MaxTTL, and emits an EventPolicyTrip when it differs from the requested
+// one.
+func (c *CacheMachine) applyTTLPolicy(key string, ttl time.Duration) time.Duration {
+	requested := ttl
+	ttl = c.adaptiveTTL(key, ttl)
+	if c.TTLPolicy != nil {
+		ttl = c.TTLPolicy(key, ttl)
+		if ttl < 0 {
+			ttl = 0
+		}
+	}
+	if c.MaxTTL > 0 && (ttl == 0 || ttl > c.MaxTTL) {
+		ttl = c.MaxTTL
+	}
+	if c.MinTTL > 0 && ttl != 0 && ttl < c.MinTTL {
+		ttl = c.MinTTL
+	}
+	if ttl != requested {
+		c.emitEvent(EventPolicyTrip, "TTL rewritten by policy", map[string]interface{}{
+			"key":       key,
+			"requested": requested.String(),
+			"applied":   ttl.String(),
+		})
+	}
+	return ttl
+}
@@ -0,0 +1,56 @@
+package cachemachine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_SetWithTTL(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+	CacheMachine.MinTTL = time.Minute
+	CacheMachine.MaxTTL = 24 * time.Hour
+	CacheMachine.TTLPolicy = func(key string, ttl time.Duration) time.Duration {
+		if strings.HasPrefix(key, "session:") {
+			return time.Hour
+		}
+		return ttl
+	}
+
+	var events []Event
+	CacheMachine.EnableEvents(func(event Event) { events = append(events, event) }, 100)
+
+	for i, c := range []struct {
+		key      string
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		{key: "key1", ttl: time.Hour, expected: time.Hour},
+		{key: "key2", ttl: time.Second, expected: time.Minute},
+		{key: "key3", ttl: 48 * time.Hour, expected: 24 * time.Hour},
+		{key: "key4", ttl: 0, expected: 24 * time.Hour},
+		{key: "session:1", ttl: 0, expected: time.Hour},
+	} {
+		err := CacheMachine.SetWithTTL(c.key, []byte("12345"), c.ttl)
+		if err != nil {
+			t.Errorf("#%d: Expected no error setting %s, got %s", i+1, c.key, err)
+		}
+		state, _ := CacheMachine.SyncState(c.key)
+		if ttl := state.ExpiresAt.Sub(state.SetAt); ttl != c.expected {
+			t.Errorf("#%d: Expected TTL of %s to be %s, got %s", i+1, c.key, c.expected, ttl)
+		}
+	}
+
+	CacheMachine.DisableEvents()
+	if len(events) != 4 {
+		t.Errorf("Expected 4 policy trips, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.Type != EventPolicyTrip {
+			t.Errorf("Expected a policy trip, got %s", event.Type)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxUsagePrefixes bounds the number of prefixes whose hits are counted for
+// the usage reports. The hits of the other prefixes are counted under
+// OtherNamespace.
+const MaxUsagePrefixes = 1000
+
+// UsagePrefix is the usage of the keys sharing a prefix, in a UsageReport.
+// The prefix of a key at a given depth is made of its first segments, at
+// most depth of them and never the last one, so that the keys "users:1" and
+// "users:1:avatar" have the prefix "users" at depth 1, and the prefixes
+// "users" and "users:1" at depth 2. Keys without separator fall under
+// DefaultNamespace.
+type UsagePrefix struct {
+	Prefix string `json:"prefix"`
+	Keys   int64  `json:"keys"`
+	Bytes  int64  `json:"bytes"`
+	Hits   int64  `json:"hits"`
+
+	// Children are the usages of the prefixes one segment deeper, by
+	// decreasing number of bytes.
+	Children []*UsagePrefix `json:"children,omitempty"`
+}
+
+// UsageReport aggregates the usage of the key space by prefix, see
+// EnableUsageTracking.
+type UsageReport struct {
+	Time     time.Time      `json:"time"`
+	Depth    int            `json:"depth"`
+	Prefixes []*UsagePrefix `json:"prefixes"`
+}
+
+// usageTable counts the hits of the keys per prefix at the tracked depth.
+type usageTable struct {
+	depth    int32
+	prefixes sync.Map
+	count    int64
+	other    int64
+	mu       sync.Mutex
+}
+
+// EnableUsageTracking starts counting the hits of the keys per prefix, up to
+// depth segments, for the usage reports. Hits counted before are dropped.
+func (c *CacheMachine) EnableUsageTracking(depth int) {
+	if depth < 1 {
+		depth = 1
+	}
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+	c.usage.prefixes.Range(func(prefix, _ interface{}) bool {
+		c.usage.prefixes.Delete(prefix)
+		return true
+	})
+	atomic.StoreInt64(&c.usage.count, 0)
+	atomic.StoreInt64(&c.usage.other, 0)
+	atomic.StoreInt32(&c.usage.depth, int32(depth))
+}
+
+// keyPrefix returns the prefix of key at depth, see UsagePrefix.
+func keyPrefix(key string, depth int) string {
+	segments := strings.Split(key, NamespaceSeparator)
+	if len(segments) == 1 || segments[0] == "" {
+		return DefaultNamespace
+	}
+	if depth > len(segments)-1 {
+		depth = len(segments) - 1
+	}
+	return strings.Join(segments[:depth], NamespaceSeparator)
+}
+
+// recordUsageHit counts a hit of key, when usage tracking is enabled.
+func (c *CacheMachine) recordUsageHit(key string) {
+	depth := atomic.LoadInt32(&c.usage.depth)
+	if depth == 0 {
+		return
+	}
+	prefix := keyPrefix(key, int(depth))
+	if hits, ok := c.usage.prefixes.Load(prefix); ok {
+		atomic.AddInt64(hits.(*int64), 1)
+		return
+	}
+	if atomic.LoadInt64(&c.usage.count) >= MaxUsagePrefixes {
+		atomic.AddInt64(&c.usage.other, 1)
+		return
+	}
+
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+	if hits, ok := c.usage.prefixes.Load(prefix); ok {
+		atomic.AddInt64(hits.(*int64), 1)
+		return
+	}
+	if atomic.LoadInt64(&c.usage.count) >= MaxUsagePrefixes {
+		atomic.AddInt64(&c.usage.other, 1)
+		return
+	}
+	hits := new(int64)
+	*hits = 1
+	c.usage.prefixes.Store(prefix, hits)
+	atomic.AddInt64(&c.usage.count, 1)
+}
+
+// UsageReport returns the usage of the key space by prefix, up to the depth
+// given to EnableUsageTracking. The sizes of the entries are read from every
+// tier but S3, so the report is meant to be built periodically rather than
+// on every request. It returns nil when usage tracking is not enabled.
+func (c *CacheMachine) UsageReport() *UsageReport {
+	depth := int(atomic.LoadInt32(&c.usage.depth))
+	if depth == 0 {
+		return nil
+	}
+	report := &UsageReport{Time: time.Now(), Depth: depth}
+	roots := make(map[string]*UsagePrefix)
+	children := make(map[*UsagePrefix]map[string]*UsagePrefix)
+
+	// usage returns the usage of the prefixes of a key, at every depth.
+	usage := func(deepest string) []*UsagePrefix {
+		segments := strings.Split(deepest, NamespaceSeparator)
+		var prefixes []*UsagePrefix
+		level := roots
+		for i := range segments {
+			prefix := strings.Join(segments[:i+1], NamespaceSeparator)
+			p, ok := level[prefix]
+			if !ok {
+				p = &UsagePrefix{Prefix: prefix}
+				level[prefix] = p
+				children[p] = make(map[string]*UsagePrefix)
+			}
+			prefixes = append(prefixes, p)
+			level = children[p]
+		}
+		return prefixes
+	}
+
+	now := time.Now()
+	for i := range c.syncTable {
+		shard := &c.syncTable[i]
+		shard.Lock()
+		for key, cacheSync := range shard.entries {
+			if cacheSync.Negative || !c.live(key, cacheSync, now) {
+				continue
+			}
+			size := c.entrySize(key)
+			for _, p := range usage(keyPrefix(key, depth)) {
+				p.Keys++
+				p.Bytes += size
+			}
+		}
+		shard.Unlock()
+	}
+	c.usage.prefixes.Range(func(prefix, hits interface{}) bool {
+		for _, p := range usage(prefix.(string)) {
+			p.Hits += atomic.LoadInt64(hits.(*int64))
+		}
+		return true
+	})
+	if other := atomic.LoadInt64(&c.usage.other); other > 0 {
+		roots[OtherNamespace] = &UsagePrefix{Prefix: OtherNamespace, Hits: other}
+	}
+
+	var sorted func(level map[string]*UsagePrefix) []*UsagePrefix
+	sorted = func(level map[string]*UsagePrefix) []*UsagePrefix {
+		prefixes := make([]*UsagePrefix, 0, len(level))
+		for _, p := range level {
+			p.Children = sorted(children[p])
+			prefixes = append(prefixes, p)
+		}
+		sort.Slice(prefixes, func(i, j int) bool {
+			if prefixes[i].Bytes != prefixes[j].Bytes {
+				return prefixes[i].Bytes > prefixes[j].Bytes
+			}
+			return prefixes[i].Prefix < prefixes[j].Prefix
+		})
+		if len(prefixes) == 0 {
+			return nil
+		}
+		return prefixes
+	}
+	report.Prefixes = sorted(roots)
+	if report.Prefixes == nil {
+		report.Prefixes = []*UsagePrefix{}
+	}
+	return report
+}
+
+// entrySize returns the size of the value of key in RAM, or on disk. It must
+// be called with the stripe of the key locked.
+func (c *CacheMachine) entrySize(key string) int64 {
+	if value, err := c.RamCache.Peek([]byte(key)); err == nil {
+		return int64(len(value))
+	}
+	if diskCache := c.DiskCache; diskCache != nil {
+		if size, ok := diskCache.EntrySize(key); ok {
+			return size
+		}
+	}
+	return 0
+}
+
+// ExportUsage writes the usage report to the file at path as JSON every
+// interval, until Close is called. Usage tracking must be enabled first.
+func (c *CacheMachine) ExportUsage(path string, interval time.Duration) error {
+	if atomic.LoadInt32(&c.usage.depth) == 0 {
+		return fmt.Errorf("usage tracking is not enabled")
+	}
+	c.AddSink(&usageFileSink{c: c, path: path}, interval)
+	return nil
+}
+
+// usageFileSink writes the usage report on every push, ignoring the stats.
+type usageFileSink struct {
+	c    *CacheMachine
+	path string
+}
+
+func (s *usageFileSink) Push(ctx context.Context, stats Stats) error {
+	data, err := json.MarshalIndent(s.c.UsageReport(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding usage report: %s", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing usage report to %s: %s", s.path, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("error writing usage report to %s: %s", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cachemachine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	for _, c := range []struct {
+		key    string
+		depth  int
+		prefix string
+	}{
+		{"users:1", 1, "users"},
+		{"users:1", 2, "users"},
+		{"users:1:avatar", 2, "users:1"},
+		{"users:1:avatar", 3, "users:1"},
+		{"config", 2, DefaultNamespace},
+		{":1", 1, DefaultNamespace},
+	} {
+		if prefix := keyPrefix(c.key, c.depth); prefix != c.prefix {
+			t.Errorf("Expected prefix %q for %q at depth %d, got %q", c.prefix, c.key, c.depth, prefix)
+		}
+	}
+}
+
+func TestCacheMachine_UsageReport(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	if CacheMachine.UsageReport() != nil {
+		t.Errorf("Expected no report without usage tracking")
+	}
+	CacheMachine.EnableUsageTracking(2)
+
+	CacheMachine.Set("users:1:profile", []byte("12345"))
+	CacheMachine.Set("users:1:avatar", []byte("1234567890"))
+	CacheMachine.Set("users:2:profile", []byte("12345"))
+	CacheMachine.Set("sessions:abc", []byte("123"))
+	CacheMachine.Get("users:1:profile")
+	CacheMachine.Get("users:2:profile")
+	CacheMachine.Get("users:2:profile")
+	CacheMachine.Get("sessions:abc")
+	CacheMachine.Get("sessions:missing")
+
+	report := CacheMachine.UsageReport()
+	if report.Depth != 2 || len(report.Prefixes) != 2 {
+		t.Fatalf("Expected 2 prefixes at depth 2, got %+v", report)
+	}
+	users := report.Prefixes[0]
+	if users.Prefix != "users" || users.Keys != 3 || users.Bytes != 20 || users.Hits != 3 {
+		t.Errorf("Unexpected usage of users: %+v", users)
+	}
+	if len(users.Children) != 2 || users.Children[0].Prefix != "users:1" || users.Children[0].Bytes != 15 || users.Children[0].Hits != 1 {
+		t.Errorf("Unexpected usage of users:1: %+v", users.Children[0])
+	}
+	if users.Children[1].Prefix != "users:2" || users.Children[1].Hits != 2 {
+		t.Errorf("Unexpected usage of users:2: %+v", users.Children[1])
+	}
+	sessions := report.Prefixes[1]
+	if sessions.Prefix != "sessions" || sessions.Keys != 1 || sessions.Bytes != 3 || sessions.Hits != 1 || sessions.Children != nil {
+		t.Errorf("Unexpected usage of sessions: %+v", sessions)
+	}
+}
+
+func TestCacheMachine_ExportUsage(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	path := filepath.Join(tmpFolder, "usage.json")
+	if err := CacheMachine.ExportUsage(path, time.Hour); err == nil {
+		t.Errorf("Expected an error exporting usage without tracking")
+	}
+	CacheMachine.EnableUsageTracking(1)
+	if err := CacheMachine.ExportUsage(path, time.Hour); err != nil {
+		t.Errorf("Expected no error exporting usage, got %s", err)
+	}
+	CacheMachine.Set("users:1", []byte("12345"))
+	CacheMachine.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the usage report to be written, got %s", err)
+	}
+	var report UsageReport
+	json.Unmarshal(data, &report)
+	if len(report.Prefixes) != 1 || report.Prefixes[0].Prefix != "users" || report.Prefixes[0].Bytes != 5 {
+		t.Errorf("Unexpected usage report %s", data)
+	}
+}
@@ -0,0 +1,125 @@
+package cachemachine
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// Residency describes where the entry for a key currently lives, as
+// reported by Where.
+type Residency struct {
+	Key string `json:"key"`
+
+	// Tracked is set when the sync table knows the key, in which case the
+	// fields below describe its sync state.
+	Tracked    bool      `json:"tracked"`
+	SetAt      time.Time `json:"set_at,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	Expired    bool      `json:"expired"`
+	Negative   bool      `json:"negative"`
+	DiskSynced bool      `json:"disk_synced"`
+	S3Synced   bool      `json:"s3_synced"`
+	LegalHold  bool      `json:"legal_hold"`
+
+	// Copies are the copies of the value held by the tiers, fastest
+	// first.
+	Copies []TierCopy `json:"copies"`
+}
+
+// TierCopy describes the copy of a value held by a tier.
+type TierCopy struct {
+	Tier string `json:"tier"`
+	Size int    `json:"size"`
+
+	// Checksum is the CRC-32C of the value, so that copies can be told
+	// apart.
+	Checksum string `json:"checksum"`
+
+	// ExpiresAt is when the RAM copy expires, if ever, and CreatedAt when
+	// the disk copy was written. Path is the file of the disk copy.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	Path      string    `json:"path,omitempty"`
+
+	// Error is set when the copy could not be read, such as a copy that did
+	// not match its checksum. A corrupt copy is deleted, as on any read.
+	Error string `json:"error,omitempty"`
+}
+
+// Where reports which tiers currently hold the entry for key, with the size
+// and checksum of each copy, and the sync state of the entry. Unlike the
+// other reads, it queries the S3 tier even when the entry is not known to
+// be synced there, so that it can be used to debug the sync. It does not
+// affect the access statistics of the tiers.
+func (c *CacheMachine) Where(key string) Residency {
+	residency, _ := c.WhereCtx(context.Background(), key)
+	return residency
+}
+
+// WhereCtx is like Where, but gives up querying the S3 tier when ctx is
+// done, in which case it returns the copies found so far with the error of
+// ctx.
+func (c *CacheMachine) WhereCtx(ctx context.Context, key string) (Residency, error) {
+	ctx, cancel := withDefaultTimeout(ctx, c.DefaultTimeouts.Get)
+	defer cancel()
+	residency := Residency{Key: key, Copies: []TierCopy{}}
+
+	shard := c.syncTable.shard(key)
+	shard.Lock()
+	if cacheSync, ok := shard.entries[key]; ok {
+		residency.Tracked = true
+		residency.SetAt = cacheSync.SetAt
+		residency.ExpiresAt = cacheSync.ExpiresAt
+		residency.Expired = cacheSync.expired(time.Now())
+		residency.Negative = cacheSync.Negative
+		residency.DiskSynced = cacheSync.DiskSynced
+		residency.S3Synced = cacheSync.S3Sync
+		residency.LegalHold = c.legalHolds.held(key)
+	}
+	if value, err := c.RamCache.Peek([]byte(key)); err == nil {
+		tierCopy := newTierCopy(tierRAM, value)
+		if ttl, err := c.RamCache.TTL([]byte(key)); err == nil && ttl > 0 {
+			tierCopy.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Second).Truncate(time.Second)
+		}
+		residency.Copies = append(residency.Copies, tierCopy)
+	}
+	if diskCache := c.DiskCache; diskCache != nil {
+		if meta, ok := diskCache.Stat(key); ok {
+			value, err := diskCache.Peek(key)
+			tierCopy := newTierCopy(tierDisk, value)
+			tierCopy.Size = int(meta.Size)
+			tierCopy.CreatedAt = meta.CreatedAt
+			tierCopy.Path = meta.Path
+			if err != nil {
+				tierCopy.Checksum = ""
+				tierCopy.Error = err.Error()
+			}
+			residency.Copies = append(residency.Copies, tierCopy)
+		}
+	}
+	shard.Unlock()
+
+	if s3Cache := c.S3Cache; s3Cache != nil {
+		value, err := c.getObject(ctx, s3Cache, key)
+		switch {
+		case err == nil:
+			residency.Copies = append(residency.Copies, newTierCopy(tierS3, value))
+		case err == ErrObjectNotFound:
+		case ctx.Err() != nil:
+			return residency, ctx.Err()
+		default:
+			residency.Copies = append(residency.Copies, TierCopy{Tier: string(tierS3), Error: err.Error()})
+		}
+	}
+	return residency, nil
+}
+
+func newTierCopy(t tier, value []byte) TierCopy {
+	return TierCopy{
+		Tier:     string(t),
+		Size:     len(value),
+		Checksum: fmt.Sprintf("%08x", crc32.Checksum(value, crc32c)),
+	}
+}
@@ -0,0 +1,91 @@
+package cachemachine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCacheMachine_Where(t *testing.T) {
+	CacheMachine, err := NewCacheMachine(10, 1024)
+	if err != nil {
+		t.Errorf("Error creating cache machine: %s", err)
+	}
+
+	tmpFolder, err := createTempFolder()
+	if err != nil {
+		t.Errorf("Error creating temp folder: %s", err)
+	}
+	defer removeTempFolder(tmpFolder)
+
+	err = CacheMachine.EnableDiskCache(1024, tmpFolder)
+	if err != nil {
+		t.Errorf("Expected no error enabling disk cache, got %s", err)
+	}
+	defer CacheMachine.DisableDiskCache()
+
+	store := newMemoryStore()
+	CacheMachine.EnableS3Cache(store)
+
+	residency := CacheMachine.Where("key1")
+	if residency.Tracked || len(residency.Copies) != 0 {
+		t.Errorf("Expected no copy of a missing key, got %+v", residency)
+	}
+
+	CacheMachine.SetWithTTL("key1", []byte("12345"), time.Hour)
+	residency = CacheMachine.Where("key1")
+	if !residency.Tracked || residency.DiskSynced || len(residency.Copies) != 1 || residency.Copies[0].Tier != "ram" {
+		t.Errorf("Expected a RAM copy only before the sync, got %+v", residency)
+	}
+	if ram := residency.Copies[0]; ram.Size != 5 || ram.Checksum == "" || ram.ExpiresAt.IsZero() {
+		t.Errorf("Expected the size, checksum and expiry of the RAM copy, got %+v", ram)
+	}
+
+	CacheMachine.Flush()
+	residency = CacheMachine.Where("key1")
+	if !residency.DiskSynced || !residency.S3Synced || len(residency.Copies) != 3 {
+		t.Fatalf("Expected a copy in every tier after the sync, got %+v", residency)
+	}
+	for i, tier := range []string{"ram", "disk", "s3"} {
+		tierCopy := residency.Copies[i]
+		if tierCopy.Tier != tier || tierCopy.Size != 5 || tierCopy.Checksum != residency.Copies[0].Checksum {
+			t.Errorf("Expected an identical %s copy, got %+v", tier, tierCopy)
+		}
+	}
+	disk := residency.Copies[1]
+	if disk.Path == "" || disk.CreatedAt.IsZero() {
+		t.Errorf("Expected the path and creation time of the disk copy, got %+v", disk)
+	}
+
+	// The disk copy is corrupt, and S3 holds another value.
+	if err := os.Truncate(disk.Path, 3); err != nil {
+		t.Fatalf("Error truncating %s: %s", disk.Path, err)
+	}
+	store.Put(context.Background(), "key1", sealObject([]byte("67890")))
+	residency = CacheMachine.Where("key1")
+	if len(residency.Copies) != 3 || residency.Copies[1].Error == "" {
+		t.Fatalf("Expected the disk copy to be reported corrupt, got %+v", residency)
+	}
+	if residency.Copies[2].Checksum == residency.Copies[0].Checksum {
+		t.Errorf("Expected the S3 copy to differ, got %+v", residency.Copies)
+	}
+
+	server := httptest.NewServer(CacheMachine.AdminHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/where/key1")
+	if err != nil {
+		t.Fatalf("Error querying the admin handler: %s", err)
+	}
+	defer resp.Body.Close()
+	var fromAdmin Residency
+	if err := json.NewDecoder(resp.Body).Decode(&fromAdmin); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a residency, got %d, %v", resp.StatusCode, err)
+	}
+	if fromAdmin.Key != "key1" || len(fromAdmin.Copies) != 2 {
+		t.Errorf("Expected the RAM and S3 copies of key1, got %+v", fromAdmin)
+	}
+}